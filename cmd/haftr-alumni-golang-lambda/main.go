@@ -7,37 +7,48 @@ import (
 	"time"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/app"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/awslabs/aws-lambda-go-api-proxy/handlerfunc"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type awsEventHandlerFunc func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error)
 
 func main() {
-	h := getAWSLambdaEventHandler()
+	client := db.NewClient(os.Getenv("MONGO_URI"), os.Getenv("DB_NAME"))
+	h := getAWSLambdaEventHandler(client)
+
+	// Connecting during the cold start keeps the first request from paying for it. A failure is retried on that request
+	start := time.Now()
+	if _, err := client.Database(context.Background()); err != nil {
+		log.Print(errors.Wrap(err, "main - cannot connect to mongo"))
+	} else {
+		log.Printf("Connected to mongo in %v", time.Since(start))
+	}
+
 	lambda.Start(h)
 }
 
-func getAWSLambdaEventHandler() awsEventHandlerFunc {
+// getAWSLambdaEventHandler serves API Gateway requests, building the app once per connection to the database
+// so it is reused by every invocation of a warm container
+func getAWSLambdaEventHandler(client *db.Client) awsEventHandlerFunc {
+	var (
+		database *mongo.Database
+		adapter  *handlerfunc.HandlerFuncAdapter
+	)
 	return func(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-		mongoURI := os.Getenv("MONGO_URI")
-		dbName := os.Getenv("DB_NAME")
-
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+		d, err := client.Database(ctx)
 		if err != nil {
-			log.Fatal(errors.Wrap(err, "main - cannot connect to mongo"))
+			return events.APIGatewayProxyResponse{}, errors.Wrap(err, "main - cannot connect to mongo")
+		}
+		if d != database {
+			a := app.New(d)
+			database, adapter = d, handlerfunc.New(a.Handler())
 		}
-		defer client.Disconnect(ctx)
-		db := client.Database(dbName)
 
-		a := app.New(db)
-		lambdaProxyAdapter := handlerfunc.New(a.Handler())
-		return lambdaProxyAdapter.ProxyWithContext(ctx, req)
+		return adapter.ProxyWithContext(ctx, req)
 	}
 }
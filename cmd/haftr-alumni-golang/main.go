@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/app"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -19,6 +20,13 @@ func main() {
 	mongoURI := os.Getenv("MONGO_URI")
 	dbName := os.Getenv("DB_NAME")
 
+	// STORE=memory runs the whole app without Mongo, losing everything on exit
+	if os.Getenv("STORE") == "memory" {
+		a := app.NewFromStore(db.NewMemoryStore())
+		fmt.Printf("Starting server on port %v with an in-memory store\n", port)
+		log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), a.Handler()))
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
@@ -26,8 +34,17 @@ func main() {
 		log.Fatal(errors.Wrap(err, "main - cannot connect to mongo"))
 	}
 	defer client.Disconnect(ctx)
-	db := client.Database(dbName)
-	a := app.New(db)
+	database := client.Database(dbName)
+	ss, err := db.RetrieveMigrationStatus(database)(ctx)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "main - cannot retrieve migration status"))
+	}
+	for _, s := range ss {
+		if !s.Applied {
+			log.Printf("Migration version=%v is pending, run haftr-alumni-migrate up", s.Version)
+		}
+	}
+	a := app.New(database)
 	fmt.Printf("Starting server on port %v\n", port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), a.Handler()))
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const usage = "usage: haftr-alumni-migrate [up|status]"
+
+func main() {
+	cmd := "up"
+	if len(os.Args) > 1 {
+		cmd = os.Args[1]
+	}
+	if len(os.Args) > 2 || (cmd != "up" && cmd != "status") {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	mongoURI := os.Getenv("MONGO_URI")
+	dbName := os.Getenv("DB_NAME")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*gotime.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "main - cannot connect to mongo"))
+	}
+	defer client.Disconnect(context.Background())
+	database := client.Database(dbName)
+
+	switch cmd {
+	case "up":
+		ss, err := db.RunMigrations(database, time.CurrentEpoch)(context.Background())
+		for _, s := range ss {
+			fmt.Printf("Applied migration %v: %v\n", s.Version, s.Description)
+		}
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "main - cannot run migrations"))
+		}
+		if len(ss) == 0 {
+			fmt.Println("No pending migrations")
+		}
+	case "status":
+		ss, err := db.RetrieveMigrationStatus(database)(context.Background())
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "main - cannot retrieve migration status"))
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tAPPLIED\tDESCRIPTION")
+		for _, s := range ss {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedTimestamp.String()
+			}
+			fmt.Fprintf(w, "%v\t%v\t%v\n", s.Version, applied, s.Description)
+		}
+		w.Flush()
+	}
+}
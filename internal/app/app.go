@@ -1,12 +1,15 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	gotime "time"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
 	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/storage"
@@ -14,115 +17,302 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+const defaultResetPasswordTTL = gotime.Hour
+
 // App is a representation of an App
 type App struct {
-	AddUserHandler              http.HandlerFunc
-	LoginUserHandler            http.HandlerFunc
-	AutoLoginUserHandler        http.HandlerFunc
-	ApproveUserHandler          http.HandlerFunc
-	DenyUserHandler             http.HandlerFunc
-	ForgotPasswordHandler       http.HandlerFunc
-	SetNewPasswordHandler       http.HandlerFunc
-	AddAlumniHandler            http.HandlerFunc
-	RetrieveAlumniByIDHandler   http.HandlerFunc
-	RetrieveAllAlumniHandler    http.HandlerFunc
-	HappyBirthdayHandler        http.HandlerFunc
-	ExportCSVHandler            http.HandlerFunc
-	UpdateAlumniHandler         http.HandlerFunc
-	MakeAlumniPublicHandler     http.HandlerFunc
-	MakeAlumniPrivateHandler    http.HandlerFunc
-	HappyBirthdayEmailScheduled ScheduledFunc
-	CorsHandler                 http.HandlerFunc
+	AddUserHandler                http.HandlerFunc
+	LoginUserHandler              http.HandlerFunc
+	CompleteMFALoginHandler       http.HandlerFunc
+	EnrollTOTPHandler             http.HandlerFunc
+	ConfirmTOTPHandler            http.HandlerFunc
+	DisableTOTPHandler            http.HandlerFunc
+	VerifyEmailHandler            http.HandlerFunc
+	ResendVerificationHandler     http.HandlerFunc
+	AutoLoginUserHandler          http.HandlerFunc
+	RefreshTokenHandler           http.HandlerFunc
+	LogoutHandler                 http.HandlerFunc
+	DeleteAccountHandler          http.HandlerFunc
+	ExportAccountHandler          http.HandlerFunc
+	RequestEmailChangeHandler     http.HandlerFunc
+	ConfirmEmailChangeHandler     http.HandlerFunc
+	CancelEmailChangeHandler      http.HandlerFunc
+	InviteAlumniHandler           http.HandlerFunc
+	AcceptInvitationHandler       http.HandlerFunc
+	SearchClaimableAlumniHandler  http.HandlerFunc
+	ClaimAlumniHandler            http.HandlerFunc
+	RetrieveProfileClaimsHandler  http.HandlerFunc
+	ApproveProfileClaimHandler    http.HandlerFunc
+	RejectProfileClaimHandler     http.HandlerFunc
+	RetrieveUsersHandler          http.HandlerFunc
+	BulkUpdateUsersHandler        http.HandlerFunc
+	ApproveUserHandler            http.HandlerFunc
+	DenyUserHandler               http.HandlerFunc
+	UnlockUserHandler             http.HandlerFunc
+	GrantRoleHandler              http.HandlerFunc
+	RevokeRoleHandler             http.HandlerFunc
+	RetrieveUserRolesHandler      http.HandlerFunc
+	RetrieveEmailTemplatesHandler http.HandlerFunc
+	UpsertEmailTemplateHandler    http.HandlerFunc
+	ForgotPasswordHandler         http.HandlerFunc
+	SetNewPasswordHandler         http.HandlerFunc
+	AddAlumniHandler              http.HandlerFunc
+	RetrieveAlumniByIDHandler     http.HandlerFunc
+	RetrieveAllAlumniHandler      http.HandlerFunc
+	HappyBirthdayHandler          http.HandlerFunc
+	ExportCSVHandler              http.HandlerFunc
+	UpdateAlumniHandler           http.HandlerFunc
+	MakeAlumniPublicHandler       http.HandlerFunc
+	MakeAlumniPrivateHandler      http.HandlerFunc
+	RetrieveAlumniHistoryHandler  http.HandlerFunc
+	RestoreAlumniHandler          http.HandlerFunc
+	RetrieveAuditEventsHandler    http.HandlerFunc
+	ExportAuditCSVHandler         http.HandlerFunc
+	HappyBirthdayEmailScheduled   ScheduledFunc
+	CorsHandler                   http.HandlerFunc
+	AuthMiddleware                MiddlewareFunc
 }
 
 // Handler turns the App into an http hander
 func (a *App) Handler() http.HandlerFunc {
+	authn := a.AuthMiddleware
 	router := httprouter.New()
-	router.HandlerFunc(http.MethodPost, "/users", a.AddUserHandler)
+	router.HandlerFunc(http.MethodPost, "/users", authn(auth.Anonymous, a.AddUserHandler))
+	router.HandlerFunc(http.MethodGet, "/users", authn(auth.Admin, a.RetrieveUsersHandler))
+	router.HandlerFunc(http.MethodPatch, "/users", authn(auth.Admin, a.BulkUpdateUsersHandler))
 	router.HandlerFunc(http.MethodOptions, "/users", a.CorsHandler)
-	router.HandlerFunc(http.MethodPost, "/login", a.LoginUserHandler)
+	router.HandlerFunc(http.MethodPost, "/login", authn(auth.Anonymous, a.LoginUserHandler))
 	router.HandlerFunc(http.MethodOptions, "/login", a.CorsHandler)
-	router.HandlerFunc(http.MethodGet, "/autologin", a.AutoLoginUserHandler)
+	router.HandlerFunc(http.MethodPost, "/login/2fa", authn(auth.Anonymous, a.CompleteMFALoginHandler))
+	router.HandlerFunc(http.MethodOptions, "/login/2fa", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/2fa/enroll", authn(auth.Authenticated, a.EnrollTOTPHandler))
+	router.HandlerFunc(http.MethodOptions, "/2fa/enroll", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/2fa/confirm", authn(auth.Authenticated, a.ConfirmTOTPHandler))
+	router.HandlerFunc(http.MethodOptions, "/2fa/confirm", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/2fa/disable", authn(auth.Authenticated, a.DisableTOTPHandler))
+	router.HandlerFunc(http.MethodOptions, "/2fa/disable", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/verify", authn(auth.Anonymous, a.VerifyEmailHandler))
+	router.HandlerFunc(http.MethodOptions, "/verify", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/verify/resend", authn(auth.Authenticated, a.ResendVerificationHandler))
+	router.HandlerFunc(http.MethodOptions, "/verify/resend", a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, "/autologin", authn(auth.Authenticated, a.AutoLoginUserHandler))
 	router.HandlerFunc(http.MethodOptions, "/autologin", a.CorsHandler)
-	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/approve", userIdKey), a.ApproveUserHandler)
+	router.HandlerFunc(http.MethodPost, "/refresh", authn(auth.Anonymous, a.RefreshTokenHandler))
+	router.HandlerFunc(http.MethodOptions, "/refresh", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/logout", authn(auth.Authenticated, a.LogoutHandler))
+	router.HandlerFunc(http.MethodOptions, "/logout", a.CorsHandler)
+	router.HandlerFunc(http.MethodDelete, fmt.Sprintf("/users/:%v", userIdKey), authn(auth.Authenticated, a.DeleteAccountHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, fmt.Sprintf("/users/:%v/export", userIdKey), authn(auth.Authenticated, a.ExportAccountHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/export", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, fmt.Sprintf("/users/:%v/email", userIdKey), authn(auth.Authenticated, a.RequestEmailChangeHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/email", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/email/confirm", authn(auth.Anonymous, a.ConfirmEmailChangeHandler))
+	router.HandlerFunc(http.MethodOptions, "/email/confirm", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/email/cancel", authn(auth.Anonymous, a.CancelEmailChangeHandler))
+	router.HandlerFunc(http.MethodOptions, "/email/cancel", a.CorsHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/approve", userIdKey), authn(auth.Admin, a.ApproveUserHandler))
 	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/approve", userIdKey), a.CorsHandler)
-	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/deny", userIdKey), a.DenyUserHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/deny", userIdKey), authn(auth.Admin, a.DenyUserHandler))
 	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/deny", userIdKey), a.CorsHandler)
-	router.HandlerFunc(http.MethodPost, "/forgotpassword", a.ForgotPasswordHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/unlock", userIdKey), authn(auth.Admin, a.UnlockUserHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/unlock", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, fmt.Sprintf("/users/:%v/roles", userIdKey), authn(auth.Admin, a.RetrieveUserRolesHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/roles", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/roles/grant", userIdKey), authn(auth.Admin, a.GrantRoleHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/roles/grant", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/users/:%v/roles/revoke", userIdKey), authn(auth.Admin, a.RevokeRoleHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/users/:%v/roles/revoke", userIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, "/templates", authn(auth.Admin, a.RetrieveEmailTemplatesHandler))
+	router.HandlerFunc(http.MethodOptions, "/templates", a.CorsHandler)
+	router.HandlerFunc(http.MethodPut, fmt.Sprintf("/templates/:%v", templateNameKey), authn(auth.Admin, a.UpsertEmailTemplateHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/templates/:%v", templateNameKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/invitations/accept", authn(auth.Anonymous, a.AcceptInvitationHandler))
+	router.HandlerFunc(http.MethodOptions, "/invitations/accept", a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, "/forgotpassword", authn(auth.Anonymous, a.ForgotPasswordHandler))
 	router.HandlerFunc(http.MethodOptions, "/forgotpassword", a.CorsHandler)
-	router.HandlerFunc(http.MethodPost, "/setpassword", a.SetNewPasswordHandler)
+	router.HandlerFunc(http.MethodPost, "/setpassword", authn(auth.Anonymous, a.SetNewPasswordHandler))
 	router.HandlerFunc(http.MethodOptions, "/setpassword", a.CorsHandler)
-	router.HandlerFunc(http.MethodPost, "/alumni", a.AddAlumniHandler)
-	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/alumni/:%v", alumniIdKey), a.UpdateAlumniHandler)
-	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/alumni/:%v/gopublic", alumniIdKey), a.MakeAlumniPublicHandler)
+	router.HandlerFunc(http.MethodPost, "/alumni", authn(auth.Authenticated, a.AddAlumniHandler))
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/alumni/:%v", alumniIdKey), authn(auth.Authenticated, a.UpdateAlumniHandler))
+	router.HandlerFunc(http.MethodPost, fmt.Sprintf("/alumni/:%v/invite", alumniIdKey), authn(auth.Admin, a.InviteAlumniHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v/invite", alumniIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, fmt.Sprintf("/alumni/:%v/claim", alumniIdKey), authn(auth.Authenticated, a.ClaimAlumniHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v/claim", alumniIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, "/unclaimed", authn(auth.Authenticated, a.SearchClaimableAlumniHandler))
+	router.HandlerFunc(http.MethodOptions, "/unclaimed", a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, "/claims", authn(auth.Admin, a.RetrieveProfileClaimsHandler))
+	router.HandlerFunc(http.MethodOptions, "/claims", a.CorsHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/claims/:%v/approve", claimIdKey), authn(auth.Admin, a.ApproveProfileClaimHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/claims/:%v/approve", claimIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/claims/:%v/reject", claimIdKey), authn(auth.Admin, a.RejectProfileClaimHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/claims/:%v/reject", claimIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/alumni/:%v/gopublic", alumniIdKey), authn(auth.Authenticated, a.MakeAlumniPublicHandler))
 	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v/gopublic", alumniIdKey), a.CorsHandler)
-	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/alumni/:%v/goprivate", alumniIdKey), a.MakeAlumniPrivateHandler)
+	router.HandlerFunc(http.MethodPatch, fmt.Sprintf("/alumni/:%v/goprivate", alumniIdKey), authn(auth.Authenticated, a.MakeAlumniPrivateHandler))
 	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v/goprivate", alumniIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, fmt.Sprintf("/alumni/:%v/history", alumniIdKey), authn(auth.Admin, a.RetrieveAlumniHistoryHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v/history", alumniIdKey), a.CorsHandler)
+	router.HandlerFunc(http.MethodPost, fmt.Sprintf("/alumni/:%v/history/:%v/restore", alumniIdKey, versionKey), authn(auth.Admin, a.RestoreAlumniHandler))
+	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v/history/:%v/restore", alumniIdKey, versionKey), a.CorsHandler)
 	router.HandlerFunc(http.MethodOptions, fmt.Sprintf("/alumni/:%v", alumniIdKey), a.CorsHandler)
-	router.HandlerFunc(http.MethodGet, fmt.Sprintf("/alumni/:%v", alumniIdKey), a.RetrieveAlumniByIDHandler)
-	router.HandlerFunc(http.MethodGet, "/alumni", a.RetrieveAllAlumniHandler)
-	router.HandlerFunc(http.MethodGet, "/csv/alumni", a.ExportCSVHandler)
+	router.HandlerFunc(http.MethodGet, fmt.Sprintf("/alumni/:%v", alumniIdKey), authn(auth.Authenticated, a.RetrieveAlumniByIDHandler))
+	router.HandlerFunc(http.MethodGet, "/alumni", authn(auth.Approved, a.RetrieveAllAlumniHandler))
+	router.HandlerFunc(http.MethodGet, "/csv/alumni", authn(auth.Admin, a.ExportCSVHandler))
 	router.HandlerFunc(http.MethodOptions, "/csv/alumni", a.CorsHandler)
-	router.HandlerFunc(http.MethodGet, "/happybirthday", a.HappyBirthdayHandler)
+	router.HandlerFunc(http.MethodGet, "/audit", authn(auth.Admin, a.RetrieveAuditEventsHandler))
+	router.HandlerFunc(http.MethodOptions, "/audit", a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, "/csv/audit", authn(auth.Admin, a.ExportAuditCSVHandler))
+	router.HandlerFunc(http.MethodOptions, "/csv/audit", a.CorsHandler)
+	router.HandlerFunc(http.MethodGet, "/happybirthday", authn(auth.Anonymous, a.HappyBirthdayHandler))
 	h := http.HandlerFunc(router.ServeHTTP)
 	return h
 }
 
 // OptionalArgs is a representation of all the optional arguments for this application
 type OptionalArgs struct {
-	EpochTimeProvider           time.EpochProviderFunc
-	UUIDGenerator               uuid.GenV4Func
-	PhotosS3Bucket              string
-	AddUser                     db.InsertUserFunc
-	RetrieveUserByEmail         db.RetrieveUserByEmailFunc
-	RetrieveUserByID            db.RetrieveUserByIDFunc
-	RetrieveUserByAlumniID      db.RetrieveUserByAlumniIDFunc
-	RetrieveUsersAlumniIDs      db.RetrieveUsersAlumniIDsFunc
-	ReplaceUser                 db.ReplaceUserFunc
-	InsertResetPassword         db.CreateResetPasswordFunc
-	RetrieveResetPassword       db.FindResetPasswordFunc
-	DeleteResetPasswords        db.DeleteResetPasswordsFunc
-	InsertAlumni                db.InsertAlumniFunc
-	RetrieveAlumniByID          db.RetrieveAlumniByIDFunc
-	RetrieveAlumnis             db.RetrieveAllAlumniFunc
-	UpdateAlumni                db.UpdateAlumniFunc
-	ChangeAlumniPrivacyStatus   db.ChangeAlumniPrivacyFunc
-	RetrieveEmailTemplateByName db.RetrieveEmailTemplateByNameFunc
-	S3Upload                    storage.UploadFunc
-	S3Presign                   storage.PresignFunc
-	SendEmail                   email.SendEmailFunc
+	EpochTimeProvider            time.EpochProviderFunc
+	UUIDGenerator                uuid.GenV4Func
+	PhotosS3Bucket               string
+	RequireAdmin2FA              bool
+	ResetPasswordTTL             gotime.Duration
+	AddUser                      db.InsertUserFunc
+	RetrieveUserByEmail          db.RetrieveUserByEmailFunc
+	RetrieveUserByID             db.RetrieveUserByIDFunc
+	RetrieveUserByAlumniID       db.RetrieveUserByAlumniIDFunc
+	RetrieveUsers                db.RetrieveUsersFunc
+	DeleteUser                   db.DeleteUserFunc
+	ReplaceUser                  db.ReplaceUserFunc
+	InsertResetPassword          db.CreateResetPasswordFunc
+	ConsumeResetPassword         db.ConsumeResetPasswordFunc
+	CountResetPasswords          db.CountResetPasswordsFunc
+	DeleteResetPasswords         db.DeleteResetPasswordsFunc
+	InsertRefreshToken           db.InsertRefreshTokenFunc
+	RetrieveRefreshToken         db.RetrieveRefreshTokenByIDFunc
+	RotateRefreshToken           db.RotateRefreshTokenFunc
+	DeleteRefreshToken           db.DeleteRefreshTokenFunc
+	DeleteUserRefreshTokens      db.DeleteUserRefreshTokensFunc
+	InsertAlumni                 db.InsertAlumniFunc
+	DeleteAlumni                 db.DeleteAlumniFunc
+	RetrieveAlumniByID           db.RetrieveAlumniByIDFunc
+	RetrieveAlumnis              db.RetrieveAllAlumniFunc
+	RetrieveAlumniAccounts       db.RetrieveAlumniAccountsFunc
+	RetrieveUnclaimedAlumni      db.RetrieveUnclaimedAlumniFunc
+	UpdateAlumni                 db.UpdateAlumniFunc
+	ChangeAlumniPrivacyStatus    db.ChangeAlumniPrivacyFunc
+	InsertAlumniHistory          db.InsertAlumniHistoryFunc
+	RetrieveAlumniHistory        db.RetrieveAlumniHistoryFunc
+	RetrieveAlumniHistoryVersion db.RetrieveAlumniHistoryVersionFunc
+	DeleteAlumniHistory          db.DeleteAlumniHistoryFunc
+	InsertAuditEvent             db.InsertAuditEventFunc
+	RetrieveAuditEvents          db.RetrieveAuditEventsFunc
+	RetrieveEmailTemplateByName  db.RetrieveEmailTemplateByNameFunc
+	RetrieveAllEmailTemplates    db.RetrieveAllEmailTemplatesFunc
+	UpsertEmailTemplate          db.UpsertEmailTemplateFunc
+	InsertRoleChange             db.InsertRoleChangeFunc
+	RetrieveRoleChanges          db.RetrieveRoleChangesFunc
+	InsertEmailVerification      db.InsertEmailVerificationFunc
+	RetrieveEmailVerification    db.RetrieveEmailVerificationFunc
+	DeleteEmailVerifications     db.DeleteEmailVerificationsFunc
+	InsertEmailChange            db.InsertEmailChangeFunc
+	RetrieveEmailChange          db.RetrieveEmailChangeFunc
+	DeleteEmailChanges           db.DeleteEmailChangesFunc
+	UpdateUserEmail              db.UpdateUserEmailFunc
+	UpdateAlumniEmail            db.UpdateAlumniEmailFunc
+	InsertInvitation             db.InsertInvitationFunc
+	ConsumeInvitation            db.ConsumeInvitationFunc
+	DeleteInvitations            db.DeleteInvitationsFunc
+	InsertProfileClaim           db.InsertProfileClaimFunc
+	RetrieveProfileClaimByID     db.RetrieveProfileClaimByIDFunc
+	RetrieveProfileClaims        db.RetrieveProfileClaimsFunc
+	ReplaceProfileClaim          db.ReplaceProfileClaimFunc
+	RetrieveLoginAttempt         db.RetrieveLoginAttemptFunc
+	RecordLoginFailure           db.RecordLoginFailureFunc
+	DeleteLoginAttempts          db.DeleteLoginAttemptsFunc
+	S3Upload                     storage.UploadFunc
+	S3Presign                    storage.PresignFunc
+	S3Download                   storage.DownloadFunc
+	S3Delete                     storage.DeleteFunc
+	SendEmail                    email.SendEmailFunc
 }
 
 // Option is a representation of a function that modifies optional arguments
 type Option func(oa *OptionalArgs)
 
-// New creates a new App
+// New creates a new App backed by a Mongo database
 func New(provideDb *mongo.Database, opts ...Option) App {
+	return NewFromStore(db.NewMongoStore(provideDb), opts...)
+}
+
+// NewFromStore creates a new App backed by any Store, such as the in-memory one
+func NewFromStore(store db.Store, opts ...Option) App {
 	s3Config := storage.DefaultConfig()
 	sesConfig := email.DefaultConfig()
 
 	oa := OptionalArgs{
-		EpochTimeProvider:           time.CurrentEpoch,
-		UUIDGenerator:               uuid.GenV4,
-		PhotosS3Bucket:              os.Getenv("S3_BUCKET"),
-		AddUser:                     db.InsertUser(provideDb),
-		RetrieveUserByEmail:         db.RetrieveUserByEmail(provideDb),
-		RetrieveUserByID:            db.RetrieveUserByID(provideDb),
-		RetrieveUserByAlumniID:      db.RetrieveUserByAlumniID(provideDb),
-		RetrieveUsersAlumniIDs:      db.RetrieveUsersAlumniIDs(provideDb),
-		ReplaceUser:                 db.ReplaceUser(provideDb),
-		InsertResetPassword:         db.CreateResetPassword(provideDb),
-		RetrieveResetPassword:       db.FindResetPassword(provideDb),
-		DeleteResetPasswords:        db.DeleteResetPasswords(provideDb),
-		InsertAlumni:                db.InsertAlumni(provideDb),
-		RetrieveAlumniByID:          db.RetrieveAlumniByID(provideDb),
-		RetrieveAlumnis:             db.RetrieveAllAlumni(provideDb),
-		UpdateAlumni:                db.UpdateAlumni(provideDb),
-		ChangeAlumniPrivacyStatus:   db.ChangeAlumniPrivacy(provideDb),
-		RetrieveEmailTemplateByName: db.RetrieveEmailTemplateByName(provideDb),
-		S3Upload:                    storage.UploadToS3(s3Config),
-		S3Presign:                   storage.PresignObject(s3Config),
-		SendEmail:                   email.SendEmail(sesConfig),
+		EpochTimeProvider:            time.CurrentEpoch,
+		UUIDGenerator:                uuid.GenV4,
+		PhotosS3Bucket:               os.Getenv("S3_BUCKET"),
+		RequireAdmin2FA:              os.Getenv("REQUIRE_ADMIN_2FA") == "true",
+		ResetPasswordTTL:             durationFromEnv("RESET_PASSWORD_TTL", defaultResetPasswordTTL),
+		AddUser:                      store.Users.Insert,
+		RetrieveUserByEmail:          store.Users.RetrieveByEmail,
+		RetrieveUserByID:             store.Users.RetrieveByID,
+		RetrieveUserByAlumniID:       store.Users.RetrieveByAlumniID,
+		RetrieveUsers:                store.Users.Retrieve,
+		DeleteUser:                   store.Users.Delete,
+		ReplaceUser:                  store.Users.Replace,
+		InsertResetPassword:          store.ResetPasswords.Create,
+		ConsumeResetPassword:         store.ResetPasswords.Consume,
+		CountResetPasswords:          store.ResetPasswords.Count,
+		DeleteResetPasswords:         store.ResetPasswords.Delete,
+		InsertRefreshToken:           store.RefreshTokens.Insert,
+		RetrieveRefreshToken:         store.RefreshTokens.RetrieveByID,
+		RotateRefreshToken:           store.RefreshTokens.Rotate,
+		DeleteRefreshToken:           store.RefreshTokens.Delete,
+		DeleteUserRefreshTokens:      store.RefreshTokens.DeleteForUser,
+		InsertAlumni:                 store.Alumni.Insert,
+		DeleteAlumni:                 store.Alumni.Delete,
+		RetrieveAlumniByID:           store.Alumni.RetrieveByID,
+		RetrieveAlumnis:              store.Alumni.RetrieveAll,
+		RetrieveAlumniAccounts:       store.Alumni.RetrieveAccounts,
+		RetrieveUnclaimedAlumni:      store.Alumni.RetrieveUnclaimed,
+		UpdateAlumni:                 store.Alumni.Update,
+		ChangeAlumniPrivacyStatus:    store.Alumni.ChangePrivacy,
+		InsertAlumniHistory:          store.AlumniHistory.Insert,
+		RetrieveAlumniHistory:        store.AlumniHistory.Retrieve,
+		RetrieveAlumniHistoryVersion: store.AlumniHistory.RetrieveVersion,
+		DeleteAlumniHistory:          store.AlumniHistory.DeleteForAlumni,
+		InsertAuditEvent:             store.AuditEvents.Insert,
+		RetrieveAuditEvents:          store.AuditEvents.Retrieve,
+		RetrieveEmailTemplateByName:  store.Templates.RetrieveByName,
+		RetrieveAllEmailTemplates:    store.Templates.RetrieveAll,
+		UpsertEmailTemplate:          store.Templates.Upsert,
+		InsertRoleChange:             store.RoleChanges.Insert,
+		RetrieveRoleChanges:          store.RoleChanges.Retrieve,
+		InsertEmailVerification:      store.EmailVerifications.Insert,
+		RetrieveEmailVerification:    store.EmailVerifications.Retrieve,
+		DeleteEmailVerifications:     store.EmailVerifications.DeleteForUser,
+		InsertEmailChange:            store.EmailChanges.Insert,
+		RetrieveEmailChange:          store.EmailChanges.Retrieve,
+		DeleteEmailChanges:           store.EmailChanges.DeleteForUser,
+		UpdateUserEmail:              store.Users.UpdateEmail,
+		UpdateAlumniEmail:            store.Alumni.UpdateEmail,
+		InsertInvitation:             store.Invitations.Insert,
+		ConsumeInvitation:            store.Invitations.Consume,
+		DeleteInvitations:            store.Invitations.DeleteForAlumni,
+		InsertProfileClaim:           store.ProfileClaims.Insert,
+		RetrieveProfileClaimByID:     store.ProfileClaims.RetrieveByID,
+		RetrieveProfileClaims:        store.ProfileClaims.Retrieve,
+		ReplaceProfileClaim:          store.ProfileClaims.Replace,
+		RetrieveLoginAttempt:         store.LoginAttempts.Retrieve,
+		RecordLoginFailure:           store.LoginAttempts.RecordFailure,
+		DeleteLoginAttempts:          store.LoginAttempts.Delete,
+		S3Upload:                     storage.UploadToS3(s3Config),
+		S3Presign:                    storage.PresignObject(s3Config),
+		S3Download:                   storage.DownloadFromS3(s3Config),
+		S3Delete:                     storage.DeleteFromS3(s3Config),
+		SendEmail:                    email.SendEmail(sesConfig),
 	}
 
 	for _, opt := range opts {
@@ -131,48 +321,125 @@ func New(provideDb *mongo.Database, opts ...Option) App {
 
 	uploadImage := storage.UploadImage(oa.S3Upload, oa.PhotosS3Bucket)
 	presignURL := storage.GetImageURL(oa.S3Presign, oa.PhotosS3Bucket)
+	downloadImage := storage.DownloadImage(oa.S3Download, oa.PhotosS3Bucket)
+	deleteImage := storage.DeleteImage(oa.S3Delete, oa.PhotosS3Bucket)
 
-	addUserHandler := AddUserHandler(oa.EpochTimeProvider, oa.UUIDGenerator, oa.AddUser, oa.RetrieveUserByEmail)
-	loginUserHandler := LoginUserHandler(oa.RetrieveUserByEmail, oa.EpochTimeProvider)
-	autologinUserHandler := AutoLoginUserHandler(oa.RetrieveUserByID, oa.EpochTimeProvider)
-	approveUserHandler := ApproveUserHandler(oa.RetrieveUserByID, oa.EpochTimeProvider, oa.ReplaceUser)
-	denyUserHandler := DenyUserHandler(oa.RetrieveUserByID, oa.EpochTimeProvider, oa.ReplaceUser)
-	forgotPasswordHandler := ForgotPasswordHandler(oa.RetrieveUserByEmail, oa.RetrieveEmailTemplateByName, oa.SendEmail, oa.InsertResetPassword, oa.EpochTimeProvider)
-	setPasswordHandler := SetNewPasswordHandler(oa.RetrieveResetPassword, oa.DeleteResetPasswords, oa.RetrieveUserByEmail, oa.ReplaceUser, oa.EpochTimeProvider)
-	addAlumniHandler := AddAlumniHandler(oa.RetrieveUserByID, oa.InsertAlumni, oa.ReplaceUser, oa.RetrieveEmailTemplateByName, oa.EpochTimeProvider, oa.UUIDGenerator, uploadImage, presignURL, oa.SendEmail)
-	updateAlumniHandler := UpdateAlumniHandler(oa.RetrieveUserByID, oa.UpdateAlumni, oa.RetrieveAlumniByID, oa.RetrieveEmailTemplateByName, oa.EpochTimeProvider, oa.UUIDGenerator, uploadImage, presignURL, oa.SendEmail)
-	retrieveAlumniByIdHandler := RetrieveAlumniByIDHandler(oa.RetrieveAlumniByID, oa.RetrieveUserByID, oa.RetrieveUserByAlumniID, oa.EpochTimeProvider, presignURL)
-	retrieveAllAlumniHandler := RetrieveAlumniHandler(oa.RetrieveAlumnis, oa.RetrieveUserByID, oa.RetrieveUsersAlumniIDs, oa.RetrieveUserByAlumniID, oa.EpochTimeProvider, presignURL)
-	makeAlumniPublicHandler := ChangeAlumniPrivacyHandler(oa.RetrieveAlumniByID, oa.RetrieveUserByID, oa.ChangeAlumniPrivacyStatus, oa.EpochTimeProvider, presignURL, true)
-	makeAlumniPrivateHandler := ChangeAlumniPrivacyHandler(oa.RetrieveAlumniByID, oa.RetrieveUserByID, oa.ChangeAlumniPrivacyStatus, oa.EpochTimeProvider, presignURL, false)
-	exportCsvHandler := ExportCSVHandler(oa.RetrieveAlumnis, oa.RetrieveUserByID, oa.RetrieveUsersAlumniIDs, oa.EpochTimeProvider, presignURL)
+	addUserHandler := AddUserHandler(oa.EpochTimeProvider, oa.UUIDGenerator, oa.AddUser, oa.RetrieveUserByEmail, oa.InsertRefreshToken, oa.InsertEmailVerification, oa.RetrieveEmailTemplateByName, oa.SendEmail)
+	verifyEmailHandler := VerifyEmailHandler(oa.RetrieveEmailVerification, oa.DeleteEmailVerifications, oa.RetrieveUserByID, oa.ReplaceUser, oa.EpochTimeProvider)
+	resendVerificationHandler := ResendVerificationEmailHandler(oa.InsertEmailVerification, oa.DeleteEmailVerifications, oa.RetrieveEmailTemplateByName, oa.SendEmail, oa.EpochTimeProvider)
+	loginUserHandler := LoginUserHandler(oa.RetrieveUserByEmail, oa.RetrieveLoginAttempt, oa.RecordLoginFailure, oa.DeleteLoginAttempts, oa.InsertRefreshToken, oa.EpochTimeProvider, oa.UUIDGenerator)
+	completeMFALoginHandler := CompleteMFALoginHandler(oa.RetrieveUserByID, oa.ReplaceUser, oa.RetrieveLoginAttempt, oa.RecordLoginFailure, oa.DeleteLoginAttempts, oa.InsertRefreshToken, oa.EpochTimeProvider, oa.UUIDGenerator)
+	enrollTOTPHandler := EnrollTOTPHandler(oa.ReplaceUser, oa.EpochTimeProvider)
+	confirmTOTPHandler := ConfirmTOTPHandler(oa.ReplaceUser, oa.EpochTimeProvider)
+	disableTOTPHandler := DisableTOTPHandler(oa.ReplaceUser, oa.EpochTimeProvider, oa.RequireAdmin2FA)
+	autologinUserHandler := AutoLoginUserHandler(oa.EpochTimeProvider)
+	refreshTokenHandler := RefreshTokenHandler(oa.RotateRefreshToken, oa.RetrieveUserByID, oa.EpochTimeProvider)
+	logoutHandler := LogoutHandler(oa.DeleteRefreshToken)
+	deleteAccountHandler := DeleteAccountHandler(oa.RetrieveAlumniByID, deleteImage, oa.DeleteAlumni, oa.DeleteAlumniHistory, oa.DeleteResetPasswords, oa.DeleteEmailVerifications, oa.DeleteEmailChanges, oa.DeleteUserRefreshTokens, oa.DeleteLoginAttempts, oa.DeleteUser)
+	requestEmailChangeHandler := RequestEmailChangeHandler(oa.RetrieveUserByEmail, oa.InsertEmailChange, oa.DeleteEmailChanges, oa.RetrieveEmailTemplateByName, oa.SendEmail, oa.EpochTimeProvider)
+	confirmEmailChangeHandler := ConfirmEmailChangeHandler(oa.RetrieveEmailChange, oa.DeleteEmailChanges, oa.RetrieveUserByID, oa.UpdateUserEmail, oa.UpdateAlumniEmail, oa.DeleteResetPasswords, oa.DeleteEmailVerifications, oa.EpochTimeProvider)
+	cancelEmailChangeHandler := CancelEmailChangeHandler(oa.RetrieveEmailChange, oa.DeleteEmailChanges, oa.EpochTimeProvider)
+	inviteAlumniHandler := InviteAlumniHandler(oa.RetrieveAlumniByID, oa.RetrieveUserByAlumniID, oa.RetrieveUserByEmail, oa.InsertInvitation, oa.DeleteInvitations, oa.RetrieveEmailTemplateByName, oa.SendEmail, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	acceptInvitationHandler := AcceptInvitationHandler(oa.ConsumeInvitation, oa.RetrieveUserByEmail, oa.RetrieveUserByAlumniID, oa.AddUser, oa.InsertRefreshToken, oa.EpochTimeProvider, oa.UUIDGenerator)
+	searchClaimableAlumniHandler := SearchClaimableAlumniHandler(oa.RetrieveUnclaimedAlumni)
+	claimAlumniHandler := ClaimAlumniHandler(oa.RetrieveAlumniByID, oa.RetrieveUserByAlumniID, oa.RetrieveProfileClaims, oa.InsertProfileClaim, oa.EpochTimeProvider, oa.UUIDGenerator)
+	retrieveProfileClaimsHandler := RetrieveProfileClaimsHandler(oa.RetrieveProfileClaims, oa.RetrieveAlumniByID)
+	approveProfileClaimHandler := ApproveProfileClaimHandler(oa.RetrieveProfileClaimByID, oa.RetrieveProfileClaims, oa.ReplaceProfileClaim, oa.RetrieveAlumniByID, oa.RetrieveUserByID, oa.RetrieveUserByAlumniID, oa.ReplaceUser, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	rejectProfileClaimHandler := RejectProfileClaimHandler(oa.RetrieveProfileClaimByID, oa.ReplaceProfileClaim, oa.RetrieveAlumniByID, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	exportAccountHandler := ExportAccountHandler(oa.RetrieveAlumniByID, oa.RetrieveRoleChanges, downloadImage)
+	retrieveUsersHandler := RetrieveUsersHandler(oa.RetrieveUsers)
+	bulkUpdateUsersHandler := BulkUpdateUsersHandler(oa.RetrieveUserByID, oa.RetrieveAlumniByID, oa.EpochTimeProvider, oa.ReplaceUser, oa.DeleteUserRefreshTokens, oa.InsertAuditEvent, oa.UUIDGenerator, oa.RetrieveEmailTemplateByName, oa.SendEmail)
+	approveUserHandler := ApproveUserHandler(oa.RetrieveUserByID, oa.RetrieveAlumniByID, oa.EpochTimeProvider, oa.ReplaceUser, oa.InsertAuditEvent, oa.UUIDGenerator, oa.RetrieveEmailTemplateByName, oa.SendEmail)
+	unlockUserHandler := UnlockUserHandler(oa.RetrieveUserByID, oa.RetrieveAlumniByID, oa.DeleteLoginAttempts, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	denyUserHandler := DenyUserHandler(oa.RetrieveUserByID, oa.RetrieveAlumniByID, oa.EpochTimeProvider, oa.ReplaceUser, oa.DeleteUserRefreshTokens, oa.InsertAuditEvent, oa.UUIDGenerator, oa.RetrieveEmailTemplateByName, oa.SendEmail)
+	grantRoleHandler := GrantRoleHandler(oa.RetrieveUserByID, oa.ReplaceUser, oa.InsertRoleChange, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	revokeRoleHandler := RevokeRoleHandler(oa.RetrieveUserByID, oa.ReplaceUser, oa.InsertRoleChange, oa.DeleteUserRefreshTokens, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	retrieveUserRolesHandler := RetrieveUserRolesHandler(oa.RetrieveUserByID, oa.RetrieveRoleChanges)
+	retrieveEmailTemplatesHandler := RetrieveEmailTemplatesHandler(oa.RetrieveAllEmailTemplates)
+	upsertEmailTemplateHandler := UpsertEmailTemplateHandler(oa.UpsertEmailTemplate, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
+	forgotPasswordHandler := ForgotPasswordHandler(oa.RetrieveUserByEmail, oa.RetrieveEmailTemplateByName, oa.SendEmail, oa.InsertResetPassword, oa.CountResetPasswords, oa.EpochTimeProvider, oa.ResetPasswordTTL)
+	setPasswordHandler := SetNewPasswordHandler(oa.ConsumeResetPassword, oa.DeleteResetPasswords, oa.RetrieveUserByEmail, oa.ReplaceUser, oa.InsertRefreshToken, oa.DeleteUserRefreshTokens, oa.EpochTimeProvider, oa.UUIDGenerator)
+	addAlumniHandler := AddAlumniHandler(oa.InsertAlumni, oa.InsertAlumniHistory, oa.InsertAuditEvent, oa.ReplaceUser, oa.RetrieveEmailTemplateByName, oa.EpochTimeProvider, oa.UUIDGenerator, uploadImage, presignURL, oa.SendEmail)
+	updateAlumniHandler := UpdateAlumniHandler(oa.UpdateAlumni, oa.RetrieveAlumniByID, oa.RetrieveUserByAlumniID, oa.InsertAlumniHistory, oa.InsertAuditEvent, oa.RetrieveEmailTemplateByName, oa.EpochTimeProvider, oa.UUIDGenerator, uploadImage, presignURL, oa.SendEmail)
+	retrieveAlumniByIdHandler := RetrieveAlumniByIDHandler(oa.RetrieveAlumniByID, oa.RetrieveUserByAlumniID, presignURL)
+	retrieveAllAlumniHandler := RetrieveAlumniHandler(oa.RetrieveAlumniAccounts, presignURL)
+	makeAlumniPublicHandler := ChangeAlumniPrivacyHandler(oa.RetrieveAlumniByID, oa.ChangeAlumniPrivacyStatus, oa.InsertAlumniHistory, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator, presignURL, true)
+	makeAlumniPrivateHandler := ChangeAlumniPrivacyHandler(oa.RetrieveAlumniByID, oa.ChangeAlumniPrivacyStatus, oa.InsertAlumniHistory, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator, presignURL, false)
+	retrieveAlumniHistoryHandler := RetrieveAlumniHistoryHandler(oa.RetrieveAlumniByID, oa.RetrieveAlumniHistory)
+	restoreAlumniHandler := RestoreAlumniHandler(oa.RetrieveAlumniByID, oa.RetrieveAlumniHistoryVersion, oa.UpdateAlumni, oa.InsertAlumniHistory, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator, presignURL)
+	exportCsvHandler := ExportCSVHandler(oa.RetrieveAlumniAccounts, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator, presignURL)
+	retrieveAuditEventsHandler := RetrieveAuditEventsHandler(oa.RetrieveAuditEvents)
+	exportAuditCsvHandler := ExportAuditCSVHandler(oa.RetrieveAuditEvents, oa.InsertAuditEvent, oa.EpochTimeProvider, oa.UUIDGenerator)
 	happyBirthdayHandler := HappyBirthdayHandler(oa.RetrieveAlumnis, oa.EpochTimeProvider)
 
 	happyBirthdayEmailScheduled := HappyBirthdayEmailScheduled(oa.RetrieveAlumnis, oa.EpochTimeProvider, oa.RetrieveEmailTemplateByName, oa.RetrieveUserByAlumniID, oa.SendEmail)
 
 	corsHandler := CorsHandler()
+	authMiddleware := AuthMiddleware(oa.RetrieveUserByID, oa.RetrieveRefreshToken, oa.EpochTimeProvider, oa.UUIDGenerator, oa.RequireAdmin2FA)
 
 	return App{
-		AddUserHandler:              addUserHandler,
-		LoginUserHandler:            loginUserHandler,
-		AutoLoginUserHandler:        autologinUserHandler,
-		ApproveUserHandler:          approveUserHandler,
-		DenyUserHandler:             denyUserHandler,
-		ForgotPasswordHandler:       forgotPasswordHandler,
-		SetNewPasswordHandler:       setPasswordHandler,
-		AddAlumniHandler:            addAlumniHandler,
-		RetrieveAlumniByIDHandler:   retrieveAlumniByIdHandler,
-		RetrieveAllAlumniHandler:    retrieveAllAlumniHandler,
-		ExportCSVHandler:            exportCsvHandler,
-		HappyBirthdayHandler:        happyBirthdayHandler,
-		UpdateAlumniHandler:         updateAlumniHandler,
-		MakeAlumniPublicHandler:     makeAlumniPublicHandler,
-		MakeAlumniPrivateHandler:    makeAlumniPrivateHandler,
-		HappyBirthdayEmailScheduled: happyBirthdayEmailScheduled,
-		CorsHandler:                 corsHandler,
+		AddUserHandler:                addUserHandler,
+		LoginUserHandler:              loginUserHandler,
+		CompleteMFALoginHandler:       completeMFALoginHandler,
+		EnrollTOTPHandler:             enrollTOTPHandler,
+		ConfirmTOTPHandler:            confirmTOTPHandler,
+		DisableTOTPHandler:            disableTOTPHandler,
+		VerifyEmailHandler:            verifyEmailHandler,
+		ResendVerificationHandler:     resendVerificationHandler,
+		AutoLoginUserHandler:          autologinUserHandler,
+		RefreshTokenHandler:           refreshTokenHandler,
+		LogoutHandler:                 logoutHandler,
+		DeleteAccountHandler:          deleteAccountHandler,
+		ExportAccountHandler:          exportAccountHandler,
+		RequestEmailChangeHandler:     requestEmailChangeHandler,
+		ConfirmEmailChangeHandler:     confirmEmailChangeHandler,
+		CancelEmailChangeHandler:      cancelEmailChangeHandler,
+		InviteAlumniHandler:           inviteAlumniHandler,
+		AcceptInvitationHandler:       acceptInvitationHandler,
+		SearchClaimableAlumniHandler:  searchClaimableAlumniHandler,
+		ClaimAlumniHandler:            claimAlumniHandler,
+		RetrieveProfileClaimsHandler:  retrieveProfileClaimsHandler,
+		ApproveProfileClaimHandler:    approveProfileClaimHandler,
+		RejectProfileClaimHandler:     rejectProfileClaimHandler,
+		RetrieveUsersHandler:          retrieveUsersHandler,
+		BulkUpdateUsersHandler:        bulkUpdateUsersHandler,
+		ApproveUserHandler:            approveUserHandler,
+		DenyUserHandler:               denyUserHandler,
+		UnlockUserHandler:             unlockUserHandler,
+		GrantRoleHandler:              grantRoleHandler,
+		RevokeRoleHandler:             revokeRoleHandler,
+		RetrieveUserRolesHandler:      retrieveUserRolesHandler,
+		RetrieveEmailTemplatesHandler: retrieveEmailTemplatesHandler,
+		UpsertEmailTemplateHandler:    upsertEmailTemplateHandler,
+		ForgotPasswordHandler:         forgotPasswordHandler,
+		SetNewPasswordHandler:         setPasswordHandler,
+		AddAlumniHandler:              addAlumniHandler,
+		RetrieveAlumniByIDHandler:     retrieveAlumniByIdHandler,
+		RetrieveAllAlumniHandler:      retrieveAllAlumniHandler,
+		ExportCSVHandler:              exportCsvHandler,
+		HappyBirthdayHandler:          happyBirthdayHandler,
+		UpdateAlumniHandler:           updateAlumniHandler,
+		MakeAlumniPublicHandler:       makeAlumniPublicHandler,
+		MakeAlumniPrivateHandler:      makeAlumniPrivateHandler,
+		RetrieveAlumniHistoryHandler:  retrieveAlumniHistoryHandler,
+		RestoreAlumniHandler:          restoreAlumniHandler,
+		RetrieveAuditEventsHandler:    retrieveAuditEventsHandler,
+		ExportAuditCSVHandler:         exportAuditCsvHandler,
+		HappyBirthdayEmailScheduled:   happyBirthdayEmailScheduled,
+		CorsHandler:                   corsHandler,
+		AuthMiddleware:                authMiddleware,
 	}
 }
 
-func (a *App) RunHappyBirthdayEmail() error {
-	return a.HappyBirthdayEmailScheduled()
+func (a *App) RunHappyBirthdayEmail(ctx context.Context) error {
+	return a.HappyBirthdayEmailScheduled(ctx)
+}
+
+// durationFromEnv parses a duration such as "30m" from the environment, falling back to def when it is unset or invalid
+func durationFromEnv(key string, def gotime.Duration) gotime.Duration {
+	d, err := gotime.ParseDuration(os.Getenv(key))
+	if err != nil || d <= 0 {
+		return def
+	}
+	return d
 }
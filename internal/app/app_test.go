@@ -0,0 +1,654 @@
+package app
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/jsonpatch"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var placeholder = regexp.MustCompile(`\$\{(\w+)\}`)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the responses received")
+
+const (
+	testPassword     = "Corr3ct-Horse-Battery"
+	testAdminEmail   = "admin@haftr.org"
+	testAdminID      = "00000000-0000-4000-8000-00000000a000"
+	testAlumniID     = "00000000-0000-4000-8000-00000000b000"
+	testPhotosBucket = "photos"
+	testJWTSecret    = "e2e-test-secret"
+	redactedValue    = "<redacted>"
+	goldenDir        = "testdata/golden"
+	fixtureDir       = "testdata"
+)
+
+// jsonPatchHeader sends a request body as a JSON Patch
+var jsonPatchHeader = map[string]string{"Content-Type": jsonpatch.ContentType}
+
+// testNow is 9am on January 1st, so the birthday of the alumni in the fixtures is today
+var testNow = time.Epoch(gotime.Date(2021, gotime.January, 1, 9, 0, 0, 0, gotime.UTC).UnixNano())
+
+// redactedKeys are the response fields holding random values, which can never match a golden file
+var redactedKeys = map[string]bool{
+	"refreshToken":  true,
+	"secret":        true,
+	"uri":           true,
+	"recoveryCodes": true,
+}
+
+// fakeS3 stores uploaded objects in memory
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) upload(_ context.Context, r io.Reader, bucket, key string, opts ...storage.UploadOption) error {
+	bb, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[bucket+"/"+key] = bb
+	return nil
+}
+
+func (s *fakeS3) presign(_ context.Context, bucket, key string) (string, error) {
+	return fmt.Sprintf("https://%v.s3.test/%v", bucket, key), nil
+}
+
+func (s *fakeS3) download(_ context.Context, bucket, key string) ([]byte, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bb, ok := s.objects[bucket+"/"+key]
+	if !ok {
+		return nil, "", fmt.Errorf("fake s3 - no object with key=%v", key)
+	}
+	return bb, http.DetectContentType(bb), nil
+}
+
+func (s *fakeS3) delete(_ context.Context, bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, bucket+"/"+key)
+	return nil
+}
+
+// fakeSES records every email sent
+type fakeSES struct {
+	mu   sync.Mutex
+	sent []email.SendRequest
+}
+
+func (s *fakeSES) send(_ context.Context, req email.SendRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, req)
+	return nil
+}
+
+// lastTo returns the body of the last email sent to the recipient
+func (s *fakeSES) lastTo(recipient string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.sent) - 1; i >= 0; i-- {
+		if s.sent[i].Recipient == recipient {
+			return s.sent[i].HTMLContent
+		}
+	}
+	return ""
+}
+
+// sequentialUUIDs generates v4 shaped UUIDs counting up from 1
+func sequentialUUIDs() uuid.GenV4Func {
+	var mu sync.Mutex
+	n := 0
+	return func() uuid.V4 {
+		mu.Lock()
+		defer mu.Unlock()
+		n++
+		return uuid.V4(fmt.Sprintf("00000000-0000-4000-8000-%012d", n))
+	}
+}
+
+type testServer struct {
+	t      *testing.T
+	url    string
+	store  db.Store
+	emails *fakeSES
+	tokens map[string]string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	os.Setenv("JWT_SECRET", testJWTSecret)
+
+	store := db.NewMemoryStore()
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	ses := &fakeSES{}
+	a := NewFromStore(store, func(oa *OptionalArgs) {
+		oa.EpochTimeProvider = func() time.Epoch { return testNow }
+		oa.UUIDGenerator = sequentialUUIDs()
+		oa.PhotosS3Bucket = testPhotosBucket
+		oa.RequireAdmin2FA = false
+		oa.S3Upload = s3.upload
+		oa.S3Presign = s3.presign
+		oa.S3Download = s3.download
+		oa.S3Delete = s3.delete
+		oa.SendEmail = ses.send
+	})
+	srv := httptest.NewServer(a.Handler())
+	t.Cleanup(srv.Close)
+
+	ts := &testServer{t: t, url: srv.URL, store: store, emails: ses, tokens: map[string]string{}}
+	ts.seed()
+	return ts
+}
+
+// seed adds the email templates, a super admin and an alumni record entered by an admin before its alumnus signed up
+func (ts *testServer) seed() {
+	names := []string{
+		internal.NewAlumniTemplateName,
+		internal.UpdatedAlumniTemplateName,
+		internal.ForgotPasswordTemplateName,
+		internal.HappyBirthdayTemplateName,
+		internal.VerifyEmailTemplateName,
+		internal.ApprovedUserTemplateName,
+		internal.DeniedUserTemplateName,
+		internal.ChangeEmailTemplateName,
+		internal.EmailChangedTemplateName,
+		internal.InvitationTemplateName,
+	}
+	for _, name := range names {
+		et := internal.EmailTemplate{Name: name, Subject: name, HTML: "token={{Token}}"}
+		if err := ts.store.Templates.Upsert(context.Background(), et); err != nil {
+			ts.t.Fatal(err)
+		}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	admin := internal.User{
+		ID:               testAdminID,
+		Email:            testAdminEmail,
+		Password:         hash,
+		Roles:            []internal.Role{{Name: internal.SuperAdminRole}},
+		EmailVerified:    true,
+		Status:           internal.ApprovedUserStatus,
+		CreatedTimestamp: testNow,
+	}
+	if err := ts.store.Users.Insert(context.Background(), admin); err != nil {
+		ts.t.Fatal(err)
+	}
+
+	a := internal.Alumni{
+		ID:               testAlumniID,
+		Firstname:        "Jane",
+		Lastname:         "Roe",
+		EmailAddress:     "jane.roe@example.com",
+		HighSchool:       internal.School{Name: "HAFTR High School", YearEnded: "1999"},
+		HAFTR:            true,
+		CreatedTimestamp: testNow,
+	}
+	if err := ts.store.Alumni.Insert(context.Background(), a); err != nil {
+		ts.t.Fatal(err)
+	}
+}
+
+// step is one request of the scenario, whose response is compared to the golden file named after it
+type step struct {
+	name       string
+	method     string
+	path       string
+	as         string
+	body       interface{}
+	form       map[string]string
+	file       string
+	header     map[string]string
+	wantStatus int
+	wantHeader map[string]string
+	golden     string
+	after      func(ts *testServer, body []byte)
+}
+
+func (ts *testServer) run(s step) {
+	ts.t.Run(s.name, func(t *testing.T) {
+		req, err := ts.request(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		bb, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != s.wantStatus {
+			t.Errorf("got status %v, want %v, body %s", res.StatusCode, s.wantStatus, bb)
+		}
+		for k, v := range s.wantHeader {
+			if got := res.Header.Get(k); got != v {
+				t.Errorf("got header %v=%v, want %v", k, got, v)
+			}
+		}
+
+		got, err := normalize(res.Header.Get("Content-Type"), bb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareGolden(t, goldenPath(s), got)
+
+		if s.after != nil && res.StatusCode == s.wantStatus {
+			s.after(ts, bb)
+		}
+	})
+}
+
+func (ts *testServer) request(s step) (*http.Request, error) {
+	var body io.Reader
+	contentType := ""
+	switch {
+	case s.form != nil:
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for k, v := range s.form {
+			if err := mw.WriteField(k, v); err != nil {
+				return nil, err
+			}
+		}
+		if s.file != "" {
+			fw, err := mw.CreateFormFile(profilePictureKey, filepath.Base(s.file))
+			if err != nil {
+				return nil, err
+			}
+			fw.Write(testPNG)
+		}
+		if err := mw.Close(); err != nil {
+			return nil, err
+		}
+		body, contentType = &buf, mw.FormDataContentType()
+	case s.body != nil:
+		bb, err := requestBody(s.body)
+		if err != nil {
+			return nil, err
+		}
+		body, contentType = strings.NewReader(ts.expand(string(bb))), "application/json"
+	}
+
+	req, err := http.NewRequest(s.method, ts.url+ts.expand(s.path), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if s.as != "" {
+		req.Header.Set(authTokenKey, "Bearer "+ts.tokens[s.as])
+	}
+	for k, v := range s.header {
+		req.Header.Set(k, ts.expand(v))
+	}
+	return req, nil
+}
+
+// expand replaces the ${name} placeholders in a path or body with the values saved by earlier steps
+func (ts *testServer) expand(s string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		return ts.tokens[m[2:len(m)-1]]
+	})
+}
+
+// requestBody returns the body of a request, which is read from the fixtures when it is a file name
+func requestBody(body interface{}) ([]byte, error) {
+	if name, ok := body.(string); ok {
+		return ioutil.ReadFile(filepath.Join(fixtureDir, name))
+	}
+	return json.Marshal(body)
+}
+
+func goldenPath(s step) string {
+	if s.golden != "" {
+		return filepath.Join(fixtureDir, s.golden)
+	}
+	return filepath.Join(goldenDir, s.name+".golden")
+}
+
+// normalize indents JSON responses, redacting their random values, and lists the files in ZIP responses
+func normalize(contentType string, bb []byte) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(contentType, "application/json"):
+		if len(bytes.TrimSpace(bb)) == 0 {
+			return bb, nil
+		}
+		var v interface{}
+		if err := json.Unmarshal(bb, &v); err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "    ")
+		err := enc.Encode(redact(v))
+		return out.Bytes(), err
+	case strings.HasPrefix(contentType, "application/zip"):
+		zr, err := zip.NewReader(bytes.NewReader(bb), int64(len(bb)))
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		sort.Strings(names)
+		return []byte(strings.Join(names, "\n") + "\n"), nil
+	}
+	return bb, nil
+}
+
+func redact(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, e := range vv {
+			if redactedKeys[k] && e != nil && e != "" {
+				vv[k] = redactedValue
+				continue
+			}
+			vv[k] = redact(e)
+		}
+	case []interface{}:
+		for i, e := range vv {
+			vv[i] = redact(e)
+		}
+	}
+	return v
+}
+
+func compareGolden(t *testing.T, path string, got []byte) {
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read golden file, run go test with -update to create it: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("response does not match %v\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// saveToken keeps the access token of a login response for later steps to authenticate as
+func saveToken(as string) func(ts *testServer, body []byte) {
+	return func(ts *testServer, body []byte) {
+		var res struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+			Token        string `json:"token"`
+			RefreshToken string `json:"refreshToken"`
+		}
+		if err := json.Unmarshal(body, &res); err != nil {
+			ts.t.Fatal(err)
+		}
+		ts.tokens[as] = res.Token
+		ts.tokens[as+"Id"] = res.User.ID
+		ts.tokens[as+"Refresh"] = res.RefreshToken
+	}
+}
+
+// saveEmailToken keeps the token in the last email sent to the recipient
+func saveEmailToken(name, recipient string) func(ts *testServer, body []byte) {
+	return func(ts *testServer, body []byte) {
+		ts.tokens[name] = strings.TrimPrefix(ts.emails.lastTo(recipient), "token=")
+	}
+}
+
+// saveField keeps a top level field of a JSON response
+func saveField(name, field string) func(ts *testServer, body []byte) {
+	return func(ts *testServer, body []byte) {
+		var res map[string]interface{}
+		if err := json.Unmarshal(body, &res); err != nil {
+			ts.t.Fatal(err)
+		}
+		ts.tokens[name] = fmt.Sprint(res[field])
+	}
+}
+
+func loginBody(email string) map[string]string {
+	return map[string]string{"email": email, "password": testPassword}
+}
+
+func readFixture(t *testing.T, name string) string {
+	bb, err := ioutil.ReadFile(filepath.Join(fixtureDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bb)
+}
+
+// testPNG is the smallest valid PNG, a single transparent pixel
+var testPNG = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48, 0x44, 0x52,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06, 0x00, 0x00, 0x00, 0x1f, 0x15, 0xc4,
+	0x89, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0x00, 0x01, 0x00, 0x00,
+	0x05, 0x00, 0x01, 0x0d, 0x0a, 0x2d, 0xb4, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44, 0xae,
+	0x42, 0x60, 0x82,
+}
+
+func TestEndToEnd(t *testing.T) {
+	ts := newTestServer(t)
+	alumniForm := map[string]string{jsonDataKey: readFixture(t, "add_alumni_request.json")}
+
+	steps := []step{
+		// Signing up and logging in
+		{name: "signup", method: http.MethodPost, path: "/users", body: "add_or_login_user_request.json", wantStatus: http.StatusOK, golden: "add_or_login_user_response.json", after: saveToken("user")},
+		{name: "signup_duplicate_email", method: http.MethodPost, path: "/users", body: "add_or_login_user_request.json", wantStatus: http.StatusConflict},
+		{name: "login_wrong_password", method: http.MethodPost, path: "/login", body: map[string]string{"email": "test@gmail.com", "password": "Wr0ng-Password!"}, wantStatus: http.StatusUnauthorized},
+		{name: "login_unknown_email", method: http.MethodPost, path: "/login", body: loginBody("nobody@example.com"), wantStatus: http.StatusUnauthorized},
+		{name: "login", method: http.MethodPost, path: "/login", body: "add_or_login_user_request.json", wantStatus: http.StatusOK, after: saveToken("user")},
+		{name: "login_admin", method: http.MethodPost, path: "/login", body: loginBody(testAdminEmail), wantStatus: http.StatusOK, after: saveToken("admin")},
+		{name: "autologin_missing_token", method: http.MethodGet, path: "/autologin", wantStatus: http.StatusUnauthorized},
+		{name: "autologin_invalid_token", method: http.MethodGet, path: "/autologin", as: "nobody", wantStatus: http.StatusUnauthorized},
+		{name: "autologin", method: http.MethodGet, path: "/autologin", as: "user", wantStatus: http.StatusOK},
+		{name: "refresh", method: http.MethodPost, path: "/refresh", body: map[string]string{"refreshToken": "${userRefresh}"}, wantStatus: http.StatusOK, after: func(ts *testServer, body []byte) {
+			ts.tokens["staleRefresh"] = ts.tokens["userRefresh"]
+			saveToken("user")(ts, body)
+		}},
+		{name: "refresh_reused", method: http.MethodPost, path: "/refresh", body: map[string]string{"refreshToken": "${staleRefresh}"}, wantStatus: http.StatusUnauthorized},
+
+		// Verifying the email address
+		{name: "verify_email_invalid_token", method: http.MethodPost, path: "/verify", body: map[string]string{"token": "not-a-token"}, wantStatus: http.StatusUnauthorized},
+		{name: "resend_verification", method: http.MethodPost, path: "/verify/resend", as: "user", wantStatus: http.StatusOK, after: saveEmailToken("verifyToken", "test@gmail.com")},
+		{name: "verify_email", method: http.MethodPost, path: "/verify", body: map[string]string{"token": "${verifyToken}"}, wantStatus: http.StatusOK},
+
+		// Approving the user
+		{name: "list_alumni_unapproved", method: http.MethodGet, path: "/alumni", as: "user", wantStatus: http.StatusForbidden},
+		{name: "list_users_not_admin", method: http.MethodGet, path: "/users", as: "user", wantStatus: http.StatusForbidden},
+		{name: "list_pending_users", method: http.MethodGet, path: "/users?status=PENDING", as: "admin", wantStatus: http.StatusOK},
+		{name: "approve_user_not_admin", method: http.MethodPatch, path: "/users/${userId}/approve", as: "user", wantStatus: http.StatusForbidden},
+		{name: "approve_user_unknown", method: http.MethodPatch, path: "/users/00000000-0000-4000-8000-999999999999/approve", as: "admin", wantStatus: http.StatusNotFound},
+		{name: "approve_user", method: http.MethodPatch, path: "/users/${userId}/approve", as: "admin", wantStatus: http.StatusOK},
+
+		// The user's alumni record
+		{name: "add_alumni_unauthenticated", method: http.MethodPost, path: "/alumni", form: alumniForm, wantStatus: http.StatusUnauthorized},
+		{name: "add_alumni", method: http.MethodPost, path: "/alumni", as: "user", form: alumniForm, file: "profile.png", wantStatus: http.StatusOK, golden: "add_alumni_response.json", after: saveField("alumniId", "id")},
+		{name: "retrieve_alumni", method: http.MethodGet, path: "/alumni/${alumniId}", as: "user", wantStatus: http.StatusOK, wantHeader: map[string]string{eTagKey: `"1"`}},
+		{name: "retrieve_alumni_unknown", method: http.MethodGet, path: "/alumni/00000000-0000-4000-8000-999999999999", as: "admin", wantStatus: http.StatusNotFound},
+		{name: "update_alumni", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", form: map[string]string{jsonDataKey: `{"cellPhone": "5551234567", "profession": ["teacher"]}`}, wantStatus: http.StatusOK},
+		{name: "update_alumni_if_match", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: map[string]string{ifMatchKey: `"2"`}, form: map[string]string{jsonDataKey: `{"workPhone": "5557654321"}`}, wantStatus: http.StatusOK, wantHeader: map[string]string{eTagKey: `"3"`}},
+		{name: "update_alumni_stale_version", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "admin", header: map[string]string{ifMatchKey: `"2"`}, form: map[string]string{jsonDataKey: `{"workPhone": "5550000000"}`}, wantStatus: http.StatusConflict, wantHeader: map[string]string{eTagKey: `"3"`}},
+		{name: "update_alumni_invalid_if_match", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: map[string]string{ifMatchKey: `W/"3"`}, form: map[string]string{jsonDataKey: `{"workPhone": "5550000000"}`}, wantStatus: http.StatusBadRequest},
+		{name: "update_alumni_set_flags", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", form: map[string]string{jsonDataKey: `{"motherDeceased": true, "alumniNewsletters": true}`}, wantStatus: http.StatusOK},
+		{name: "update_alumni_clear_fields", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", form: map[string]string{jsonDataKey: `{"motherDeceased": false, "alumniNewsletters": false, "workPhone": "", "comment": null}`}, wantStatus: http.StatusOK},
+		{name: "patch_alumni", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: jsonPatchHeader, body: []map[string]interface{}{
+			{"op": "test", "path": "/gradSchools/0/name", "value": "Grad School"},
+			{"op": "add", "path": "/siblings/-", "value": map[string]string{"firstname": "Younger", "lastname": "Doe"}},
+			{"op": "replace", "path": "/children/0/firstname", "value": "Kid"},
+			{"op": "remove", "path": "/oldAddresses/0"},
+			{"op": "add", "path": "/grandparents/0", "value": map[string]string{"lastname": "Doe"}},
+		}, wantStatus: http.StatusOK, wantHeader: map[string]string{eTagKey: `"6"`}},
+		{name: "patch_alumni_unknown_field", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: jsonPatchHeader, body: []map[string]interface{}{
+			{"op": "add", "path": "/siblings/0/nickname", "value": "Sib"},
+		}, wantStatus: http.StatusBadRequest},
+		{name: "patch_alumni_not_array_field", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: jsonPatchHeader, body: []map[string]interface{}{
+			{"op": "add", "path": "/firstname", "value": "Jim"},
+		}, wantStatus: http.StatusBadRequest},
+		{name: "patch_alumni_failed_test", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: jsonPatchHeader, body: []map[string]interface{}{
+			{"op": "replace", "path": "/children/0/firstname", "value": "Other"},
+			{"op": "test", "path": "/gradSchools/0/name", "value": "Another School"},
+		}, wantStatus: http.StatusConflict},
+		{name: "patch_alumni_invalid_op", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: jsonPatchHeader, body: []map[string]interface{}{
+			{"op": "rename", "path": "/children/0"},
+		}, wantStatus: http.StatusBadRequest},
+		{name: "patch_alumni_stale_version", method: http.MethodPatch, path: "/alumni/${alumniId}", as: "user", header: map[string]string{"Content-Type": jsonpatch.ContentType, ifMatchKey: `"5"`}, body: []map[string]interface{}{
+			{"op": "remove", "path": "/children/0"},
+		}, wantStatus: http.StatusConflict, wantHeader: map[string]string{eTagKey: `"6"`}},
+		{name: "retrieve_alumni_after_patch", method: http.MethodGet, path: "/alumni/${alumniId}", as: "user", wantStatus: http.StatusOK, wantHeader: map[string]string{eTagKey: `"6"`}},
+		{name: "update_alumni_not_own", method: http.MethodPatch, path: "/alumni/" + testAlumniID, as: "user", form: map[string]string{jsonDataKey: `{"cellPhone": "5551234567"}`}, wantStatus: http.StatusForbidden},
+		{name: "update_unclaimed_alumni_stale_version", method: http.MethodPatch, path: "/alumni/" + testAlumniID, as: "admin", header: map[string]string{ifMatchKey: `"1"`}, form: map[string]string{jsonDataKey: `{"cellPhone": "5551234567"}`}, wantStatus: http.StatusConflict},
+		{name: "make_alumni_public", method: http.MethodPatch, path: "/alumni/${alumniId}/gopublic", as: "user", wantStatus: http.StatusOK},
+		{name: "make_alumni_private", method: http.MethodPatch, path: "/alumni/${alumniId}/goprivate", as: "user", wantStatus: http.StatusOK},
+		{name: "restore_alumni_not_admin", method: http.MethodPost, path: "/alumni/${alumniId}/history/1/restore", as: "user", wantStatus: http.StatusForbidden},
+		{name: "restore_alumni_invalid_version", method: http.MethodPost, path: "/alumni/${alumniId}/history/first/restore", as: "admin", wantStatus: http.StatusBadRequest},
+		{name: "restore_alumni_unknown_version", method: http.MethodPost, path: "/alumni/${alumniId}/history/99/restore", as: "admin", wantStatus: http.StatusNotFound},
+		{name: "restore_alumni_stale_version", method: http.MethodPost, path: "/alumni/${alumniId}/history/1/restore", as: "admin", header: map[string]string{ifMatchKey: `"7"`}, wantStatus: http.StatusConflict},
+		{name: "restore_alumni", method: http.MethodPost, path: "/alumni/${alumniId}/history/1/restore", as: "admin", header: map[string]string{ifMatchKey: `"8"`}, wantStatus: http.StatusOK, wantHeader: map[string]string{eTagKey: `"9"`}},
+		{name: "restore_alumni_undo", method: http.MethodPost, path: "/alumni/${alumniId}/history/8/restore", as: "admin", wantStatus: http.StatusOK, wantHeader: map[string]string{eTagKey: `"10"`}},
+		{name: "retrieve_alumni_history_not_admin", method: http.MethodGet, path: "/alumni/${alumniId}/history", as: "user", wantStatus: http.StatusForbidden},
+		{name: "retrieve_alumni_history", method: http.MethodGet, path: "/alumni/${alumniId}/history", as: "admin", wantStatus: http.StatusOK},
+		{name: "list_alumni", method: http.MethodGet, path: "/alumni?lastname=doe", as: "user", wantStatus: http.StatusOK},
+		{name: "list_alumni_admin", method: http.MethodGet, path: "/alumni?limit=1&page=2", as: "admin", wantStatus: http.StatusOK},
+		{name: "list_alumni_approved_accounts", method: http.MethodGet, path: "/alumni?status=APPROVED", as: "admin", wantStatus: http.StatusOK},
+		{name: "list_alumni_denied_accounts", method: http.MethodGet, path: "/alumni?status=DENIED", as: "admin", wantStatus: http.StatusOK},
+		{name: "export_csv_not_admin", method: http.MethodGet, path: "/csv/alumni", as: "user", wantStatus: http.StatusForbidden},
+		{name: "export_csv", method: http.MethodGet, path: "/csv/alumni", as: "admin", wantStatus: http.StatusOK, wantHeader: map[string]string{"Content-Disposition": `attachment; filename="alumnis.csv"`}},
+		{name: "happy_birthday", method: http.MethodGet, path: "/happybirthday", wantStatus: http.StatusOK},
+
+		// Email templates
+		{name: "list_templates_not_admin", method: http.MethodGet, path: "/templates", as: "user", wantStatus: http.StatusForbidden},
+		{name: "upsert_template_invalid", method: http.MethodPut, path: "/templates/" + internal.NewAlumniTemplateName, as: "admin", body: map[string]string{"subject": "New alumni", "html": "{{#if}}"}, wantStatus: http.StatusBadRequest},
+		{name: "upsert_template", method: http.MethodPut, path: "/templates/" + internal.NewAlumniTemplateName, as: "admin", body: map[string]string{"subject": "New alumni {{Firstname}}", "html": "token={{Token}}"}, wantStatus: http.StatusOK},
+		{name: "list_templates", method: http.MethodGet, path: "/templates", as: "admin", wantStatus: http.StatusOK},
+
+		// Roles
+		{name: "grant_role_not_admin", method: http.MethodPatch, path: "/users/${userId}/roles/grant", as: "user", body: map[string]string{"name": internal.ClassCoordinatorRole, "graduationYear": "2008"}, wantStatus: http.StatusForbidden},
+		{name: "grant_role_invalid", method: http.MethodPatch, path: "/users/${userId}/roles/grant", as: "admin", body: map[string]string{"name": "OWNER"}, wantStatus: http.StatusBadRequest},
+		{name: "grant_role", method: http.MethodPatch, path: "/users/${userId}/roles/grant", as: "admin", body: map[string]string{"name": internal.ClassCoordinatorRole, "graduationYear": "2008"}, wantStatus: http.StatusOK},
+		{name: "login_class_coordinator", method: http.MethodPost, path: "/login", body: "add_or_login_user_request.json", wantStatus: http.StatusOK, after: saveToken("user")},
+		{name: "list_users_class_coordinator", method: http.MethodGet, path: "/users", as: "user", wantStatus: http.StatusForbidden},
+		{name: "export_csv_class_coordinator", method: http.MethodGet, path: "/csv/alumni", as: "user", wantStatus: http.StatusOK},
+		{name: "retrieve_roles", method: http.MethodGet, path: "/users/${userId}/roles", as: "admin", wantStatus: http.StatusOK},
+		{name: "revoke_role", method: http.MethodPatch, path: "/users/${userId}/roles/revoke", as: "admin", body: map[string]string{"name": internal.ClassCoordinatorRole, "graduationYear": "2008"}, wantStatus: http.StatusOK},
+		{name: "autologin_after_role_revoked", method: http.MethodGet, path: "/autologin", as: "user", wantStatus: http.StatusUnauthorized},
+		{name: "login_after_role_revoked", method: http.MethodPost, path: "/login", body: "add_or_login_user_request.json", wantStatus: http.StatusOK, after: saveToken("user")},
+
+		// A second user, denied then approved in bulk
+		{name: "signup_second_user", method: http.MethodPost, path: "/users", body: loginBody("second@example.com"), wantStatus: http.StatusOK, after: saveToken("second")},
+		{name: "list_pending_users_unverified", method: http.MethodGet, path: "/users?status=PENDING", as: "admin", wantStatus: http.StatusOK},
+		{name: "approve_user_unverified", method: http.MethodPatch, path: "/users/${secondId}/approve", as: "admin", wantStatus: http.StatusBadRequest},
+		{name: "bulk_approve_users_unverified", method: http.MethodPatch, path: "/users", as: "admin", body: map[string]interface{}{"action": "APPROVE", "userIds": []string{"${secondId}"}}, wantStatus: http.StatusOK},
+		{name: "deny_user", method: http.MethodPatch, path: "/users/${secondId}/deny", as: "admin", body: map[string]string{"reason": "We could not find you in our records"}, wantStatus: http.StatusOK},
+		{name: "autologin_denied", method: http.MethodGet, path: "/autologin", as: "second", wantStatus: http.StatusUnauthorized},
+		{name: "bulk_update_users_invalid_action", method: http.MethodPatch, path: "/users", as: "admin", body: map[string]interface{}{"action": "DELETE", "userIds": []string{"${secondId}"}}, wantStatus: http.StatusBadRequest},
+		{name: "unlock_user", method: http.MethodPatch, path: "/users/${secondId}/unlock", as: "admin", wantStatus: http.StatusOK},
+		{name: "list_audit_not_admin", method: http.MethodGet, path: "/audit", as: "user", wantStatus: http.StatusForbidden},
+		{name: "list_audit_invalid_outcome", method: http.MethodGet, path: "/audit?outcome=MAYBE", as: "admin", wantStatus: http.StatusBadRequest},
+		{name: "list_audit_invalid_target", method: http.MethodGet, path: "/audit?targetType=ROLE", as: "admin", wantStatus: http.StatusBadRequest},
+		{name: "list_audit", method: http.MethodGet, path: "/audit?limit=100", as: "admin", wantStatus: http.StatusOK},
+		{name: "list_audit_denied_updates", method: http.MethodGet, path: "/audit?action=UPDATE&outcome=DENIED", as: "admin", wantStatus: http.StatusOK},
+		{name: "export_audit_csv", method: http.MethodGet, path: "/csv/audit?targetType=USER", as: "admin", header: map[string]string{requestIdKey: "export-audit-request"}, wantStatus: http.StatusOK, wantHeader: map[string]string{"Content-Disposition": `attachment; filename="audit.csv"`}},
+		{name: "list_audit_exports", method: http.MethodGet, path: "/audit?action=EXPORT_AUDIT", as: "admin", wantStatus: http.StatusOK},
+		{name: "login_second_user", method: http.MethodPost, path: "/login", body: loginBody("second@example.com"), wantStatus: http.StatusOK, after: saveToken("second")},
+
+		// Resetting a forgotten password
+		{name: "forgot_password", method: http.MethodPost, path: "/forgotpassword", body: map[string]string{"email": "test@gmail.com"}, wantStatus: http.StatusOK, after: saveEmailToken("resetToken", "test@gmail.com")},
+		{name: "set_password_invalid_token", method: http.MethodPost, path: "/setpassword", body: map[string]string{"email": "test@gmail.com", "token": "not-a-token", "password": testPassword}, wantStatus: http.StatusUnauthorized},
+		{name: "set_password", method: http.MethodPost, path: "/setpassword", body: map[string]string{"email": "test@gmail.com", "token": "${resetToken}", "password": testPassword}, wantStatus: http.StatusOK, after: saveToken("user")},
+
+		// Inviting the alumnus of a record an admin entered
+		{name: "invite_alumni_not_admin", method: http.MethodPost, path: "/alumni/" + testAlumniID + "/invite", as: "user", wantStatus: http.StatusForbidden},
+		{name: "invite_alumni", method: http.MethodPost, path: "/alumni/" + testAlumniID + "/invite", as: "admin", wantStatus: http.StatusOK, after: saveEmailToken("inviteToken", "jane.roe@example.com")},
+		{name: "accept_invitation_invalid_token", method: http.MethodPost, path: "/invitations/accept", body: map[string]string{"email": "jane.roe@example.com", "token": "not-a-token", "password": testPassword}, wantStatus: http.StatusUnauthorized},
+		{name: "accept_invitation", method: http.MethodPost, path: "/invitations/accept", body: map[string]string{"email": "jane.roe@example.com", "token": "${inviteToken}", "password": testPassword}, wantStatus: http.StatusOK, after: saveToken("jane")},
+
+		// Claiming, and disputing, an alumni record
+		{name: "signup_claimant", method: http.MethodPost, path: "/users", body: loginBody("claimant@example.com"), wantStatus: http.StatusOK, after: saveToken("claimant")},
+		{name: "search_unclaimed_unverified", method: http.MethodGet, path: "/unclaimed?firstname=jane&lastname=roe&yearGraduated=1999", as: "claimant", wantStatus: http.StatusForbidden},
+		{name: "resend_verification_claimant", method: http.MethodPost, path: "/verify/resend", as: "claimant", wantStatus: http.StatusOK, after: saveEmailToken("claimantVerifyToken", "claimant@example.com")},
+		{name: "verify_email_claimant", method: http.MethodPost, path: "/verify", body: map[string]string{"token": "${claimantVerifyToken}"}, wantStatus: http.StatusOK},
+		{name: "search_unclaimed_without_year", method: http.MethodGet, path: "/unclaimed?firstname=jane&lastname=roe", as: "claimant", wantStatus: http.StatusBadRequest},
+		{name: "search_unclaimed_linked", method: http.MethodGet, path: "/unclaimed?firstname=jane&lastname=roe&yearGraduated=1999", as: "claimant", wantStatus: http.StatusOK},
+		{name: "claim_alumni", method: http.MethodPost, path: "/alumni/" + testAlumniID + "/claim", as: "claimant", body: map[string]string{"message": "Jane is my legal name"}, wantStatus: http.StatusOK, after: saveField("claimId", "id")},
+		{name: "claim_alumni_again", method: http.MethodPost, path: "/alumni/" + testAlumniID + "/claim", as: "claimant", wantStatus: http.StatusConflict},
+		{name: "list_claims_not_admin", method: http.MethodGet, path: "/claims", as: "claimant", wantStatus: http.StatusForbidden},
+		{name: "list_claims", method: http.MethodGet, path: "/claims?status=PENDING", as: "admin", wantStatus: http.StatusOK},
+		{name: "reject_claim", method: http.MethodPatch, path: "/claims/${claimId}/reject", as: "admin", body: map[string]string{"reason": "Jane already holds this record"}, wantStatus: http.StatusOK},
+		{name: "approve_rejected_claim", method: http.MethodPatch, path: "/claims/${claimId}/approve", as: "admin", wantStatus: http.StatusConflict},
+		{name: "list_audit_claims", method: http.MethodGet, path: "/audit?targetType=CLAIM", as: "admin", wantStatus: http.StatusOK},
+
+		// Two factor authentication
+		{name: "enroll_totp", method: http.MethodPost, path: "/2fa/enroll", as: "user", wantStatus: http.StatusOK},
+		{name: "confirm_totp_invalid_code", method: http.MethodPost, path: "/2fa/confirm", as: "user", body: map[string]string{"code": "000000"}, wantStatus: http.StatusBadRequest},
+
+		// Changing the login email
+		{name: "change_email_wrong_password", method: http.MethodPost, path: "/users/me/email", as: "user", body: map[string]interface{}{"email": "new@example.com", "password": "Wr0ng-Password!"}, wantStatus: http.StatusUnauthorized},
+		{name: "change_email_other_user", method: http.MethodPost, path: "/users/${secondId}/email", as: "user", body: map[string]interface{}{"email": "new@example.com", "password": testPassword}, wantStatus: http.StatusForbidden},
+		{name: "change_email", method: http.MethodPost, path: "/users/me/email", as: "user", body: map[string]interface{}{"email": "new@example.com", "password": testPassword, "syncAlumni": true}, wantStatus: http.StatusOK, after: saveEmailToken("changeToken", "new@example.com")},
+		{name: "confirm_email_change", method: http.MethodPost, path: "/email/confirm", body: map[string]string{"token": "${changeToken}"}, wantStatus: http.StatusOK},
+		{name: "cancel_confirmed_email_change", method: http.MethodPost, path: "/email/cancel", body: map[string]string{"token": "${changeToken}"}, wantStatus: http.StatusUnauthorized},
+		{name: "login_new_email", method: http.MethodPost, path: "/login", body: loginBody("new@example.com"), wantStatus: http.StatusOK, after: saveToken("user")},
+
+		// Exporting and deleting the account
+		{name: "export_account_other_user", method: http.MethodGet, path: "/users/${secondId}/export", as: "user", wantStatus: http.StatusForbidden},
+		{name: "export_account", method: http.MethodGet, path: "/users/me/export", as: "user", wantStatus: http.StatusOK},
+		{name: "logout", method: http.MethodPost, path: "/logout", as: "second", wantStatus: http.StatusOK},
+		{name: "autologin_logged_out", method: http.MethodGet, path: "/autologin", as: "second", wantStatus: http.StatusUnauthorized},
+		{name: "delete_account_wrong_password", method: http.MethodDelete, path: "/users/me", as: "user", body: map[string]string{"password": "Wr0ng-Password!"}, wantStatus: http.StatusUnauthorized},
+		{name: "delete_account", method: http.MethodDelete, path: "/users/me", as: "user", body: map[string]string{"password": testPassword}, wantStatus: http.StatusOK},
+		{name: "autologin_deleted", method: http.MethodGet, path: "/autologin", as: "user", wantStatus: http.StatusUnauthorized},
+		{name: "retrieve_deleted_alumni", method: http.MethodGet, path: "/alumni/${alumniId}", as: "admin", wantStatus: http.StatusNotFound},
+
+		// CORS preflight
+		{name: "cors_preflight", method: http.MethodOptions, path: "/users", wantStatus: http.StatusOK},
+	}
+
+	for _, s := range steps {
+		ts.run(s)
+	}
+}
@@ -6,35 +6,59 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	gotime "time"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
 	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/jsonpatch"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/storage"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/workflow"
 	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/julienschmidt/httprouter"
-	"github.com/pkg/errors"
 )
 
 const (
 	profilePictureKey = "profile"
 	authTokenKey      = "Authorization"
+	requestIdKey      = "X-Request-ID"
+	contentTypeKey    = "Content-Type"
+	eTagKey           = "ETag"
+	ifMatchKey        = "If-Match"
 	jsonDataKey       = "json"
 	userIdKey         = "userId"
 	alumniIdKey       = "alumniId"
+	templateNameKey   = "name"
+	claimIdKey        = "claimId"
+	versionKey        = "version"
+	currentUserAlias  = "me"
 	limitKey          = "limit"
 	pageKey           = "page"
 	firstnameKey      = "firstname"
 	lastnameKey       = "lastname"
 	yearGraduatedKey  = "yearGraduated"
 	statusKey         = "status"
+	emailKey          = "email"
+	adminKey          = "admin"
+	hasAlumniKey      = "hasAlumni"
+	createdAfterKey   = "createdAfter"
+	createdBeforeKey  = "createdBefore"
+	actorIdKey        = "actorId"
+	actionKey         = "action"
+	targetTypeKey     = "targetType"
+	targetIdKey       = "targetId"
+	outcomeKey        = "outcome"
 )
 
 var (
@@ -42,123 +66,852 @@ var (
 )
 
 // AddUserHandler handles an http request to add a new User
-func AddUserHandler(provideTime time.EpochProviderFunc, genUUID uuid.GenV4Func, insertUser db.InsertUserFunc, retrieveUserByEmail db.RetrieveUserByEmailFunc) http.HandlerFunc {
+func AddUserHandler(provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	insertUser db.InsertUserFunc,
+	retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	insertEmailVerification db.InsertEmailVerificationFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.UserRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		addUser := workflow.AddUser(insertUser, retrieveUserByEmail, insertRefreshToken, insertEmailVerification, getEmailTemplate, sendEmail, provideTime, genUUID)
+		resp, err := addUser(r.Context(), req)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// VerifyEmailHandler handles an http request to verify the email address of a User
+func VerifyEmailHandler(retrieveEmailVerification db.RetrieveEmailVerificationFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.VerifyEmail
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		verifyEmail := workflow.VerifyEmail(retrieveEmailVerification, deleteEmailVerifications, retrieveUserById, replaceUser, provideTime)
+		user, err := verifyEmail(r.Context(), req)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(user, w)
+	}
+}
+
+// ResendVerificationEmailHandler handles an http request to send the caller a new verification email
+func ResendVerificationEmailHandler(insertEmailVerification db.InsertEmailVerificationFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		resend := workflow.ResendVerificationEmail(insertEmailVerification, deleteEmailVerifications, getEmailTemplate, sendEmail, provideTime)
+		if err := resend(r.Context(), p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(nil, w)
+	}
+}
+
+// LoginUserHandler handles an http request to log in a User
+func LoginUserHandler(retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	retrieveLoginAttempt db.RetrieveLoginAttemptFunc,
+	recordLoginFailure db.RecordLoginFailureFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.UserRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+		loginUser := workflow.LoginUser(retrieveUserByEmail, retrieveLoginAttempt, recordLoginFailure, deleteLoginAttempts, insertRefreshToken, provideTime, genUUID)
+		resp, err := loginUser(r.Context(), req, clientIP(r))
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// CompleteMFALoginHandler handles an http request to finish logging in a User with their second factor
+func CompleteMFALoginHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	retrieveLoginAttempt db.RetrieveLoginAttemptFunc,
+	recordLoginFailure db.RecordLoginFailureFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.MFALoginRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		completeLogin := workflow.CompleteMFALogin(retrieveUserById, replaceUser, retrieveLoginAttempt, recordLoginFailure, deleteLoginAttempts, insertRefreshToken, provideTime, genUUID)
+		resp, err := completeLogin(r.Context(), req, clientIP(r))
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// EnrollTOTPHandler handles an http request to start enrolling the caller in two factor authentication
+func EnrollTOTPHandler(replaceUser db.ReplaceUserFunc, provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		enroll := workflow.EnrollTOTP(replaceUser, provideTime)
+		resp, err := enroll(r.Context(), p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// ConfirmTOTPHandler handles an http request to finish enrolling the caller in two factor authentication
+func ConfirmTOTPHandler(replaceUser db.ReplaceUserFunc, provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		var req pkg.TOTPCodeRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		confirm := workflow.ConfirmTOTP(replaceUser, provideTime)
+		resp, err := confirm(r.Context(), req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// DisableTOTPHandler handles an http request to turn off two factor authentication for the caller
+func DisableTOTPHandler(replaceUser db.ReplaceUserFunc, provideTime time.EpochProviderFunc, requireAdmin2FA bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		var req pkg.TOTPCodeRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		disable := workflow.DisableTOTP(replaceUser, provideTime, requireAdmin2FA)
+		user, err := disable(r.Context(), req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(user, w)
+	}
+}
+
+// AutoLoginUserHandler handles an http request to auto login a user
+func AutoLoginUserHandler(provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		autologin := workflow.AutoLoginUser(provideTime)
+		resp, err := autologin(r.Context(), p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// RefreshTokenHandler handles an http request to exchange a refresh token for new tokens
+func RefreshTokenHandler(rotateRefreshToken db.RotateRefreshTokenFunc, retrieveUserById db.RetrieveUserByIDFunc, provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.RefreshTokenRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		refresh := workflow.RefreshToken(rotateRefreshToken, retrieveUserById, provideTime)
+		resp, err := refresh(r.Context(), req.RefreshToken)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(resp, w)
+	}
+}
+
+// LogoutHandler handles an http request to end the caller's session
+func LogoutHandler(deleteRefreshToken db.DeleteRefreshTokenFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		logout := workflow.Logout(deleteRefreshToken)
+		if err := logout(r.Context(), p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(nil, w)
+	}
+}
+
+func ApproveUserHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	provideTime time.EpochProviderFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	genUUID uuid.GenV4Func,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		userId, err := retrieveResourceID(userIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		approveUser := workflow.ApproveUser(retrieveUserById, retrieveAlumniById, provideTime, replaceUser, insertAuditEvent, genUUID, getEmailTemplate, sendEmail)
+		user, err := approveUser(r.Context(), userId, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(user, w)
+	}
+}
+
+func DeleteAccountHandler(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	deleteImage storage.DeleteImageFunc,
+	deleteAlumni db.DeleteAlumniFunc,
+	deleteAlumniHistory db.DeleteAlumniHistoryFunc,
+	deleteResetPasswords db.DeleteResetPasswordsFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	deleteUser db.DeleteUserFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		if err := requireSelf(r, p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		var req pkg.DeleteAccountRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		deleteAccount := workflow.DeleteAccount(retrieveAlumniById, deleteImage, deleteAlumni, deleteAlumniHistory, deleteResetPasswords, deleteEmailVerifications, deleteEmailChanges, deleteUserRefreshTokens, deleteLoginAttempts, deleteUser)
+		if err := deleteAccount(r.Context(), req, p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(nil, w)
+	}
+}
+
+func RequestEmailChangeHandler(retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	insertEmailChange db.InsertEmailChangeFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		if err := requireSelf(r, p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		var req pkg.ChangeEmailRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		requestEmailChange := workflow.RequestEmailChange(retrieveUserByEmail, insertEmailChange, deleteEmailChanges, getEmailTemplate, sendEmail, provideTime)
+		if err := requestEmailChange(r.Context(), req, p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(nil, w)
+	}
+}
+
+func ConfirmEmailChangeHandler(retrieveEmailChange db.RetrieveEmailChangeFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	updateUserEmail db.UpdateUserEmailFunc,
+	updateAlumniEmail db.UpdateAlumniEmailFunc,
+	deleteResetPasswords db.DeleteResetPasswordsFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.EmailChangeToken
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		confirmEmailChange := workflow.ConfirmEmailChange(retrieveEmailChange, deleteEmailChanges, retrieveUserById, updateUserEmail, updateAlumniEmail, deleteResetPasswords, deleteEmailVerifications, provideTime)
+		user, err := confirmEmailChange(r.Context(), req)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(user, w)
+	}
+}
+
+func CancelEmailChangeHandler(retrieveEmailChange db.RetrieveEmailChangeFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.EmailChangeToken
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		cancelEmailChange := workflow.CancelEmailChange(retrieveEmailChange, deleteEmailChanges, provideTime)
+		if err := cancelEmailChange(r.Context(), req); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(nil, w)
+	}
+}
+
+func InviteAlumniHandler(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	insertInvitation db.InsertInvitationFunc,
+	deleteInvitations db.DeleteInvitationsFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		alumniId, err := retrieveResourceID(alumniIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		// The email is optional when the alumni record already has one, so an empty body is allowed
+		var req pkg.InvitationRequest
+		if err := JSONToDTO(&req, w, r); err != nil && err != io.EOF {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		inviteAlumni := workflow.InviteAlumni(retrieveAlumniById, retrieveUserByAlumniId, retrieveUserByEmail, insertInvitation, deleteInvitations, getEmailTemplate, sendEmail, insertAuditEvent, provideTime, genUUID)
+		if err := inviteAlumni(r.Context(), alumniId, req, p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(nil, w)
+	}
+}
+
+func AcceptInvitationHandler(consumeInvitation db.ConsumeInvitationFunc,
+	retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	insertUser db.InsertUserFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req pkg.AcceptInvitationRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		acceptInvitation := workflow.AcceptInvitation(consumeInvitation, retrieveUserByEmail, retrieveUserByAlumniId, insertUser, insertRefreshToken, provideTime, genUUID)
+		userResponse, err := acceptInvitation(r.Context(), req)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(userResponse, w)
+	}
+}
+
+func SearchClaimableAlumniHandler(retrieveUnclaimedAlumni db.RetrieveUnclaimedAlumniFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		params, err := getQueryParams(r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		searchClaimableAlumni := workflow.SearchClaimableAlumni(retrieveUnclaimedAlumni)
+		matches, err := searchClaimableAlumni(r.Context(), params, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(matches, w)
+	}
+}
+
+func ClaimAlumniHandler(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	retrieveProfileClaims db.RetrieveProfileClaimsFunc,
+	insertProfileClaim db.InsertProfileClaimFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		alumniId, err := retrieveResourceID(alumniIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		// The message is optional, so an empty body is allowed
+		var req pkg.ClaimRequest
+		if err := JSONToDTO(&req, w, r); err != nil && err != io.EOF {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		claimAlumni := workflow.ClaimAlumni(retrieveAlumniById, retrieveUserByAlumniId, retrieveProfileClaims, insertProfileClaim, provideTime, genUUID)
+		claim, err := claimAlumni(r.Context(), alumniId, req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(claim, w)
+	}
+}
+
+func RetrieveProfileClaimsHandler(retrieveProfileClaims db.RetrieveProfileClaimsFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		status := r.URL.Query().Get(statusKey)
+		if status != "" && status != internal.PendingClaimStatus && status != internal.ApprovedClaimStatus && status != internal.RejectedClaimStatus {
+			ServeError(apperror.New(apperror.ValidationCode, "handler - invalid claim status=%v", status), w)
+			return
+		}
+
+		retrieveClaims := workflow.RetrieveProfileClaims(retrieveProfileClaims, retrieveAlumniById)
+		claims, err := retrieveClaims(r.Context(), status, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(claims, w)
+	}
+}
+
+func ApproveProfileClaimHandler(retrieveProfileClaimById db.RetrieveProfileClaimByIDFunc,
+	retrieveProfileClaims db.RetrieveProfileClaimsFunc,
+	replaceProfileClaim db.ReplaceProfileClaimFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		claimId, err := retrieveResourceID(claimIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		// The reason is optional, so an empty body is allowed
+		var req pkg.ReviewClaimRequest
+		if err := JSONToDTO(&req, w, r); err != nil && err != io.EOF {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		approveClaim := workflow.ApproveProfileClaim(retrieveProfileClaimById, retrieveProfileClaims, replaceProfileClaim, retrieveAlumniById, retrieveUserById, retrieveUserByAlumniId, replaceUser, insertAuditEvent, provideTime, genUUID)
+		claim, err := approveClaim(r.Context(), claimId, req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(claim, w)
+	}
+}
+
+func RejectProfileClaimHandler(retrieveProfileClaimById db.RetrieveProfileClaimByIDFunc,
+	replaceProfileClaim db.ReplaceProfileClaimFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		claimId, err := retrieveResourceID(claimIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		// The reason is optional, so an empty body is allowed
+		var req pkg.ReviewClaimRequest
+		if err := JSONToDTO(&req, w, r); err != nil && err != io.EOF {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		rejectClaim := workflow.RejectProfileClaim(retrieveProfileClaimById, replaceProfileClaim, retrieveAlumniById, insertAuditEvent, provideTime, genUUID)
+		claim, err := rejectClaim(r.Context(), claimId, req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(claim, w)
+	}
+}
+
+func ExportAccountHandler(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveRoleChanges db.RetrieveRoleChangesFunc,
+	downloadImage storage.DownloadImageFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		if err := requireSelf(r, p); err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		exportAccount := workflow.ExportAccount(retrieveAlumniById, retrieveRoleChanges, downloadImage)
+		bb, err := exportAccount(r.Context(), p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeZIP(bb, "haftr-alumni-export.zip", w)
+	}
+}
+
+func RetrieveUsersHandler(retrieveUsers db.RetrieveUsersFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		params, err := getUserQueryParams(r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		retrieveUsers := workflow.RetrieveUsers(retrieveUsers)
+		res, err := retrieveUsers(r.Context(), params, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(res, w)
+	}
+}
+
+func BulkUpdateUsersHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	provideTime time.EpochProviderFunc,
+	replaceUser db.ReplaceUserFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	genUUID uuid.GenV4Func,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		var req pkg.BulkUserRequest
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
+		}
+
+		bulkUpdateUsers := workflow.BulkUpdateUsers(retrieveUserById, retrieveAlumniById, provideTime, replaceUser, deleteUserRefreshTokens, insertAuditEvent, genUUID, getEmailTemplate, sendEmail)
+		res, err := bulkUpdateUsers(r.Context(), req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(res, w)
+	}
+}
+
+func UnlockUserHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		userId, err := retrieveResourceID(userIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		unlockUser := workflow.UnlockUser(retrieveUserById, retrieveAlumniById, deleteLoginAttempts, insertAuditEvent, provideTime, genUUID)
+		user, err := unlockUser(r.Context(), userId, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(user, w)
+	}
+}
+
+func DenyUserHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	provideTime time.EpochProviderFunc,
+	replaceUser db.ReplaceUserFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	genUUID uuid.GenV4Func,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req pkg.UserRequest
-		if err := JSONToDTO(&req, w, r); err != nil {
-			ServeInternalError(err, w)
+		p := principal(r)
+
+		userId, err := retrieveResourceID(userIdKey, r)
+		if err != nil {
+			ServeError(err, w)
 			return
 		}
 
-		addUser := workflow.AddUser(insertUser, retrieveUserByEmail, provideTime, genUUID)
-		user, uToken, err := addUser(req)
-		if err != nil {
-			ServeInternalError(err, w)
+		// The reason is optional, so an empty body is allowed
+		var req pkg.DenyUserRequest
+		if err := JSONToDTO(&req, w, r); err != nil && err != io.EOF {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
 			return
 		}
 
-		resp := pkg.UserResponse{
-			User:  user,
-			Token: uToken,
+		denyUser := workflow.DenyUser(retrieveUserById, retrieveAlumniById, provideTime, replaceUser, deleteUserRefreshTokens, insertAuditEvent, genUUID, getEmailTemplate, sendEmail)
+		user, err := denyUser(r.Context(), userId, req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
 		}
 
-		ServeJSON(resp, w)
+		ServeJSON(user, w)
 	}
 }
 
-// LoginUserHandler handles an http request to log in a User
-func LoginUserHandler(retrieveUserByEmail db.RetrieveUserByEmailFunc, provideTime time.EpochProviderFunc) http.HandlerFunc {
+func GrantRoleHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertRoleChange db.InsertRoleChangeFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req pkg.UserRequest
-		if err := JSONToDTO(&req, w, r); err != nil {
-			ServeInternalError(err, w)
+		p := principal(r)
+
+		userId, err := retrieveResourceID(userIdKey, r)
+		if err != nil {
+			ServeError(err, w)
 			return
 		}
-		loginUser := workflow.LoginUser(retrieveUserByEmail, provideTime)
-		user, uToken, err := loginUser(req)
-		if err != nil {
-			ServeInternalError(err, w)
+
+		var req pkg.Role
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
 			return
 		}
 
-		resp := pkg.UserResponse{
-			User:  user,
-			Token: uToken,
+		grantRole := workflow.GrantRole(retrieveUserById, replaceUser, insertRoleChange, insertAuditEvent, provideTime, genUUID)
+		user, err := grantRole(r.Context(), userId, req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
 		}
 
-		ServeJSON(resp, w)
+		ServeJSON(user, w)
 	}
 }
 
-// AutoLoginUserHandler handles an http request to auto login a user
-func AutoLoginUserHandler(retrieveUserById db.RetrieveUserByIDFunc, provideTime time.EpochProviderFunc) http.HandlerFunc {
+func RevokeRoleHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertRoleChange db.InsertRoleChangeFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
-		autologin := workflow.AutoLoginUser(retrieveUserById, provideTime)
-		user, uToken, err := autologin(token)
+		userId, err := retrieveResourceID(userIdKey, r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		resp := pkg.UserResponse{
-			User:  user,
-			Token: uToken,
+		var req pkg.Role
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
+			return
 		}
 
-		ServeJSON(resp, w)
+		revokeRole := workflow.RevokeRole(retrieveUserById, replaceUser, insertRoleChange, deleteUserRefreshTokens, insertAuditEvent, provideTime, genUUID)
+		user, err := revokeRole(r.Context(), userId, req, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(user, w)
 	}
 }
 
-func ApproveUserHandler(retrieveUserById db.RetrieveUserByIDFunc,
-	provideTime time.EpochProviderFunc,
-	replaceUser db.ReplaceUserFunc) http.HandlerFunc {
+func RetrieveUserRolesHandler(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveRoleChanges db.RetrieveRoleChangesFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
 		userId, err := retrieveResourceID(userIdKey, r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		approveUser := workflow.ApproveUser(retrieveUserById, provideTime, replaceUser)
-		user, err := approveUser(userId, token)
+		retrieveUserRoles := workflow.RetrieveUserRoles(retrieveUserById, retrieveRoleChanges)
+		resp, err := retrieveUserRoles(r.Context(), userId, p)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		ServeJSON(user, w)
+		ServeJSON(resp, w)
 	}
 }
 
-func DenyUserHandler(retrieveUserById db.RetrieveUserByIDFunc,
+func RetrieveEmailTemplatesHandler(retrieveAllEmailTemplates db.RetrieveAllEmailTemplatesFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		retrieveEmailTemplates := workflow.RetrieveEmailTemplates(retrieveAllEmailTemplates)
+		templates, err := retrieveEmailTemplates(r.Context(), p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(templates, w)
+	}
+}
+
+func UpsertEmailTemplateHandler(upsertEmailTemplate db.UpsertEmailTemplateFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	provideTime time.EpochProviderFunc,
-	replaceUser db.ReplaceUserFunc) http.HandlerFunc {
+	genUUID uuid.GenV4Func) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
-		userId, err := retrieveResourceID(userIdKey, r)
+		name, err := retrieveResourceID(templateNameKey, r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
+			return
+		}
+
+		var req pkg.EmailTemplate
+		if err := JSONToDTO(&req, w, r); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
 			return
 		}
 
-		denyUser := workflow.DenyUser(retrieveUserById, provideTime, replaceUser)
-		user, err := denyUser(userId, token)
+		saveEmailTemplate := workflow.UpsertEmailTemplate(upsertEmailTemplate, insertAuditEvent, provideTime, genUUID)
+		et, err := saveEmailTemplate(r.Context(), name, req, p)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		ServeJSON(user, w)
+		ServeJSON(et, w)
 	}
 }
 
-func AddAlumniHandler(retrieveUserById db.RetrieveUserByIDFunc,
-	insertAlumni db.InsertAlumniFunc,
+func AddAlumniHandler(insertAlumni db.InsertAlumniFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	replaceUser db.ReplaceUserFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	provideTime time.EpochProviderFunc,
@@ -168,13 +921,13 @@ func AddAlumniHandler(retrieveUserById db.RetrieveUserByIDFunc,
 	sendEmail email.SendEmailFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			ServeInternalError(err, w)
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to parse multipart form"), w)
 			return
 		}
 
 		var req pkg.AlumniRequest
-		if err := json.Unmarshal([]byte(r.Form[jsonDataKey][0]), &req); err != nil {
-			ServeInternalError(err, w)
+		if err := json.Unmarshal([]byte(r.Form.Get(jsonDataKey)), &req); err != nil {
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode %v form field", jsonDataKey), w)
 			return
 		}
 
@@ -194,16 +947,16 @@ func AddAlumniHandler(retrieveUserById db.RetrieveUserByIDFunc,
 		}
 
 		if !isAllowedMimeType(fileData.ContentType) && fileErr == nil {
-			ServeInternalError(errors.Errorf("handler - mime type=%v is unsupported", fileData.ContentType), w)
+			ServeError(apperror.New(apperror.ValidationCode, "handler - mime type=%v is unsupported", fileData.ContentType), w)
 			return
 		}
 
-		token := getAuthToken(r)
+		p := principal(r)
 
-		addAlum := workflow.AddAlumni(retrieveUserById, insertAlumni, replaceUser, getEmailTemplate, provideTime, genUUID, uploadToS3, presignURL, sendEmail)
-		alumni, err := addAlum(req, fileData, token, fileErr != nil)
+		addAlum := workflow.AddAlumni(insertAlumni, insertHistory, insertAuditEvent, replaceUser, getEmailTemplate, provideTime, genUUID, uploadToS3, presignURL, sendEmail)
+		alumni, err := addAlum(r.Context(), req, fileData, p, fileErr != nil)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
@@ -211,9 +964,11 @@ func AddAlumniHandler(retrieveUserById db.RetrieveUserByIDFunc,
 	}
 }
 
-func UpdateAlumniHandler(retrieveUserById db.RetrieveUserByIDFunc,
-	updateAlumni db.UpdateAlumniFunc,
+func UpdateAlumniHandler(updateAlumni db.UpdateAlumniFunc,
 	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	provideTime time.EpochProviderFunc,
 	genUUID uuid.GenV4Func,
@@ -221,109 +976,139 @@ func UpdateAlumniHandler(retrieveUserById db.RetrieveUserByIDFunc,
 	presignURL storage.GetImageURLFunc,
 	sendEmail email.SendEmailFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseMultipartForm(32 << 20); err != nil {
-			ServeInternalError(err, w)
-			return
-		}
-
 		alumId, err := retrieveResourceID(alumniIdKey, r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		var req pkg.UpdateAlumniRequest
-		if err := json.Unmarshal([]byte(r.Form[jsonDataKey][0]), &req); err != nil {
-			ServeInternalError(err, w)
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			ServeError(err, w)
 			return
 		}
 
-		f, fh, fileErr := r.FormFile(profilePictureKey)
-		var buf bytes.Buffer
-		var tee io.Reader
-		var fileData pkg.FileData
-		if fileErr == nil {
-			tee = io.TeeReader(f, &buf)
+		p := principal(r)
 
-			fileData = pkg.FileData{
-				Content:     &buf,
-				Header:      fh,
-				ContentType: getFileContentType(tee, fh.Filename),
+		var alumni pkg.Alumni
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get(contentTypeKey)); mediaType == jsonpatch.ContentType {
+			patch, decodeErr := jsonpatch.Decode(r.Body)
+			if decodeErr != nil {
+				ServeError(apperror.Wrap(decodeErr, apperror.ValidationCode, "handler - unable to decode json patch"), w)
+				return
+			}
+
+			patchAlum := workflow.PatchAlumni(updateAlumni, retrieveAlumniById, insertHistory, insertAuditEvent, getEmailTemplate, provideTime, genUUID, presignURL, sendEmail)
+			alumni, err = patchAlum(r.Context(), patch, alumId, version, p)
+		} else {
+			req, fileData, skipFileUpload, formErr := updateAlumniForm(r)
+			if formErr != nil {
+				ServeError(formErr, w)
+				return
 			}
+
+			updateAlum := workflow.UpdateAlumni(updateAlumni, retrieveAlumniById, insertHistory, insertAuditEvent, getEmailTemplate, provideTime, genUUID, uploadToS3, presignURL, sendEmail)
+			alumni, err = updateAlum(r.Context(), req, alumId, version, fileData, p, skipFileUpload)
 		}
+		if apperror.CodeOf(err) == apperror.ConflictCode {
+			// Send back the alumni as it is now, so the client can reapply its changes and retry with the new ETag
+			retrieveAlum := workflow.RetrieveAlumniByID(retrieveAlumniById, retrieveUserByAlumniId, presignURL)
+			current, err := retrieveAlum(r.Context(), alumId, p)
+			if err != nil {
+				ServeError(err, w)
+				return
+			}
 
-		if !isAllowedMimeType(fileData.ContentType) && fileErr == nil {
-			ServeInternalError(errors.Errorf("handler - mime type=%v is unsupported", fileData.ContentType), w)
+			serveAlumni(current, http.StatusConflict, w)
 			return
 		}
-
-		token := getAuthToken(r)
-
-		updateAlum := workflow.UpdateAlumni(retrieveUserById, updateAlumni, retrieveAlumniById, getEmailTemplate, provideTime, genUUID, uploadToS3, presignURL, sendEmail)
-		alumni, err := updateAlum(req, alumId, fileData, token, fileErr != nil)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		ServeJSON(alumni, w)
+		serveAlumni(alumni, http.StatusOK, w)
+	}
+}
+
+// updateAlumniForm reads an alumni update from a multipart form, along with the new profile picture if there is one
+func updateAlumniForm(r *http.Request) (pkg.UpdateAlumniRequest, pkg.FileData, bool, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return pkg.UpdateAlumniRequest{}, pkg.FileData{}, false, apperror.Wrap(err, apperror.ValidationCode, "handler - unable to parse multipart form")
+	}
+
+	var req pkg.UpdateAlumniRequest
+	if err := json.Unmarshal([]byte(r.Form.Get(jsonDataKey)), &req); err != nil {
+		return pkg.UpdateAlumniRequest{}, pkg.FileData{}, false, apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode %v form field", jsonDataKey)
+	}
+
+	f, fh, fileErr := r.FormFile(profilePictureKey)
+	if fileErr != nil {
+		return req, pkg.FileData{}, true, nil
+	}
+
+	var buf bytes.Buffer
+	tee := io.TeeReader(f, &buf)
+	fileData := pkg.FileData{
+		Content:     &buf,
+		Header:      fh,
+		ContentType: getFileContentType(tee, fh.Filename),
+	}
+
+	if !isAllowedMimeType(fileData.ContentType) {
+		return pkg.UpdateAlumniRequest{}, pkg.FileData{}, false, apperror.New(apperror.ValidationCode, "handler - mime type=%v is unsupported", fileData.ContentType)
 	}
+	return req, fileData, false, nil
 }
 
 func CorsHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PATCH, OPTIONS, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, PATCH, OPTIONS, DELETE")
+		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match")
 		w.Header().Set("Access-Control-Request-Headers", "Content-Type, Authorization")
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
 func RetrieveAlumniByIDHandler(retrieveByID db.RetrieveAlumniByIDFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
 	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
-	provideTime time.EpochProviderFunc,
 	presignURL storage.GetImageURLFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
 		alumId, err := retrieveResourceID(alumniIdKey, r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		retrieveAlum := workflow.RetrieveAlumniByID(retrieveByID, retrieveUserById, retrieveUserByAlumniId, provideTime, presignURL)
-		alum, err := retrieveAlum(alumId, token)
+		retrieveAlum := workflow.RetrieveAlumniByID(retrieveByID, retrieveUserByAlumniId, presignURL)
+		alum, err := retrieveAlum(r.Context(), alumId, p)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		ServeJSON(alum, w)
+		serveAlumni(alum, http.StatusOK, w)
 	}
 }
 
-func RetrieveAlumniHandler(retrieveAlumnis db.RetrieveAllAlumniFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
-	retrieveUsersAlumniIDs db.RetrieveUsersAlumniIDsFunc,
-	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
-	provideTime time.EpochProviderFunc,
+func RetrieveAlumniHandler(retrieveAlumniAccounts db.RetrieveAlumniAccountsFunc,
 	presignURL storage.GetImageURLFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
 		params, err := getQueryParams(r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		retrieveAlumnis := workflow.RetrieveAlumni(retrieveAlumnis, retrieveUserById, retrieveUsersAlumniIDs, retrieveUserByAlumniId, provideTime, presignURL)
-		aa, pi, err := retrieveAlumnis(params, token)
+		retrieveAlumnis := workflow.RetrieveAlumni(retrieveAlumniAccounts, presignURL)
+		aa, pi, err := retrieveAlumnis(r.Context(), params, p)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
@@ -340,16 +1125,18 @@ func ForgotPasswordHandler(retrieveUserByEmail db.RetrieveUserByEmailFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	sendEmail email.SendEmailFunc,
 	insertResetPassword db.CreateResetPasswordFunc,
-	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	countResetPasswords db.CountResetPasswordsFunc,
+	provideTime time.EpochProviderFunc,
+	resetPasswordTTL gotime.Duration) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var rp pkg.ResetPassword
 		if err := JSONToDTO(&rp, w, r); err != nil {
-			ServeInternalError(err, w)
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
 			return
 		}
 
-		forgotPassword := workflow.ForgotPassword(retrieveUserByEmail, getEmailTemplate, sendEmail, insertResetPassword, provideTime)
-		if err := forgotPassword(rp.Email); err != nil {
+		forgotPassword := workflow.ForgotPassword(retrieveUserByEmail, getEmailTemplate, sendEmail, insertResetPassword, countResetPasswords, provideTime, resetPasswordTTL)
+		if err := forgotPassword(r.Context(), rp.Email); err != nil {
 			log.Print(err)
 		}
 
@@ -357,67 +1144,112 @@ func ForgotPasswordHandler(retrieveUserByEmail db.RetrieveUserByEmailFunc,
 	}
 }
 
-func SetNewPasswordHandler(retrieveResetPassword db.FindResetPasswordFunc,
+func SetNewPasswordHandler(consumeResetPassword db.ConsumeResetPasswordFunc,
 	deleteResetPasswords db.DeleteResetPasswordsFunc,
 	retrieveUserByEmail db.RetrieveUserByEmailFunc,
 	replaceUser db.ReplaceUserFunc,
-	provideTime time.EpochProviderFunc) http.HandlerFunc {
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var rp pkg.ResetPassword
 		if err := JSONToDTO(&rp, w, r); err != nil {
-			ServeInternalError(err, w)
+			ServeError(apperror.Wrap(err, apperror.ValidationCode, "handler - unable to decode request body"), w)
 			return
 		}
 
-		setNewPassword := workflow.SetNewPassword(retrieveResetPassword, deleteResetPasswords, retrieveUserByEmail, replaceUser, provideTime)
-		user, uToken, err := setNewPassword(rp)
+		setNewPassword := workflow.SetNewPassword(consumeResetPassword, deleteResetPasswords, retrieveUserByEmail, replaceUser, insertRefreshToken, deleteUserRefreshTokens, provideTime, genUUID)
+		userResponse, err := setNewPassword(r.Context(), rp)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		userResponse := pkg.UserResponse{
-			User:  user,
-			Token: uToken,
-		}
-
 		ServeJSON(userResponse, w)
 	}
 }
 
-func ExportCSVHandler(retrieveAlumnis db.RetrieveAllAlumniFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
-	retrieveUsersAlumniIDs db.RetrieveUsersAlumniIDsFunc,
+func ExportCSVHandler(retrieveAlumniAccounts db.RetrieveAlumniAccountsFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
 	presignURL storage.GetImageURLFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
 		params, err := getQueryParams(r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
+			return
+		}
+
+		params.Limit = -1
+
+		exportCsv := workflow.ExportCSV(retrieveAlumniAccounts, insertAuditEvent, provideTime, genUUID, presignURL)
+		bb, err := exportCsv(r.Context(), params, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeCSV(bb, "alumnis.csv", w)
+	}
+}
+
+func RetrieveAuditEventsHandler(retrieveAuditEvents db.RetrieveAuditEventsFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		params, err := getAuditQueryParams(r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		retrieveEvents := workflow.RetrieveAuditEvents(retrieveAuditEvents)
+		res, err := retrieveEvents(r.Context(), params, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(res, w)
+	}
+}
+
+func ExportAuditCSVHandler(retrieveAuditEvents db.RetrieveAuditEventsFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		params, err := getAuditQueryParams(r)
+		if err != nil {
+			ServeError(err, w)
 			return
 		}
 
 		params.Limit = -1
 
-		exportCsv := workflow.ExportCSV(retrieveAlumnis, retrieveUserById, retrieveUsersAlumniIDs, provideTime, presignURL)
-		bb, err := exportCsv(params, token)
+		exportCsv := workflow.ExportAuditCSV(retrieveAuditEvents, insertAuditEvent, provideTime, genUUID)
+		bb, err := exportCsv(r.Context(), params, p)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		ServeCSV(bb, w)
+		ServeCSV(bb, "audit.csv", w)
 	}
 }
 
 func HappyBirthdayHandler(retrieveAlumnis db.RetrieveAllAlumniFunc, provideTime time.EpochProviderFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		happyBirthday := workflow.HappyBirthday(retrieveAlumnis, provideTime)
-		aa, err := happyBirthday()
+		aa, err := happyBirthday(r.Context())
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
@@ -426,24 +1258,26 @@ func HappyBirthdayHandler(retrieveAlumnis db.RetrieveAllAlumniFunc, provideTime
 }
 
 func ChangeAlumniPrivacyHandler(retrieveByID db.RetrieveAlumniByIDFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
 	changePrivacyStatus db.ChangeAlumniPrivacyFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
 	presignURL storage.GetImageURLFunc,
 	isPublic bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := getAuthToken(r)
+		p := principal(r)
 
 		alumId, err := retrieveResourceID(alumniIdKey, r)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
-		changeStatus := workflow.ChangeAlumniPrivacy(retrieveByID, retrieveUserById, changePrivacyStatus, provideTime, presignURL, isPublic)
-		a, err := changeStatus(alumId, token)
+		changeStatus := workflow.ChangeAlumniPrivacy(retrieveByID, changePrivacyStatus, insertHistory, insertAuditEvent, provideTime, genUUID, presignURL, isPublic)
+		a, err := changeStatus(r.Context(), alumId, p)
 		if err != nil {
-			ServeInternalError(err, w)
+			ServeError(err, w)
 			return
 		}
 
@@ -451,56 +1285,191 @@ func ChangeAlumniPrivacyHandler(retrieveByID db.RetrieveAlumniByIDFunc,
 	}
 }
 
+func RetrieveAlumniHistoryHandler(retrieveByID db.RetrieveAlumniByIDFunc,
+	retrieveHistory db.RetrieveAlumniHistoryFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		alumId, err := retrieveResourceID(alumniIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		retrieveAlumHistory := workflow.RetrieveAlumniHistory(retrieveByID, retrieveHistory)
+		hh, err := retrieveAlumHistory(r.Context(), alumId, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		ServeJSON(hh, w)
+	}
+}
+
+func RestoreAlumniHandler(retrieveByID db.RetrieveAlumniByIDFunc,
+	retrieveHistoryVersion db.RetrieveAlumniHistoryVersionFunc,
+	updateAlumni db.UpdateAlumniFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	presignURL storage.GetImageURLFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := principal(r)
+
+		alumId, err := retrieveResourceID(alumniIdKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		v, err := retrieveResourceID(versionKey, r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+		toVersion, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || toVersion < 1 {
+			ServeError(apperror.New(apperror.ValidationCode, "handler - %v=%v is not a version", versionKey, v), w)
+			return
+		}
+
+		version, err := ifMatchVersion(r)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		restoreAlum := workflow.RestoreAlumni(retrieveByID, retrieveHistoryVersion, updateAlumni, insertHistory, insertAuditEvent, provideTime, genUUID, presignURL)
+		a, err := restoreAlum(r.Context(), alumId, toVersion, version, p)
+		if err != nil {
+			ServeError(err, w)
+			return
+		}
+
+		serveAlumni(a, http.StatusOK, w)
+	}
+}
+
 // JSONToDTO decodes an http request JSON body to a data transfer object
 func JSONToDTO(DTO interface{}, w http.ResponseWriter, r *http.Request) error {
 	decoder := json.NewDecoder(r.Body)
 	return decoder.Decode(&DTO)
 }
 
-// ServeInternalError serves a 500 error
-func ServeInternalError(err error, w http.ResponseWriter) {
-	var newError struct {
-		Message string `json:"message"`
+// ServeError serves an error with the http status and code matching its apperror.Code
+func ServeError(err error, w http.ResponseWriter) {
+	newError := struct {
+		Code    apperror.Code `json:"code"`
+		Message string        `json:"message"`
+	}{
+		Code:    apperror.CodeOf(err),
+		Message: err.Error(),
 	}
-	newError.Message = err.Error()
-	bb, err := json.MarshalIndent(newError, "", "\t")
-	if err != nil {
-		ServeInternalError(err, w)
+	bb, _ := json.MarshalIndent(newError, "", "\t")
+
+	if newError.Code == apperror.InternalCode {
+		log.Print(err)
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(http.StatusInternalServerError)
+	w.WriteHeader(apperror.HTTPStatus(err))
 	w.Write(bb)
 }
 
-//ServeJSON returns a JSON response for an http request
+// ServeJSON returns a JSON response for an http request
 func ServeJSON(res interface{}, w http.ResponseWriter) {
+	ServeJSONWithStatus(res, http.StatusOK, w)
+}
+
+// ServeJSONWithStatus returns a JSON response with the given http status for an http request
+func ServeJSONWithStatus(res interface{}, status int, w http.ResponseWriter) {
 	bb, err := json.Marshal(res)
 	if err != nil {
-		ServeInternalError(err, w)
+		ServeError(err, w)
+		return
 	}
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PATCH, OPTIONS, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, PATCH, OPTIONS, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match")
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(bb)
 }
 
-func ServeCSV(bb []byte, w http.ResponseWriter) {
+// serveAlumni returns an alumni as a JSON response, with its version as the ETag when the caller can see the full record
+func serveAlumni(a pkg.AlumniInterface, status int, w http.ResponseWriter) {
+	if full, ok := a.(pkg.Alumni); ok {
+		w.Header().Set(eTagKey, fmt.Sprintf(`"%d"`, full.Version))
+		w.Header().Set("Access-Control-Expose-Headers", eTagKey)
+	}
+	ServeJSONWithStatus(a, status, w)
+}
+
+// ServeCSV returns a CSV file as a download for an http request
+func ServeCSV(bb []byte, filename string, w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, "alumnis.csv"))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, filename))
+	w.Write(bb)
+}
+
+// ServeZIP returns a ZIP file as a download for an http request
+func ServeZIP(bb []byte, filename string, w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, filename))
 	w.Write(bb)
 }
 
+// requireSelf returns an error unless the userId in the path is the caller, either by id or as "me"
+func requireSelf(r *http.Request, p auth.Principal) error {
+	userId, err := retrieveResourceID(userIdKey, r)
+	if err != nil {
+		return err
+	}
+	if userId != currentUserAlias && userId != p.User.ID.Val() {
+		return apperror.New(apperror.ForbiddenCode, "handler - userId=%v can only do this for their own account", p.User.ID)
+	}
+	return nil
+}
+
+// clientIP returns the address of the client, as seen by API Gateway when running in Lambda. X-Forwarded-For is
+// never used since the client can set it to anything, which would get around the per IP login throttle
+func clientIP(r *http.Request) string {
+	if rc, ok := core.GetAPIGatewayContextFromContext(r.Context()); ok && rc.Identity.SourceIP != "" {
+		return rc.Identity.SourceIP
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ifMatchVersion returns the alumni version in the If-Match header of a request, or 0 when any version may be changed
+func ifMatchVersion(r *http.Request) (int64, error) {
+	tag := strings.TrimSpace(r.Header.Get(ifMatchKey))
+	if tag == "" || tag == "*" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+	if err != nil || version < 1 || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+		return 0, apperror.New(apperror.ValidationCode, "handler - %v=%v is not an ETag returned for an alumni", ifMatchKey, tag)
+	}
+	return version, nil
+}
+
 // retrieveResourceID retrieves a resource id from an incoming http request
 func retrieveResourceID(idKey string, r *http.Request) (string, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	tID := params.ByName(idKey)
 	if tID == "" {
-		return tID, fmt.Errorf("%s not found in request context", idKey)
+		return tID, apperror.New(apperror.ValidationCode, "handler - %s not found in request context", idKey)
 	}
 	return tID, nil
 }
@@ -512,14 +1481,14 @@ func getQueryParams(r *http.Request) (pkg.QueryParams, error) {
 	if r.URL.Query().Get(limitKey) != "" {
 		lim, err = strconv.Atoi(r.URL.Query().Get(limitKey))
 		if err != nil {
-			return pkg.QueryParams{}, errors.Wrapf(err, "handler - error parsing limit param=%v", r.URL.Query().Get(limitKey))
+			return pkg.QueryParams{}, apperror.Wrap(err, apperror.ValidationCode, "handler - error parsing limit param=%v", r.URL.Query().Get(limitKey))
 		}
 	}
 
 	if r.URL.Query().Get(pageKey) != "" {
 		page, err = strconv.Atoi(r.URL.Query().Get(pageKey))
 		if err != nil {
-			return pkg.QueryParams{}, errors.Wrapf(err, "handler - error parsing page param=%v", r.URL.Query().Get(pageKey))
+			return pkg.QueryParams{}, apperror.Wrap(err, apperror.ValidationCode, "handler - error parsing page param=%v", r.URL.Query().Get(pageKey))
 		}
 	}
 
@@ -546,12 +1515,116 @@ func getQueryParams(r *http.Request) (pkg.QueryParams, error) {
 	return params, nil
 }
 
-func getAuthToken(r *http.Request) string {
-	authHeader := r.Header.Get(authTokenKey)
-	if authHeader != "" {
-		return strings.Split(authHeader, " ")[1]
+func getUserQueryParams(r *http.Request) (pkg.UserQueryParams, error) {
+	q := r.URL.Query()
+	params := pkg.UserQueryParams{
+		Limit:         internal.DefaultPageLimit,
+		Page:          1,
+		Email:         q.Get(emailKey),
+		Status:        q.Get(statusKey),
+		CreatedAfter:  q.Get(createdAfterKey),
+		CreatedBefore: q.Get(createdBeforeKey),
+	}
+
+	if q.Get(limitKey) != "" {
+		lim, err := strconv.ParseInt(q.Get(limitKey), 10, 64)
+		if err != nil || lim < 1 {
+			return pkg.UserQueryParams{}, apperror.New(apperror.ValidationCode, "handler - limit param=%v must be a positive number", q.Get(limitKey))
+		}
+		params.Limit = lim
+	}
+
+	if q.Get(pageKey) != "" {
+		page, err := strconv.ParseInt(q.Get(pageKey), 10, 64)
+		if err != nil || page < 1 {
+			return pkg.UserQueryParams{}, apperror.New(apperror.ValidationCode, "handler - page param=%v must be a positive number", q.Get(pageKey))
+		}
+		params.Page = page
+	}
+
+	if params.Status != "" && params.Status != internal.PendingUserStatus && params.Status != internal.ApprovedUserStatus && params.Status != internal.DeniedUserStatus {
+		return pkg.UserQueryParams{}, apperror.New(apperror.ValidationCode, "handler - unknown status param=%v", params.Status)
+	}
+
+	for key, dst := range map[string]**bool{adminKey: &params.Admin, hasAlumniKey: &params.HasAlumni} {
+		if q.Get(key) == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(q.Get(key))
+		if err != nil {
+			return pkg.UserQueryParams{}, apperror.Wrap(err, apperror.ValidationCode, "handler - error parsing %v param=%v", key, q.Get(key))
+		}
+		*dst = &b
+	}
+
+	for key, v := range map[string]string{createdAfterKey: params.CreatedAfter, createdBeforeKey: params.CreatedBefore} {
+		if v == "" {
+			continue
+		}
+		if _, err := time.NewISO8601(v); err != nil {
+			return pkg.UserQueryParams{}, apperror.Wrap(err, apperror.ValidationCode, "handler - error parsing %v param=%v", key, v)
+		}
+	}
+
+	return params, nil
+}
+
+func getAuditQueryParams(r *http.Request) (pkg.AuditQueryParams, error) {
+	q := r.URL.Query()
+	params := pkg.AuditQueryParams{
+		Limit:         internal.DefaultPageLimit,
+		Page:          1,
+		ActorID:       q.Get(actorIdKey),
+		Action:        q.Get(actionKey),
+		TargetType:    q.Get(targetTypeKey),
+		TargetID:      q.Get(targetIdKey),
+		Outcome:       q.Get(outcomeKey),
+		CreatedAfter:  q.Get(createdAfterKey),
+		CreatedBefore: q.Get(createdBeforeKey),
+	}
+
+	if q.Get(limitKey) != "" {
+		lim, err := strconv.ParseInt(q.Get(limitKey), 10, 64)
+		if err != nil || lim < 1 {
+			return pkg.AuditQueryParams{}, apperror.New(apperror.ValidationCode, "handler - limit param=%v must be a positive number", q.Get(limitKey))
+		}
+		params.Limit = lim
+	}
+
+	if q.Get(pageKey) != "" {
+		page, err := strconv.ParseInt(q.Get(pageKey), 10, 64)
+		if err != nil || page < 1 {
+			return pkg.AuditQueryParams{}, apperror.New(apperror.ValidationCode, "handler - page param=%v must be a positive number", q.Get(pageKey))
+		}
+		params.Page = page
+	}
+
+	if params.TargetType != "" {
+		known := false
+		for _, t := range internal.AuditTargets {
+			if params.TargetType == t {
+				known = true
+			}
+		}
+		if !known {
+			return pkg.AuditQueryParams{}, apperror.New(apperror.ValidationCode, "handler - unknown targetType param=%v", params.TargetType)
+		}
+	}
+
+	if params.Outcome != "" && params.Outcome != internal.SuccessOutcome && params.Outcome != internal.DeniedOutcome && params.Outcome != internal.FailureOutcome {
+		return pkg.AuditQueryParams{}, apperror.New(apperror.ValidationCode, "handler - unknown outcome param=%v", params.Outcome)
+	}
+
+	for key, v := range map[string]string{createdAfterKey: params.CreatedAfter, createdBeforeKey: params.CreatedBefore} {
+		if v == "" {
+			continue
+		}
+		if _, err := time.NewISO8601(v); err != nil {
+			return pkg.AuditQueryParams{}, apperror.Wrap(err, apperror.ValidationCode, "handler - error parsing %v param=%v", key, v)
+		}
 	}
-	return ""
+
+	return params, nil
 }
 
 func getFileContentType(r io.Reader, fn string) string {
@@ -0,0 +1,57 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/workflow"
+	"github.com/awslabs/aws-lambda-go-api-proxy/core"
+)
+
+// MiddlewareFunc wraps an http handler so it is only called for requests meeting an auth requirement
+type MiddlewareFunc func(req auth.Requirement, next http.HandlerFunc) http.HandlerFunc
+
+// AuthMiddleware resolves the caller of a request once and stores them in the request context
+func AuthMiddleware(retrieveUserById db.RetrieveUserByIDFunc, retrieveRefreshToken db.RetrieveRefreshTokenByIDFunc, provideTime time.EpochProviderFunc, genUUID uuid.GenV4Func, requireAdmin2FA bool) MiddlewareFunc {
+	authenticate := workflow.Authenticate(retrieveUserById, retrieveRefreshToken, provideTime, requireAdmin2FA)
+	return func(req auth.Requirement, next http.HandlerFunc) http.HandlerFunc {
+		if req == auth.Anonymous {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			tokenString, ok := auth.BearerToken(r)
+			if !ok {
+				ServeError(apperror.New(apperror.UnauthorizedCode, "handler - missing or malformed %v header", authTokenKey), w)
+				return
+			}
+
+			p, err := authenticate(r.Context(), tokenString, req)
+			if err != nil {
+				ServeError(err, w)
+				return
+			}
+
+			p.IP, p.RequestID, p.CorrelationID = clientIP(r), requestID(r, genUUID), r.Header.Get(requestIdKey)
+			next(w, r.WithContext(auth.NewContext(r.Context(), p)))
+		}
+	}
+}
+
+// requestID returns the ID API Gateway gave a request, or a generated one when not running behind API Gateway.
+// The X-Request-ID header is set by the caller so it is never used in its place
+func requestID(r *http.Request, genUUID uuid.GenV4Func) string {
+	if rc, ok := core.GetAPIGatewayContextFromContext(r.Context()); ok && rc.RequestID != "" {
+		return rc.RequestID
+	}
+	return genUUID().Val()
+}
+
+// principal returns the caller resolved by AuthMiddleware
+func principal(r *http.Request) auth.Principal {
+	p, _ := auth.FromContext(r.Context())
+	return p
+}
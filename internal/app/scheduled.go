@@ -1,22 +1,24 @@
 package app
 
 import (
+	"context"
+
 	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/workflow"
 )
 
-type ScheduledFunc func() error
+type ScheduledFunc func(ctx context.Context) error
 
 func HappyBirthdayEmailScheduled(retrieveAlumnis db.RetrieveAllAlumniFunc,
 	provideTime time.EpochProviderFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
 	sendEmail email.SendEmailFunc) ScheduledFunc {
-	return func() error {
+	return func(ctx context.Context) error {
 		happyBirthdayEmail := workflow.HappyBirthdayEmail(retrieveAlumnis, provideTime, getEmailTemplate, retrieveUserByAlumniId, sendEmail)
-		if err := happyBirthdayEmail(); err != nil {
+		if err := happyBirthdayEmail(ctx); err != nil {
 			return err
 		}
 		return nil
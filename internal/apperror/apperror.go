@@ -0,0 +1,81 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Code is a stable, machine readable identifier for a category of error
+type Code string
+
+const (
+	NotFoundCode     Code = "NOT_FOUND"
+	UnauthorizedCode Code = "UNAUTHORIZED"
+	ForbiddenCode    Code = "FORBIDDEN"
+	ConflictCode     Code = "CONFLICT"
+	ValidationCode   Code = "VALIDATION"
+	RateLimitedCode  Code = "RATE_LIMITED"
+	InternalCode     Code = "INTERNAL"
+)
+
+// Error is an error tagged with a Code
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+// Error returns the message of the error followed by the message of any wrapped error
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return fmt.Sprintf("%v: %v", e.Message, e.Err.Error())
+}
+
+// Unwrap returns the wrapped error, if any
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates a new error with the given code
+func New(code Code, format string, args ...interface{}) error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap tags an existing error with the given code. Wrap returns nil if err is nil
+func Wrap(err error, code Code, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...), Err: err}
+}
+
+// CodeOf returns the outermost Code found in the chain of err, defaulting to InternalCode
+func CodeOf(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return InternalCode
+}
+
+// HTTPStatus returns the http status code for the Code of err
+func HTTPStatus(err error) int {
+	switch CodeOf(err) {
+	case NotFoundCode:
+		return http.StatusNotFound
+	case UnauthorizedCode:
+		return http.StatusUnauthorized
+	case ForbiddenCode:
+		return http.StatusForbidden
+	case ConflictCode:
+		return http.StatusConflict
+	case ValidationCode:
+		return http.StatusBadRequest
+	case RateLimitedCode:
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+)
+
+const (
+	authHeaderKey = "Authorization"
+	bearerScheme  = "bearer"
+)
+
+// Requirement is the level of authentication a route requires
+type Requirement int
+
+const (
+	// Anonymous routes can be called without a token
+	Anonymous Requirement = iota
+	// Authenticated routes require a valid token
+	Authenticated
+	// Approved routes require a valid token belonging to an approved user or an admin
+	Approved
+	// Admin routes require a valid token belonging to a user with an administrative role
+	Admin
+)
+
+// Principal is the resolved caller of a request
+type Principal struct {
+	User      internal.User
+	SessionID uuid.V4
+	// RolesWithheld is set when the roles of the user are not honored for this request, such as an admin yet to enable two factor authentication
+	RolesWithheld bool
+	// IP and RequestID identify where the request came from so the actions of the principal can be audited
+	IP        string
+	RequestID string
+	// CorrelationID is the X-Request-ID sent by the client, if any. It is not trusted to identify the request
+	CorrelationID string
+}
+
+// Roles returns the roles honored for the principal
+func (p Principal) Roles() []internal.Role {
+	if p.RolesWithheld {
+		return nil
+	}
+	return p.User.EffectiveRoles()
+}
+
+// IsAdmin returns whether the principal holds any administrative role
+func (p Principal) IsAdmin() bool {
+	return len(p.Roles()) > 0
+}
+
+// IsApproved returns whether the principal has been approved or is an admin
+func (p Principal) IsApproved() bool {
+	return p.User.IsApproved() || p.IsAdmin()
+}
+
+// OwnsAlumni returns whether the alumni with the given ID is linked to the principal
+func (p Principal) OwnsAlumni(alumniId string) bool {
+	return alumniId != "" && p.User.AlumniID.Val() == alumniId
+}
+
+type principalContextKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(Principal)
+	return p, ok
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer <token>" header
+func BearerToken(r *http.Request) (string, bool) {
+	parts := strings.Fields(r.Header.Get(authHeaderKey))
+	if len(parts) != 2 || !strings.EqualFold(parts[0], bearerScheme) {
+		return "", false
+	}
+	return parts[1], true
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+)
+
+// Permission is an action that can be granted to a user through their roles
+type Permission string
+
+const (
+	ApproveUsersPermission    Permission = "APPROVE_USERS"
+	ViewAlumniPermission      Permission = "VIEW_ALUMNI"
+	EditAlumniPermission      Permission = "EDIT_ALUMNI"
+	ExportCSVPermission       Permission = "EXPORT_CSV"
+	ManageTemplatesPermission Permission = "MANAGE_TEMPLATES"
+	ManageRolesPermission     Permission = "MANAGE_ROLES"
+	ViewAuditPermission       Permission = "VIEW_AUDIT"
+)
+
+var rolePermissions = map[string][]Permission{
+	internal.SuperAdminRole: {
+		ApproveUsersPermission,
+		ViewAlumniPermission,
+		EditAlumniPermission,
+		ExportCSVPermission,
+		ManageTemplatesPermission,
+		ManageRolesPermission,
+		ViewAuditPermission,
+	},
+	internal.DivisionAdminRole: {
+		ApproveUsersPermission,
+		ViewAlumniPermission,
+		EditAlumniPermission,
+		ExportCSVPermission,
+	},
+	internal.ClassCoordinatorRole: {
+		ViewAlumniPermission,
+		EditAlumniPermission,
+		ExportCSVPermission,
+	},
+	internal.StaffRole: {
+		ViewAlumniPermission,
+		ExportCSVPermission,
+	},
+}
+
+// Grants returns whether the role grants the permission
+func Grants(r internal.Role, perm Permission) bool {
+	for _, rp := range rolePermissions[r.Name] {
+		if rp == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// Can returns whether any of the principal's roles grant the permission, regardless of scope
+func (p Principal) Can(perm Permission) bool {
+	for _, r := range p.Roles() {
+		if Grants(r, perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanForAlumni returns whether any of the principal's roles grant the permission over the alumni
+func (p Principal) CanForAlumni(perm Permission, a internal.Alumni) bool {
+	for _, r := range p.Roles() {
+		if Grants(r, perm) && r.Covers(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// AlumniScope returns the alumni the principal can act on with the permission
+func (p Principal) AlumniScope(perm Permission) internal.AlumniScope {
+	var scope internal.AlumniScope
+	for _, r := range p.Roles() {
+		if !Grants(r, perm) {
+			continue
+		}
+		switch r.Name {
+		case internal.DivisionAdminRole:
+			scope.Divisions = append(scope.Divisions, r.Division)
+		case internal.ClassCoordinatorRole:
+			scope.GraduationYears = append(scope.GraduationYears, r.GraduationYear)
+		default:
+			return internal.AlumniScope{All: true}
+		}
+	}
+	return scope
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+// benchmarkAlumni is how many alumni, each with an account, the benchmarks list from
+const benchmarkAlumni = 1000
+
+var benchmarkParams = pkg.QueryParams{Limit: internal.DefaultPageLimit, Page: 1, Status: internal.ApprovedUserStatus}
+
+// benchmarkStores returns a seeded in-memory store, and a seeded Mongo store when MONGO_URI points at a server
+// the benchmark may create and drop a database on
+func benchmarkStores(b *testing.B) map[string]Store {
+	stores := map[string]Store{"memory": NewMemoryStore()}
+
+	if uri := os.Getenv("MONGO_URI"); uri != "" {
+		ctx := context.Background()
+		client := NewClient(uri, fmt.Sprintf("haftr_bench_%d", gotime.Now().UnixNano()))
+		database, err := client.Database(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() {
+			database.Drop(ctx)
+			client.Disconnect(ctx)
+		})
+		if _, err := RunMigrations(database, func() time.Epoch { return 0 })(ctx); err != nil {
+			b.Fatal(err)
+		}
+		stores["mongo"] = NewMongoStore(database)
+	}
+
+	for name, store := range stores {
+		if err := seedAlumniAccounts(store, benchmarkAlumni); err != nil {
+			b.Fatalf("unable to seed %v store, %v", name, err)
+		}
+	}
+	return stores
+}
+
+func seedAlumniAccounts(store Store, n int) error {
+	ctx := context.Background()
+	for i := 0; i < n; i++ {
+		alumniId := uuid.V4(fmt.Sprintf("00000000-0000-4000-8000-%012d", 2*i))
+		a := internal.Alumni{ID: alumniId, Firstname: "First", Lastname: fmt.Sprintf("Last%d", i), IsPublic: true}
+		if err := store.Alumni.Insert(ctx, a); err != nil {
+			return err
+		}
+
+		u := internal.User{
+			ID:            uuid.V4(fmt.Sprintf("00000000-0000-4000-8000-%012d", 2*i+1)),
+			Email:         fmt.Sprintf("alumni%d@example.com", i),
+			AlumniID:      alumniId,
+			Status:        internal.ApprovedUserStatus,
+			EmailVerified: true,
+		}
+		if err := store.Users.Insert(ctx, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BenchmarkRetrieveAlumniAccounts lists a page of alumni joined with their accounts in one query
+func BenchmarkRetrieveAlumniAccounts(b *testing.B) {
+	ctx := context.Background()
+	for name, store := range benchmarkStores(b) {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				aa, _, err := store.Alumni.RetrieveAccounts(ctx, benchmarkParams, "", internal.AlumniScope{All: true})
+				if err != nil || len(aa) != internal.DefaultPageLimit {
+					b.Fatalf("got %v alumni, err=%v", len(aa), err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkRetrieveAlumniThenUsers lists the same page the way alumni used to be listed, loading the alumni ids
+// of every account with the status and then looking up the account of each alumnus on the page
+func BenchmarkRetrieveAlumniThenUsers(b *testing.B) {
+	ctx := context.Background()
+	for name, store := range benchmarkStores(b) {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				uu, _, err := store.Users.Retrieve(ctx, pkg.UserQueryParams{Status: benchmarkParams.Status, Limit: benchmarkAlumni, Page: 1}, internal.AlumniScope{All: true})
+				if err != nil {
+					b.Fatal(err)
+				}
+				ids := []string{}
+				for _, u := range uu {
+					ids = append(ids, u.AlumniID.Val())
+				}
+
+				aa, _, err := store.Alumni.RetrieveAll(ctx, benchmarkParams, "", internal.AlumniScope{All: true})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, a := range aa {
+					if !containsString(ids, a.ID.Val()) {
+						continue
+					}
+					if _, err := store.Users.RetrieveByAlumniID(ctx, a.ID.Val()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
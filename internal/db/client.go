@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"log"
+	"sync"
+	gotime "time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	// A Lambda container serves one request at a time, so it never needs many connections
+	maxPoolSize         = 10
+	connectTimeout      = 10 * gotime.Second
+	pingTimeout         = 2 * gotime.Second
+	healthCheckInterval = gotime.Minute
+)
+
+// Client is a pooled connection to a mongo database, meant to be created once and shared across Lambda invocations.
+// When it has sat idle for a while, say while the container was frozen, it pings the server before being used and
+// reconnects if the pool no longer works
+type Client struct {
+	uri      string
+	dbName   string
+	mu       sync.Mutex
+	client   *mongo.Client
+	database *mongo.Database
+	lastUsed gotime.Time
+}
+
+// NewClient returns a Client for the database, which connects on first use
+func NewClient(uri, dbName string) *Client {
+	return &Client{uri: uri, dbName: dbName}
+}
+
+// Database returns the database, connecting on first use and reconnecting when a health check fails.
+// The same *mongo.Database is returned until the client reconnects
+func (c *Client) Database(ctx context.Context) (*mongo.Database, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := gotime.Now()
+	if c.client != nil && now.Sub(c.lastUsed) > healthCheckInterval {
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		err := c.client.Ping(pingCtx, readpref.Primary())
+		cancel()
+		if err != nil {
+			log.Printf("Reconnecting to mongo after failed health check, %v", err)
+			c.client.Disconnect(context.Background())
+			c.client, c.database = nil, nil
+		}
+	}
+
+	if c.client == nil {
+		connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		defer cancel()
+		opts := options.Client().ApplyURI(c.uri).SetMaxPoolSize(maxPoolSize)
+		client, err := mongo.Connect(connectCtx, opts)
+		if err != nil {
+			return nil, errors.Wrap(err, "db - unable to connect to mongo")
+		}
+		c.client, c.database = client, client.Database(c.dbName)
+	}
+
+	c.lastUsed = now
+	return c.database, nil
+}
+
+// Disconnect closes every connection in the pool
+func (c *Client) Disconnect(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+	err := c.client.Disconnect(ctx)
+	c.client, c.database = nil, nil
+	return err
+}
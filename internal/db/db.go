@@ -1,47 +1,156 @@
 package db
 
 import (
+	"context"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal"
 	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
 )
 
 const (
-	usersCollectionName          = "users"
-	alumnisCollectionName        = "alumnis"
-	emailTemplatesCollectionName = "emailTemplates"
-	resetPasswordsCollectionName = "resetPasswords"
+	usersCollectionName              = "users"
+	alumnisCollectionName            = "alumnis"
+	emailTemplatesCollectionName     = "emailTemplates"
+	resetPasswordsCollectionName     = "resetPasswords"
+	refreshTokensCollectionName      = "refreshTokens"
+	roleChangesCollectionName        = "roleChanges"
+	emailVerificationsCollectionName = "emailVerifications"
+	loginAttemptsCollectionName      = "loginAttempts"
+	emailChangesCollectionName       = "emailChanges"
+	invitationsCollectionName        = "invitations"
+	profileClaimsCollectionName      = "profileClaims"
+	alumniHistoryCollectionName      = "alumniHistory"
+	auditEventsCollectionName        = "auditEvents"
+	migrationsCollectionName         = "migrations"
 )
 
+// operationTimeout bounds every database operation, on top of any deadline of the request it is made for
+const operationTimeout = 5 * gotime.Second
+
+// duplicateKeyCode is the code mongo fails a write with when it would violate a unique index
+const duplicateKeyCode = 11000
+
 var (
 	zeroInt64 = int64(0)
 )
 
-type InsertUserFunc func(u internal.User) error
+type InsertUserFunc func(ctx context.Context, u internal.User) error
+
+type RetrieveUserByEmailFunc func(ctx context.Context, email string) (internal.User, error)
+
+type RetrieveUserByIDFunc func(ctx context.Context, id string) (internal.User, error)
+
+type RetrieveUserByAlumniIDFunc func(ctx context.Context, alumniId string) (internal.User, error)
+
+// RetrieveUsersFunc lists the users matching the params. Unless the scope is All, only users linked to an alumni record
+// within the scope are listed
+type RetrieveUsersFunc func(ctx context.Context, params pkg.UserQueryParams, scope internal.AlumniScope) ([]internal.User, pkg.PageInfo, error)
+
+type ReplaceUserFunc func(ctx context.Context, u internal.User) error
+
+type UpdateUserEmailFunc func(ctx context.Context, id, oldEmail, newEmail string, at time.Epoch) error
+
+type DeleteUserFunc func(ctx context.Context, id string) error
+
+type DeleteAlumniFunc func(ctx context.Context, id string) error
+
+type UpdateAlumniEmailFunc func(ctx context.Context, id, email string) error
+
+type InsertAlumniFunc func(ctx context.Context, a internal.Alumni) error
+
+// UpdateAlumniFunc applies an update to an alumni only while it is still at the given version, moves it to the next one
+// and returns it as updated
+type UpdateAlumniFunc func(ctx context.Context, id string, version int64, a internal.UpdateAlumniRequest) (internal.Alumni, error)
+
+type RetrieveAlumniByIDFunc func(ctx context.Context, id string) (internal.Alumni, error)
+
+// ChangeAlumniPrivacyFunc changes the privacy of an alumni the same way UpdateAlumniFunc updates it
+type ChangeAlumniPrivacyFunc func(ctx context.Context, id string, version int64, isPublic bool) (internal.Alumni, error)
+
+type RetrieveAllAlumniFunc func(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.Alumni, pkg.PageInfo, error)
+
+type RetrieveAlumniAccountsFunc func(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.AlumniAccount, pkg.PageInfo, error)
+
+// RetrieveUnclaimedAlumniFunc finds the alumni with exactly the first name, last name and graduation year searched for
+// that are not linked to any user account
+type RetrieveUnclaimedAlumniFunc func(ctx context.Context, params pkg.QueryParams) ([]internal.Alumni, pkg.PageInfo, error)
+
+type RetrieveEmailTemplateByNameFunc func(ctx context.Context, name string) (internal.EmailTemplate, error)
+
+type RetrieveAllEmailTemplatesFunc func(ctx context.Context) ([]internal.EmailTemplate, error)
+
+type UpsertEmailTemplateFunc func(ctx context.Context, et internal.EmailTemplate) error
+
+type CreateResetPasswordFunc func(ctx context.Context, rp internal.ResetPassword) error
+
+type ConsumeResetPasswordFunc func(ctx context.Context, email string, tokenHash string, now gotime.Time) (internal.ResetPassword, error)
+
+type CountResetPasswordsFunc func(ctx context.Context, email string, since gotime.Time) (int64, error)
+
+type DeleteResetPasswordsFunc func(ctx context.Context, email string) error
+
+type InsertRefreshTokenFunc func(ctx context.Context, rt internal.RefreshToken) error
+
+type RetrieveRefreshTokenByIDFunc func(ctx context.Context, id string) (internal.RefreshToken, error)
+
+type RotateRefreshTokenFunc func(ctx context.Context, oldHash string, rt internal.RefreshToken) (internal.RefreshToken, error)
+
+type DeleteRefreshTokenFunc func(ctx context.Context, id string) error
+
+type DeleteUserRefreshTokensFunc func(ctx context.Context, userId string) error
+
+type InsertRoleChangeFunc func(ctx context.Context, rc internal.RoleChange) error
+
+type RetrieveRoleChangesFunc func(ctx context.Context, userId string) ([]internal.RoleChange, error)
+
+type InsertAlumniHistoryFunc func(ctx context.Context, h internal.AlumniHistory) error
+
+// RetrieveAlumniHistoryFunc returns the history of an alumni, newest version first
+type RetrieveAlumniHistoryFunc func(ctx context.Context, alumniId string) ([]internal.AlumniHistory, error)
+
+type RetrieveAlumniHistoryVersionFunc func(ctx context.Context, alumniId string, version int64) (internal.AlumniHistory, error)
+
+type DeleteAlumniHistoryFunc func(ctx context.Context, alumniId string) error
+
+type InsertAuditEventFunc func(ctx context.Context, e internal.AuditEvent) error
+
+// RetrieveAuditEventsFunc returns a page of the audit events matching params, newest first. A negative limit returns them all
+type RetrieveAuditEventsFunc func(ctx context.Context, params pkg.AuditQueryParams) ([]internal.AuditEvent, pkg.PageInfo, error)
+
+type InsertEmailVerificationFunc func(ctx context.Context, ev internal.EmailVerification) error
+
+type RetrieveEmailVerificationFunc func(ctx context.Context, tokenHash string) (internal.EmailVerification, error)
+
+type DeleteEmailVerificationsFunc func(ctx context.Context, userId string) error
+
+type InsertEmailChangeFunc func(ctx context.Context, ec internal.EmailChange) error
 
-type RetrieveUserByEmailFunc func(email string) (internal.User, error)
+type RetrieveEmailChangeFunc func(ctx context.Context, tokenHash string) (internal.EmailChange, error)
 
-type RetrieveUserByIDFunc func(id string) (internal.User, error)
+type DeleteEmailChangesFunc func(ctx context.Context, userId string) error
 
-type RetrieveUserByAlumniIDFunc func(alumniId string) (internal.User, error)
+type InsertInvitationFunc func(ctx context.Context, i internal.Invitation) error
 
-type RetrieveUsersAlumniIDsFunc func(status string) ([]string, error)
+type ConsumeInvitationFunc func(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.Invitation, error)
 
-type ReplaceUserFunc func(u internal.User) error
+type DeleteInvitationsFunc func(ctx context.Context, alumniId string) error
 
-type InsertAlumniFunc func(a internal.Alumni) error
+type InsertProfileClaimFunc func(ctx context.Context, c internal.ProfileClaim) error
 
-type UpdateAlumniFunc func(id string, a internal.UpdateAlumniRequest) error
+type RetrieveProfileClaimByIDFunc func(ctx context.Context, id string) (internal.ProfileClaim, error)
 
-type RetrieveAlumniByIDFunc func(id string) (internal.Alumni, error)
+type RetrieveProfileClaimsFunc func(ctx context.Context, status, userId, alumniId string) ([]internal.ProfileClaim, error)
 
-type ChangeAlumniPrivacyFunc func(id string, isPublic bool) error
+type ReplaceProfileClaimFunc func(ctx context.Context, c internal.ProfileClaim) error
 
-type RetrieveAllAlumniFunc func(params pkg.QueryParams, alumniId string, isAdmin bool, ids ...string) ([]internal.Alumni, pkg.PageInfo, error)
+type RetrieveLoginAttemptFunc func(ctx context.Context, key string) (internal.LoginAttempt, error)
 
-type RetrieveEmailTemplateByNameFunc func(name string) (internal.EmailTemplate, error)
+type RecordLoginFailureFunc func(ctx context.Context, key string, at gotime.Time, expiresAt gotime.Time) (internal.LoginAttempt, error)
 
-type CreateResetPasswordFunc func(rp internal.ResetPassword) error
+type DeleteLoginAttemptsFunc func(ctx context.Context, keys ...string) error
 
-type FindResetPasswordFunc func(email string, token string) (internal.ResetPassword, error)
+type RetrieveMigrationStatusFunc func(ctx context.Context) ([]MigrationStatus, error)
 
-type DeleteResetPasswordsFunc func(email string) error
+type RunMigrationsFunc func(ctx context.Context) ([]MigrationStatus, error)
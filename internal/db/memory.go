@@ -0,0 +1,1113 @@
+package db
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memoryDB holds every collection of an in-memory Store, behind a single lock
+type memoryDB struct {
+	mu                 sync.Mutex
+	users              []internal.User
+	alumni             []internal.Alumni
+	templates          map[string]internal.EmailTemplate
+	resetPasswords     []internal.ResetPassword
+	refreshTokens      []internal.RefreshToken
+	roleChanges        []internal.RoleChange
+	alumniHistory      []internal.AlumniHistory
+	auditEvents        []internal.AuditEvent
+	emailVerifications []internal.EmailVerification
+	emailChanges       []internal.EmailChange
+	invitations        []internal.Invitation
+	profileClaims      []internal.ProfileClaim
+	loginAttempts      map[string]internal.LoginAttempt
+}
+
+// NewMemoryStore returns an empty Store kept in memory, which filters and paginates the way the Mongo one does.
+// Nothing expires from it on its own, so expired tokens are only ever rejected by the checks made on reading them.
+func NewMemoryStore() Store {
+	m := &memoryDB{
+		templates:     map[string]internal.EmailTemplate{},
+		loginAttempts: map[string]internal.LoginAttempt{},
+	}
+	return Store{
+		Users:              memoryUserRepository{m},
+		Alumni:             memoryAlumniRepository{m},
+		Templates:          memoryTemplateRepository{m},
+		ResetPasswords:     memoryResetPasswordRepository{m},
+		RefreshTokens:      memoryRefreshTokenRepository{m},
+		RoleChanges:        memoryRoleChangeRepository{m},
+		AlumniHistory:      memoryAlumniHistoryRepository{m},
+		AuditEvents:        memoryAuditEventRepository{m},
+		EmailVerifications: memoryEmailVerificationRepository{m},
+		EmailChanges:       memoryEmailChangeRepository{m},
+		Invitations:        memoryInvitationRepository{m},
+		ProfileClaims:      memoryProfileClaimRepository{m},
+		LoginAttempts:      memoryLoginAttemptRepository{m},
+	}
+}
+
+type memoryUserRepository struct {
+	*memoryDB
+}
+
+func (r memoryUserRepository) Insert(ctx context.Context, u internal.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.indexOfEmail(u.Email) >= 0 {
+		return apperror.New(apperror.ConflictCode, "db - a user already exists with email=%v", u.Email)
+	}
+	var stored internal.User
+	if err := copyDocument(&stored, u); err != nil {
+		return errors.Wrapf(err, "db - unable to insert user with id=%v", u.ID)
+	}
+	r.users = append(r.users, stored)
+	return nil
+}
+
+func (r memoryUserRepository) RetrieveByEmail(ctx context.Context, email string) (internal.User, error) {
+	return r.find(func(u internal.User) bool { return u.Email == strings.ToLower(email) }, "db - unable to find user with email=%v", email)
+}
+
+func (r memoryUserRepository) RetrieveByID(ctx context.Context, id string) (internal.User, error) {
+	return r.find(func(u internal.User) bool { return u.ID.Val() == id }, "db - unable to find user with id=%v", id)
+}
+
+func (r memoryUserRepository) RetrieveByAlumniID(ctx context.Context, alumniId string) (internal.User, error) {
+	return r.find(func(u internal.User) bool { return u.AlumniID.Val() == alumniId }, "db - unable to find user with alumniId=%v", alumniId)
+}
+
+func (r memoryUserRepository) Retrieve(ctx context.Context, params pkg.UserQueryParams, scope internal.AlumniScope) ([]internal.User, pkg.PageInfo, error) {
+	var after, before time.Epoch
+	if params.CreatedAfter != "" {
+		t, err := time.NewISO8601(params.CreatedAfter)
+		if err != nil {
+			return []internal.User{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdAfter=%v", params.CreatedAfter)
+		}
+		after = t.ToEpoch()
+	}
+	if params.CreatedBefore != "" {
+		t, err := time.NewISO8601(params.CreatedBefore)
+		if err != nil {
+			return []internal.User{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdBefore=%v", params.CreatedBefore)
+		}
+		before = t.ToEpoch()
+	}
+
+	matches := func(u internal.User) bool {
+		if params.Email != "" && !containsFold(u.Email, params.Email) {
+			return false
+		}
+		// Users created before statuses existed have none, and are treated as pending
+		if params.Status == internal.PendingUserStatus {
+			if u.Status != params.Status && u.Status != "" {
+				return false
+			}
+			// and are only queued for approval once they have verified their email
+			if !u.EmailVerified {
+				return false
+			}
+		} else if params.Status != "" && u.Status != params.Status {
+			return false
+		}
+		if params.Admin != nil && *params.Admin != (u.Admin || len(u.Roles) > 0) {
+			return false
+		}
+		if params.HasAlumni != nil && *params.HasAlumni != (u.AlumniID != "") {
+			return false
+		}
+		if params.CreatedAfter != "" && u.CreatedTimestamp < after {
+			return false
+		}
+		if params.CreatedBefore != "" && u.CreatedTimestamp >= before {
+			return false
+		}
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Mirrors the join onto alumni RetrieveUsers makes for scoped admins
+	visible := map[string]bool{}
+	if !scope.All {
+		for _, a := range r.alumni {
+			if inScope(a, scope) {
+				visible[a.ID.Val()] = true
+			}
+		}
+	}
+
+	uu := []internal.User{}
+	for _, u := range r.users {
+		if matches(u) && (scope.All || visible[u.AlumniID.Val()]) {
+			uu = append(uu, u)
+		}
+	}
+	sort.SliceStable(uu, func(i, j int) bool {
+		if uu[i].CreatedTimestamp != uu[j].CreatedTimestamp {
+			return uu[i].CreatedTimestamp < uu[j].CreatedTimestamp
+		}
+		return uu[i].ID < uu[j].ID
+	})
+
+	count := int64(len(uu))
+	start, end := pageBounds(count, (params.Page-1)*params.Limit, params.Limit)
+	page := []internal.User{}
+	for _, u := range uu[start:end] {
+		var c internal.User
+		if err := copyDocument(&c, u); err != nil {
+			return []internal.User{}, pkg.PageInfo{}, errors.Wrap(err, "db - error copying user")
+		}
+		page = append(page, c)
+	}
+
+	return page, newPageInfo(count, params.Page, params.Limit), nil
+}
+
+func (r memoryUserRepository) Replace(ctx context.Context, u internal.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == u.ID {
+			var stored internal.User
+			if err := copyDocument(&stored, u); err != nil {
+				return errors.Wrapf(err, "db - unable to replace user with id=%v", u.ID)
+			}
+			r.users[i] = stored
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r memoryUserRepository) UpdateEmail(ctx context.Context, id, oldEmail, newEmail string, at time.Epoch) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, u := range r.users {
+		if u.ID.Val() != id || u.Email != oldEmail {
+			continue
+		}
+		if j := r.indexOfEmail(newEmail); j >= 0 && j != i {
+			return apperror.New(apperror.ConflictCode, "db - a user already exists with email=%v", newEmail)
+		}
+		r.users[i].Email = newEmail
+		r.users[i].EmailVerified = true
+		r.users[i].LastUpdatedTimestamp = at
+		return nil
+	}
+	return apperror.New(apperror.NotFoundCode, "db - unable to find user with id=%v and email=%v", id, oldEmail)
+}
+
+func (r memoryUserRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, u := range r.users {
+		if u.ID.Val() == id {
+			r.users = append(r.users[:i], r.users[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (r memoryUserRepository) find(match func(u internal.User) bool, format string, args ...interface{}) (internal.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if match(u) {
+			var found internal.User
+			if err := copyDocument(&found, u); err != nil {
+				return internal.User{}, errors.Wrapf(err, format, args...)
+			}
+			return found, nil
+		}
+	}
+	return internal.User{}, apperror.New(apperror.NotFoundCode, format, args...)
+}
+
+// indexOfEmail returns the index of the user with the email, or -1. The caller must hold the lock
+func (r memoryUserRepository) indexOfEmail(email string) int {
+	for i, u := range r.users {
+		if u.Email == email {
+			return i
+		}
+	}
+	return -1
+}
+
+type memoryAlumniRepository struct {
+	*memoryDB
+}
+
+func (r memoryAlumniRepository) Insert(ctx context.Context, a internal.Alumni) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stored internal.Alumni
+	if err := copyDocument(&stored, a); err != nil {
+		return errors.Wrapf(err, "db - unable to insert alumni with id=%v", a.ID)
+	}
+	r.alumni = append(r.alumni, stored)
+	return nil
+}
+
+// Update sets the fields of the alumni the update has values for, as $set does with the omitempty fields of the update,
+// and removes the fields it unsets
+func (r memoryAlumniRepository) Update(ctx context.Context, id string, version int64, a internal.UpdateAlumniRequest) (internal.Alumni, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.alumni {
+		if r.alumni[i].ID.Val() != id || r.alumni[i].Version != version {
+			continue
+		}
+
+		var doc, update bson.M
+		if err := copyDocument(&doc, r.alumni[i]); err != nil {
+			return internal.Alumni{}, errors.Wrapf(err, "db - unable to update alumniId=%v", id)
+		}
+		if err := copyDocument(&update, a); err != nil {
+			return internal.Alumni{}, errors.Wrapf(err, "db - unable to update alumniId=%v", id)
+		}
+		for k, v := range update {
+			doc[k] = v
+		}
+		for _, k := range a.Unset {
+			delete(doc, k)
+		}
+
+		var updated internal.Alumni
+		if err := copyDocument(&updated, doc); err != nil {
+			return internal.Alumni{}, errors.Wrapf(err, "db - unable to update alumniId=%v", id)
+		}
+		updated.Version++
+		r.alumni[i] = updated
+		return r.copyAlumni(i)
+	}
+	return internal.Alumni{}, apperror.New(apperror.ConflictCode, "db - alumniId=%v is no longer at version=%v", id, version)
+}
+
+func (r memoryAlumniRepository) RetrieveByID(ctx context.Context, id string) (internal.Alumni, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, a := range r.alumni {
+		if a.ID.Val() == id {
+			var found internal.Alumni
+			if err := copyDocument(&found, a); err != nil {
+				return internal.Alumni{}, errors.Wrapf(err, "db - unable to find alumni with id=%v", id)
+			}
+			return found, nil
+		}
+	}
+	return internal.Alumni{}, apperror.New(apperror.NotFoundCode, "db - unable to find alumni with id=%v", id)
+}
+
+func (r memoryAlumniRepository) RetrieveAll(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.Alumni, pkg.PageInfo, error) {
+	matches, ok := alumniMatcher(params, alumniId, scope)
+	if !ok {
+		return []internal.Alumni{}, pkg.PageInfo{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	aa := []internal.Alumni{}
+	for _, a := range r.alumni {
+		if matches(a) {
+			aa = append(aa, a)
+		}
+	}
+
+	var skip int64
+	if params.Page > 0 {
+		skip = (params.Page - 1) * params.Limit
+	}
+	limit := params.Limit
+	if limit == (-1) {
+		skip, limit = 0, 0
+	}
+
+	count := int64(len(aa))
+	start, end := pageBounds(count, skip, limit)
+	page := []internal.Alumni{}
+	for _, a := range aa[start:end] {
+		var c internal.Alumni
+		if err := copyDocument(&c, a); err != nil {
+			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - error copying alumni")
+		}
+		page = append(page, c)
+	}
+
+	return page, newPageInfo(count, params.Page, params.Limit), nil
+}
+
+func (r memoryAlumniRepository) RetrieveAccounts(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.AlumniAccount, pkg.PageInfo, error) {
+	matches, ok := alumniMatcher(params, alumniId, scope)
+	if !ok {
+		return []internal.AlumniAccount{}, pkg.PageInfo{}, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	accounts := map[string]internal.User{}
+	for _, u := range r.users {
+		if _, ok := accounts[u.AlumniID.Val()]; !ok && u.AlumniID != "" && accountHasStatus(u, params.Status) {
+			accounts[u.AlumniID.Val()] = u
+		}
+	}
+
+	aa := []internal.AlumniAccount{}
+	for _, a := range r.alumni {
+		if u, ok := accounts[a.ID.Val()]; ok && matches(a) {
+			aa = append(aa, internal.AlumniAccount{Alumni: a, User: u})
+		}
+	}
+
+	var skip int64
+	if params.Page > 0 {
+		skip = (params.Page - 1) * params.Limit
+	}
+	limit := params.Limit
+	if limit == (-1) {
+		skip, limit = 0, 0
+	}
+
+	count := int64(len(aa))
+	start, end := pageBounds(count, skip, limit)
+	page := []internal.AlumniAccount{}
+	for _, a := range aa[start:end] {
+		var c internal.AlumniAccount
+		if err := copyDocument(&c.Alumni, a.Alumni); err != nil {
+			return []internal.AlumniAccount{}, pkg.PageInfo{}, errors.Wrap(err, "db - error copying alumni")
+		}
+		if err := copyDocument(&c.User, a.User); err != nil {
+			return []internal.AlumniAccount{}, pkg.PageInfo{}, errors.Wrap(err, "db - error copying user")
+		}
+		page = append(page, c)
+	}
+
+	return page, newPageInfo(count, params.Page, params.Limit), nil
+}
+
+func (r memoryAlumniRepository) RetrieveUnclaimed(ctx context.Context, params pkg.QueryParams) ([]internal.Alumni, pkg.PageInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	claimed := map[string]bool{}
+	for _, u := range r.users {
+		claimed[u.AlumniID.Val()] = true
+	}
+
+	aa := []internal.Alumni{}
+	for _, a := range r.alumni {
+		if !claimed[a.ID.Val()] && isClaimable(a, params) {
+			aa = append(aa, a)
+		}
+	}
+
+	var skip int64
+	if params.Page > 0 {
+		skip = (params.Page - 1) * params.Limit
+	}
+
+	count := int64(len(aa))
+	start, end := pageBounds(count, skip, params.Limit)
+	page := []internal.Alumni{}
+	for _, a := range aa[start:end] {
+		var c internal.Alumni
+		if err := copyDocument(&c, a); err != nil {
+			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - error copying alumni")
+		}
+		page = append(page, c)
+	}
+
+	return page, newPageInfo(count, params.Page, params.Limit), nil
+}
+
+func (r memoryAlumniRepository) ChangePrivacy(ctx context.Context, id string, version int64, isPublic bool) (internal.Alumni, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.alumni {
+		if r.alumni[i].ID.Val() == id && r.alumni[i].Version == version {
+			r.alumni[i].IsPublic = isPublic
+			r.alumni[i].Version++
+			return r.copyAlumni(i)
+		}
+	}
+	return internal.Alumni{}, apperror.New(apperror.ConflictCode, "db - alumniId=%v is no longer at version=%v", id, version)
+}
+
+// copyAlumni returns a copy of the alumni at index i that shares nothing with the stored one
+func (r memoryAlumniRepository) copyAlumni(i int) (internal.Alumni, error) {
+	var a internal.Alumni
+	if err := copyDocument(&a, r.alumni[i]); err != nil {
+		return internal.Alumni{}, errors.Wrapf(err, "db - unable to copy alumniId=%v", r.alumni[i].ID)
+	}
+	return a, nil
+}
+
+func (r memoryAlumniRepository) UpdateEmail(ctx context.Context, id, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.alumni {
+		if r.alumni[i].ID.Val() == id {
+			r.alumni[i].EmailAddress = email
+			r.alumni[i].Version++
+		}
+	}
+	return nil
+}
+
+func (r memoryAlumniRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, a := range r.alumni {
+		if a.ID.Val() == id {
+			r.alumni = append(r.alumni[:i], r.alumni[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+type memoryTemplateRepository struct {
+	*memoryDB
+}
+
+func (r memoryTemplateRepository) RetrieveByName(ctx context.Context, name string) (internal.EmailTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	et, ok := r.templates[name]
+	if !ok {
+		return internal.EmailTemplate{}, apperror.New(apperror.NotFoundCode, "db - unable to find email template with name=%v", name)
+	}
+	return et, nil
+}
+
+func (r memoryTemplateRepository) RetrieveAll(ctx context.Context) ([]internal.EmailTemplate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ets := []internal.EmailTemplate{}
+	for _, et := range r.templates {
+		ets = append(ets, et)
+	}
+	sort.Slice(ets, func(i, j int) bool { return ets[i].Name < ets[j].Name })
+	return ets, nil
+}
+
+func (r memoryTemplateRepository) Upsert(ctx context.Context, et internal.EmailTemplate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.templates[et.Name] = et
+	return nil
+}
+
+type memoryResetPasswordRepository struct {
+	*memoryDB
+}
+
+func (r memoryResetPasswordRepository) Create(ctx context.Context, rp internal.ResetPassword) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resetPasswords = append(r.resetPasswords, rp)
+	return nil
+}
+
+func (r memoryResetPasswordRepository) Consume(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.ResetPassword, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rp := range r.resetPasswords {
+		if rp.Email == strings.ToLower(email) && rp.TokenHash == tokenHash && rp.ExpiresAt.After(now) {
+			r.resetPasswords = append(r.resetPasswords[:i], r.resetPasswords[i+1:]...)
+			return rp, nil
+		}
+	}
+	return internal.ResetPassword{}, apperror.New(apperror.NotFoundCode, "db - unable to find reset password with email=%v", email)
+}
+
+func (r memoryResetPasswordRepository) Count(ctx context.Context, email string, since gotime.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for _, rp := range r.resetPasswords {
+		if rp.Email == strings.ToLower(email) && !rp.CreatedTimestamp.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r memoryResetPasswordRepository) Delete(ctx context.Context, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.resetPasswords[:0]
+	for _, rp := range r.resetPasswords {
+		if rp.Email != email {
+			kept = append(kept, rp)
+		}
+	}
+	r.resetPasswords = kept
+	return nil
+}
+
+type memoryRefreshTokenRepository struct {
+	*memoryDB
+}
+
+func (r memoryRefreshTokenRepository) Insert(ctx context.Context, rt internal.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refreshTokens = append(r.refreshTokens, rt)
+	return nil
+}
+
+func (r memoryRefreshTokenRepository) RetrieveByID(ctx context.Context, id string) (internal.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rt := range r.refreshTokens {
+		if rt.ID.Val() == id {
+			return rt, nil
+		}
+	}
+	return internal.RefreshToken{}, apperror.New(apperror.NotFoundCode, "db - unable to find refresh token with id=%v", id)
+}
+
+func (r memoryRefreshTokenRepository) Rotate(ctx context.Context, oldHash string, rt internal.RefreshToken) (internal.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := rt.LastUpdatedTimestamp.ToISO8601().Val()
+	for i, old := range r.refreshTokens {
+		if old.TokenHash == oldHash && old.ExpiresAt.After(now) {
+			r.refreshTokens[i].TokenHash = rt.TokenHash
+			r.refreshTokens[i].ExpiresAt = rt.ExpiresAt
+			r.refreshTokens[i].LastUpdatedTimestamp = rt.LastUpdatedTimestamp
+			return r.refreshTokens[i], nil
+		}
+	}
+	return internal.RefreshToken{}, apperror.New(apperror.NotFoundCode, "db - unable to rotate refresh token")
+}
+
+func (r memoryRefreshTokenRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.refreshTokens[:0]
+	for _, rt := range r.refreshTokens {
+		if rt.ID.Val() != id {
+			kept = append(kept, rt)
+		}
+	}
+	r.refreshTokens = kept
+	return nil
+}
+
+func (r memoryRefreshTokenRepository) DeleteForUser(ctx context.Context, userId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.refreshTokens[:0]
+	for _, rt := range r.refreshTokens {
+		if rt.UserID.Val() != userId {
+			kept = append(kept, rt)
+		}
+	}
+	r.refreshTokens = kept
+	return nil
+}
+
+type memoryRoleChangeRepository struct {
+	*memoryDB
+}
+
+func (r memoryRoleChangeRepository) Insert(ctx context.Context, rc internal.RoleChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.roleChanges = append(r.roleChanges, rc)
+	return nil
+}
+
+func (r memoryRoleChangeRepository) Retrieve(ctx context.Context, userId string) ([]internal.RoleChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rcs := []internal.RoleChange{}
+	for _, rc := range r.roleChanges {
+		if rc.UserID.Val() == userId {
+			rcs = append(rcs, rc)
+		}
+	}
+	sort.SliceStable(rcs, func(i, j int) bool { return rcs[i].CreatedTimestamp > rcs[j].CreatedTimestamp })
+	return rcs, nil
+}
+
+type memoryAlumniHistoryRepository struct {
+	*memoryDB
+}
+
+func (r memoryAlumniHistoryRepository) Insert(ctx context.Context, h internal.AlumniHistory) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stored internal.AlumniHistory
+	if err := copyDocument(&stored, h); err != nil {
+		return errors.Wrapf(err, "db - unable to insert history of alumniId=%v", h.AlumniID)
+	}
+	r.alumniHistory = append(r.alumniHistory, stored)
+	return nil
+}
+
+func (r memoryAlumniHistoryRepository) Retrieve(ctx context.Context, alumniId string) ([]internal.AlumniHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	hh := []internal.AlumniHistory{}
+	for _, h := range r.alumniHistory {
+		if h.AlumniID.Val() == alumniId {
+			h.Alumni = internal.Alumni{}
+			hh = append(hh, h)
+		}
+	}
+	sort.SliceStable(hh, func(i, j int) bool { return hh[i].Version > hh[j].Version })
+	return hh, nil
+}
+
+func (r memoryAlumniHistoryRepository) RetrieveVersion(ctx context.Context, alumniId string, version int64) (internal.AlumniHistory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, h := range r.alumniHistory {
+		if h.AlumniID.Val() == alumniId && h.Version == version {
+			var found internal.AlumniHistory
+			if err := copyDocument(&found, h); err != nil {
+				return internal.AlumniHistory{}, errors.Wrapf(err, "db - unable to find version=%v of alumniId=%v", version, alumniId)
+			}
+			return found, nil
+		}
+	}
+	return internal.AlumniHistory{}, apperror.New(apperror.NotFoundCode, "db - unable to find version=%v of alumniId=%v", version, alumniId)
+}
+
+func (r memoryAlumniHistoryRepository) DeleteForAlumni(ctx context.Context, alumniId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := []internal.AlumniHistory{}
+	for _, h := range r.alumniHistory {
+		if h.AlumniID.Val() != alumniId {
+			kept = append(kept, h)
+		}
+	}
+	r.alumniHistory = kept
+	return nil
+}
+
+type memoryAuditEventRepository struct {
+	*memoryDB
+}
+
+func (r memoryAuditEventRepository) Insert(ctx context.Context, e internal.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.auditEvents = append(r.auditEvents, e)
+	return nil
+}
+
+func (r memoryAuditEventRepository) Retrieve(ctx context.Context, params pkg.AuditQueryParams) ([]internal.AuditEvent, pkg.PageInfo, error) {
+	var after, before time.Epoch
+	if params.CreatedAfter != "" {
+		t, err := time.NewISO8601(params.CreatedAfter)
+		if err != nil {
+			return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdAfter=%v", params.CreatedAfter)
+		}
+		after = t.ToEpoch()
+	}
+	if params.CreatedBefore != "" {
+		t, err := time.NewISO8601(params.CreatedBefore)
+		if err != nil {
+			return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdBefore=%v", params.CreatedBefore)
+		}
+		before = t.ToEpoch()
+	}
+
+	matches := func(e internal.AuditEvent) bool {
+		if params.ActorID != "" && e.ActorID.Val() != params.ActorID {
+			return false
+		}
+		if params.Action != "" && e.Action != params.Action {
+			return false
+		}
+		if params.TargetType != "" && e.TargetType != params.TargetType {
+			return false
+		}
+		if params.TargetID != "" && e.TargetID != params.TargetID {
+			return false
+		}
+		if params.Outcome != "" && e.Outcome != params.Outcome {
+			return false
+		}
+		if params.CreatedAfter != "" && e.CreatedTimestamp < after {
+			return false
+		}
+		if params.CreatedBefore != "" && e.CreatedTimestamp >= before {
+			return false
+		}
+		return true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Walking backwards keeps events recorded at the same time newest first, as Mongo does by _id
+	ee := []internal.AuditEvent{}
+	for i := len(r.auditEvents) - 1; i >= 0; i-- {
+		if matches(r.auditEvents[i]) {
+			ee = append(ee, r.auditEvents[i])
+		}
+	}
+	sort.SliceStable(ee, func(i, j int) bool { return ee[i].CreatedTimestamp > ee[j].CreatedTimestamp })
+
+	count := int64(len(ee))
+	start, end := pageBounds(count, (params.Page-1)*params.Limit, params.Limit)
+	page := append([]internal.AuditEvent{}, ee[start:end]...)
+
+	return page, newPageInfo(count, params.Page, params.Limit), nil
+}
+
+type memoryEmailVerificationRepository struct {
+	*memoryDB
+}
+
+func (r memoryEmailVerificationRepository) Insert(ctx context.Context, ev internal.EmailVerification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.emailVerifications = append(r.emailVerifications, ev)
+	return nil
+}
+
+func (r memoryEmailVerificationRepository) Retrieve(ctx context.Context, tokenHash string) (internal.EmailVerification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ev := range r.emailVerifications {
+		if ev.TokenHash == tokenHash {
+			return ev, nil
+		}
+	}
+	return internal.EmailVerification{}, apperror.New(apperror.NotFoundCode, "db - unable to find email verification")
+}
+
+func (r memoryEmailVerificationRepository) DeleteForUser(ctx context.Context, userId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.emailVerifications[:0]
+	for _, ev := range r.emailVerifications {
+		if ev.UserID.Val() != userId {
+			kept = append(kept, ev)
+		}
+	}
+	r.emailVerifications = kept
+	return nil
+}
+
+type memoryEmailChangeRepository struct {
+	*memoryDB
+}
+
+func (r memoryEmailChangeRepository) Insert(ctx context.Context, ec internal.EmailChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.emailChanges = append(r.emailChanges, ec)
+	return nil
+}
+
+func (r memoryEmailChangeRepository) Retrieve(ctx context.Context, tokenHash string) (internal.EmailChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, ec := range r.emailChanges {
+		if ec.TokenHash == tokenHash || ec.CancelTokenHash == tokenHash {
+			return ec, nil
+		}
+	}
+	return internal.EmailChange{}, apperror.New(apperror.NotFoundCode, "db - unable to find email change")
+}
+
+func (r memoryEmailChangeRepository) DeleteForUser(ctx context.Context, userId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.emailChanges[:0]
+	for _, ec := range r.emailChanges {
+		if ec.UserID.Val() != userId {
+			kept = append(kept, ec)
+		}
+	}
+	r.emailChanges = kept
+	return nil
+}
+
+type memoryInvitationRepository struct {
+	*memoryDB
+}
+
+func (r memoryInvitationRepository) Insert(ctx context.Context, i internal.Invitation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.invitations = append(r.invitations, i)
+	return nil
+}
+
+func (r memoryInvitationRepository) Consume(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.Invitation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for idx, i := range r.invitations {
+		if i.Email == strings.ToLower(email) && i.TokenHash == tokenHash && i.ExpiresAt.After(now) {
+			r.invitations = append(r.invitations[:idx], r.invitations[idx+1:]...)
+			return i, nil
+		}
+	}
+	return internal.Invitation{}, apperror.New(apperror.NotFoundCode, "db - unable to find invitation with email=%v", email)
+}
+
+func (r memoryInvitationRepository) DeleteForAlumni(ctx context.Context, alumniId string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.invitations[:0]
+	for _, i := range r.invitations {
+		if i.AlumniID.Val() != alumniId {
+			kept = append(kept, i)
+		}
+	}
+	r.invitations = kept
+	return nil
+}
+
+type memoryProfileClaimRepository struct {
+	*memoryDB
+}
+
+func (r memoryProfileClaimRepository) Insert(ctx context.Context, c internal.ProfileClaim) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.profileClaims = append(r.profileClaims, c)
+	return nil
+}
+
+func (r memoryProfileClaimRepository) RetrieveByID(ctx context.Context, id string) (internal.ProfileClaim, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.profileClaims {
+		if c.ID.Val() == id {
+			return c, nil
+		}
+	}
+	return internal.ProfileClaim{}, apperror.New(apperror.NotFoundCode, "db - unable to find profile claim with id=%v", id)
+}
+
+func (r memoryProfileClaimRepository) Retrieve(ctx context.Context, status, userId, alumniId string) ([]internal.ProfileClaim, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cc := []internal.ProfileClaim{}
+	for _, c := range r.profileClaims {
+		if (status == "" || c.Status == status) &&
+			(userId == "" || c.UserID.Val() == userId) &&
+			(alumniId == "" || c.AlumniID.Val() == alumniId) {
+			cc = append(cc, c)
+		}
+	}
+	sort.SliceStable(cc, func(i, j int) bool { return cc[i].CreatedTimestamp < cc[j].CreatedTimestamp })
+	return cc, nil
+}
+
+func (r memoryProfileClaimRepository) Replace(ctx context.Context, c internal.ProfileClaim) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.profileClaims {
+		if r.profileClaims[i].ID == c.ID {
+			r.profileClaims[i] = c
+		}
+	}
+	return nil
+}
+
+type memoryLoginAttemptRepository struct {
+	*memoryDB
+}
+
+func (r memoryLoginAttemptRepository) Retrieve(ctx context.Context, key string) (internal.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	la, ok := r.loginAttempts[key]
+	if !ok {
+		return internal.LoginAttempt{}, apperror.New(apperror.NotFoundCode, "db - unable to find login attempts for key=%v", key)
+	}
+	return la, nil
+}
+
+func (r memoryLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at gotime.Time, expiresAt gotime.Time) (internal.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	la, ok := r.loginAttempts[key]
+	// Stands in for the TTL index, which would have removed the expired attempts
+	if !ok || !la.ExpiresAt.After(at) {
+		la = internal.LoginAttempt{Key: key}
+	}
+	la.Failures++
+	la.LastFailureAt = at
+	la.ExpiresAt = expiresAt
+	r.loginAttempts[key] = la
+	return la, nil
+}
+
+func (r memoryLoginAttemptRepository) Delete(ctx context.Context, keys ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range keys {
+		delete(r.loginAttempts, key)
+	}
+	return nil
+}
+
+// matchesLastname returns whether any of the last names of the alumni or their family contain the search
+func matchesLastname(a internal.Alumni, lastname string) bool {
+	if containsFold(a.Lastname, lastname) || containsFold(a.MarriedName, lastname) ||
+		containsFold(a.MaidenName, lastname) || containsFold(a.SpouseMaidenName, lastname) {
+		return true
+	}
+	for _, s := range a.Siblings {
+		if containsFold(s.Lastname, lastname) {
+			return true
+		}
+	}
+	for _, g := range a.Grandparents {
+		if containsFold(g.Lastname, lastname) {
+			return true
+		}
+	}
+	return false
+}
+
+// inScope returns whether the alumni is visible within a scope that does not cover every alumni
+func inScope(a internal.Alumni, scope internal.AlumniScope) bool {
+	if scope.Public && a.IsPublic {
+		return true
+	}
+	for _, d := range scope.Divisions {
+		if a.InDivision(d) {
+			return true
+		}
+	}
+	return containsString(scope.GraduationYears, a.HighSchool.YearEnded)
+}
+
+// alumniMatcher mirrors alumniFilter, returning false when the scope cannot see any alumni
+func alumniMatcher(params pkg.QueryParams, alumniId string, scope internal.AlumniScope) (func(a internal.Alumni) bool, bool) {
+	if !scope.All && !scope.Public && len(scope.Divisions) == 0 && len(scope.GraduationYears) == 0 {
+		return nil, false
+	}
+
+	return func(a internal.Alumni) bool {
+		if !containsFold(a.Firstname, params.Firstname) ||
+			!strings.Contains(a.Birthday, params.Birthday) ||
+			!strings.Contains(a.HighSchool.YearEnded, params.YearGraduated) ||
+			a.ID.Val() == alumniId {
+			return false
+		}
+		if !matchesLastname(a, params.Lastname) {
+			return false
+		}
+		return scope.All || inScope(a, scope)
+	}, true
+}
+
+// isClaimable mirrors claimableFilter
+func isClaimable(a internal.Alumni, params pkg.QueryParams) bool {
+	lastname := strings.TrimSpace(params.Lastname)
+	return strings.EqualFold(a.Firstname, strings.TrimSpace(params.Firstname)) &&
+		a.HighSchool.YearEnded == strings.TrimSpace(params.YearGraduated) &&
+		(strings.EqualFold(a.Lastname, lastname) || strings.EqualFold(a.MarriedName, lastname) || strings.EqualFold(a.MaidenName, lastname))
+}
+
+// pageBounds returns the slice of count results skipping skip of them and limited to limit, where a limit of 0 is no limit
+func pageBounds(count int64, skip int64, limit int64) (int64, int64) {
+	if skip < 0 {
+		skip = 0
+	}
+	if skip > count {
+		skip = count
+	}
+	end := count
+	if limit > 0 && skip+limit < count {
+		end = skip + limit
+	}
+	return skip, end
+}
+
+// copyDocument copies src into dst through bson, so the store never shares slices with its callers.
+// dst must be zero valued, as decoding into a slice reuses it
+func copyDocument(dst interface{}, src interface{}) error {
+	bb, err := bson.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(bb, dst)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package db
+
+import (
+	"context"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a versioned change to the indexes or data of the database, applied at most once
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, provideMongo *mongo.Database) error
+}
+
+// MigrationStatus is whether a migration has been applied to the database, and when
+type MigrationStatus struct {
+	Version          int        `bson:"version"`
+	Description      string     `bson:"description"`
+	Applied          bool       `bson:"-"`
+	AppliedTimestamp time.Epoch `bson:"appliedTimestamp"`
+}
+
+// Migrations are applied in order of version. Never edit or renumber a migration once it has been released,
+// add a new one instead. Every migration must be safe to run again, in case a run stops before recording it
+var Migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Create TTL indexes that remove expired tokens and counters",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			ttlCollections := []string{
+				resetPasswordsCollectionName,
+				emailVerificationsCollectionName,
+				loginAttemptsCollectionName,
+				refreshTokensCollectionName,
+				emailChangesCollectionName,
+				invitationsCollectionName,
+			}
+			for _, name := range ttlCollections {
+				model := mongo.IndexModel{
+					Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				}
+				if err := createIndexes(ctx, provideMongo, name, model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     2,
+		Description: "Create unique indexes on user emails, ids and other lookup keys",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			// Emails are the login key, so two users must never end up sharing one
+			unique := map[string][]string{
+				usersCollectionName:          {"email", "id"},
+				alumnisCollectionName:        {"id"},
+				emailTemplatesCollectionName: {"name"},
+				refreshTokensCollectionName:  {"id", "tokenHash"},
+				roleChangesCollectionName:    {"id"},
+				profileClaimsCollectionName:  {"id"},
+				loginAttemptsCollectionName:  {"key"},
+			}
+			for name, keys := range unique {
+				for _, key := range keys {
+					model := mongo.IndexModel{
+						Keys:    bson.D{{Key: key, Value: 1}},
+						Options: options.Index().SetUnique(true),
+					}
+					if err := createIndexes(ctx, provideMongo, name, model); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     3,
+		Description: "Create indexes for the filters and sorts used to list and look up records",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			indexes := map[string][]bson.D{
+				usersCollectionName: {
+					{{Key: "alumniId", Value: 1}},
+					{{Key: "status", Value: 1}, {Key: "createdTimestamp", Value: 1}, {Key: "id", Value: 1}},
+				},
+				alumnisCollectionName: {
+					{{Key: "birthday", Value: 1}},
+					{{Key: "highschool.yearEnded", Value: 1}, {Key: "lastname", Value: 1}, {Key: "firstname", Value: 1}},
+					{{Key: "lastname", Value: 1}, {Key: "firstname", Value: 1}},
+				},
+				resetPasswordsCollectionName: {
+					{{Key: "email", Value: 1}, {Key: "createdTimestamp", Value: 1}},
+				},
+				refreshTokensCollectionName: {
+					{{Key: "userId", Value: 1}},
+				},
+				roleChangesCollectionName: {
+					{{Key: "userId", Value: 1}, {Key: "createdTimestamp", Value: -1}},
+				},
+				emailVerificationsCollectionName: {
+					{{Key: "tokenHash", Value: 1}},
+					{{Key: "userId", Value: 1}},
+				},
+				emailChangesCollectionName: {
+					{{Key: "tokenHash", Value: 1}},
+					{{Key: "cancelTokenHash", Value: 1}},
+					{{Key: "userId", Value: 1}},
+				},
+				invitationsCollectionName: {
+					{{Key: "email", Value: 1}, {Key: "tokenHash", Value: 1}},
+					{{Key: "alumniId", Value: 1}},
+				},
+				profileClaimsCollectionName: {
+					{{Key: "status", Value: 1}, {Key: "createdTimestamp", Value: 1}},
+					{{Key: "userId", Value: 1}},
+					{{Key: "alumniId", Value: 1}},
+				},
+			}
+			for name, keys := range indexes {
+				for _, k := range keys {
+					if err := createIndexes(ctx, provideMongo, name, mongo.IndexModel{Keys: k}); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: "Backfill a PENDING status on users created before approvals existed",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			col := provideMongo.Collection(usersCollectionName)
+			filter := bson.M{"$or": []bson.M{{"status": ""}, {"status": bson.M{"$exists": false}}}}
+			update := bson.M{"$set": bson.M{"status": internal.PendingUserStatus}}
+
+			if _, err := col.UpdateMany(ctx, filter, update); err != nil {
+				return errors.Wrap(err, "db - unable to backfill user statuses")
+			}
+			return nil
+		},
+	},
+	{
+		Version:     5,
+		Description: "Mark users created before email verification existed as verified",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			col := provideMongo.Collection(usersCollectionName)
+			filter := bson.M{"emailVerified": bson.M{"$exists": false}}
+			update := bson.M{"$set": bson.M{"emailVerified": true}}
+
+			if _, err := col.UpdateMany(ctx, filter, update); err != nil {
+				return errors.Wrap(err, "db - unable to backfill user email verification")
+			}
+			return nil
+		},
+	},
+	{
+		Version:     6,
+		Description: "Start alumni created before optimistic concurrency existed at version 1",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			col := provideMongo.Collection(alumnisCollectionName)
+			filter := bson.M{"version": bson.M{"$exists": false}}
+			update := bson.M{"$set": bson.M{"version": 1}}
+
+			if _, err := col.UpdateMany(ctx, filter, update); err != nil {
+				return errors.Wrap(err, "db - unable to backfill alumni versions")
+			}
+			return nil
+		},
+	},
+	{
+		Version:     7,
+		Description: "Create indexes to look up the history of an alumni by version",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			// Two changes can never both move an alumni to the same version, so neither can their history
+			models := []mongo.IndexModel{
+				{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "alumniId", Value: 1}, {Key: "version", Value: -1}}, Options: options.Index().SetUnique(true)},
+			}
+			return createIndexes(ctx, provideMongo, alumniHistoryCollectionName, models...)
+		},
+	},
+	{
+		Version:     8,
+		Description: "Create indexes to filter the audit trail by actor, action and target",
+		Up: func(ctx context.Context, provideMongo *mongo.Database) error {
+			models := []mongo.IndexModel{
+				{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)},
+				{Keys: bson.D{{Key: "createdTimestamp", Value: -1}}},
+				{Keys: bson.D{{Key: "actorId", Value: 1}, {Key: "createdTimestamp", Value: -1}}},
+				{Keys: bson.D{{Key: "action", Value: 1}, {Key: "createdTimestamp", Value: -1}}},
+				{Keys: bson.D{{Key: "targetId", Value: 1}, {Key: "createdTimestamp", Value: -1}}},
+			}
+			return createIndexes(ctx, provideMongo, auditEventsCollectionName, models...)
+		},
+	},
+}
+
+// RetrieveMigrationStatus lists every known migration and whether it has been applied
+func RetrieveMigrationStatus(provideMongo *mongo.Database) RetrieveMigrationStatusFunc {
+	return func(ctx context.Context) ([]MigrationStatus, error) {
+		applied, err := retrieveAppliedMigrations(ctx, provideMongo)
+		if err != nil {
+			return []MigrationStatus{}, err
+		}
+
+		ss := []MigrationStatus{}
+		for _, m := range Migrations {
+			s, ok := applied[m.Version]
+			if !ok {
+				s = MigrationStatus{Version: m.Version, Description: m.Description}
+			}
+			ss = append(ss, s)
+		}
+
+		return ss, nil
+	}
+}
+
+// RunMigrations applies every pending migration in order, stopping at the first one to fail, and returns those it applied
+func RunMigrations(provideMongo *mongo.Database, provideTime time.EpochProviderFunc) RunMigrationsFunc {
+	return func(ctx context.Context) ([]MigrationStatus, error) {
+		col := provideMongo.Collection(migrationsCollectionName)
+
+		model := mongo.IndexModel{
+			Keys:    bson.D{{Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}
+		if err := createIndexes(ctx, provideMongo, migrationsCollectionName, model); err != nil {
+			return []MigrationStatus{}, err
+		}
+
+		applied, err := retrieveAppliedMigrations(ctx, provideMongo)
+		if err != nil {
+			return []MigrationStatus{}, err
+		}
+
+		ss := []MigrationStatus{}
+		for _, m := range Migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+
+			if err := m.Up(ctx, provideMongo); err != nil {
+				return ss, errors.Wrapf(err, "db - unable to apply migration version=%v", m.Version)
+			}
+
+			s := MigrationStatus{
+				Version:          m.Version,
+				Description:      m.Description,
+				Applied:          true,
+				AppliedTimestamp: provideTime(),
+			}
+			if _, err := col.InsertOne(ctx, s); err != nil && !isDuplicateKey(err) {
+				return ss, errors.Wrapf(err, "db - unable to record migration version=%v", m.Version)
+			}
+			ss = append(ss, s)
+		}
+
+		return ss, nil
+	}
+}
+
+func retrieveAppliedMigrations(ctx context.Context, provideMongo *mongo.Database) (map[int]MigrationStatus, error) {
+	col := provideMongo.Collection(migrationsCollectionName)
+	cur, err := col.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "db - unable to find applied migrations")
+	}
+
+	defer cur.Close(ctx)
+	applied := map[int]MigrationStatus{}
+	for cur.Next(ctx) {
+		var s MigrationStatus
+		if err := cur.Decode(&s); err != nil {
+			return nil, errors.Wrap(err, "db - error decoding migration")
+		}
+		s.Applied = true
+		applied[s.Version] = s
+	}
+
+	return applied, cur.Err()
+}
+
+// createIndexes creates the indexes on a collection. Creating an index that already exists is a no-op
+func createIndexes(ctx context.Context, provideMongo *mongo.Database, collection string, models ...mongo.IndexModel) error {
+	if _, err := provideMongo.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+		return errors.Wrapf(err, "db - unable to create indexes on collection=%v", collection)
+	}
+	return nil
+}
@@ -4,8 +4,11 @@ import (
 	"context"
 	"regexp"
 	"strings"
+	gotime "time"
 
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
 	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,86 +18,181 @@ import (
 )
 
 func InsertUser(provideMongo *mongo.Database) InsertUserFunc {
-	return func(u internal.User) error {
+	return func(ctx context.Context, u internal.User) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(usersCollectionName)
-		_, err := col.InsertOne(context.Background(), u)
+		_, err := col.InsertOne(ctx, u)
 		return err
 	}
 }
 
 func RetrieveUserByEmail(provideMongo *mongo.Database) RetrieveUserByEmailFunc {
-	return func(email string) (internal.User, error) {
+	return func(ctx context.Context, email string) (internal.User, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(usersCollectionName)
 		filter := bson.M{"email": strings.ToLower(email)}
 
 		var u internal.User
-		if err := col.FindOne(context.Background(), filter).Decode(&u); err != nil {
-			return internal.User{}, errors.Wrapf(err, "db - unable to find user with email=%v", email)
+		if err := col.FindOne(ctx, filter).Decode(&u); err != nil {
+			return internal.User{}, notFoundOrWrap(err, "db - unable to find user with email=%v", email)
 		}
 		return u, nil
 	}
 }
 
 func RetrieveUserByID(provideMongo *mongo.Database) RetrieveUserByIDFunc {
-	return func(id string) (internal.User, error) {
+	return func(ctx context.Context, id string) (internal.User, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(usersCollectionName)
 		filter := bson.M{"id": id}
 
 		var u internal.User
-		if err := col.FindOne(context.Background(), filter).Decode(&u); err != nil {
-			return internal.User{}, errors.Wrapf(err, "db - unable to find user with id=%v", id)
+		if err := col.FindOne(ctx, filter).Decode(&u); err != nil {
+			return internal.User{}, notFoundOrWrap(err, "db - unable to find user with id=%v", id)
 		}
 		return u, nil
 	}
 }
 
 func RetrieveUserByAlumniID(provideMongo *mongo.Database) RetrieveUserByAlumniIDFunc {
-	return func(alumniId string) (internal.User, error) {
+	return func(ctx context.Context, alumniId string) (internal.User, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(usersCollectionName)
 		filter := bson.M{"alumniId": alumniId}
 
 		var u internal.User
-		if err := col.FindOne(context.Background(), filter).Decode(&u); err != nil {
-			return internal.User{}, errors.Wrapf(err, "db - unable to find user with alumniId=%v", alumniId)
+		if err := col.FindOne(ctx, filter).Decode(&u); err != nil {
+			return internal.User{}, notFoundOrWrap(err, "db - unable to find user with alumniId=%v", alumniId)
 		}
 		return u, nil
 	}
 }
 
-func RetrieveUsersAlumniIDs(provideMongo *mongo.Database) RetrieveUsersAlumniIDsFunc {
-	return func(status string) ([]string, error) {
+func RetrieveUsers(provideMongo *mongo.Database) RetrieveUsersFunc {
+	return func(ctx context.Context, params pkg.UserQueryParams, scope internal.AlumniScope) ([]internal.User, pkg.PageInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(usersCollectionName)
 
 		filter := bson.M{}
-		if status != "" {
-			filter = bson.M{"status": status}
+		and := []bson.M{}
+		if params.Email != "" {
+			filter["email"] = bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Email), Options: "i"}}
+		}
+		if params.Status == internal.PendingUserStatus {
+			// Users created before statuses existed have none, and are treated as pending
+			and = append(and, bson.M{"$or": []bson.M{{"status": params.Status}, {"status": ""}, {"status": bson.M{"$exists": false}}}})
+			// and are only queued for approval once they have verified their email, as in accountFilter
+			filter["emailVerified"] = bson.M{"$ne": false}
+		} else if params.Status != "" {
+			filter["status"] = params.Status
+		}
+		if params.Admin != nil {
+			admin := []bson.M{{"admin": true}, {"roles.0": bson.M{"$exists": true}}}
+			if *params.Admin {
+				and = append(and, bson.M{"$or": admin})
+			} else {
+				and = append(and, bson.M{"$nor": admin})
+			}
+		}
+		if params.HasAlumni != nil {
+			noAlumni := []bson.M{{"alumniId": ""}, {"alumniId": bson.M{"$exists": false}}}
+			if *params.HasAlumni {
+				and = append(and, bson.M{"$nor": noAlumni})
+			} else {
+				and = append(and, bson.M{"$or": noAlumni})
+			}
+		}
+		created := bson.M{}
+		if params.CreatedAfter != "" {
+			after, err := time.NewISO8601(params.CreatedAfter)
+			if err != nil {
+				return []internal.User{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdAfter=%v", params.CreatedAfter)
+			}
+			created["$gte"] = after.ToEpoch()
+		}
+		if params.CreatedBefore != "" {
+			before, err := time.NewISO8601(params.CreatedBefore)
+			if err != nil {
+				return []internal.User{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdBefore=%v", params.CreatedBefore)
+			}
+			created["$lt"] = before.ToEpoch()
+		}
+		if len(created) > 0 {
+			filter["createdTimestamp"] = created
 		}
-		ctx := context.Background()
-		cur, err := col.Find(ctx, filter)
+		if len(and) > 0 {
+			filter["$and"] = and
+		}
+
+		// Scoped admins only see the users linked to an alumni record within their scope, which is joined
+		// onto each user so the scope is matched, and the page taken, in the one query
+		pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+		if !scope.All {
+			visible, ok := scopeFilter(scope)
+			if !ok {
+				return []internal.User{}, newPageInfo(0, params.Page, params.Limit), nil
+			}
+			pipeline = append(pipeline,
+				bson.D{{Key: "$lookup", Value: bson.M{
+					"from":         alumnisCollectionName,
+					"localField":   "alumniId",
+					"foreignField": "id",
+					"as":           "alumni",
+				}}},
+				bson.D{{Key: "$match", Value: bson.M{"alumni": bson.M{"$elemMatch": visible}}}},
+				bson.D{{Key: "$project", Value: bson.M{"alumni": 0}}},
+			)
+		}
+
+		page := append(mongo.Pipeline{}, pipeline...)
+		page = append(page, bson.D{{Key: "$sort", Value: bson.D{{Key: "createdTimestamp", Value: 1}, {Key: "id", Value: 1}}}})
+		if params.Page > 1 {
+			page = append(page, bson.D{{Key: "$skip", Value: (params.Page - 1) * params.Limit}})
+		}
+		if params.Limit > 0 {
+			page = append(page, bson.D{{Key: "$limit", Value: params.Limit}})
+		}
+
+		cur, err := col.Aggregate(ctx, page)
 		if err != nil {
-			return []string{}, errors.Wrapf(err, "db - unable to retrieve users")
+			return []internal.User{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to retrieve users")
 		}
 
 		defer cur.Close(ctx)
-		var ids []string
+		uu := []internal.User{}
 		for cur.Next(ctx) {
 			var u internal.User
 			if err := cur.Decode(&u); err != nil {
-				return []string{}, errors.Wrap(err, "db - error decoding user")
+				return []internal.User{}, pkg.PageInfo{}, errors.Wrap(err, "db - error decoding user")
 			}
-			ids = append(ids, u.AlumniID.Val())
+			uu = append(uu, u)
+		}
+		if err := cur.Err(); err != nil {
+			return []internal.User{}, pkg.PageInfo{}, errors.Wrap(err, "db - error reading users")
 		}
 
-		return ids, nil
+		count, err := countAggregate(ctx, col, pipeline)
+		if err != nil {
+			return []internal.User{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to calculate page info")
+		}
+
+		return uu, newPageInfo(count, params.Page, params.Limit), nil
 	}
 }
 
 func ReplaceUser(provideMongo *mongo.Database) ReplaceUserFunc {
-	return func(u internal.User) error {
+	return func(ctx context.Context, u internal.User) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(usersCollectionName)
 		filter := bson.M{"id": u.ID}
 
-		_, err := col.ReplaceOne(context.Background(), filter, u)
+		_, err := col.ReplaceOne(ctx, filter, u)
 		if err != nil {
 			return errors.Wrapf(err, "db - unable to replace user with id=%v", u.ID)
 		}
@@ -102,39 +200,123 @@ func ReplaceUser(provideMongo *mongo.Database) ReplaceUserFunc {
 	}
 }
 
+func UpdateUserEmail(provideMongo *mongo.Database) UpdateUserEmailFunc {
+	return func(ctx context.Context, id, oldEmail, newEmail string, at time.Epoch) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(usersCollectionName)
+		// Matching on the old email too means a change confirmed twice, or raced by another, only applies once
+		filter := bson.M{"id": id, "email": oldEmail}
+		update := bson.M{"$set": bson.M{
+			"email":                newEmail,
+			"emailVerified":        true,
+			"lastUpdatedTimestamp": at,
+		}}
+
+		res, err := col.UpdateOne(ctx, filter, update)
+		if isDuplicateKey(err) {
+			return apperror.Wrap(err, apperror.ConflictCode, "db - a user already exists with email=%v", newEmail)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "db - unable to update email of user with id=%v", id)
+		}
+		if res.MatchedCount == 0 {
+			return apperror.New(apperror.NotFoundCode, "db - unable to find user with id=%v and email=%v", id, oldEmail)
+		}
+		return nil
+	}
+}
+
+func DeleteUser(provideMongo *mongo.Database) DeleteUserFunc {
+	return func(ctx context.Context, id string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(usersCollectionName)
+		filter := bson.M{"id": id}
+
+		if _, err := col.DeleteOne(ctx, filter); err != nil {
+			return errors.Wrapf(err, "db - unable to delete user with id=%v", id)
+		}
+		return nil
+	}
+}
+
+func DeleteAlumni(provideMongo *mongo.Database) DeleteAlumniFunc {
+	return func(ctx context.Context, id string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumnisCollectionName)
+		filter := bson.M{"id": id}
+
+		if _, err := col.DeleteOne(ctx, filter); err != nil {
+			return errors.Wrapf(err, "db - unable to delete alumni with id=%v", id)
+		}
+		return nil
+	}
+}
+
+func UpdateAlumniEmail(provideMongo *mongo.Database) UpdateAlumniEmailFunc {
+	return func(ctx context.Context, id, email string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumnisCollectionName)
+		filter := bson.M{"id": id}
+		update := bson.M{
+			"$set": bson.M{"emailAddress": email},
+			"$inc": bson.M{"version": 1},
+		}
+
+		if _, err := col.UpdateOne(ctx, filter, update); err != nil {
+			return errors.Wrapf(err, "db - unable to update email of alumni with id=%v", id)
+		}
+		return nil
+	}
+}
+
 func InsertAlumni(provideMongo *mongo.Database) InsertAlumniFunc {
-	return func(a internal.Alumni) error {
+	return func(ctx context.Context, a internal.Alumni) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(alumnisCollectionName)
-		_, err := col.InsertOne(context.Background(), a)
+		_, err := col.InsertOne(ctx, a)
 		return err
 	}
 }
 
 func UpdateAlumni(provideMongo *mongo.Database) UpdateAlumniFunc {
-	return func(id string, a internal.UpdateAlumniRequest) error {
+	return func(ctx context.Context, id string, version int64, a internal.UpdateAlumniRequest) (internal.Alumni, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(alumnisCollectionName)
-		filter := bson.M{"id": id}
+		// Matching on the version means an update made against a stale copy of the alumni never overwrites a newer one
+		filter := bson.M{"id": id, "version": version}
 
 		update := bson.D{
-			{"$set", a},
+			{Key: "$set", Value: a},
+			{Key: "$inc", Value: bson.M{"version": 1}},
 		}
-		_, err := col.UpdateOne(context.Background(), filter, update)
-		if err != nil {
-			return errors.Wrapf(err, "db - unable to update alumniId=%v", id)
+		if len(a.Unset) > 0 {
+			unset := bson.M{}
+			for _, field := range a.Unset {
+				unset[field] = ""
+			}
+			update = append(update, bson.E{Key: "$unset", Value: unset})
 		}
 
-		return nil
+		return updateAlumniVersion(ctx, col, filter, update)
 	}
 }
 
 func RetrieveAlumniByID(provideMongo *mongo.Database) RetrieveAlumniByIDFunc {
-	return func(id string) (internal.Alumni, error) {
+	return func(ctx context.Context, id string) (internal.Alumni, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(alumnisCollectionName)
 		filter := bson.M{"id": id}
 
 		var a internal.Alumni
-		if err := col.FindOne(context.Background(), filter).Decode(&a); err != nil {
-			return internal.Alumni{}, errors.Wrapf(err, "db - unable to find alumni with id=%v", id)
+		if err := col.FindOne(ctx, filter).Decode(&a); err != nil {
+			return internal.Alumni{}, notFoundOrWrap(err, "db - unable to find alumni with id=%v", id)
 		}
 
 		return a, nil
@@ -142,49 +324,46 @@ func RetrieveAlumniByID(provideMongo *mongo.Database) RetrieveAlumniByIDFunc {
 }
 
 func ChangeAlumniPrivacy(provideMongo *mongo.Database) ChangeAlumniPrivacyFunc {
-	return func(id string, isPublic bool) error {
+	return func(ctx context.Context, id string, version int64, isPublic bool) (internal.Alumni, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(alumnisCollectionName)
-		filter := bson.M{"id": id}
+		filter := bson.M{"id": id, "version": version}
 
 		update := bson.D{
-			{"$set", bson.D{{
-				"isPublic", isPublic,
+			{Key: "$set", Value: bson.D{{
+				Key: "isPublic", Value: isPublic,
 			}}},
+			{Key: "$inc", Value: bson.M{"version": 1}},
 		}
 
-		_, err := col.UpdateOne(context.Background(), filter, update)
-		if err != nil {
-			return errors.Wrapf(err, "db - unable to update privacy status for alumniId=%v", id)
-		}
-
-		return nil
+		return updateAlumniVersion(ctx, col, filter, update)
 	}
 }
 
-func RetrieveAllAlumni(provideMongo *mongo.Database) RetrieveAllAlumniFunc {
-	return func(params pkg.QueryParams, alumniId string, isAdmin bool, ids ...string) ([]internal.Alumni, pkg.PageInfo, error) {
-		col := provideMongo.Collection(alumnisCollectionName)
-		filter := bson.M{
-			"firstname":            bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Firstname), Options: "i"}},
-			"birthday":             bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Birthday)}},
-			"highschool.yearEnded": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.YearGraduated)}},
-			"id":                   bson.M{"$ne": alumniId},
-			"$or": []bson.M{
-				{"lastname": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
-				{"marriedName": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
-				{"maidenName": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
-				{"spouseMaidenName": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
-				{"siblings.lastname": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
-				{"grandparents.lastname": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
-			},
-		}
+// updateAlumniVersion applies an update to the alumni matching a filter on its id and version, returning the updated alumni
+func updateAlumniVersion(ctx context.Context, col *mongo.Collection, filter bson.M, update bson.D) (internal.Alumni, error) {
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-		if !isAdmin {
-			filter["isPublic"] = true
+	var updated internal.Alumni
+	if err := col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return internal.Alumni{}, apperror.Wrap(err, apperror.ConflictCode, "db - alumniId=%v is no longer at version=%v", filter["id"], filter["version"])
 		}
+		return internal.Alumni{}, errors.Wrapf(err, "db - unable to update alumniId=%v", filter["id"])
+	}
+
+	return updated, nil
+}
 
-		if len(ids) > 0 {
-			filter["id"] = bson.M{"$in": ids, "$ne": alumniId}
+func RetrieveAllAlumni(provideMongo *mongo.Database) RetrieveAllAlumniFunc {
+	return func(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.Alumni, pkg.PageInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumnisCollectionName)
+		filter, ok := alumniFilter(params, alumniId, scope)
+		if !ok {
+			return []internal.Alumni{}, pkg.PageInfo{}, nil
 		}
 
 		var skip int64
@@ -202,7 +381,6 @@ func RetrieveAllAlumni(provideMongo *mongo.Database) RetrieveAllAlumniFunc {
 			opts.Skip = &zeroInt64
 		}
 
-		ctx := context.Background()
 		cur, err := col.Find(ctx, filter, &opts)
 		if err != nil {
 			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to find any alumnis")
@@ -218,7 +396,7 @@ func RetrieveAllAlumni(provideMongo *mongo.Database) RetrieveAllAlumniFunc {
 			aa = append(aa, a)
 		}
 
-		pi, err := pageInfo(col, filter, params.Page, params.Limit)
+		pi, err := pageInfo(ctx, col, filter, params.Page, params.Limit)
 		if err != nil {
 			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to calculate page info")
 		}
@@ -227,12 +405,259 @@ func RetrieveAllAlumni(provideMongo *mongo.Database) RetrieveAllAlumniFunc {
 	}
 }
 
-func pageInfo(col *mongo.Collection, filter interface{}, page int64, limit int64) (pkg.PageInfo, error) {
-	count, err := col.CountDocuments(context.Background(), filter)
+// RetrieveAlumniAccounts finds the alumni linked to a user account, each joined with that account in the same
+// aggregation rather than looking users up one alumnus at a time. params.Status filters on the status of the account
+func RetrieveAlumniAccounts(provideMongo *mongo.Database) RetrieveAlumniAccountsFunc {
+	return func(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.AlumniAccount, pkg.PageInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumnisCollectionName)
+		filter, ok := alumniFilter(params, alumniId, scope)
+		if !ok {
+			return []internal.AlumniAccount{}, pkg.PageInfo{}, nil
+		}
+
+		hasAccount := bson.M{"users.0": bson.M{"$exists": true}}
+		if account := accountFilter(params.Status); len(account) > 0 {
+			hasAccount = bson.M{"users": bson.M{"$elemMatch": account}}
+		}
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: filter}},
+			{{Key: "$lookup", Value: bson.M{
+				"from":         usersCollectionName,
+				"localField":   "id",
+				"foreignField": "alumniId",
+				"as":           "users",
+			}}},
+			{{Key: "$match", Value: hasAccount}},
+		}
+
+		page := append(mongo.Pipeline{}, pipeline...)
+		if params.Limit != (-1) {
+			if params.Page > 1 {
+				page = append(page, bson.D{{Key: "$skip", Value: (params.Page - 1) * params.Limit}})
+			}
+			if params.Limit > 0 {
+				page = append(page, bson.D{{Key: "$limit", Value: params.Limit}})
+			}
+		}
+
+		cur, err := col.Aggregate(ctx, page)
+		if err != nil {
+			return []internal.AlumniAccount{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to find any alumni accounts")
+		}
+
+		defer cur.Close(ctx)
+		aa := []internal.AlumniAccount{}
+		for cur.Next(ctx) {
+			var joined struct {
+				internal.Alumni `bson:",inline"`
+				Users           []internal.User `bson:"users"`
+			}
+			if err := cur.Decode(&joined); err != nil {
+				return []internal.AlumniAccount{}, pkg.PageInfo{}, errors.Wrap(err, "db - error decoding alumni account")
+			}
+			aa = append(aa, internal.AlumniAccount{Alumni: joined.Alumni, User: firstAccount(joined.Users, params.Status)})
+		}
+		if err := cur.Err(); err != nil {
+			return []internal.AlumniAccount{}, pkg.PageInfo{}, errors.Wrap(err, "db - error reading alumni accounts")
+		}
+
+		// Every result is already in hand when the whole list was asked for, so there is nothing left to count
+		count := int64(len(aa))
+		if params.Limit != (-1) {
+			count, err = countAggregate(ctx, col, pipeline)
+			if err != nil {
+				return []internal.AlumniAccount{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to calculate page info")
+			}
+		}
+
+		return aa, newPageInfo(count, params.Page, params.Limit), nil
+	}
+}
+
+// RetrieveUnclaimedAlumni finds the alumni a user may be looking to claim. Records already linked to an account are
+// left out by the aggregation itself, so each page and its count only hold unclaimed records
+func RetrieveUnclaimedAlumni(provideMongo *mongo.Database) RetrieveUnclaimedAlumniFunc {
+	return func(ctx context.Context, params pkg.QueryParams) ([]internal.Alumni, pkg.PageInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumnisCollectionName)
+
+		pipeline := mongo.Pipeline{
+			{{Key: "$match", Value: claimableFilter(params)}},
+			{{Key: "$lookup", Value: bson.M{
+				"from":         usersCollectionName,
+				"localField":   "id",
+				"foreignField": "alumniId",
+				"as":           "users",
+			}}},
+			{{Key: "$match", Value: bson.M{"users.0": bson.M{"$exists": false}}}},
+			{{Key: "$project", Value: bson.M{"users": 0}}},
+		}
+
+		page := append(mongo.Pipeline{}, pipeline...)
+		if params.Page > 1 {
+			page = append(page, bson.D{{Key: "$skip", Value: (params.Page - 1) * params.Limit}})
+		}
+		page = append(page, bson.D{{Key: "$limit", Value: params.Limit}})
+
+		cur, err := col.Aggregate(ctx, page)
+		if err != nil {
+			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to find any unclaimed alumni")
+		}
+
+		defer cur.Close(ctx)
+		aa := []internal.Alumni{}
+		for cur.Next(ctx) {
+			var a internal.Alumni
+			if err := cur.Decode(&a); err != nil {
+				return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - error decoding alumni")
+			}
+			aa = append(aa, a)
+		}
+		if err := cur.Err(); err != nil {
+			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - error reading unclaimed alumni")
+		}
+
+		count, err := countAggregate(ctx, col, pipeline)
+		if err != nil {
+			return []internal.Alumni{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to calculate page info")
+		}
+
+		return aa, newPageInfo(count, params.Page, params.Limit), nil
+	}
+}
+
+// claimableFilter returns the filter for alumni with exactly the first name, last name and graduation year searched
+// for, ignoring case. The last name may be the one they were born with or married into
+func claimableFilter(params pkg.QueryParams) bson.M {
+	exactly := func(v string) bson.M {
+		return bson.M{"$regex": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(strings.TrimSpace(v)) + "$", Options: "i"}}
+	}
+	return bson.M{
+		"firstname":            exactly(params.Firstname),
+		"highschool.yearEnded": strings.TrimSpace(params.YearGraduated),
+		"$or": []bson.M{
+			{"lastname": exactly(params.Lastname)},
+			{"marriedName": exactly(params.Lastname)},
+			{"maidenName": exactly(params.Lastname)},
+		},
+	}
+}
+
+// alumniFilter returns the filter for alumni matching the search params within the scope, other than the caller's own record.
+// It returns false when the scope cannot see any alumni
+func alumniFilter(params pkg.QueryParams, alumniId string, scope internal.AlumniScope) (bson.M, bool) {
+	filter := bson.M{
+		"firstname":            bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Firstname), Options: "i"}},
+		"birthday":             bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Birthday)}},
+		"highschool.yearEnded": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.YearGraduated)}},
+		"id":                   bson.M{"$ne": alumniId},
+		"$or": []bson.M{
+			{"lastname": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
+			{"marriedName": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
+			{"maidenName": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
+			{"spouseMaidenName": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
+			{"siblings.lastname": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
+			{"grandparents.lastname": bson.M{"$regex": primitive.Regex{Pattern: regexp.QuoteMeta(params.Lastname), Options: "i"}}},
+		},
+	}
+
+	if !scope.All {
+		visible, ok := scopeFilter(scope)
+		if !ok {
+			return nil, false
+		}
+		filter["$and"] = []bson.M{visible}
+	}
+
+	return filter, true
+}
+
+// scopeFilter returns the filter for the alumni within a scope that is not All, returning false when it cannot see any
+func scopeFilter(scope internal.AlumniScope) (bson.M, bool) {
+	visible := []bson.M{}
+	if scope.Public {
+		visible = append(visible, bson.M{"isPublic": true})
+	}
+	for _, d := range scope.Divisions {
+		visible = append(visible, bson.M{strings.ToLower(d): true})
+	}
+	if len(scope.GraduationYears) > 0 {
+		visible = append(visible, bson.M{"highschool.yearEnded": bson.M{"$in": scope.GraduationYears}})
+	}
+	if len(visible) == 0 {
+		return nil, false
+	}
+	return bson.M{"$or": visible}, true
+}
+
+// accountFilter returns the filter for user accounts with the status, any account when status is empty
+func accountFilter(status string) bson.M {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+	// Accounts awaiting approval are only queued once they prove they own their email address,
+	// users created before verification existed have no emailVerified field and stay queued
+	if status == internal.PendingUserStatus {
+		filter["emailVerified"] = bson.M{"$ne": false}
+	}
+	return filter
+}
+
+// firstAccount returns the first of the users linked to an alumni record that has the status. The query already
+// matched one of them, so the first user stands in for a legacy account whose missing fields decode as zero values
+func firstAccount(uu []internal.User, status string) internal.User {
+	for _, u := range uu {
+		if accountHasStatus(u, status) {
+			return u
+		}
+	}
+	if len(uu) > 0 {
+		return uu[0]
+	}
+	return internal.User{}
+}
+
+// accountHasStatus mirrors accountFilter for a user already in hand
+func accountHasStatus(u internal.User, status string) bool {
+	if status == "" {
+		return true
+	}
+	return u.Status == status && (status != internal.PendingUserStatus || u.EmailVerified)
+}
+
+func countAggregate(ctx context.Context, col *mongo.Collection, pipeline mongo.Pipeline) (int64, error) {
+	cur, err := col.Aggregate(ctx, append(pipeline, bson.D{{Key: "$count", Value: "count"}}))
+	if err != nil {
+		return 0, err
+	}
+
+	defer cur.Close(ctx)
+	var res struct {
+		Count int64 `bson:"count"`
+	}
+	if cur.Next(ctx) {
+		if err := cur.Decode(&res); err != nil {
+			return 0, err
+		}
+	}
+	return res.Count, cur.Err()
+}
+
+func pageInfo(ctx context.Context, col *mongo.Collection, filter interface{}, page int64, limit int64) (pkg.PageInfo, error) {
+	count, err := col.CountDocuments(ctx, filter)
 	if err != nil {
 		return pkg.PageInfo{}, err
 	}
 
+	return newPageInfo(count, page, limit), nil
+}
+
+// newPageInfo returns which page of the results matching a query is being returned, out of how many
+func newPageInfo(count int64, page int64, limit int64) pkg.PageInfo {
 	if page == 0 {
 		page = 1
 	}
@@ -245,56 +670,658 @@ func pageInfo(col *mongo.Collection, filter interface{}, page int64, limit int64
 		pages++
 	}
 
-	pi := pkg.PageInfo{
+	return pkg.PageInfo{
 		CurrentPage: page,
 		LastPage:    pages,
 	}
-
-	return pi, nil
 }
 
 func RetrieveEmailTemplateByName(provideMongo *mongo.Database) RetrieveEmailTemplateByNameFunc {
-	return func(name string) (internal.EmailTemplate, error) {
+	return func(ctx context.Context, name string) (internal.EmailTemplate, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(emailTemplatesCollectionName)
 		filter := bson.M{"name": name}
 
 		var et internal.EmailTemplate
-		if err := col.FindOne(context.Background(), filter).Decode(&et); err != nil {
-			return internal.EmailTemplate{}, errors.Wrapf(err, "db - unable to find email template with name=%v", name)
+		if err := col.FindOne(ctx, filter).Decode(&et); err != nil {
+			return internal.EmailTemplate{}, notFoundOrWrap(err, "db - unable to find email template with name=%v", name)
 		}
 
 		return et, nil
 	}
 }
 
+func RetrieveAllEmailTemplates(provideMongo *mongo.Database) RetrieveAllEmailTemplatesFunc {
+	return func(ctx context.Context) ([]internal.EmailTemplate, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailTemplatesCollectionName)
+
+		opts := options.Find().SetSort(bson.M{"name": 1})
+		cur, err := col.Find(ctx, bson.M{}, opts)
+		if err != nil {
+			return []internal.EmailTemplate{}, errors.Wrap(err, "db - unable to retrieve email templates")
+		}
+
+		defer cur.Close(ctx)
+		ets := []internal.EmailTemplate{}
+		if err := cur.All(ctx, &ets); err != nil {
+			return []internal.EmailTemplate{}, errors.Wrap(err, "db - error decoding email templates")
+		}
+
+		return ets, nil
+	}
+}
+
+func UpsertEmailTemplate(provideMongo *mongo.Database) UpsertEmailTemplateFunc {
+	return func(ctx context.Context, et internal.EmailTemplate) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailTemplatesCollectionName)
+		filter := bson.M{"name": et.Name}
+
+		opts := options.Replace().SetUpsert(true)
+		if _, err := col.ReplaceOne(ctx, filter, et, opts); err != nil {
+			return errors.Wrapf(err, "db - unable to upsert email template with name=%v", et.Name)
+		}
+
+		return nil
+	}
+}
+
 func CreateResetPassword(provideMongo *mongo.Database) CreateResetPasswordFunc {
-	return func(rp internal.ResetPassword) error {
+	return func(ctx context.Context, rp internal.ResetPassword) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(resetPasswordsCollectionName)
-		_, err := col.InsertOne(context.Background(), rp)
+		_, err := col.InsertOne(ctx, rp)
 		return err
 	}
 }
 
-func FindResetPassword(provideMongo *mongo.Database) FindResetPasswordFunc {
-	return func(email, token string) (internal.ResetPassword, error) {
+// ConsumeResetPassword deletes and returns an unexpired reset password, so that it can only be used once
+func ConsumeResetPassword(provideMongo *mongo.Database) ConsumeResetPasswordFunc {
+	return func(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.ResetPassword, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(resetPasswordsCollectionName)
-		filter := bson.M{"email": email, "token": token}
+		filter := bson.M{
+			"email":     strings.ToLower(email),
+			"tokenHash": tokenHash,
+			"expiresAt": bson.M{"$gt": now},
+		}
 
 		var rp internal.ResetPassword
-		if err := col.FindOne(context.Background(), filter).Decode(&rp); err != nil {
-			return internal.ResetPassword{}, errors.Wrapf(err, "db - unable to find reset password with email=%v", email)
+		if err := col.FindOneAndDelete(ctx, filter).Decode(&rp); err != nil {
+			return internal.ResetPassword{}, notFoundOrWrap(err, "db - unable to find reset password with email=%v", email)
 		}
 
 		return rp, nil
 	}
 }
 
+func CountResetPasswords(provideMongo *mongo.Database) CountResetPasswordsFunc {
+	return func(ctx context.Context, email string, since gotime.Time) (int64, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(resetPasswordsCollectionName)
+		filter := bson.M{
+			"email":            strings.ToLower(email),
+			"createdTimestamp": bson.M{"$gte": since},
+		}
+
+		n, err := col.CountDocuments(ctx, filter)
+		if err != nil {
+			return 0, errors.Wrapf(err, "db - unable to count reset passwords with email=%v", email)
+		}
+
+		return n, nil
+	}
+}
+
 func DeleteResetPasswords(provideMongo *mongo.Database) DeleteResetPasswordsFunc {
-	return func(email string) error {
+	return func(ctx context.Context, email string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
 		col := provideMongo.Collection(resetPasswordsCollectionName)
 		filter := bson.M{"email": email}
 
-		_, err := col.DeleteMany(context.Background(), filter)
+		_, err := col.DeleteMany(ctx, filter)
+		return err
+	}
+}
+
+func InsertRefreshToken(provideMongo *mongo.Database) InsertRefreshTokenFunc {
+	return func(ctx context.Context, rt internal.RefreshToken) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(refreshTokensCollectionName)
+		_, err := col.InsertOne(ctx, rt)
+		return err
+	}
+}
+
+func RetrieveRefreshTokenByID(provideMongo *mongo.Database) RetrieveRefreshTokenByIDFunc {
+	return func(ctx context.Context, id string) (internal.RefreshToken, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(refreshTokensCollectionName)
+		filter := bson.M{"id": id}
+
+		var rt internal.RefreshToken
+		if err := col.FindOne(ctx, filter).Decode(&rt); err != nil {
+			return internal.RefreshToken{}, notFoundOrWrap(err, "db - unable to find refresh token with id=%v", id)
+		}
+
+		return rt, nil
+	}
+}
+
+// RotateRefreshToken atomically swaps the refresh token matching oldHash for rt, so a refresh token can only be used once
+func RotateRefreshToken(provideMongo *mongo.Database) RotateRefreshTokenFunc {
+	return func(ctx context.Context, oldHash string, rt internal.RefreshToken) (internal.RefreshToken, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(refreshTokensCollectionName)
+		filter := bson.M{
+			"tokenHash": oldHash,
+			"expiresAt": bson.M{"$gt": rt.LastUpdatedTimestamp.ToISO8601().Val()},
+		}
+
+		update := bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "tokenHash", Value: rt.TokenHash},
+				{Key: "expiresAt", Value: rt.ExpiresAt},
+				{Key: "lastUpdatedTimestamp", Value: rt.LastUpdatedTimestamp},
+			}},
+		}
+		opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+		var updated internal.RefreshToken
+		if err := col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+			return internal.RefreshToken{}, notFoundOrWrap(err, "db - unable to rotate refresh token")
+		}
+
+		return updated, nil
+	}
+}
+
+func DeleteRefreshToken(provideMongo *mongo.Database) DeleteRefreshTokenFunc {
+	return func(ctx context.Context, id string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(refreshTokensCollectionName)
+		filter := bson.M{"id": id}
+
+		_, err := col.DeleteOne(ctx, filter)
+		return err
+	}
+}
+
+func DeleteUserRefreshTokens(provideMongo *mongo.Database) DeleteUserRefreshTokensFunc {
+	return func(ctx context.Context, userId string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(refreshTokensCollectionName)
+		filter := bson.M{"userId": userId}
+
+		_, err := col.DeleteMany(ctx, filter)
+		return err
+	}
+}
+
+func InsertRoleChange(provideMongo *mongo.Database) InsertRoleChangeFunc {
+	return func(ctx context.Context, rc internal.RoleChange) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(roleChangesCollectionName)
+		_, err := col.InsertOne(ctx, rc)
+		return err
+	}
+}
+
+func RetrieveRoleChanges(provideMongo *mongo.Database) RetrieveRoleChangesFunc {
+	return func(ctx context.Context, userId string) ([]internal.RoleChange, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(roleChangesCollectionName)
+		filter := bson.M{"userId": userId}
+
+		opts := options.Find().SetSort(bson.M{"createdTimestamp": -1})
+		cur, err := col.Find(ctx, filter, opts)
+		if err != nil {
+			return []internal.RoleChange{}, errors.Wrapf(err, "db - unable to retrieve role changes for userId=%v", userId)
+		}
+
+		defer cur.Close(ctx)
+		rcs := []internal.RoleChange{}
+		if err := cur.All(ctx, &rcs); err != nil {
+			return []internal.RoleChange{}, errors.Wrap(err, "db - error decoding role changes")
+		}
+
+		return rcs, nil
+	}
+}
+
+func InsertAlumniHistory(provideMongo *mongo.Database) InsertAlumniHistoryFunc {
+	return func(ctx context.Context, h internal.AlumniHistory) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumniHistoryCollectionName)
+		if _, err := col.InsertOne(ctx, h); err != nil {
+			return errors.Wrapf(err, "db - unable to insert history of alumniId=%v", h.AlumniID)
+		}
+		return nil
+	}
+}
+
+func RetrieveAlumniHistory(provideMongo *mongo.Database) RetrieveAlumniHistoryFunc {
+	return func(ctx context.Context, alumniId string) ([]internal.AlumniHistory, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumniHistoryCollectionName)
+		filter := bson.M{"alumniId": alumniId}
+
+		// The snapshots are only needed to restore a version, so are left out of the timeline
+		opts := options.Find().SetSort(bson.M{"version": -1}).SetProjection(bson.M{"alumni": 0})
+		cur, err := col.Find(ctx, filter, opts)
+		if err != nil {
+			return []internal.AlumniHistory{}, errors.Wrapf(err, "db - unable to retrieve history of alumniId=%v", alumniId)
+		}
+
+		defer cur.Close(ctx)
+		hh := []internal.AlumniHistory{}
+		if err := cur.All(ctx, &hh); err != nil {
+			return []internal.AlumniHistory{}, errors.Wrap(err, "db - error decoding alumni history")
+		}
+
+		return hh, nil
+	}
+}
+
+func RetrieveAlumniHistoryVersion(provideMongo *mongo.Database) RetrieveAlumniHistoryVersionFunc {
+	return func(ctx context.Context, alumniId string, version int64) (internal.AlumniHistory, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumniHistoryCollectionName)
+		filter := bson.M{"alumniId": alumniId, "version": version}
+
+		var h internal.AlumniHistory
+		if err := col.FindOne(ctx, filter).Decode(&h); err != nil {
+			return internal.AlumniHistory{}, notFoundOrWrap(err, "db - unable to find version=%v of alumniId=%v", version, alumniId)
+		}
+
+		return h, nil
+	}
+}
+
+func DeleteAlumniHistory(provideMongo *mongo.Database) DeleteAlumniHistoryFunc {
+	return func(ctx context.Context, alumniId string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(alumniHistoryCollectionName)
+		filter := bson.M{"alumniId": alumniId}
+
+		if _, err := col.DeleteMany(ctx, filter); err != nil {
+			return errors.Wrapf(err, "db - unable to delete history of alumniId=%v", alumniId)
+		}
+		return nil
+	}
+}
+
+func InsertAuditEvent(provideMongo *mongo.Database) InsertAuditEventFunc {
+	return func(ctx context.Context, e internal.AuditEvent) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(auditEventsCollectionName)
+
+		if _, err := col.InsertOne(ctx, e); err != nil {
+			return errors.Wrapf(err, "db - unable to insert audit event action=%v", e.Action)
+		}
+		return nil
+	}
+}
+
+func RetrieveAuditEvents(provideMongo *mongo.Database) RetrieveAuditEventsFunc {
+	return func(ctx context.Context, params pkg.AuditQueryParams) ([]internal.AuditEvent, pkg.PageInfo, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(auditEventsCollectionName)
+
+		filter := bson.M{}
+		if params.ActorID != "" {
+			filter["actorId"] = params.ActorID
+		}
+		if params.Action != "" {
+			filter["action"] = params.Action
+		}
+		if params.TargetType != "" {
+			filter["targetType"] = params.TargetType
+		}
+		if params.TargetID != "" {
+			filter["targetId"] = params.TargetID
+		}
+		if params.Outcome != "" {
+			filter["outcome"] = params.Outcome
+		}
+
+		created := bson.M{}
+		if params.CreatedAfter != "" {
+			after, err := time.NewISO8601(params.CreatedAfter)
+			if err != nil {
+				return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdAfter=%v", params.CreatedAfter)
+			}
+			created["$gte"] = after.ToEpoch()
+		}
+		if params.CreatedBefore != "" {
+			before, err := time.NewISO8601(params.CreatedBefore)
+			if err != nil {
+				return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to parse createdBefore=%v", params.CreatedBefore)
+			}
+			created["$lt"] = before.ToEpoch()
+		}
+		if len(created) > 0 {
+			filter["createdTimestamp"] = created
+		}
+
+		// _id breaks ties between events recorded at the same time in the order they were inserted
+		opts := options.Find().SetSort(bson.D{{Key: "createdTimestamp", Value: -1}, {Key: "_id", Value: -1}})
+		if params.Limit > 0 {
+			opts.SetSkip((params.Page - 1) * params.Limit).SetLimit(params.Limit)
+		}
+
+		cur, err := col.Find(ctx, filter, opts)
+		if err != nil {
+			return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrap(err, "db - unable to retrieve audit events")
+		}
+
+		defer cur.Close(ctx)
+		ee := []internal.AuditEvent{}
+		for cur.Next(ctx) {
+			var e internal.AuditEvent
+			if err := cur.Decode(&e); err != nil {
+				return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrap(err, "db - error decoding audit event")
+			}
+			ee = append(ee, e)
+		}
+
+		pi, err := pageInfo(ctx, col, filter, params.Page, params.Limit)
+		if err != nil {
+			return []internal.AuditEvent{}, pkg.PageInfo{}, errors.Wrapf(err, "db - unable to calculate page info")
+		}
+
+		return ee, pi, cur.Err()
+	}
+}
+
+func InsertEmailVerification(provideMongo *mongo.Database) InsertEmailVerificationFunc {
+	return func(ctx context.Context, ev internal.EmailVerification) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailVerificationsCollectionName)
+		_, err := col.InsertOne(ctx, ev)
 		return err
 	}
 }
+
+func RetrieveEmailVerification(provideMongo *mongo.Database) RetrieveEmailVerificationFunc {
+	return func(ctx context.Context, tokenHash string) (internal.EmailVerification, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailVerificationsCollectionName)
+		filter := bson.M{"tokenHash": tokenHash}
+
+		var ev internal.EmailVerification
+		if err := col.FindOne(ctx, filter).Decode(&ev); err != nil {
+			return internal.EmailVerification{}, notFoundOrWrap(err, "db - unable to find email verification")
+		}
+
+		return ev, nil
+	}
+}
+
+func DeleteEmailVerifications(provideMongo *mongo.Database) DeleteEmailVerificationsFunc {
+	return func(ctx context.Context, userId string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailVerificationsCollectionName)
+		filter := bson.M{"userId": userId}
+
+		_, err := col.DeleteMany(ctx, filter)
+		return err
+	}
+}
+
+func InsertEmailChange(provideMongo *mongo.Database) InsertEmailChangeFunc {
+	return func(ctx context.Context, ec internal.EmailChange) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailChangesCollectionName)
+		_, err := col.InsertOne(ctx, ec)
+		return err
+	}
+}
+
+// RetrieveEmailChange finds the pending email change either of its tokens was issued for
+func RetrieveEmailChange(provideMongo *mongo.Database) RetrieveEmailChangeFunc {
+	return func(ctx context.Context, tokenHash string) (internal.EmailChange, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailChangesCollectionName)
+		filter := bson.M{"$or": []bson.M{{"tokenHash": tokenHash}, {"cancelTokenHash": tokenHash}}}
+
+		var ec internal.EmailChange
+		if err := col.FindOne(ctx, filter).Decode(&ec); err != nil {
+			return internal.EmailChange{}, notFoundOrWrap(err, "db - unable to find email change")
+		}
+
+		return ec, nil
+	}
+}
+
+func DeleteEmailChanges(provideMongo *mongo.Database) DeleteEmailChangesFunc {
+	return func(ctx context.Context, userId string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(emailChangesCollectionName)
+		filter := bson.M{"userId": userId}
+
+		_, err := col.DeleteMany(ctx, filter)
+		return err
+	}
+}
+
+func InsertInvitation(provideMongo *mongo.Database) InsertInvitationFunc {
+	return func(ctx context.Context, i internal.Invitation) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(invitationsCollectionName)
+		_, err := col.InsertOne(ctx, i)
+		return err
+	}
+}
+
+// ConsumeInvitation deletes and returns the unexpired invitation matching the email and token, so it can only be accepted once
+func ConsumeInvitation(provideMongo *mongo.Database) ConsumeInvitationFunc {
+	return func(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.Invitation, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(invitationsCollectionName)
+		filter := bson.M{
+			"email":     strings.ToLower(email),
+			"tokenHash": tokenHash,
+			"expiresAt": bson.M{"$gt": now},
+		}
+
+		var i internal.Invitation
+		if err := col.FindOneAndDelete(ctx, filter).Decode(&i); err != nil {
+			return internal.Invitation{}, notFoundOrWrap(err, "db - unable to find invitation with email=%v", email)
+		}
+
+		return i, nil
+	}
+}
+
+func DeleteInvitations(provideMongo *mongo.Database) DeleteInvitationsFunc {
+	return func(ctx context.Context, alumniId string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(invitationsCollectionName)
+		filter := bson.M{"alumniId": alumniId}
+
+		_, err := col.DeleteMany(ctx, filter)
+		return err
+	}
+}
+
+func InsertProfileClaim(provideMongo *mongo.Database) InsertProfileClaimFunc {
+	return func(ctx context.Context, c internal.ProfileClaim) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(profileClaimsCollectionName)
+		_, err := col.InsertOne(ctx, c)
+		return err
+	}
+}
+
+func RetrieveProfileClaimByID(provideMongo *mongo.Database) RetrieveProfileClaimByIDFunc {
+	return func(ctx context.Context, id string) (internal.ProfileClaim, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(profileClaimsCollectionName)
+		filter := bson.M{"id": id}
+
+		var c internal.ProfileClaim
+		if err := col.FindOne(ctx, filter).Decode(&c); err != nil {
+			return internal.ProfileClaim{}, notFoundOrWrap(err, "db - unable to find profile claim with id=%v", id)
+		}
+		return c, nil
+	}
+}
+
+// RetrieveProfileClaims finds the claims matching each of the filters that are not empty, oldest first
+func RetrieveProfileClaims(provideMongo *mongo.Database) RetrieveProfileClaimsFunc {
+	return func(ctx context.Context, status, userId, alumniId string) ([]internal.ProfileClaim, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(profileClaimsCollectionName)
+		filter := bson.M{}
+		if status != "" {
+			filter["status"] = status
+		}
+		if userId != "" {
+			filter["userId"] = userId
+		}
+		if alumniId != "" {
+			filter["alumniId"] = alumniId
+		}
+		opts := options.Find().SetSort(bson.D{{Key: "createdTimestamp", Value: 1}})
+
+		cur, err := col.Find(ctx, filter, opts)
+		if err != nil {
+			return []internal.ProfileClaim{}, errors.Wrap(err, "db - unable to retrieve profile claims")
+		}
+
+		defer cur.Close(ctx)
+		cc := []internal.ProfileClaim{}
+		for cur.Next(ctx) {
+			var c internal.ProfileClaim
+			if err := cur.Decode(&c); err != nil {
+				return []internal.ProfileClaim{}, errors.Wrap(err, "db - error decoding profile claim")
+			}
+			cc = append(cc, c)
+		}
+
+		return cc, cur.Err()
+	}
+}
+
+func ReplaceProfileClaim(provideMongo *mongo.Database) ReplaceProfileClaimFunc {
+	return func(ctx context.Context, c internal.ProfileClaim) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(profileClaimsCollectionName)
+		filter := bson.M{"id": c.ID}
+
+		if _, err := col.ReplaceOne(ctx, filter, c); err != nil {
+			return errors.Wrapf(err, "db - unable to replace profile claim with id=%v", c.ID)
+		}
+		return nil
+	}
+}
+
+func RetrieveLoginAttempt(provideMongo *mongo.Database) RetrieveLoginAttemptFunc {
+	return func(ctx context.Context, key string) (internal.LoginAttempt, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(loginAttemptsCollectionName)
+		filter := bson.M{"key": key}
+
+		var la internal.LoginAttempt
+		if err := col.FindOne(ctx, filter).Decode(&la); err != nil {
+			return internal.LoginAttempt{}, notFoundOrWrap(err, "db - unable to find login attempts for key=%v", key)
+		}
+
+		return la, nil
+	}
+}
+
+func RecordLoginFailure(provideMongo *mongo.Database) RecordLoginFailureFunc {
+	return func(ctx context.Context, key string, at gotime.Time, expiresAt gotime.Time) (internal.LoginAttempt, error) {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(loginAttemptsCollectionName)
+		filter := bson.M{"key": key}
+
+		update := bson.D{
+			{Key: "$inc", Value: bson.D{{Key: "failures", Value: 1}}},
+			{Key: "$set", Value: bson.D{
+				{Key: "lastFailureAt", Value: at},
+				{Key: "expiresAt", Value: expiresAt},
+			}},
+		}
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+		var la internal.LoginAttempt
+		if err := col.FindOneAndUpdate(ctx, filter, update, opts).Decode(&la); err != nil {
+			return internal.LoginAttempt{}, errors.Wrapf(err, "db - unable to record login failure for key=%v", key)
+		}
+
+		return la, nil
+	}
+}
+
+func DeleteLoginAttempts(provideMongo *mongo.Database) DeleteLoginAttemptsFunc {
+	return func(ctx context.Context, keys ...string) error {
+		ctx, cancel := context.WithTimeout(ctx, operationTimeout)
+		defer cancel()
+		col := provideMongo.Collection(loginAttemptsCollectionName)
+		filter := bson.M{"key": bson.M{"$in": keys}}
+
+		_, err := col.DeleteMany(ctx, filter)
+		return err
+	}
+}
+
+// isDuplicateKey returns whether err was caused by a write violating a unique index
+func isDuplicateKey(err error) bool {
+	var we mongo.WriteException
+	if errors.As(err, &we) {
+		for _, e := range we.WriteErrors {
+			if e.Code == duplicateKeyCode {
+				return true
+			}
+		}
+	}
+	var ce mongo.CommandError
+	return errors.As(err, &ce) && ce.Code == duplicateKeyCode
+}
+
+// notFoundOrWrap wraps err, tagging it as not found when no document matched the query
+func notFoundOrWrap(err error, format string, args ...interface{}) error {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return apperror.Wrap(err, apperror.NotFoundCode, format, args...)
+	}
+	return errors.Wrapf(err, format, args...)
+}
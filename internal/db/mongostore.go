@@ -0,0 +1,294 @@
+package db
+
+import (
+	"context"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// NewMongoStore returns a Store persisting to the collections of a Mongo database
+func NewMongoStore(provideMongo *mongo.Database) Store {
+	return Store{
+		Users:              mongoUserRepository{provideMongo},
+		Alumni:             mongoAlumniRepository{provideMongo},
+		Templates:          mongoTemplateRepository{provideMongo},
+		ResetPasswords:     mongoResetPasswordRepository{provideMongo},
+		RefreshTokens:      mongoRefreshTokenRepository{provideMongo},
+		RoleChanges:        mongoRoleChangeRepository{provideMongo},
+		AlumniHistory:      mongoAlumniHistoryRepository{provideMongo},
+		AuditEvents:        mongoAuditEventRepository{provideMongo},
+		EmailVerifications: mongoEmailVerificationRepository{provideMongo},
+		EmailChanges:       mongoEmailChangeRepository{provideMongo},
+		Invitations:        mongoInvitationRepository{provideMongo},
+		ProfileClaims:      mongoProfileClaimRepository{provideMongo},
+		LoginAttempts:      mongoLoginAttemptRepository{provideMongo},
+	}
+}
+
+type mongoUserRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoUserRepository) Insert(ctx context.Context, u internal.User) error {
+	return InsertUser(r.provideMongo)(ctx, u)
+}
+
+func (r mongoUserRepository) RetrieveByEmail(ctx context.Context, email string) (internal.User, error) {
+	return RetrieveUserByEmail(r.provideMongo)(ctx, email)
+}
+
+func (r mongoUserRepository) RetrieveByID(ctx context.Context, id string) (internal.User, error) {
+	return RetrieveUserByID(r.provideMongo)(ctx, id)
+}
+
+func (r mongoUserRepository) RetrieveByAlumniID(ctx context.Context, alumniId string) (internal.User, error) {
+	return RetrieveUserByAlumniID(r.provideMongo)(ctx, alumniId)
+}
+
+func (r mongoUserRepository) Retrieve(ctx context.Context, params pkg.UserQueryParams, scope internal.AlumniScope) ([]internal.User, pkg.PageInfo, error) {
+	return RetrieveUsers(r.provideMongo)(ctx, params, scope)
+}
+
+func (r mongoUserRepository) Replace(ctx context.Context, u internal.User) error {
+	return ReplaceUser(r.provideMongo)(ctx, u)
+}
+
+func (r mongoUserRepository) UpdateEmail(ctx context.Context, id, oldEmail, newEmail string, at time.Epoch) error {
+	return UpdateUserEmail(r.provideMongo)(ctx, id, oldEmail, newEmail, at)
+}
+
+func (r mongoUserRepository) Delete(ctx context.Context, id string) error {
+	return DeleteUser(r.provideMongo)(ctx, id)
+}
+
+type mongoAlumniRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoAlumniRepository) Insert(ctx context.Context, a internal.Alumni) error {
+	return InsertAlumni(r.provideMongo)(ctx, a)
+}
+
+func (r mongoAlumniRepository) Update(ctx context.Context, id string, version int64, a internal.UpdateAlumniRequest) (internal.Alumni, error) {
+	return UpdateAlumni(r.provideMongo)(ctx, id, version, a)
+}
+
+func (r mongoAlumniRepository) RetrieveByID(ctx context.Context, id string) (internal.Alumni, error) {
+	return RetrieveAlumniByID(r.provideMongo)(ctx, id)
+}
+
+func (r mongoAlumniRepository) RetrieveAll(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.Alumni, pkg.PageInfo, error) {
+	return RetrieveAllAlumni(r.provideMongo)(ctx, params, alumniId, scope)
+}
+
+func (r mongoAlumniRepository) RetrieveAccounts(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.AlumniAccount, pkg.PageInfo, error) {
+	return RetrieveAlumniAccounts(r.provideMongo)(ctx, params, alumniId, scope)
+}
+
+func (r mongoAlumniRepository) RetrieveUnclaimed(ctx context.Context, params pkg.QueryParams) ([]internal.Alumni, pkg.PageInfo, error) {
+	return RetrieveUnclaimedAlumni(r.provideMongo)(ctx, params)
+}
+
+func (r mongoAlumniRepository) ChangePrivacy(ctx context.Context, id string, version int64, isPublic bool) (internal.Alumni, error) {
+	return ChangeAlumniPrivacy(r.provideMongo)(ctx, id, version, isPublic)
+}
+
+func (r mongoAlumniRepository) UpdateEmail(ctx context.Context, id, email string) error {
+	return UpdateAlumniEmail(r.provideMongo)(ctx, id, email)
+}
+
+func (r mongoAlumniRepository) Delete(ctx context.Context, id string) error {
+	return DeleteAlumni(r.provideMongo)(ctx, id)
+}
+
+type mongoTemplateRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoTemplateRepository) RetrieveByName(ctx context.Context, name string) (internal.EmailTemplate, error) {
+	return RetrieveEmailTemplateByName(r.provideMongo)(ctx, name)
+}
+
+func (r mongoTemplateRepository) RetrieveAll(ctx context.Context) ([]internal.EmailTemplate, error) {
+	return RetrieveAllEmailTemplates(r.provideMongo)(ctx)
+}
+
+func (r mongoTemplateRepository) Upsert(ctx context.Context, et internal.EmailTemplate) error {
+	return UpsertEmailTemplate(r.provideMongo)(ctx, et)
+}
+
+type mongoResetPasswordRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoResetPasswordRepository) Create(ctx context.Context, rp internal.ResetPassword) error {
+	return CreateResetPassword(r.provideMongo)(ctx, rp)
+}
+
+func (r mongoResetPasswordRepository) Consume(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.ResetPassword, error) {
+	return ConsumeResetPassword(r.provideMongo)(ctx, email, tokenHash, now)
+}
+
+func (r mongoResetPasswordRepository) Count(ctx context.Context, email string, since gotime.Time) (int64, error) {
+	return CountResetPasswords(r.provideMongo)(ctx, email, since)
+}
+
+func (r mongoResetPasswordRepository) Delete(ctx context.Context, email string) error {
+	return DeleteResetPasswords(r.provideMongo)(ctx, email)
+}
+
+type mongoRefreshTokenRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoRefreshTokenRepository) Insert(ctx context.Context, rt internal.RefreshToken) error {
+	return InsertRefreshToken(r.provideMongo)(ctx, rt)
+}
+
+func (r mongoRefreshTokenRepository) RetrieveByID(ctx context.Context, id string) (internal.RefreshToken, error) {
+	return RetrieveRefreshTokenByID(r.provideMongo)(ctx, id)
+}
+
+func (r mongoRefreshTokenRepository) Rotate(ctx context.Context, oldHash string, rt internal.RefreshToken) (internal.RefreshToken, error) {
+	return RotateRefreshToken(r.provideMongo)(ctx, oldHash, rt)
+}
+
+func (r mongoRefreshTokenRepository) Delete(ctx context.Context, id string) error {
+	return DeleteRefreshToken(r.provideMongo)(ctx, id)
+}
+
+func (r mongoRefreshTokenRepository) DeleteForUser(ctx context.Context, userId string) error {
+	return DeleteUserRefreshTokens(r.provideMongo)(ctx, userId)
+}
+
+type mongoRoleChangeRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoRoleChangeRepository) Insert(ctx context.Context, rc internal.RoleChange) error {
+	return InsertRoleChange(r.provideMongo)(ctx, rc)
+}
+
+func (r mongoRoleChangeRepository) Retrieve(ctx context.Context, userId string) ([]internal.RoleChange, error) {
+	return RetrieveRoleChanges(r.provideMongo)(ctx, userId)
+}
+
+type mongoAlumniHistoryRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoAlumniHistoryRepository) Insert(ctx context.Context, h internal.AlumniHistory) error {
+	return InsertAlumniHistory(r.provideMongo)(ctx, h)
+}
+
+func (r mongoAlumniHistoryRepository) Retrieve(ctx context.Context, alumniId string) ([]internal.AlumniHistory, error) {
+	return RetrieveAlumniHistory(r.provideMongo)(ctx, alumniId)
+}
+
+func (r mongoAlumniHistoryRepository) RetrieveVersion(ctx context.Context, alumniId string, version int64) (internal.AlumniHistory, error) {
+	return RetrieveAlumniHistoryVersion(r.provideMongo)(ctx, alumniId, version)
+}
+
+func (r mongoAlumniHistoryRepository) DeleteForAlumni(ctx context.Context, alumniId string) error {
+	return DeleteAlumniHistory(r.provideMongo)(ctx, alumniId)
+}
+
+type mongoAuditEventRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoAuditEventRepository) Insert(ctx context.Context, e internal.AuditEvent) error {
+	return InsertAuditEvent(r.provideMongo)(ctx, e)
+}
+
+func (r mongoAuditEventRepository) Retrieve(ctx context.Context, params pkg.AuditQueryParams) ([]internal.AuditEvent, pkg.PageInfo, error) {
+	return RetrieveAuditEvents(r.provideMongo)(ctx, params)
+}
+
+type mongoEmailVerificationRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoEmailVerificationRepository) Insert(ctx context.Context, ev internal.EmailVerification) error {
+	return InsertEmailVerification(r.provideMongo)(ctx, ev)
+}
+
+func (r mongoEmailVerificationRepository) Retrieve(ctx context.Context, tokenHash string) (internal.EmailVerification, error) {
+	return RetrieveEmailVerification(r.provideMongo)(ctx, tokenHash)
+}
+
+func (r mongoEmailVerificationRepository) DeleteForUser(ctx context.Context, userId string) error {
+	return DeleteEmailVerifications(r.provideMongo)(ctx, userId)
+}
+
+type mongoEmailChangeRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoEmailChangeRepository) Insert(ctx context.Context, ec internal.EmailChange) error {
+	return InsertEmailChange(r.provideMongo)(ctx, ec)
+}
+
+func (r mongoEmailChangeRepository) Retrieve(ctx context.Context, tokenHash string) (internal.EmailChange, error) {
+	return RetrieveEmailChange(r.provideMongo)(ctx, tokenHash)
+}
+
+func (r mongoEmailChangeRepository) DeleteForUser(ctx context.Context, userId string) error {
+	return DeleteEmailChanges(r.provideMongo)(ctx, userId)
+}
+
+type mongoInvitationRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoInvitationRepository) Insert(ctx context.Context, i internal.Invitation) error {
+	return InsertInvitation(r.provideMongo)(ctx, i)
+}
+
+func (r mongoInvitationRepository) Consume(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.Invitation, error) {
+	return ConsumeInvitation(r.provideMongo)(ctx, email, tokenHash, now)
+}
+
+func (r mongoInvitationRepository) DeleteForAlumni(ctx context.Context, alumniId string) error {
+	return DeleteInvitations(r.provideMongo)(ctx, alumniId)
+}
+
+type mongoProfileClaimRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoProfileClaimRepository) Insert(ctx context.Context, c internal.ProfileClaim) error {
+	return InsertProfileClaim(r.provideMongo)(ctx, c)
+}
+
+func (r mongoProfileClaimRepository) RetrieveByID(ctx context.Context, id string) (internal.ProfileClaim, error) {
+	return RetrieveProfileClaimByID(r.provideMongo)(ctx, id)
+}
+
+func (r mongoProfileClaimRepository) Retrieve(ctx context.Context, status, userId, alumniId string) ([]internal.ProfileClaim, error) {
+	return RetrieveProfileClaims(r.provideMongo)(ctx, status, userId, alumniId)
+}
+
+func (r mongoProfileClaimRepository) Replace(ctx context.Context, c internal.ProfileClaim) error {
+	return ReplaceProfileClaim(r.provideMongo)(ctx, c)
+}
+
+type mongoLoginAttemptRepository struct {
+	provideMongo *mongo.Database
+}
+
+func (r mongoLoginAttemptRepository) Retrieve(ctx context.Context, key string) (internal.LoginAttempt, error) {
+	return RetrieveLoginAttempt(r.provideMongo)(ctx, key)
+}
+
+func (r mongoLoginAttemptRepository) RecordFailure(ctx context.Context, key string, at gotime.Time, expiresAt gotime.Time) (internal.LoginAttempt, error) {
+	return RecordLoginFailure(r.provideMongo)(ctx, key, at, expiresAt)
+}
+
+func (r mongoLoginAttemptRepository) Delete(ctx context.Context, keys ...string) error {
+	return DeleteLoginAttempts(r.provideMongo)(ctx, keys...)
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+// UserRepository stores users
+type UserRepository interface {
+	Insert(ctx context.Context, u internal.User) error
+	RetrieveByEmail(ctx context.Context, email string) (internal.User, error)
+	RetrieveByID(ctx context.Context, id string) (internal.User, error)
+	RetrieveByAlumniID(ctx context.Context, alumniId string) (internal.User, error)
+	Retrieve(ctx context.Context, params pkg.UserQueryParams, scope internal.AlumniScope) ([]internal.User, pkg.PageInfo, error)
+	Replace(ctx context.Context, u internal.User) error
+	UpdateEmail(ctx context.Context, id, oldEmail, newEmail string, at time.Epoch) error
+	Delete(ctx context.Context, id string) error
+}
+
+// AlumniRepository stores alumni records
+type AlumniRepository interface {
+	Insert(ctx context.Context, a internal.Alumni) error
+	Update(ctx context.Context, id string, version int64, a internal.UpdateAlumniRequest) (internal.Alumni, error)
+	RetrieveByID(ctx context.Context, id string) (internal.Alumni, error)
+	RetrieveAll(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.Alumni, pkg.PageInfo, error)
+	RetrieveAccounts(ctx context.Context, params pkg.QueryParams, alumniId string, scope internal.AlumniScope) ([]internal.AlumniAccount, pkg.PageInfo, error)
+	RetrieveUnclaimed(ctx context.Context, params pkg.QueryParams) ([]internal.Alumni, pkg.PageInfo, error)
+	ChangePrivacy(ctx context.Context, id string, version int64, isPublic bool) (internal.Alumni, error)
+	UpdateEmail(ctx context.Context, id, email string) error
+	Delete(ctx context.Context, id string) error
+}
+
+// TemplateRepository stores email templates
+type TemplateRepository interface {
+	RetrieveByName(ctx context.Context, name string) (internal.EmailTemplate, error)
+	RetrieveAll(ctx context.Context) ([]internal.EmailTemplate, error)
+	Upsert(ctx context.Context, et internal.EmailTemplate) error
+}
+
+// ResetPasswordRepository stores pending password resets
+type ResetPasswordRepository interface {
+	Create(ctx context.Context, rp internal.ResetPassword) error
+	Consume(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.ResetPassword, error)
+	Count(ctx context.Context, email string, since gotime.Time) (int64, error)
+	Delete(ctx context.Context, email string) error
+}
+
+// RefreshTokenRepository stores login sessions
+type RefreshTokenRepository interface {
+	Insert(ctx context.Context, rt internal.RefreshToken) error
+	RetrieveByID(ctx context.Context, id string) (internal.RefreshToken, error)
+	Rotate(ctx context.Context, oldHash string, rt internal.RefreshToken) (internal.RefreshToken, error)
+	Delete(ctx context.Context, id string) error
+	DeleteForUser(ctx context.Context, userId string) error
+}
+
+// RoleChangeRepository stores the history of roles granted and revoked
+type RoleChangeRepository interface {
+	Insert(ctx context.Context, rc internal.RoleChange) error
+	Retrieve(ctx context.Context, userId string) ([]internal.RoleChange, error)
+}
+
+// AlumniHistoryRepository stores every version of each alumni and the changes that led to it
+type AlumniHistoryRepository interface {
+	Insert(ctx context.Context, h internal.AlumniHistory) error
+	Retrieve(ctx context.Context, alumniId string) ([]internal.AlumniHistory, error)
+	RetrieveVersion(ctx context.Context, alumniId string, version int64) (internal.AlumniHistory, error)
+	DeleteForAlumni(ctx context.Context, alumniId string) error
+}
+
+// AuditEventRepository stores an append-only trail of administrative actions
+type AuditEventRepository interface {
+	Insert(ctx context.Context, e internal.AuditEvent) error
+	Retrieve(ctx context.Context, params pkg.AuditQueryParams) ([]internal.AuditEvent, pkg.PageInfo, error)
+}
+
+// EmailVerificationRepository stores pending email verifications
+type EmailVerificationRepository interface {
+	Insert(ctx context.Context, ev internal.EmailVerification) error
+	Retrieve(ctx context.Context, tokenHash string) (internal.EmailVerification, error)
+	DeleteForUser(ctx context.Context, userId string) error
+}
+
+// EmailChangeRepository stores pending changes of login email
+type EmailChangeRepository interface {
+	Insert(ctx context.Context, ec internal.EmailChange) error
+	Retrieve(ctx context.Context, tokenHash string) (internal.EmailChange, error)
+	DeleteForUser(ctx context.Context, userId string) error
+}
+
+// InvitationRepository stores pending invitations to alumni records
+type InvitationRepository interface {
+	Insert(ctx context.Context, i internal.Invitation) error
+	Consume(ctx context.Context, email, tokenHash string, now gotime.Time) (internal.Invitation, error)
+	DeleteForAlumni(ctx context.Context, alumniId string) error
+}
+
+// ProfileClaimRepository stores claims on alumni records
+type ProfileClaimRepository interface {
+	Insert(ctx context.Context, c internal.ProfileClaim) error
+	RetrieveByID(ctx context.Context, id string) (internal.ProfileClaim, error)
+	Retrieve(ctx context.Context, status, userId, alumniId string) ([]internal.ProfileClaim, error)
+	Replace(ctx context.Context, c internal.ProfileClaim) error
+}
+
+// LoginAttemptRepository stores recent failed logins
+type LoginAttemptRepository interface {
+	Retrieve(ctx context.Context, key string) (internal.LoginAttempt, error)
+	RecordFailure(ctx context.Context, key string, at gotime.Time, expiresAt gotime.Time) (internal.LoginAttempt, error)
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Store is the set of repositories the application persists its data in
+type Store struct {
+	Users              UserRepository
+	Alumni             AlumniRepository
+	Templates          TemplateRepository
+	ResetPasswords     ResetPasswordRepository
+	RefreshTokens      RefreshTokenRepository
+	RoleChanges        RoleChangeRepository
+	AlumniHistory      AlumniHistoryRepository
+	AuditEvents        AuditEventRepository
+	EmailVerifications EmailVerificationRepository
+	EmailChanges       EmailChangeRepository
+	Invitations        InvitationRepository
+	ProfileClaims      ProfileClaimRepository
+	LoginAttempts      LoginAttemptRepository
+}
@@ -1,7 +1,10 @@
 package email
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -15,6 +18,9 @@ const (
 	defaultRegion = "us-east-1"
 )
 
+// sendTimeout bounds a single call to SES, on top of any deadline of the request it is made for
+const sendTimeout = 10 * time.Second
+
 // Config is a representation of email configurations
 type Config struct {
 	Region string
@@ -34,17 +40,41 @@ type SendRequest struct {
 }
 
 // SendEmailFunc returns functionality to send an email
-type SendEmailFunc func(emailReq SendRequest) error
+type SendEmailFunc func(ctx context.Context, emailReq SendRequest) error
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*ses.SES{}
+)
+
+// sesClient returns the SES client for the region of c, creating it on first use so it is reused across Lambda invocations
+func sesClient(c Config) (*ses.SES, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if svc, ok := clients[c.Region]; ok {
+		return svc, nil
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(c.Region)},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := ses.New(sess)
+	clients[c.Region] = svc
+	return svc, nil
+}
 
 // SendEmail sends an email to a recipeint given HTML content
 func SendEmail(c Config) SendEmailFunc {
-	return func(emailReq SendRequest) error {
-		sess, err := session.NewSession(&aws.Config{
-			Region: aws.String(c.Region)},
-		)
-
-		// Create an SES session.
-		svc := ses.New(sess)
+	return func(ctx context.Context, emailReq SendRequest) error {
+		svc, err := sesClient(c)
+		if err != nil {
+			return err
+		}
 
 		// Assemble the email.
 		input := &ses.SendEmailInput{
@@ -70,7 +100,9 @@ func SendEmail(c Config) SendEmailFunc {
 		}
 
 		// Attempt to send the email.
-		result, err := svc.SendEmail(input)
+		ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+		defer cancel()
+		result, err := svc.SendEmailWithContext(ctx, input)
 
 		// Display error messages if they occur.
 		if err != nil {
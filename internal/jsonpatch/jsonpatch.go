@@ -0,0 +1,309 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ContentType is the media type of a JSON Patch document
+const ContentType = "application/json-patch+json"
+
+const (
+	addOp     = "add"
+	removeOp  = "remove"
+	replaceOp = "replace"
+	moveOp    = "move"
+	copyOp    = "copy"
+	testOp    = "test"
+)
+
+// ErrTestFailed is the cause of the error Apply returns when a test operation finds a different value than it expected
+var ErrTestFailed = errors.New("value does not match")
+
+// Patch is a JSON Patch document, a list of operations applied in order to a JSON document as described by RFC 6902
+type Patch []Operation
+
+// Operation is a single change to a JSON document, at the location given by a JSON Pointer (RFC 6901)
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Decode reads a JSON Patch document and checks each of its operations is well formed
+func Decode(r io.Reader) (Patch, error) {
+	var p Patch
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return Patch{}, errors.Wrap(err, "jsonpatch - unable to decode patch")
+	}
+
+	for i, o := range p {
+		if err := o.validate(); err != nil {
+			return Patch{}, errors.Wrapf(err, "jsonpatch - operation %v is invalid", i)
+		}
+	}
+	return p, nil
+}
+
+// Apply applies every operation of the patch to a JSON document and returns the patched document.
+// If any operation fails the whole patch fails, leaving the document unchanged
+func (p Patch) Apply(doc []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return nil, errors.Wrap(err, "jsonpatch - unable to decode document")
+	}
+
+	for i, o := range p {
+		var err error
+		if v, err = o.apply(v); err != nil {
+			return nil, errors.Wrapf(err, "jsonpatch - unable to apply operation %v, %v %v", i, o.Op, o.Path)
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+func (o Operation) validate() error {
+	switch o.Op {
+	case addOp, replaceOp, testOp:
+		if o.Value == nil {
+			return errors.Errorf("%v requires a value", o.Op)
+		}
+	case moveOp, copyOp:
+		if _, err := parsePointer(o.From); err != nil {
+			return err
+		}
+	case removeOp:
+	default:
+		return errors.Errorf("op=%q is not one of add, remove, replace, move, copy or test", o.Op)
+	}
+
+	_, err := parsePointer(o.Path)
+	return err
+}
+
+func (o Operation) apply(doc interface{}) (interface{}, error) {
+	path, _ := parsePointer(o.Path)
+
+	switch o.Op {
+	case addOp, replaceOp, testOp:
+		var value interface{}
+		if err := json.Unmarshal(o.Value, &value); err != nil {
+			return nil, errors.Wrap(err, "unable to decode value")
+		}
+		switch o.Op {
+		case addOp:
+			return add(doc, path, value)
+		case replaceOp:
+			return replace(doc, path, value)
+		}
+
+		current, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	case removeOp:
+		return remove(doc, path)
+	}
+
+	from, _ := parsePointer(o.From)
+	value, err := get(doc, from)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Op == moveOp {
+		if o.From == o.Path {
+			return doc, nil
+		}
+		if strings.HasPrefix(o.Path, o.From+"/") {
+			return nil, errors.New("a value cannot be moved into one of its own children")
+		}
+		if doc, err = remove(doc, from); err != nil {
+			return nil, err
+		}
+		return add(doc, path, value)
+	}
+
+	// Copies must not share maps or slices with the original, or a later operation would change both
+	bb, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var clone interface{}
+	if err := json.Unmarshal(bb, &clone); err != nil {
+		return nil, err
+	}
+	return add(doc, path, clone)
+}
+
+func add(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			if token == "-" {
+				return append(c, value), nil
+			}
+			i, err := arrayIndex(token, len(c)+1)
+			if err != nil {
+				return nil, err
+			}
+			c = append(c, nil)
+			copy(c[i+1:], c[i:])
+			c[i] = value
+			return c, nil
+		}
+		return nil, errors.Errorf("cannot add %q to a value that is not an object or array", token)
+	})
+}
+
+func remove(doc interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	return update(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, errors.Errorf("%q does not exist", token)
+			}
+			delete(c, token)
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			return append(c[:i], c[i+1:]...), nil
+		}
+		return nil, errors.Errorf("cannot remove %q from a value that is not an object or array", token)
+	})
+}
+
+func replace(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return update(doc, path, func(parent interface{}, token string) (interface{}, error) {
+		switch c := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := c[token]; !ok {
+				return nil, errors.Errorf("%q does not exist", token)
+			}
+			c[token] = value
+			return c, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			c[i] = value
+			return c, nil
+		}
+		return nil, errors.Errorf("cannot replace %q in a value that is not an object or array", token)
+	})
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch c := doc.(type) {
+		case map[string]interface{}:
+			v, ok := c[token]
+			if !ok {
+				return nil, errors.Errorf("%q does not exist", token)
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(token, len(c))
+			if err != nil {
+				return nil, err
+			}
+			doc = c[i]
+		default:
+			return nil, errors.Errorf("%q does not exist", token)
+		}
+	}
+	return doc, nil
+}
+
+// update walks to the parent of the last token in the path and replaces it with the result of fn,
+// which is needed because adding to or removing from an array makes a new slice
+func update(doc interface{}, path []string, fn func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+
+	child, err := get(doc, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = update(child, path[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c := doc.(type) {
+	case map[string]interface{}:
+		c[path[0]] = child
+	case []interface{}:
+		i, _ := arrayIndex(path[0], len(c))
+		c[i] = child
+	}
+	return doc, nil
+}
+
+// arrayIndex parses an array index, which must be less than max and have no leading zeros
+func arrayIndex(token string, max int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (len(token) > 1 && token[0] == '0') || strings.HasPrefix(token, "+") {
+		return 0, errors.Errorf("%q is not an array index", token)
+	}
+	if i >= max {
+		return 0, errors.Errorf("index %v is out of bounds", i)
+	}
+	return i, nil
+}
+
+// parsePointer splits a JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if pointer[0] != '/' {
+		return nil, errors.Errorf("path=%q must be empty or start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// DecodeStrict decodes a patched document into v, failing on fields v does not have so that a patch cannot
+// add anything outside of the schema of v
+func DecodeStrict(doc []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.DisallowUnknownFields()
+	if err := d.Decode(v); err != nil {
+		return errors.Wrap(err, "jsonpatch - patched document does not match the schema")
+	}
+	return nil
+}
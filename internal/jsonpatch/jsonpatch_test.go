@@ -0,0 +1,125 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{name: "add_field", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz", "value": "qux"}]`, want: `{"foo": "bar", "baz": "qux"}`},
+		{name: "add_array_element", doc: `{"foo": ["bar", "baz"]}`, patch: `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, want: `{"foo": ["bar", "qux", "baz"]}`},
+		{name: "add_array_end", doc: `{"foo": ["bar"]}`, patch: `[{"op": "add", "path": "/foo/-", "value": "baz"}]`, want: `{"foo": ["bar", "baz"]}`},
+		{name: "add_whole_document", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "", "value": {"baz": 1}}]`, want: `{"baz": 1}`},
+		{name: "remove_field", doc: `{"baz": "qux", "foo": "bar"}`, patch: `[{"op": "remove", "path": "/baz"}]`, want: `{"foo": "bar"}`},
+		{name: "remove_array_element", doc: `{"foo": ["bar", "qux", "baz"]}`, patch: `[{"op": "remove", "path": "/foo/1"}]`, want: `{"foo": ["bar", "baz"]}`},
+		{name: "replace_field", doc: `{"baz": "qux", "foo": "bar"}`, patch: `[{"op": "replace", "path": "/baz", "value": "boo"}]`, want: `{"baz": "boo", "foo": "bar"}`},
+		{name: "replace_with_null", doc: `{"foo": "bar"}`, patch: `[{"op": "replace", "path": "/foo", "value": null}]`, want: `{"foo": null}`},
+		{name: "move_field", doc: `{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`, patch: `[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`, want: `{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`},
+		{name: "move_array_element", doc: `{"foo": ["all", "grass", "cows", "eat"]}`, patch: `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, want: `{"foo": ["all", "cows", "eat", "grass"]}`},
+		{name: "move_to_itself", doc: `{"foo": "bar"}`, patch: `[{"op": "move", "from": "/foo", "path": "/foo"}]`, want: `{"foo": "bar"}`},
+		{name: "copy_is_not_shared", doc: `{"foo": {"bar": 1}}`, patch: `[{"op": "copy", "from": "/foo", "path": "/baz"}, {"op": "replace", "path": "/baz/bar", "value": 2}]`, want: `{"foo": {"bar": 1}, "baz": {"bar": 2}}`},
+		{name: "test_matches", doc: `{"baz": "qux", "foo": ["a", 2, "c"]}`, patch: `[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`, want: `{"baz": "qux", "foo": ["a", 2, "c"]}`},
+		{name: "escaped_pointer", doc: `{"a/b": 1, "m~n": 2}`, patch: `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "remove", "path": "/m~0n"}]`, want: `{"a/b": 3}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Decode(strings.NewReader(tt.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %v", err)
+			}
+
+			got, err := p.Apply([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("unable to apply patch: %v", err)
+			}
+
+			var g, w interface{}
+			if err := json.Unmarshal(got, &g); err != nil {
+				t.Fatalf("unable to decode patched document: %v", err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &w); err != nil {
+				t.Fatalf("unable to decode wanted document: %v", err)
+			}
+			if !reflect.DeepEqual(g, w) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyFails(t *testing.T) {
+	tests := []struct {
+		name       string
+		doc        string
+		patch      string
+		testFailed bool
+	}{
+		{name: "test_does_not_match", doc: `{"baz": "qux"}`, patch: `[{"op": "test", "path": "/baz", "value": "bar"}]`, testFailed: true},
+		{name: "add_to_missing_parent", doc: `{"foo": "bar"}`, patch: `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`},
+		{name: "add_past_array_end", doc: `{"foo": ["bar"]}`, patch: `[{"op": "add", "path": "/foo/2", "value": "baz"}]`},
+		{name: "remove_missing_field", doc: `{"foo": "bar"}`, patch: `[{"op": "remove", "path": "/baz"}]`},
+		{name: "remove_whole_document", doc: `{"foo": "bar"}`, patch: `[{"op": "remove", "path": ""}]`},
+		{name: "replace_missing_field", doc: `{"foo": "bar"}`, patch: `[{"op": "replace", "path": "/baz", "value": "qux"}]`},
+		{name: "leading_zero_index", doc: `{"foo": ["a", "b"]}`, patch: `[{"op": "replace", "path": "/foo/01", "value": "c"}]`},
+		{name: "move_into_own_child", doc: `{"foo": {"bar": 1}}`, patch: `[{"op": "move", "from": "/foo", "path": "/foo/bar/baz"}]`},
+		{name: "later_operation_fails", doc: `{"foo": "bar"}`, patch: `[{"op": "replace", "path": "/foo", "value": "baz"}, {"op": "test", "path": "/foo", "value": "bar"}]`, testFailed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Decode(strings.NewReader(tt.patch))
+			if err != nil {
+				t.Fatalf("unable to decode patch: %v", err)
+			}
+
+			got, err := p.Apply([]byte(tt.doc))
+			if err == nil {
+				t.Fatalf("got %s, want an error", got)
+			}
+			if (errors.Cause(err) == ErrTestFailed) != tt.testFailed {
+				t.Errorf("got err=%v, want a failed test=%v", err, tt.testFailed)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch string
+	}{
+		{name: "not_an_array", patch: `{"op": "add", "path": "/foo", "value": 1}`},
+		{name: "unknown_op", patch: `[{"op": "merge", "path": "/foo", "value": 1}]`},
+		{name: "missing_value", patch: `[{"op": "replace", "path": "/foo"}]`},
+		{name: "relative_path", patch: `[{"op": "remove", "path": "foo"}]`},
+		{name: "relative_from", patch: `[{"op": "move", "from": "foo", "path": "/bar"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(strings.NewReader(tt.patch)); err == nil {
+				t.Errorf("got no error decoding %v", tt.patch)
+			}
+		})
+	}
+}
+
+func TestDecodeStrict(t *testing.T) {
+	var v struct {
+		Foo string `json:"foo"`
+	}
+	if err := DecodeStrict([]byte(`{"foo": "bar"}`), &v); err != nil || v.Foo != "bar" {
+		t.Errorf("got foo=%q, err=%v, want foo=bar", v.Foo, err)
+	}
+	if err := DecodeStrict([]byte(`{"foo": "bar", "baz": 1}`), &v); err == nil {
+		t.Errorf("got no error decoding a field outside the schema")
+	}
+}
@@ -1,7 +1,11 @@
 package mapping
 
 import (
+	"context"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
@@ -11,6 +15,24 @@ import (
 	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
 )
 
+// alumniUpdateFields maps the lower case json name of each field of an alumni update to the bson name it is stored under
+var alumniUpdateFields = func() map[string]string {
+	dto := reflect.TypeOf(pkg.UpdateAlumniRequest{})
+	dbo := reflect.TypeOf(internal.UpdateAlumniRequest{})
+
+	fields := map[string]string{}
+	for i := 0; i < dto.NumField(); i++ {
+		f := dto.Field(i)
+		dbf, ok := dbo.FieldByName(f.Name)
+		if !ok || f.Tag.Get("json") == "-" {
+			continue
+		}
+		jsonName := strings.Split(f.Tag.Get("json"), ",")[0]
+		fields[strings.ToLower(jsonName)] = strings.Split(dbf.Tag.Get("bson"), ",")[0]
+	}
+	return fields
+}()
+
 // ToDbUser maps a UserRequest to an internal User
 func ToDbUser(req pkg.UserRequest, securePw []byte, genUUID uuid.GenV4Func, provideTime time.EpochProviderFunc) internal.User {
 	currentTime := provideTime()
@@ -20,6 +42,7 @@ func ToDbUser(req pkg.UserRequest, securePw []byte, genUUID uuid.GenV4Func, prov
 		Email:                strings.ToLower(req.Email),
 		Password:             securePw,
 		Admin:                false,
+		EmailVerified:        false,
 		Status:               internal.PendingUserStatus,
 		CreatedTimestamp:     currentTime,
 		LastUpdatedTimestamp: currentTime,
@@ -32,12 +55,138 @@ func ToDTOUser(u internal.User) pkg.User {
 	if s == "" {
 		s = internal.PendingUserStatus
 	}
+	roles := u.EffectiveRoles()
 	return pkg.User{
-		ID:       u.ID,
-		Email:    u.Email,
-		Admin:    u.Admin,
-		AlumniID: u.AlumniID,
-		Status:   s,
+		ID:               u.ID,
+		Email:            u.Email,
+		Admin:            len(roles) > 0,
+		Roles:            ToDTORoles(roles),
+		AlumniID:         u.AlumniID,
+		Status:           s,
+		DenialReason:     u.DenialReason,
+		EmailVerified:    u.EmailVerified,
+		TOTPEnabled:      u.TOTPEnabled,
+		CreatedTimestamp: u.CreatedTimestamp.String(),
+	}
+}
+
+// ToDTOProfileClaim maps an internal ProfileClaim to a pkg ProfileClaim
+func ToDTOProfileClaim(c internal.ProfileClaim) pkg.ProfileClaim {
+	return pkg.ProfileClaim{
+		ID:               c.ID,
+		UserID:           c.UserID,
+		AlumniID:         c.AlumniID,
+		Message:          c.Message,
+		Disputed:         c.Disputed,
+		Status:           c.Status,
+		ReviewedBy:       c.ReviewedBy,
+		ReviewReason:     c.ReviewReason,
+		CreatedTimestamp: c.CreatedTimestamp.String(),
+	}
+}
+
+// ToAlumniMatch maps an internal Alumni to the little of it shown to a user looking for their own record
+func ToAlumniMatch(a internal.Alumni) pkg.AlumniMatch {
+	return pkg.AlumniMatch{
+		ID:   a.ID,
+		Name: maskName(a.Firstname) + " " + maskName(a.Lastname),
+	}
+}
+
+// maskName keeps the first letter of a name and hides the rest, e.g. Jane becomes J***
+func maskName(name string) string {
+	rr := []rune(strings.TrimSpace(name))
+	if len(rr) == 0 {
+		return ""
+	}
+	return string(rr[0]) + strings.Repeat("*", len(rr)-1)
+}
+
+// ToDTORoles maps internal Roles to pkg Roles
+func ToDTORoles(rr []internal.Role) []pkg.Role {
+	roles := []pkg.Role{}
+	for _, r := range rr {
+		roles = append(roles, pkg.Role{
+			Name:           r.Name,
+			Division:       r.Division,
+			GraduationYear: r.GraduationYear,
+		})
+	}
+	return roles
+}
+
+// ToDBRole maps a pkg Role to an internal Role
+func ToDBRole(r pkg.Role) internal.Role {
+	return internal.Role{
+		Name:           strings.ToUpper(r.Name),
+		Division:       strings.ToUpper(r.Division),
+		GraduationYear: r.GraduationYear,
+	}
+}
+
+// ToDTORoleChanges maps internal RoleChanges to pkg RoleChanges
+func ToDTORoleChanges(rcs []internal.RoleChange) []pkg.RoleChange {
+	changes := []pkg.RoleChange{}
+	for _, rc := range rcs {
+		changes = append(changes, pkg.RoleChange{
+			ID:               rc.ID,
+			ActorID:          rc.ActorID,
+			Action:           rc.Action,
+			Role:             ToDTORoles([]internal.Role{rc.Role})[0],
+			CreatedTimestamp: rc.CreatedTimestamp.String(),
+		})
+	}
+	return changes
+}
+
+// ToDTOAlumniHistory maps internal AlumniHistory to pkg AlumniHistory
+func ToDTOAlumniHistory(hh []internal.AlumniHistory) []pkg.AlumniHistory {
+	history := []pkg.AlumniHistory{}
+	for _, h := range hh {
+		changes := []pkg.FieldChange{}
+		for _, c := range h.Changes {
+			changes = append(changes, pkg.FieldChange{Field: c.Field, Before: c.Before, After: c.After})
+		}
+		history = append(history, pkg.AlumniHistory{
+			ID:               h.ID,
+			Version:          h.Version,
+			ActorID:          h.ActorID,
+			Action:           h.Action,
+			Changes:          changes,
+			RestoredVersion:  h.RestoredVersion,
+			CreatedTimestamp: h.CreatedTimestamp.String(),
+		})
+	}
+	return history
+}
+
+// ToDTOAuditEvents maps internal AuditEvents to pkg AuditEvents
+func ToDTOAuditEvents(ee []internal.AuditEvent) []pkg.AuditEvent {
+	events := []pkg.AuditEvent{}
+	for _, e := range ee {
+		events = append(events, pkg.AuditEvent{
+			ID:               e.ID,
+			ActorID:          e.ActorID,
+			Action:           e.Action,
+			TargetType:       e.TargetType,
+			TargetID:         e.TargetID,
+			IP:               e.IP,
+			RequestID:        e.RequestID,
+			CorrelationID:    e.CorrelationID,
+			Outcome:          e.Outcome,
+			Detail:           e.Detail,
+			CreatedTimestamp: e.CreatedTimestamp.String(),
+		})
+	}
+	return events
+}
+
+// ToDTOEmailTemplate maps an internal EmailTemplate to a pkg EmailTemplate
+func ToDTOEmailTemplate(et internal.EmailTemplate) pkg.EmailTemplate {
+	return pkg.EmailTemplate{
+		Name:    et.Name,
+		Subject: et.Subject,
+		HTML:    et.HTML,
 	}
 }
 
@@ -110,17 +259,29 @@ func ToDBAlumni(r pkg.AlumniRequest, s3Filename string, provideTime time.EpochPr
 		AlumniChoir:            r.AlumniChoir,
 		Comment:                r.Comment,
 		ProfilePictureKey:      s3Filename,
+		Version:                1,
 		CreatedTimestamp:       currentTime,
 		LastUpdatedTimestamp:   currentTime,
 	}
 }
 
+// ToAlumniUpdate maps a partial update of an alumni to the fields to set and the fields to remove
 func ToAlumniUpdate(r pkg.UpdateAlumniRequest, s3Filename string, provideTime time.EpochProviderFunc) internal.UpdateAlumniRequest {
-	bday := ""
-	if r.Birthday != "" {
-		iso, err := time.NewISO8601(r.Birthday)
-		if err == nil {
-			bday = iso.DateString()
+	bday := r.Birthday
+	if bday != nil && *bday != "" {
+		iso, err := time.NewISO8601(*bday)
+		if err != nil {
+			bday = nil
+		} else {
+			d := iso.DateString()
+			bday = &d
+		}
+	}
+
+	unset := []string{}
+	for _, name := range r.Cleared {
+		if field, ok := alumniUpdateFields[strings.ToLower(name)]; ok {
+			unset = append(unset, field)
 		}
 	}
 
@@ -137,37 +298,37 @@ func ToAlumniUpdate(r pkg.UpdateAlumniRequest, s3Filename string, provideTime ti
 		FatherDeceased:         r.FatherDeceased,
 		SpouseName:             r.SpouseName,
 		SpouseMaidenName:       r.SpouseMaidenName,
-		CurrentAddress:         internal.Address(r.CurrentAddress),
+		CurrentAddress:         toDBAddressUpdate(r.CurrentAddress),
 		HomePhone:              r.HomePhone,
 		CellPhone:              r.CellPhone,
 		WorkPhone:              r.WorkPhone,
 		EmailAddress:           r.EmailAddress,
-		MiddleSchool:           internal.School(r.MiddleSchool),
-		HighSchool:             internal.School(r.HighSchool),
-		IsraelSchool:           internal.School(r.IsraelSchool),
-		CollegeAttended:        internal.School(r.CollegeAttended),
-		GradSchools:            toDBSchools(r.GradSchools),
+		MiddleSchool:           toDBSchoolUpdate(r.MiddleSchool),
+		HighSchool:             toDBSchoolUpdate(r.HighSchool),
+		IsraelSchool:           toDBSchoolUpdate(r.IsraelSchool),
+		CollegeAttended:        toDBSchoolUpdate(r.CollegeAttended),
+		GradSchools:            toDBSchoolsUpdate(r.GradSchools),
 		Profession:             r.Profession,
 		Birthday:               bday,
 		Clubs:                  r.Clubs,
 		SportsTeams:            r.SportsTeams,
 		Awards:                 r.AlumniPositions,
 		Committees:             r.Committees,
-		OldAddresses:           toDBAddresses(r.OldAddresses),
-		HillelDayCamp:          internal.Camp(r.HillelDayCamp),
-		HillelSleepCamp:        internal.Camp(r.HillelSleepCamp),
-		HiliDayCamp:            internal.Camp(r.HiliDayCamp),
-		HiliWhiteCamp:          internal.Camp(r.HiliWhiteCamp),
-		HiliInternationalCamp:  internal.Camp(r.HiliInternationalCamp),
+		OldAddresses:           toDBAddressesUpdate(r.OldAddresses),
+		HillelDayCamp:          toDBCampUpdate(r.HillelDayCamp),
+		HillelSleepCamp:        toDBCampUpdate(r.HillelSleepCamp),
+		HiliDayCamp:            toDBCampUpdate(r.HiliDayCamp),
+		HiliWhiteCamp:          toDBCampUpdate(r.HiliWhiteCamp),
+		HiliInternationalCamp:  toDBCampUpdate(r.HiliInternationalCamp),
 		HILI:                   r.HILI,
 		HILLEL:                 r.HILLEL,
 		HAFTR:                  r.HAFTR,
 		ParentOfStudent:        r.ParentOfStudent,
 		Boards:                 r.Boards,
 		AlumniPositions:        r.AlumniPositions,
-		Siblings:               toDBSiblings(r.Siblings),
-		Children:               toDBChildren(r.Children),
-		Grandparents:           toDBGrandparents(r.Grandparents),
+		Siblings:               toDBSiblingsUpdate(r.Siblings),
+		Children:               toDBChildrenUpdate(r.Children),
+		Grandparents:           toDBGrandparentsUpdate(r.Grandparents),
 		ClassPresident:         r.ClassPresident,
 		BoardOfTrustees:        r.BoardOfTrustees,
 		BoardOfEducation:       r.BoardOfEducation,
@@ -182,11 +343,125 @@ func ToAlumniUpdate(r pkg.UpdateAlumniRequest, s3Filename string, provideTime ti
 		Comment:                r.Comment,
 		ProfilePictureKey:      s3Filename,
 		LastUpdatedTimestamp:   provideTime(),
+		Unset:                  unset,
+	}
+}
+
+// ToDTOAlumniArrays maps the array fields of an alumni to the document a JSON Patch is applied to
+func ToDTOAlumniArrays(a internal.Alumni) pkg.AlumniArrays {
+	return pkg.AlumniArrays{
+		GradSchools:  toDTOSchools(a.GradSchools),
+		OldAddresses: toDTOAddresses(a.OldAddresses),
+		Siblings:     toDTOSiblings(a.Siblings),
+		Children:     toDTOChildren(a.Children),
+		Grandparents: toDTOGrandparents(a.Grandparents),
+	}
+}
+
+// ToAlumniArraysUpdate maps patched array fields to an update that replaces all of them
+func ToAlumniArraysUpdate(aa pkg.AlumniArrays) pkg.UpdateAlumniRequest {
+	return pkg.UpdateAlumniRequest{
+		GradSchools:  &aa.GradSchools,
+		OldAddresses: &aa.OldAddresses,
+		Siblings:     &aa.Siblings,
+		Children:     &aa.Children,
+		Grandparents: &aa.Grandparents,
+	}
+}
+
+// ToAlumniChanges lists every field that differs between two versions of an alumni, in order of field.
+// Nested fields and array elements are compared one string or bool at a time, under their dotted bson path
+func ToAlumniChanges(before, after internal.Alumni) []internal.FieldChange {
+	bb, aa := map[string]interface{}{}, map[string]interface{}{}
+	flattenFields("", reflect.ValueOf(before), bb)
+	flattenFields("", reflect.ValueOf(after), aa)
+
+	fields := []string{}
+	for f := range bb {
+		fields = append(fields, f)
+	}
+	for f := range aa {
+		if _, ok := bb[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []internal.FieldChange{}
+	for _, f := range fields {
+		if bb[f] != aa[f] {
+			changes = append(changes, internal.FieldChange{Field: f, Before: bb[f], After: aa[f]})
+		}
+	}
+	return changes
+}
+
+// ToAlumniRestore maps an earlier version of an alumni to an update that sets every field back to it, except whether
+// it is public, which only changes through its own endpoint
+func ToAlumniRestore(a internal.Alumni, provideTime time.EpochProviderFunc) internal.UpdateAlumniRequest {
+	return internal.UpdateAlumniRequest{
+		Title:                  &a.Title,
+		Firstname:              &a.Firstname,
+		Middlename:             &a.Middlename,
+		Lastname:               &a.Lastname,
+		MarriedName:            &a.MarriedName,
+		MaidenName:             &a.MaidenName,
+		MotherName:             &a.MotherName,
+		MotherDeceased:         &a.MotherDeceased,
+		FatherName:             &a.FatherName,
+		FatherDeceased:         &a.FatherDeceased,
+		SpouseName:             &a.SpouseName,
+		SpouseMaidenName:       &a.SpouseMaidenName,
+		CurrentAddress:         &a.CurrentAddress,
+		HomePhone:              &a.HomePhone,
+		CellPhone:              &a.CellPhone,
+		WorkPhone:              &a.WorkPhone,
+		EmailAddress:           &a.EmailAddress,
+		MiddleSchool:           &a.MiddleSchool,
+		HighSchool:             &a.HighSchool,
+		IsraelSchool:           &a.IsraelSchool,
+		CollegeAttended:        &a.CollegeAttended,
+		GradSchools:            &a.GradSchools,
+		Profession:             &a.Profession,
+		Birthday:               &a.Birthday,
+		Clubs:                  &a.Clubs,
+		SportsTeams:            &a.SportsTeams,
+		Awards:                 &a.Awards,
+		Committees:             &a.Committees,
+		OldAddresses:           &a.OldAddresses,
+		HillelDayCamp:          &a.HillelDayCamp,
+		HillelSleepCamp:        &a.HillelSleepCamp,
+		HiliDayCamp:            &a.HiliDayCamp,
+		HiliWhiteCamp:          &a.HiliWhiteCamp,
+		HiliInternationalCamp:  &a.HiliInternationalCamp,
+		HILI:                   &a.HILI,
+		HILLEL:                 &a.HILLEL,
+		HAFTR:                  &a.HAFTR,
+		ParentOfStudent:        &a.ParentOfStudent,
+		Boards:                 &a.Boards,
+		AlumniPositions:        &a.AlumniPositions,
+		Siblings:               &a.Siblings,
+		Children:               &a.Children,
+		Grandparents:           &a.Grandparents,
+		ClassPresident:         &a.ClassPresident,
+		BoardOfTrustees:        &a.BoardOfTrustees,
+		BoardOfEducation:       &a.BoardOfEducation,
+		BoardsComment:          &a.BoardsComment,
+		AlumniNewsletters:      &a.AlumniNewsletters,
+		CommunicationsOutreach: &a.CommunicationsOutreach,
+		ClassReunions:          &a.ClassReunions,
+		AlumniEvents:           &a.AlumniEvents,
+		FundraisingNetworking:  &a.FundraisingNetworking,
+		DbResearch:             &a.DbResearch,
+		AlumniChoir:            &a.AlumniChoir,
+		Comment:                &a.Comment,
+		ProfilePictureKey:      a.ProfilePictureKey,
+		LastUpdatedTimestamp:   provideTime(),
 	}
 }
 
-func ToDTOAlumni(a internal.Alumni, presignURL storage.GetImageURLFunc, u internal.User) pkg.Alumni {
-	url, err := presignURL(a.ProfilePictureKey)
+func ToDTOAlumni(ctx context.Context, a internal.Alumni, presignURL storage.GetImageURLFunc, u internal.User) pkg.Alumni {
+	url, err := presignURL(ctx, a.ProfilePictureKey)
 	if err != nil {
 		url = ""
 	}
@@ -253,11 +528,12 @@ func ToDTOAlumni(a internal.Alumni, presignURL storage.GetImageURLFunc, u intern
 		Comment:                a.Comment,
 		IsPublic:               a.IsPublic,
 		ProfilePictureURL:      url,
+		Version:                a.Version,
 	}
 }
 
-func ToCleanAlumni(a internal.Alumni, presignURL storage.GetImageURLFunc, u internal.User) pkg.CleanAlumni {
-	url, err := presignURL(a.ProfilePictureKey)
+func ToCleanAlumni(ctx context.Context, a internal.Alumni, presignURL storage.GetImageURLFunc, u internal.User) pkg.CleanAlumni {
+	url, err := presignURL(ctx, a.ProfilePictureKey)
 	if err != nil {
 		url = ""
 	}
@@ -291,6 +567,37 @@ func ToHappyBirthdayAlumni(a internal.Alumni) pkg.HappyBirthdayAlumni {
 	return aDTO
 }
 
+// unchangedAlumniFields are moved on by every change to an alumni, so are left out of its changes
+var unchangedAlumniFields = map[string]bool{"id": true, "version": true, "createdTimestamp": true, "lastUpdatedTimestamp": true}
+
+func flattenFields(path string, v reflect.Value, fields map[string]interface{}) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("bson"), ",")[0]
+			if path == "" && unchangedAlumniFields[name] {
+				continue
+			}
+			flattenFields(joinPath(path, name), v.Field(i), fields)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			flattenFields(joinPath(path, strconv.Itoa(i)), v.Index(i), fields)
+		}
+	case reflect.String:
+		fields[path] = v.String()
+	case reflect.Bool:
+		fields[path] = v.Bool()
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
 func toDBSchools(ss []pkg.School) []internal.School {
 	newSS := []internal.School{}
 	for _, s := range ss {
@@ -384,3 +691,67 @@ func toDTOGrandparents(gg []internal.Grandparent) []pkg.Grandparent {
 	}
 	return newGG
 }
+
+func toDBAddressUpdate(a *pkg.Address) *internal.Address {
+	if a == nil {
+		return nil
+	}
+	newA := internal.Address(*a)
+	return &newA
+}
+
+func toDBSchoolUpdate(s *pkg.School) *internal.School {
+	if s == nil {
+		return nil
+	}
+	newS := internal.School(*s)
+	return &newS
+}
+
+func toDBCampUpdate(c *pkg.Camp) *internal.Camp {
+	if c == nil {
+		return nil
+	}
+	newC := internal.Camp(*c)
+	return &newC
+}
+
+func toDBSchoolsUpdate(ss *[]pkg.School) *[]internal.School {
+	if ss == nil {
+		return nil
+	}
+	newSS := toDBSchools(*ss)
+	return &newSS
+}
+
+func toDBAddressesUpdate(aa *[]pkg.Address) *[]internal.Address {
+	if aa == nil {
+		return nil
+	}
+	newAA := toDBAddresses(*aa)
+	return &newAA
+}
+
+func toDBSiblingsUpdate(ss *[]pkg.Sibling) *[]internal.Sibling {
+	if ss == nil {
+		return nil
+	}
+	newSS := toDBSiblings(*ss)
+	return &newSS
+}
+
+func toDBChildrenUpdate(cc *[]pkg.Child) *[]internal.Child {
+	if cc == nil {
+		return nil
+	}
+	newCC := toDBChildren(*cc)
+	return &newCC
+}
+
+func toDBGrandparentsUpdate(gg *[]pkg.Grandparent) *[]internal.Grandparent {
+	if gg == nil {
+		return nil
+	}
+	newGG := toDBGrandparents(*gg)
+	return &newGG
+}
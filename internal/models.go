@@ -18,6 +18,55 @@ const (
 	UpdatedAlumniTemplateName  = "UPDATED_ALUMNI"
 	ForgotPasswordTemplateName = "FORGOT_PASSWORD"
 	HappyBirthdayTemplateName  = "HAPPY_BIRTHDAY"
+	VerifyEmailTemplateName    = "VERIFY_EMAIL"
+	ApprovedUserTemplateName   = "APPROVED_USER"
+	DeniedUserTemplateName     = "DENIED_USER"
+	ChangeEmailTemplateName    = "CHANGE_EMAIL"
+	EmailChangedTemplateName   = "EMAIL_CHANGED_NOTICE"
+	InvitationTemplateName     = "INVITATION"
+	MaxDenialReasonLength      = 1000
+	MaxClaimMessageLength      = 1000
+	PendingClaimStatus         = "PENDING"
+	ApprovedClaimStatus        = "APPROVED"
+	RejectedClaimStatus        = "REJECTED"
+	TOTPIssuer                 = "HAFTR Alumni"
+	SuperAdminRole             = "SUPER_ADMIN"
+	DivisionAdminRole          = "DIVISION_ADMIN"
+	ClassCoordinatorRole       = "CLASS_COORDINATOR"
+	StaffRole                  = "STAFF"
+	HILIDivision               = "HILI"
+	HILLELDivision             = "HILLEL"
+	HAFTRDivision              = "HAFTR"
+	GrantRoleAction            = "GRANT"
+	RevokeRoleAction           = "REVOKE"
+	ApproveUsersAction         = "APPROVE"
+	DenyUsersAction            = "DENY"
+	InsertAlumniAction         = "INSERT"
+	UpdateAlumniAction         = "UPDATE"
+	ChangePrivacyAction        = "PRIVACY"
+	RestoreAlumniAction        = "RESTORE"
+	ExportAlumniAction         = "EXPORT_ALUMNI"
+	ExportAuditAction          = "EXPORT_AUDIT"
+	UnlockUserAction           = "UNLOCK"
+	ApproveClaimAction         = "APPROVE_CLAIM"
+	RejectClaimAction          = "REJECT_CLAIM"
+	InviteAlumniAction         = "INVITE"
+	UpsertTemplateAction       = "UPSERT_TEMPLATE"
+	UserTarget                 = "USER"
+	AlumniTarget               = "ALUMNI"
+	AuditTarget                = "AUDIT"
+	ClaimTarget                = "CLAIM"
+	TemplateTarget             = "TEMPLATE"
+	SuccessOutcome             = "SUCCESS"
+	DeniedOutcome              = "DENIED"
+	FailureOutcome             = "FAILURE"
+	MaxBulkUsers               = 100
+)
+
+var (
+	Roles        = []string{SuperAdminRole, DivisionAdminRole, ClassCoordinatorRole, StaffRole}
+	Divisions    = []string{HILIDivision, HILLELDivision, HAFTRDivision}
+	AuditTargets = []string{UserTarget, AlumniTarget, AuditTarget, ClaimTarget, TemplateTarget}
 )
 
 // User is the internal representation of a user
@@ -27,11 +76,81 @@ type User struct {
 	Password             []byte     `bson:"password"`
 	AlumniID             uuid.V4    `bson:"alumniId"`
 	Admin                bool       `bson:"admin"`
+	Roles                []Role     `bson:"roles"`
+	EmailVerified        bool       `bson:"emailVerified"`
+	TOTPSecret           string     `bson:"totpSecret,omitempty"`
+	TOTPEnabled          bool       `bson:"totpEnabled"`
+	TOTPLastStep         int64      `bson:"totpLastStep,omitempty"`
+	RecoveryCodeHashes   []string   `bson:"recoveryCodeHashes,omitempty"`
 	Status               string     `bson:"status"`
+	DenialReason         string     `bson:"denialReason,omitempty"`
+	InvitedBy            uuid.V4    `bson:"invitedBy,omitempty"`
 	CreatedTimestamp     time.Epoch `bson:"createdTimestamp"`
 	LastUpdatedTimestamp time.Epoch `bson:"lastUpdatedTimestamp"`
 }
 
+// Role is the internal representation of an administrative role, optionally scoped to a division or graduating class
+type Role struct {
+	Name           string `bson:"name"`
+	Division       string `bson:"division,omitempty"`
+	GraduationYear string `bson:"graduationYear,omitempty"`
+}
+
+// RoleChange is the internal representation of a role being granted to or revoked from a user
+type RoleChange struct {
+	ID               uuid.V4    `bson:"id"`
+	UserID           uuid.V4    `bson:"userId"`
+	ActorID          uuid.V4    `bson:"actorId"`
+	Action           string     `bson:"action"`
+	Role             Role       `bson:"role"`
+	CreatedTimestamp time.Epoch `bson:"createdTimestamp"`
+}
+
+// AlumniHistory is the internal representation of a change made to an alumni, holding the alumni as it was afterwards
+// so that it can be restored to that version
+type AlumniHistory struct {
+	ID               uuid.V4       `bson:"id"`
+	AlumniID         uuid.V4       `bson:"alumniId"`
+	Version          int64         `bson:"version"`
+	ActorID          uuid.V4       `bson:"actorId"`
+	Action           string        `bson:"action"`
+	Changes          []FieldChange `bson:"changes"`
+	Alumni           Alumni        `bson:"alumni"`
+	RestoredVersion  int64         `bson:"restoredVersion,omitempty"`
+	CreatedTimestamp time.Epoch    `bson:"createdTimestamp"`
+}
+
+// FieldChange is the internal representation of the value of a single field before and after a change, where a field
+// is the dotted path to a string or bool and a missing value is nil
+type FieldChange struct {
+	Field  string      `bson:"field"`
+	Before interface{} `bson:"before"`
+	After  interface{} `bson:"after"`
+}
+
+// AuditEvent is the internal representation of an administrative action taken by a user, whether or not it succeeded
+type AuditEvent struct {
+	ID               uuid.V4    `bson:"id"`
+	ActorID          uuid.V4    `bson:"actorId"`
+	Action           string     `bson:"action"`
+	TargetType       string     `bson:"targetType"`
+	TargetID         string     `bson:"targetId,omitempty"`
+	IP               string     `bson:"ip"`
+	RequestID        string     `bson:"requestId,omitempty"`
+	CorrelationID    string     `bson:"correlationId,omitempty"`
+	Outcome          string     `bson:"outcome"`
+	Detail           string     `bson:"detail,omitempty"`
+	CreatedTimestamp time.Epoch `bson:"createdTimestamp"`
+}
+
+// AlumniScope is the set of alumni a caller can act on
+type AlumniScope struct {
+	All             bool
+	Public          bool
+	Divisions       []string
+	GraduationYears []string
+}
+
 // Alumni is the internal representation of an Alumni
 type Alumni struct {
 	ID                     uuid.V4       `bson:"id"`
@@ -92,75 +211,151 @@ type Alumni struct {
 	Comment                string        `bson:"comment"`
 	IsPublic               bool          `bson:"isPublic"`
 	ProfilePictureKey      string        `bson:"profilePictureKey"`
+	Version                int64         `bson:"version" csv:"-"`
 	CreatedTimestamp       time.Epoch    `bson:"createdTimestamp"`
 	LastUpdatedTimestamp   time.Epoch    `bson:"lastUpdatedTimestamp"`
 }
 
+// AlumniAccount is an alumni record joined with the user account linked to it
+type AlumniAccount struct {
+	Alumni Alumni
+	User   User
+}
+
 type ResetPassword struct {
 	Email            string      `bson:"email"`
-	Token            string      `bson:"token"`
+	TokenHash        string      `bson:"tokenHash"`
 	CreatedTimestamp gotime.Time `bson:"createdTimestamp"`
+	ExpiresAt        gotime.Time `bson:"expiresAt"`
 }
 
+// EmailVerification is the internal representation of a pending proof of ownership of a user's email address
+type EmailVerification struct {
+	UserID           uuid.V4     `bson:"userId"`
+	Email            string      `bson:"email"`
+	TokenHash        string      `bson:"tokenHash"`
+	ExpiresAt        gotime.Time `bson:"expiresAt"`
+	CreatedTimestamp time.Epoch  `bson:"createdTimestamp"`
+}
+
+// EmailChange is the internal representation of a pending change of a user's login email, confirmed from the new address
+// and cancellable from the old one
+type EmailChange struct {
+	UserID           uuid.V4     `bson:"userId"`
+	OldEmail         string      `bson:"oldEmail"`
+	NewEmail         string      `bson:"newEmail"`
+	TokenHash        string      `bson:"tokenHash"`
+	CancelTokenHash  string      `bson:"cancelTokenHash"`
+	SyncAlumni       bool        `bson:"syncAlumni"`
+	ExpiresAt        gotime.Time `bson:"expiresAt"`
+	CreatedTimestamp time.Epoch  `bson:"createdTimestamp"`
+}
+
+// Invitation is the internal representation of an admin inviting an alumnus to claim an alumni record they created
+type Invitation struct {
+	AlumniID         uuid.V4     `bson:"alumniId"`
+	Email            string      `bson:"email"`
+	TokenHash        string      `bson:"tokenHash"`
+	InvitedBy        uuid.V4     `bson:"invitedBy"`
+	ExpiresAt        gotime.Time `bson:"expiresAt"`
+	CreatedTimestamp time.Epoch  `bson:"createdTimestamp"`
+}
+
+// ProfileClaim is the internal representation of a user asking to be linked to an alumni record that already exists.
+// A claim on a record already linked to another user is a dispute, and approving it moves the record to the claimant.
+type ProfileClaim struct {
+	ID                   uuid.V4    `bson:"id"`
+	UserID               uuid.V4    `bson:"userId"`
+	AlumniID             uuid.V4    `bson:"alumniId"`
+	Message              string     `bson:"message"`
+	Disputed             bool       `bson:"disputed"`
+	Status               string     `bson:"status"`
+	ReviewedBy           uuid.V4    `bson:"reviewedBy,omitempty"`
+	ReviewReason         string     `bson:"reviewReason,omitempty"`
+	CreatedTimestamp     time.Epoch `bson:"createdTimestamp"`
+	LastUpdatedTimestamp time.Epoch `bson:"lastUpdatedTimestamp"`
+}
+
+// LoginAttempt is the internal representation of the recent failed logins for an email address or IP address
+type LoginAttempt struct {
+	Key           string      `bson:"key"`
+	Failures      int         `bson:"failures"`
+	LastFailureAt gotime.Time `bson:"lastFailureAt"`
+	ExpiresAt     gotime.Time `bson:"expiresAt"`
+}
+
+// RefreshToken is the internal representation of a login session, identified by a rotating refresh token
+type RefreshToken struct {
+	ID                   uuid.V4     `bson:"id"`
+	UserID               uuid.V4     `bson:"userId"`
+	TokenHash            string      `bson:"tokenHash"`
+	ExpiresAt            gotime.Time `bson:"expiresAt"`
+	CreatedTimestamp     time.Epoch  `bson:"createdTimestamp"`
+	LastUpdatedTimestamp time.Epoch  `bson:"lastUpdatedTimestamp"`
+}
+
+// UpdateAlumniRequest is the internal representation of a partial update of an alumni. Only the fields that are set
+// are written, and the fields named in Unset are removed
 type UpdateAlumniRequest struct {
-	Title                  string        `bson:"title,omitempty"`
-	Firstname              string        `bson:"firstname,omitempty"`
-	Middlename             string        `bson:"middlename,omitempty"`
-	Lastname               string        `bson:"lastname,omitempty"`
-	MarriedName            string        `bson:"marriedName,omitempty"`
-	MaidenName             string        `bson:"maidenName,omitempty"`
-	MotherName             string        `bson:"motherName,omitempty"`
-	MotherDeceased         bool          `bson:"motherDeceased,omitempty"`
-	FatherName             string        `bson:"fatherName,omitempty"`
-	FatherDeceased         bool          `bson:"fatherDeceased,omitempty"`
-	SpouseName             string        `bson:"spouseName,omitempty"`
-	SpouseMaidenName       string        `bson:"spouseMaidenName,omitempty"`
-	CurrentAddress         Address       `bson:"address,omitempty"`
-	HomePhone              string        `bson:"homePhone,omitempty"`
-	CellPhone              string        `bson:"cellPhone,omitempty"`
-	WorkPhone              string        `bson:"workPhone,omitempty"`
-	EmailAddress           string        `bson:"emailAddress,omitempty"`
-	MiddleSchool           School        `bson:"middleschool,omitempty"`
-	HighSchool             School        `bson:"highschool,omitempty"`
-	IsraelSchool           School        `bson:"israelSchool,omitempty"`
-	CollegeAttended        School        `bson:"collegeAttended,omitempty"`
-	GradSchools            []School      `bson:"gradSchools,omitempty"`
-	Profession             []string      `bson:"profession,omitempty"`
-	Birthday               string        `bson:"birthday,omitempty"`
-	Clubs                  []string      `bson:"clubs,omitempty"`
-	SportsTeams            []string      `bson:"sportsTeams,omitempty"`
-	Awards                 []string      `bson:"awards,omitempty"`
-	Committees             []string      `bson:"committees,omitempty"`
-	OldAddresses           []Address     `bson:"oldAddresses,omitempty"`
-	HillelDayCamp          Camp          `bson:"hillelDayCamp,omitempty"`
-	HillelSleepCamp        Camp          `bson:"hillelSleepCamp,omitempty"`
-	HiliDayCamp            Camp          `bson:"hiliDayCamp,omitempty"`
-	HiliWhiteCamp          Camp          `bson:"hiliWhiteCamp,omitempty"`
-	HiliInternationalCamp  Camp          `bson:"hiliInternationalCamp,omitempty"`
-	HILI                   bool          `bson:"hili,omitempty"`
-	HILLEL                 bool          `bson:"hillel,omitempty"`
-	HAFTR                  bool          `bson:"haftr,omitempty"`
-	ParentOfStudent        bool          `bson:"parentOfStudent,omitempty"`
-	Boards                 []string      `bson:"boards,omitempty"`
-	AlumniPositions        []string      `bson:"alumniPositions,omitempty"`
-	Siblings               []Sibling     `bson:"siblings,omitempty"`
-	Children               []Child       `bson:"children,omitempty"`
-	Grandparents           []Grandparent `bson:"grandparents,omitempty"`
-	ClassPresident         bool          `bson:"classPresident,omitempty"`
-	BoardOfTrustees        bool          `bson:"boardOfTrustees,omitempty"`
-	BoardOfEducation       bool          `bson:"boardOfEducation,omitempty"`
-	BoardsComment          string        `bson:"boardsComment,omitempty"`
-	AlumniNewsletters      bool          `bson:"alumniNewsletters,omitempty"`
-	CommunicationsOutreach bool          `bson:"communicationsOutreach,omitempty"`
-	ClassReunions          bool          `bson:"classReunions,omitempty"`
-	AlumniEvents           bool          `bson:"alumniEvents,omitempty"`
-	FundraisingNetworking  bool          `bson:"fundraisingNetworking,omitempty"`
-	DbResearch             bool          `bson:"dbResearch,omitempty"`
-	AlumniChoir            bool          `bson:"alumniChoir,omitempty"`
-	Comment                string        `bson:"comment,omitempty"`
-	IsPublic               bool          `bson:"isPublic,omitempty"`
-	ProfilePictureKey      string        `bson:"profilePictureKey"`
-	LastUpdatedTimestamp   time.Epoch    `bson:"lastUpdatedTimestamp"`
+	Title                  *string        `bson:"title,omitempty"`
+	Firstname              *string        `bson:"firstname,omitempty"`
+	Middlename             *string        `bson:"middlename,omitempty"`
+	Lastname               *string        `bson:"lastname,omitempty"`
+	MarriedName            *string        `bson:"marriedName,omitempty"`
+	MaidenName             *string        `bson:"maidenName,omitempty"`
+	MotherName             *string        `bson:"motherName,omitempty"`
+	MotherDeceased         *bool          `bson:"motherDeceased,omitempty"`
+	FatherName             *string        `bson:"fatherName,omitempty"`
+	FatherDeceased         *bool          `bson:"fatherDeceased,omitempty"`
+	SpouseName             *string        `bson:"spouseName,omitempty"`
+	SpouseMaidenName       *string        `bson:"spouseMaidenName,omitempty"`
+	CurrentAddress         *Address       `bson:"address,omitempty"`
+	HomePhone              *string        `bson:"homePhone,omitempty"`
+	CellPhone              *string        `bson:"cellPhone,omitempty"`
+	WorkPhone              *string        `bson:"workPhone,omitempty"`
+	EmailAddress           *string        `bson:"emailAddress,omitempty"`
+	MiddleSchool           *School        `bson:"middleschool,omitempty"`
+	HighSchool             *School        `bson:"highschool,omitempty"`
+	IsraelSchool           *School        `bson:"israelSchool,omitempty"`
+	CollegeAttended        *School        `bson:"collegeAttended,omitempty"`
+	GradSchools            *[]School      `bson:"gradSchools,omitempty"`
+	Profession             *[]string      `bson:"profession,omitempty"`
+	Birthday               *string        `bson:"birthday,omitempty"`
+	Clubs                  *[]string      `bson:"clubs,omitempty"`
+	SportsTeams            *[]string      `bson:"sportsTeams,omitempty"`
+	Awards                 *[]string      `bson:"awards,omitempty"`
+	Committees             *[]string      `bson:"committees,omitempty"`
+	OldAddresses           *[]Address     `bson:"oldAddresses,omitempty"`
+	HillelDayCamp          *Camp          `bson:"hillelDayCamp,omitempty"`
+	HillelSleepCamp        *Camp          `bson:"hillelSleepCamp,omitempty"`
+	HiliDayCamp            *Camp          `bson:"hiliDayCamp,omitempty"`
+	HiliWhiteCamp          *Camp          `bson:"hiliWhiteCamp,omitempty"`
+	HiliInternationalCamp  *Camp          `bson:"hiliInternationalCamp,omitempty"`
+	HILI                   *bool          `bson:"hili,omitempty"`
+	HILLEL                 *bool          `bson:"hillel,omitempty"`
+	HAFTR                  *bool          `bson:"haftr,omitempty"`
+	ParentOfStudent        *bool          `bson:"parentOfStudent,omitempty"`
+	Boards                 *[]string      `bson:"boards,omitempty"`
+	AlumniPositions        *[]string      `bson:"alumniPositions,omitempty"`
+	Siblings               *[]Sibling     `bson:"siblings,omitempty"`
+	Children               *[]Child       `bson:"children,omitempty"`
+	Grandparents           *[]Grandparent `bson:"grandparents,omitempty"`
+	ClassPresident         *bool          `bson:"classPresident,omitempty"`
+	BoardOfTrustees        *bool          `bson:"boardOfTrustees,omitempty"`
+	BoardOfEducation       *bool          `bson:"boardOfEducation,omitempty"`
+	BoardsComment          *string        `bson:"boardsComment,omitempty"`
+	AlumniNewsletters      *bool          `bson:"alumniNewsletters,omitempty"`
+	CommunicationsOutreach *bool          `bson:"communicationsOutreach,omitempty"`
+	ClassReunions          *bool          `bson:"classReunions,omitempty"`
+	AlumniEvents           *bool          `bson:"alumniEvents,omitempty"`
+	FundraisingNetworking  *bool          `bson:"fundraisingNetworking,omitempty"`
+	DbResearch             *bool          `bson:"dbResearch,omitempty"`
+	AlumniChoir            *bool          `bson:"alumniChoir,omitempty"`
+	Comment                *string        `bson:"comment,omitempty"`
+	IsPublic               *bool          `bson:"isPublic,omitempty"`
+	ProfilePictureKey      string         `bson:"profilePictureKey"`
+	LastUpdatedTimestamp   time.Epoch     `bson:"lastUpdatedTimestamp"`
+	Unset                  []string       `bson:"-"`
 }
 
 type School struct {
@@ -227,3 +422,45 @@ func (u User) IsApproved() bool {
 	}
 	return false
 }
+
+// EffectiveRoles returns the roles of a user, treating the legacy admin flag as a super admin role
+func (u User) EffectiveRoles() []Role {
+	if !u.Admin || u.HasRole(Role{Name: SuperAdminRole}) {
+		return u.Roles
+	}
+	return append([]Role{{Name: SuperAdminRole}}, u.Roles...)
+}
+
+// HasRole returns whether the user has been granted the exact role
+func (u User) HasRole(r Role) bool {
+	for _, ur := range u.Roles {
+		if ur == r {
+			return true
+		}
+	}
+	return false
+}
+
+// Covers returns whether the alumni falls within the scope of the role
+func (r Role) Covers(a Alumni) bool {
+	switch r.Name {
+	case DivisionAdminRole:
+		return a.InDivision(r.Division)
+	case ClassCoordinatorRole:
+		return r.GraduationYear != "" && a.HighSchool.YearEnded == r.GraduationYear
+	}
+	return true
+}
+
+// InDivision returns whether the alumni attended the division
+func (a Alumni) InDivision(division string) bool {
+	switch division {
+	case HILIDivision:
+		return a.HILI
+	case HILLELDivision:
+		return a.HILLEL
+	case HAFTRDivision:
+		return a.HAFTR
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[string]*s3.S3{}
+)
+
+// s3Client returns the S3 client for the region of c, creating it on first use. Clients are safe for concurrent
+// use, so sharing one keeps its session and connections warm across Lambda invocations
+func s3Client(c Config) (*s3.S3, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	if svc, ok := clients[c.Region]; ok {
+		return svc, nil
+	}
+
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(c.Region)},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	svc := s3.New(sess)
+	clients[c.Region] = svc
+	return svc, nil
+}
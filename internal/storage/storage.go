@@ -1,12 +1,13 @@
 package storage
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"log"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
@@ -16,6 +17,12 @@ const (
 	defaultRegion = "us-east-1"
 )
 
+// timeouts for a single call to S3, on top of any deadline of the request it is made for
+const (
+	requestTimeout  = 10 * time.Second
+	transferTimeout = 30 * time.Second
+)
+
 // Config is a representation of pubsub configurations
 type Config struct {
 	Region string
@@ -36,19 +43,31 @@ func DefaultConfig() Config {
 }
 
 // UploadImageFunc is a function that takes in a reader of an image file and a storage key and uploads it to S3
-type UploadImageFunc func(r io.Reader, contentType, key, fileName string) error
+type UploadImageFunc func(ctx context.Context, r io.Reader, contentType, key, fileName string) error
 
-type GetImageURLFunc func(key string) (string, error)
+type GetImageURLFunc func(ctx context.Context, key string) (string, error)
+
+// DownloadImageFunc is a function that returns an image stored in S3 along with its content type
+type DownloadImageFunc func(ctx context.Context, key string) ([]byte, string, error)
+
+// DeleteImageFunc is a function that removes an image from S3
+type DeleteImageFunc func(ctx context.Context, key string) error
 
 // UploadFunc func for uploading data to s3
-type UploadFunc func(reader io.Reader, bucket string, key string, opts ...UploadOption) error
+type UploadFunc func(ctx context.Context, reader io.Reader, bucket string, key string, opts ...UploadOption) error
 
 // PresignFunc func for presigning s3 object
-type PresignFunc func(bucket string, key string) (string, error)
+type PresignFunc func(ctx context.Context, bucket string, key string) (string, error)
+
+// DownloadFunc func for downloading an s3 object and its content type
+type DownloadFunc func(ctx context.Context, bucket string, key string) ([]byte, string, error)
+
+// DeleteFunc func for deleting an s3 object
+type DeleteFunc func(ctx context.Context, bucket string, key string) error
 
 // UploadImage uploads an image file to S3
 func UploadImage(upload UploadFunc, bucket string) UploadImageFunc {
-	return func(r io.Reader, contentType, key, fileName string) error {
+	return func(ctx context.Context, r io.Reader, contentType, key, fileName string) error {
 		metaDataOpt := func(r *OptionalUploadRequest) {
 			r.MetaData = map[string]*string{
 				"clientFilename": &fileName,
@@ -57,19 +76,31 @@ func UploadImage(upload UploadFunc, bucket string) UploadImageFunc {
 		contentTypeOpt := func(r *OptionalUploadRequest) {
 			r.ContentType = contentType
 		}
-		return upload(r, bucket, key, metaDataOpt, contentTypeOpt)
+		return upload(ctx, r, bucket, key, metaDataOpt, contentTypeOpt)
 	}
 }
 
 func GetImageURL(presignURL PresignFunc, bucket string) GetImageURLFunc {
-	return func(key string) (string, error) {
-		return presignURL(bucket, key)
+	return func(ctx context.Context, key string) (string, error) {
+		return presignURL(ctx, bucket, key)
+	}
+}
+
+func DownloadImage(download DownloadFunc, bucket string) DownloadImageFunc {
+	return func(ctx context.Context, key string) ([]byte, string, error) {
+		return download(ctx, bucket, key)
+	}
+}
+
+func DeleteImage(del DeleteFunc, bucket string) DeleteImageFunc {
+	return func(ctx context.Context, key string) error {
+		return del(ctx, bucket, key)
 	}
 }
 
 // UploadToS3 default implementation of s3 uploader
 func UploadToS3(c Config) UploadFunc {
-	return func(reader io.Reader, bucket string, key string, opts ...UploadOption) error {
+	return func(ctx context.Context, reader io.Reader, bucket string, key string, opts ...UploadOption) error {
 		optRequestInput := OptionalUploadRequest{
 			MetaData:    map[string]*string{},
 			ContentType: "application/octet-stream",
@@ -77,12 +108,15 @@ func UploadToS3(c Config) UploadFunc {
 		for _, opt := range opts {
 			opt(&optRequestInput)
 		}
-		sess, err := session.NewSession(&aws.Config{
-			Region: aws.String(c.Region)},
-		)
-		uploader := s3manager.NewUploader(sess)
+		svc, err := s3Client(c)
+		if err != nil {
+			return err
+		}
+		uploader := s3manager.NewUploaderWithClient(svc)
 
-		_, err = uploader.Upload(&s3manager.UploadInput{
+		ctx, cancel := context.WithTimeout(ctx, transferTimeout)
+		defer cancel()
+		_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
 			Bucket:      aws.String(bucket),
 			Key:         aws.String(key),
 			ContentType: aws.String(optRequestInput.ContentType),
@@ -100,16 +134,17 @@ func UploadToS3(c Config) UploadFunc {
 
 // PresignObject default implementation of S3 object URL presigner
 func PresignObject(c Config) PresignFunc {
-	return func(bucket, key string) (string, error) {
-		sess, err := session.NewSession(&aws.Config{
-			Region: aws.String(c.Region)},
-		)
+	return func(ctx context.Context, bucket, key string) (string, error) {
+		svc, err := s3Client(c)
+		if err != nil {
+			return "", err
+		}
 
-		svc := s3.New(sess)
 		req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
 			Bucket: aws.String(bucket),
 			Key:    aws.String(key),
 		})
+		req.SetContext(ctx)
 
 		urlStr, err := req.Presign(15 * time.Minute)
 		if err != nil {
@@ -119,3 +154,54 @@ func PresignObject(c Config) PresignFunc {
 		return urlStr, nil
 	}
 }
+
+// DownloadFromS3 default implementation of s3 downloader
+func DownloadFromS3(c Config) DownloadFunc {
+	return func(ctx context.Context, bucket, key string) ([]byte, string, error) {
+		svc, err := s3Client(c)
+		if err != nil {
+			return nil, "", err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, transferTimeout)
+		defer cancel()
+		out, err := svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			log.Printf("Unable to download %q from %q, %v", key, bucket, err)
+			return nil, "", err
+		}
+		defer out.Body.Close()
+
+		bb, err := ioutil.ReadAll(out.Body)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return bb, aws.StringValue(out.ContentType), nil
+	}
+}
+
+// DeleteFromS3 default implementation of s3 object deletion
+func DeleteFromS3(c Config) DeleteFunc {
+	return func(ctx context.Context, bucket, key string) error {
+		svc, err := s3Client(c)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		if _, err := svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			log.Printf("Unable to delete %q from %q, %v", key, bucket, err)
+			return err
+		}
+		log.Printf("Successfully deleted %q from %q\n", key, bucket)
+		return nil
+	}
+}
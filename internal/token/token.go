@@ -1,6 +1,10 @@
 package token
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"os"
 	gotime "time"
@@ -14,32 +18,111 @@ import (
 
 const (
 	userIdKey     = "user_id"
+	sessionIdKey  = "session_id"
 	adminKey      = "admin"
 	expirationKey = "exp"
+	purposeKey    = "purpose"
+	mfaPurpose    = "mfa"
+
+	// AccessTokenTTL is how long a JWT access token is valid for
+	AccessTokenTTL = gotime.Minute * 15
+	// RefreshTokenTTL is how long a refresh token is valid for after it was last rotated
+	RefreshTokenTTL = gotime.Hour * 24 * 30
+
+	// MFATokenTTL is how long a user has to enter their second factor after entering their password
+	MFATokenTTL = gotime.Minute * 5
+	// EmailVerificationTTL is how long an email verification token is valid for
+	EmailVerificationTTL = gotime.Hour * 48
+	// EmailChangeTTL is how long a link to confirm or cancel a change of email address is valid for
+	EmailChangeTTL = gotime.Hour * 24
+	// InvitationTTL is how long an alumnus has to accept an invitation to claim their alumni record
+	InvitationTTL = gotime.Hour * 24 * 14
+
+	opaqueTokenBytes = 32
 )
 
+// Claims are the claims carried by a user's JWT access token
+type Claims struct {
+	UserID    uuid.V4
+	SessionID uuid.V4
+	Admin     bool
+}
+
 func getJwtSecret() string {
 	return os.Getenv("JWT_SECRET")
 }
 
-func CreateUserToken(u internal.User, provideTime time.EpochProviderFunc) (string, error) {
+func CreateUserToken(u internal.User, sessionId uuid.V4, provideTime time.EpochProviderFunc) (string, error) {
 	secret := getJwtSecret()
 
-	exp, err := time.New(provideTime().ToISO8601().Val().Add(gotime.Hour * 36))
-	if err != nil {
-		return "", errors.Wrap(err, "token - unable to generate expiration")
-	}
+	exp := (provideTime() + time.Epoch(AccessTokenTTL)).ToISO8601()
 
 	m := jwt.MapClaims{}
 	m["authorized"] = true
 	m[userIdKey] = u.ID
+	m[sessionIdKey] = sessionId
 	m[expirationKey] = exp.String()
 	m[adminKey] = u.Admin
 
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, m).SignedString([]byte(secret))
 }
 
-func CheckUserToken(tokenString string, provideTime time.EpochProviderFunc) (uuid.V4, bool, error) {
+func CheckUserToken(tokenString string, provideTime time.EpochProviderFunc) (Claims, error) {
+	m, err := parseToken(tokenString, provideTime)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	if _, ok := m[purposeKey]; ok {
+		return Claims{}, fmt.Errorf("token - token is not an access token")
+	}
+
+	id, ok := m[userIdKey].(string)
+	if !ok || id == "" {
+		return Claims{}, fmt.Errorf("token - token is missing %v", userIdKey)
+	}
+	sessionId, ok := m[sessionIdKey].(string)
+	if !ok || sessionId == "" {
+		return Claims{}, fmt.Errorf("token - token is missing %v", sessionIdKey)
+	}
+	admin, _ := m[adminKey].(bool)
+
+	return Claims{UserID: uuid.V4(id), SessionID: uuid.V4(sessionId), Admin: admin}, nil
+}
+
+// CreateMFAToken creates a short lived token proving the user entered their password, to be exchanged for a session once they enter their second factor
+func CreateMFAToken(u internal.User, provideTime time.EpochProviderFunc) (string, error) {
+	exp := (provideTime() + time.Epoch(MFATokenTTL)).ToISO8601()
+
+	m := jwt.MapClaims{}
+	m[userIdKey] = u.ID
+	m[purposeKey] = mfaPurpose
+	m[expirationKey] = exp.String()
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, m).SignedString([]byte(getJwtSecret()))
+}
+
+// CheckMFAToken returns the ID of the user an MFA token was issued to
+func CheckMFAToken(tokenString string, provideTime time.EpochProviderFunc) (uuid.V4, error) {
+	m, err := parseToken(tokenString, provideTime)
+	if err != nil {
+		return "", err
+	}
+
+	if purpose, _ := m[purposeKey].(string); purpose != mfaPurpose {
+		return "", fmt.Errorf("token - token is not an MFA token")
+	}
+
+	id, ok := m[userIdKey].(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("token - token is missing %v", userIdKey)
+	}
+
+	return uuid.V4(id), nil
+}
+
+// parseToken verifies the signature and expiration of a JWT and returns its claims
+func parseToken(tokenString string, provideTime time.EpochProviderFunc) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
@@ -47,22 +130,38 @@ func CheckUserToken(tokenString string, provideTime time.EpochProviderFunc) (uui
 		return []byte(getJwtSecret()), nil
 	})
 	if err != nil {
-		return uuid.V4(""), false, err
+		return nil, err
 	}
 
-	m := token.Claims.(jwt.MapClaims)
+	m, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("token - unexpected claims type")
+	}
 
-	tTime, err := time.NewISO8601(m[expirationKey].(string))
+	exp, _ := m[expirationKey].(string)
+	tTime, err := time.NewISO8601(exp)
 	if err != nil {
-		return uuid.V4(""), false, errors.Wrapf(err, "token - unable to retrieve expiration from JWT")
+		return nil, errors.Wrapf(err, "token - unable to retrieve expiration from JWT")
 	}
 
 	if !token.Valid || tTime.Val().Before(provideTime().ToISO8601().Val()) {
-		return uuid.V4(""), false, fmt.Errorf("token - token is invalid or expired")
+		return nil, fmt.Errorf("token - token is invalid or expired")
 	}
 
-	id := m[userIdKey].(string)
-	admin := m[adminKey].(bool)
+	return m, nil
+}
+
+// NewOpaqueToken generates a new random token, such as a refresh or verification token
+func NewOpaqueToken() (string, error) {
+	bb := make([]byte, opaqueTokenBytes)
+	if _, err := rand.Read(bb); err != nil {
+		return "", errors.Wrap(err, "token - unable to generate opaque token")
+	}
+	return base64.RawURLEncoding.EncodeToString(bb), nil
+}
 
-	return uuid.V4(id), admin, nil
+// HashOpaqueToken returns the hash of an opaque token that is persisted in place of the token
+func HashOpaqueToken(opaqueToken string) string {
+	sum := sha256.Sum256([]byte(opaqueToken))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,121 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	gotime "time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Period is how long each code is valid for, as recommended by RFC 6238
+	Period = 30 * gotime.Second
+	// Digits is the length of each code
+	Digits = 6
+	// Skew is the number of periods either side of now a code is still accepted for, to allow for clock drift
+	Skew = 1
+
+	secretBytes = 20
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a new base32 encoded secret to share with an authenticator app
+func NewSecret() (string, error) {
+	bb := make([]byte, secretBytes)
+	if _, err := rand.Read(bb); err != nil {
+		return "", errors.Wrap(err, "totp - unable to generate secret")
+	}
+	return b32.EncodeToString(bb), nil
+}
+
+// ProvisioningURI returns the otpauth:// URI authenticator apps read from a QR code
+func ProvisioningURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%v?%v", label, v.Encode())
+}
+
+// Step returns the time step a time falls in
+func Step(t gotime.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code returns the code for the secret at the time step
+func Code(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", errors.Wrap(err, "totp - unable to decode secret")
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, bin%mod), nil
+}
+
+// Validate returns the time step the code matches within the allowed skew of t, or false if it matches none
+func Validate(secret, code string, t gotime.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// NewRecoveryCodes generates single use codes a user can enter in place of a TOTP code if they lose their authenticator
+func NewRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		bb := make([]byte, 5)
+		if _, err := rand.Read(bb); err != nil {
+			return []string{}, errors.Wrap(err, "totp - unable to generate recovery code")
+		}
+		c := strings.ToLower(b32.EncodeToString(bb))
+		codes = append(codes, c[:4]+"-"+c[4:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode strips the formatting a user may or may not have typed from a recovery code
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.Replace(code, "-", "", -1)
+}
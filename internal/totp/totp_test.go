@@ -0,0 +1,89 @@
+package totp
+
+import (
+	"testing"
+	gotime "time"
+)
+
+// rfcSecret is the base32 encoding of the SHA1 secret used by the test vectors in RFC 6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// The RFC vectors are eight digits, of which the last six are the code
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(gotime.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("unable to generate code at %v: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %v = %v, want %v", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := gotime.Unix(1234567890, 0)
+	step := Step(now)
+
+	for offset := int64(-Skew); offset <= Skew; offset++ {
+		code, _ := Code(rfcSecret, step+offset)
+		got, ok := Validate(rfcSecret, " "+code+" ", now)
+		if !ok || got != step+offset {
+			t.Errorf("got step=%v, ok=%v for a code %v steps from now, want step=%v", got, ok, offset, step+offset)
+		}
+	}
+
+	for _, offset := range []int64{-Skew - 1, Skew + 1} {
+		code, _ := Code(rfcSecret, step+offset)
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("got a code %v steps from now accepted, want it rejected", offset)
+		}
+	}
+
+	for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := Validate(rfcSecret, code, now); ok {
+			t.Errorf("got code=%q accepted, want it rejected", code)
+		}
+	}
+
+	if _, ok := Validate("not base32!", "005924", now); ok {
+		t.Errorf("got a code accepted for an invalid secret")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("unable to generate recovery codes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %v recovery codes, want 10", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, c := range codes {
+		n := NormalizeRecoveryCode(c)
+		if len(c) != 9 || c[4] != '-' || len(n) != 8 {
+			t.Errorf("got recovery code=%q normalized to %q, want xxxx-xxxx", c, n)
+		}
+		if seen[n] {
+			t.Errorf("got recovery code=%q twice", c)
+		}
+		seen[n] = true
+	}
+
+	if got := NormalizeRecoveryCode(" ABCD-EFGH "); got != "abcdefgh" {
+		t.Errorf("NormalizeRecoveryCode = %q, want abcdefgh", got)
+	}
+}
@@ -0,0 +1,182 @@
+package workflow
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/storage"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	accountExportFilename        = "account.json"
+	alumniExportFilename         = "alumni.json"
+	profilePictureExportFilename = "profile-picture"
+)
+
+// DeleteAccount removes the caller's account, alumni profile, profile picture and any tokens issued to them
+func DeleteAccount(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	deleteImage storage.DeleteImageFunc,
+	deleteAlumni db.DeleteAlumniFunc,
+	deleteAlumniHistory db.DeleteAlumniHistoryFunc,
+	deleteResetPasswords db.DeleteResetPasswordsFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	deleteUser db.DeleteUserFunc) DeleteAccountFunc {
+	return func(ctx context.Context, req pkg.DeleteAccountRequest, p auth.Principal) error {
+		user := p.User
+		log.Printf("Deleting account of userId=%v", user.ID)
+
+		if err := bcrypt.CompareHashAndPassword(user.Password, []byte(req.Password)); err != nil {
+			return apperror.New(apperror.UnauthorizedCode, "workflow - password does not match for userId=%v", user.ID)
+		}
+
+		// The profile picture goes first, since nothing would be left pointing at it if it failed after the alumni was deleted
+		if user.AlumniID != "" {
+			a, err := retrieveAlumniById(ctx, user.AlumniID.Val())
+			if err != nil && apperror.CodeOf(err) != apperror.NotFoundCode {
+				return errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", user.AlumniID)
+			}
+
+			if a.ProfilePictureKey != "" {
+				if err := deleteImage(ctx, a.ProfilePictureKey); err != nil {
+					return errors.Wrapf(err, "workflow - unable to delete profile picture of alumniId=%v", user.AlumniID)
+				}
+			}
+
+			if err := deleteAlumni(ctx, user.AlumniID.Val()); err != nil {
+				return errors.Wrapf(err, "workflow - unable to delete alumniId=%v", user.AlumniID)
+			}
+
+			if err := deleteAlumniHistory(ctx, user.AlumniID.Val()); err != nil {
+				return errors.Wrapf(err, "workflow - unable to delete history of alumniId=%v", user.AlumniID)
+			}
+		}
+
+		if err := deleteResetPasswords(ctx, user.Email); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete reset passwords for userId=%v", user.ID)
+		}
+
+		if err := deleteEmailVerifications(ctx, user.ID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete email verifications for userId=%v", user.ID)
+		}
+
+		if err := deleteEmailChanges(ctx, user.ID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete email changes for userId=%v", user.ID)
+		}
+
+		if err := deleteUserRefreshTokens(ctx, user.ID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to revoke sessions for userId=%v", user.ID)
+		}
+
+		if err := deleteLoginAttempts(ctx, emailLoginKey(user.Email)); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete login attempts for userId=%v", user.ID)
+		}
+
+		if err := deleteUser(ctx, user.ID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete userId=%v", user.ID)
+		}
+
+		return nil
+	}
+}
+
+// ExportAccount bundles the caller's account, alumni profile and profile picture into a ZIP
+func ExportAccount(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveRoleChanges db.RetrieveRoleChangesFunc,
+	downloadImage storage.DownloadImageFunc) ExportAccountFunc {
+	return func(ctx context.Context, p auth.Principal) ([]byte, error) {
+		user := p.User
+		log.Printf("Exporting account of userId=%v", user.ID)
+
+		rcs, err := retrieveRoleChanges(ctx, user.ID.Val())
+		if err != nil {
+			return []byte{}, errors.Wrapf(err, "workflow - unable to retrieve role history for userId=%v", user.ID)
+		}
+
+		buf := new(bytes.Buffer)
+		zw := zip.NewWriter(buf)
+
+		account := pkg.AccountExport{
+			User:                 mapping.ToDTOUser(user),
+			LastUpdatedTimestamp: user.LastUpdatedTimestamp.String(),
+			RoleHistory:          mapping.ToDTORoleChanges(rcs),
+		}
+		if err := writeZipJSON(zw, accountExportFilename, account); err != nil {
+			return []byte{}, err
+		}
+
+		if user.AlumniID != "" {
+			a, err := retrieveAlumniById(ctx, user.AlumniID.Val())
+			if err != nil && apperror.CodeOf(err) != apperror.NotFoundCode {
+				return []byte{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", user.AlumniID)
+			}
+
+			if err == nil {
+				// The picture itself is included, so there is no need for a link that expires
+				noURL := func(context.Context, string) (string, error) { return "", nil }
+				if err := writeZipJSON(zw, alumniExportFilename, mapping.ToDTOAlumni(ctx, a, noURL, user)); err != nil {
+					return []byte{}, err
+				}
+
+				if a.ProfilePictureKey != "" {
+					bb, contentType, err := downloadImage(ctx, a.ProfilePictureKey)
+					if err != nil {
+						return []byte{}, errors.Wrapf(err, "workflow - unable to download profile picture of alumniId=%v", a.ID)
+					}
+
+					f, err := zw.Create(profilePictureExportFilename + imageExtension(contentType))
+					if err != nil {
+						return []byte{}, errors.Wrap(err, "workflow - unable to add profile picture to export")
+					}
+					if _, err := f.Write(bb); err != nil {
+						return []byte{}, errors.Wrap(err, "workflow - unable to write profile picture to export")
+					}
+				}
+			}
+		}
+
+		if err := zw.Close(); err != nil {
+			return []byte{}, errors.Wrap(err, "workflow - unable to finish export")
+		}
+
+		return buf.Bytes(), nil
+	}
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v interface{}) error {
+	bb, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "workflow - unable to marshal %v", name)
+	}
+
+	f, err := zw.Create(name)
+	if err != nil {
+		return errors.Wrapf(err, "workflow - unable to add %v to export", name)
+	}
+	if _, err := f.Write(bb); err != nil {
+		return errors.Wrapf(err, "workflow - unable to write %v to export", name)
+	}
+	return nil
+}
+
+func imageExtension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	}
+	return ""
+}
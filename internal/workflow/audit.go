@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"context"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/gocarina/gocsv"
+	"github.com/pkg/errors"
+)
+
+func RetrieveAuditEvents(retrieveAuditEvents db.RetrieveAuditEventsFunc) RetrieveAuditEventsFunc {
+	return func(ctx context.Context, params pkg.AuditQueryParams, p auth.Principal) (pkg.RetrieveAuditEventsResponse, error) {
+		log.Printf("Retrieving audit events with params=%+v", params)
+
+		if !p.Can(auth.ViewAuditPermission) {
+			return pkg.RetrieveAuditEventsResponse{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to the audit trail", p.User.ID)
+		}
+
+		ee, pageInfo, err := retrieveAuditEvents(ctx, params)
+		if err != nil {
+			return pkg.RetrieveAuditEventsResponse{}, errors.Wrap(err, "workflow - unable to retrieve audit events")
+		}
+
+		return pkg.RetrieveAuditEventsResponse{Events: mapping.ToDTOAuditEvents(ee), PageInfo: pageInfo}, nil
+	}
+}
+
+func ExportAuditCSV(retrieveAuditEvents db.RetrieveAuditEventsFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) ExportAuditCSVFunc {
+	return func(ctx context.Context, params pkg.AuditQueryParams, p auth.Principal) (bb []byte, err error) {
+		log.Printf("Exporting CSV of audit events")
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.ExportAuditAction, internal.AuditTarget, "", &err)
+
+		if !p.Can(auth.ViewAuditPermission) {
+			return []byte{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to export the audit trail", p.User.ID)
+		}
+
+		ee, _, err := retrieveAuditEvents(ctx, params)
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "workflow - unable to retrieve audit events")
+		}
+
+		bb, err = gocsv.MarshalBytes(mapping.ToDTOAuditEvents(ee))
+		if err != nil {
+			return []byte{}, errors.Wrap(err, "workflow - unable to marshal to csv")
+		}
+
+		return bb, nil
+	}
+}
+
+// recordAuditEvent records an administrative action taken by the principal, and whether it succeeded according to the
+// error the action returned. It is deferred by the action so every way it can return is recorded. By then the change
+// is already made, so the action's result is never changed by a failure to record it, which is logged instead
+func recordAuditEvent(ctx context.Context, insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	p auth.Principal,
+	action string,
+	targetType string,
+	targetId string,
+	err *error) {
+	e := internal.AuditEvent{
+		ID:               genUUID(),
+		ActorID:          p.User.ID,
+		Action:           action,
+		TargetType:       targetType,
+		TargetID:         targetId,
+		IP:               p.IP,
+		RequestID:        p.RequestID,
+		CorrelationID:    p.CorrelationID,
+		Outcome:          internal.SuccessOutcome,
+		CreatedTimestamp: provideTime(),
+	}
+	if *err != nil {
+		e.Outcome = internal.FailureOutcome
+		if code := apperror.CodeOf(*err); code == apperror.ForbiddenCode || code == apperror.UnauthorizedCode {
+			e.Outcome = internal.DeniedOutcome
+		}
+		e.Detail = (*err).Error()
+	}
+
+	if insertErr := insertAuditEvent(ctx, e); insertErr != nil {
+		log.Printf("Unable to record audit event action=%v outcome=%v for userId=%v: %v", action, e.Outcome, p.User.ID, insertErr)
+	}
+}
@@ -0,0 +1,27 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/pkg/errors"
+)
+
+func TestRecordAuditEventFails(t *testing.T) {
+	failInsert := func(ctx context.Context, e internal.AuditEvent) error {
+		return errors.New("db - unavailable")
+	}
+
+	// The action has already been made by the time it is recorded, so its result stands
+	var err error
+	recordAuditEvent(context.Background(), failInsert, clockAt(testNow), sequentialUUIDs(), testAdmin, internal.ApproveUsersAction, internal.UserTarget, verifiedUserID, &err)
+	if err != nil {
+		t.Errorf("got err=%v, want the successful action left successful", err)
+	}
+
+	err = apperror.New(apperror.NotFoundCode, "workflow - no user")
+	recordAuditEvent(context.Background(), failInsert, clockAt(testNow), sequentialUUIDs(), testAdmin, internal.ApproveUsersAction, internal.UserTarget, verifiedUserID, &err)
+	wantCode(t, err, apperror.NotFoundCode)
+}
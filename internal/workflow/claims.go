@@ -0,0 +1,289 @@
+package workflow
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+// claimedByAnotherReason is recorded on the claims left pending when a different claim on the same record is approved
+const claimedByAnotherReason = "The alumni record was claimed by another user"
+
+// SearchClaimableAlumni finds the unclaimed alumni records a user without one may be looking to claim. Only a user
+// with a verified email may search, and only for exactly their first name, last name and graduation year, so the
+// directory cannot be browsed through it. Matches are masked until an admin approves a claim on one
+func SearchClaimableAlumni(retrieveUnclaimedAlumni db.RetrieveUnclaimedAlumniFunc) SearchClaimableAlumniFunc {
+	return func(ctx context.Context, params pkg.QueryParams, p auth.Principal) ([]pkg.AlumniMatch, error) {
+		user := p.User
+		log.Printf("Searching claimable alumni for userId=%v", user.ID)
+
+		if user.AlumniID != "" {
+			return []pkg.AlumniMatch{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has alumniId=%v", user.ID, user.AlumniID)
+		}
+		if !user.EmailVerified {
+			return []pkg.AlumniMatch{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v must verify their email to search for an alumni record", user.ID)
+		}
+		if strings.TrimSpace(params.Firstname) == "" || strings.TrimSpace(params.Lastname) == "" || strings.TrimSpace(params.YearGraduated) == "" {
+			return []pkg.AlumniMatch{}, apperror.New(apperror.ValidationCode, "workflow - firstname, lastname and yearGraduated are required to search for an alumni record")
+		}
+		if params.Limit < 1 || params.Limit > internal.DefaultPageLimit {
+			params.Limit = internal.DefaultPageLimit
+		}
+		if params.Page < 1 {
+			params.Page = 1
+		}
+
+		aa, _, err := retrieveUnclaimedAlumni(ctx, params)
+		if err != nil {
+			return []pkg.AlumniMatch{}, errors.Wrap(err, "workflow - unable to search unclaimed alumni")
+		}
+
+		matches := []pkg.AlumniMatch{}
+		for _, a := range aa {
+			matches = append(matches, mapping.ToAlumniMatch(a))
+		}
+
+		return matches, nil
+	}
+}
+
+// ClaimAlumni asks an admin to link the caller to an existing alumni record instead of creating a new one
+func ClaimAlumni(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	retrieveProfileClaims db.RetrieveProfileClaimsFunc,
+	insertProfileClaim db.InsertProfileClaimFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) ClaimAlumniFunc {
+	return func(ctx context.Context, alumniId string, req pkg.ClaimRequest, p auth.Principal) (pkg.ProfileClaim, error) {
+		user := p.User
+		log.Printf("Claiming alumniId=%v for userId=%v", alumniId, user.ID)
+
+		if user.AlumniID != "" {
+			return pkg.ProfileClaim{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has alumniId=%v", user.ID, user.AlumniID)
+		}
+
+		message := strings.TrimSpace(req.Message)
+		if len(message) > internal.MaxClaimMessageLength {
+			return pkg.ProfileClaim{}, apperror.New(apperror.ValidationCode, "workflow - claim message must be at most %v characters", internal.MaxClaimMessageLength)
+		}
+
+		a, err := retrieveAlumniById(ctx, alumniId)
+		if err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
+		}
+
+		pending, err := retrieveProfileClaims(ctx, internal.PendingClaimStatus, user.ID.Val(), "")
+		if err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to retrieve pending claims of userId=%v", user.ID)
+		}
+		if len(pending) > 0 {
+			return pkg.ProfileClaim{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has a pending claim on alumniId=%v", user.ID, pending[0].AlumniID)
+		}
+
+		owner, err := retrieveUserByAlumniId(ctx, alumniId)
+		if err != nil && apperror.CodeOf(err) != apperror.NotFoundCode {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to retrieve user with alumniId=%v", alumniId)
+		}
+
+		currentTime := provideTime()
+		c := internal.ProfileClaim{
+			ID:                   genUUID(),
+			UserID:               user.ID,
+			AlumniID:             a.ID,
+			Message:              message,
+			Disputed:             err == nil,
+			Status:               internal.PendingClaimStatus,
+			CreatedTimestamp:     currentTime,
+			LastUpdatedTimestamp: currentTime,
+		}
+		if c.Disputed {
+			log.Printf("Claim by userId=%v disputes alumniId=%v held by userId=%v", user.ID, alumniId, owner.ID)
+		}
+
+		if err := insertProfileClaim(ctx, c); err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to insert claim on alumniId=%v", alumniId)
+		}
+
+		return mapping.ToDTOProfileClaim(c), nil
+	}
+}
+
+// RetrieveProfileClaims lists the claims with the status on alumni records within the scope of the caller
+func RetrieveProfileClaims(retrieveProfileClaims db.RetrieveProfileClaimsFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc) RetrieveProfileClaimsFunc {
+	return func(ctx context.Context, status string, p auth.Principal) ([]pkg.ProfileClaim, error) {
+		log.Printf("Retrieving profile claims with status=%v", status)
+
+		if !p.Can(auth.ApproveUsersPermission) {
+			return []pkg.ProfileClaim{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have permission=%v", p.User.ID, auth.ApproveUsersPermission)
+		}
+
+		cc, err := retrieveProfileClaims(ctx, status, "", "")
+		if err != nil {
+			return []pkg.ProfileClaim{}, errors.Wrap(err, "workflow - unable to retrieve profile claims")
+		}
+
+		all := p.AlumniScope(auth.ApproveUsersPermission).All
+		claims := []pkg.ProfileClaim{}
+		for _, c := range cc {
+			if !all {
+				a, err := retrieveAlumniById(ctx, c.AlumniID.Val())
+				if err != nil {
+					return []pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", c.AlumniID)
+				}
+				if !p.CanForAlumni(auth.ApproveUsersPermission, a) {
+					continue
+				}
+			}
+			claims = append(claims, mapping.ToDTOProfileClaim(c))
+		}
+
+		return claims, nil
+	}
+}
+
+// ApproveProfileClaim links the claimant to the alumni record, unlinking whoever held it if the claim was a dispute
+func ApproveProfileClaim(retrieveProfileClaimById db.RetrieveProfileClaimByIDFunc,
+	retrieveProfileClaims db.RetrieveProfileClaimsFunc,
+	replaceProfileClaim db.ReplaceProfileClaimFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) ReviewProfileClaimFunc {
+	return func(ctx context.Context, claimId string, req pkg.ReviewClaimRequest, p auth.Principal) (_ pkg.ProfileClaim, err error) {
+		log.Printf("Approving profile claimId=%v", claimId)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.ApproveClaimAction, internal.ClaimTarget, claimId, &err)
+
+		c, a, err := pendingClaimForReview(ctx, claimId, req, p, retrieveProfileClaimById, retrieveAlumniById)
+		if err != nil {
+			return pkg.ProfileClaim{}, err
+		}
+
+		claimant, err := retrieveUserById(ctx, c.UserID.Val())
+		if err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to find userId=%v", c.UserID)
+		}
+		if claimant.AlumniID != "" {
+			return pkg.ProfileClaim{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v has been linked to alumniId=%v since claiming", claimant.ID, claimant.AlumniID)
+		}
+
+		currentTime := provideTime()
+		owner, err := retrieveUserByAlumniId(ctx, a.ID.Val())
+		if err != nil && apperror.CodeOf(err) != apperror.NotFoundCode {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to retrieve user with alumniId=%v", a.ID)
+		}
+		if err == nil {
+			log.Printf("Unlinking userId=%v from disputed alumniId=%v", owner.ID, a.ID)
+			owner.AlumniID = ""
+			owner.LastUpdatedTimestamp = currentTime
+			if err := replaceUser(ctx, owner); err != nil {
+				return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", owner.ID)
+			}
+		}
+
+		claimant.AlumniID = a.ID
+		claimant.LastUpdatedTimestamp = currentTime
+		if err := replaceUser(ctx, claimant); err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", claimant.ID)
+		}
+
+		c = reviewedClaim(c, internal.ApprovedClaimStatus, req.Reason, p, currentTime)
+		if err := replaceProfileClaim(ctx, c); err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to replace claimId=%v", c.ID)
+		}
+
+		// Only one claim on a record can win
+		others, err := retrieveProfileClaims(ctx, internal.PendingClaimStatus, "", a.ID.Val())
+		if err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to retrieve pending claims on alumniId=%v", a.ID)
+		}
+		for _, other := range others {
+			other = reviewedClaim(other, internal.RejectedClaimStatus, claimedByAnotherReason, p, currentTime)
+			if err := replaceProfileClaim(ctx, other); err != nil {
+				return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to replace claimId=%v", other.ID)
+			}
+		}
+
+		return mapping.ToDTOProfileClaim(c), nil
+	}
+}
+
+// RejectProfileClaim turns down a claim, leaving the alumni record as it was
+func RejectProfileClaim(retrieveProfileClaimById db.RetrieveProfileClaimByIDFunc,
+	replaceProfileClaim db.ReplaceProfileClaimFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) ReviewProfileClaimFunc {
+	return func(ctx context.Context, claimId string, req pkg.ReviewClaimRequest, p auth.Principal) (_ pkg.ProfileClaim, err error) {
+		log.Printf("Rejecting profile claimId=%v", claimId)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.RejectClaimAction, internal.ClaimTarget, claimId, &err)
+
+		c, _, err := pendingClaimForReview(ctx, claimId, req, p, retrieveProfileClaimById, retrieveAlumniById)
+		if err != nil {
+			return pkg.ProfileClaim{}, err
+		}
+
+		c = reviewedClaim(c, internal.RejectedClaimStatus, req.Reason, p, provideTime())
+		if err := replaceProfileClaim(ctx, c); err != nil {
+			return pkg.ProfileClaim{}, errors.Wrapf(err, "workflow - unable to replace claimId=%v", c.ID)
+		}
+
+		return mapping.ToDTOProfileClaim(c), nil
+	}
+}
+
+// pendingClaimForReview returns the claim and the record it is for, if it is still pending and within the scope of the caller
+func pendingClaimForReview(ctx context.Context, claimId string,
+	req pkg.ReviewClaimRequest,
+	p auth.Principal,
+	retrieveProfileClaimById db.RetrieveProfileClaimByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc) (internal.ProfileClaim, internal.Alumni, error) {
+	if !p.Can(auth.ApproveUsersPermission) {
+		return internal.ProfileClaim{}, internal.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have permission=%v", p.User.ID, auth.ApproveUsersPermission)
+	}
+	if len(strings.TrimSpace(req.Reason)) > internal.MaxDenialReasonLength {
+		return internal.ProfileClaim{}, internal.Alumni{}, apperror.New(apperror.ValidationCode, "workflow - reason must be at most %v characters", internal.MaxDenialReasonLength)
+	}
+
+	c, err := retrieveProfileClaimById(ctx, claimId)
+	if err != nil {
+		return internal.ProfileClaim{}, internal.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve claimId=%v", claimId)
+	}
+	if c.Status != internal.PendingClaimStatus {
+		return internal.ProfileClaim{}, internal.Alumni{}, apperror.New(apperror.ConflictCode, "workflow - claimId=%v has already been %v", claimId, strings.ToLower(c.Status))
+	}
+
+	a, err := retrieveAlumniById(ctx, c.AlumniID.Val())
+	if err != nil {
+		return internal.ProfileClaim{}, internal.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", c.AlumniID)
+	}
+	if !p.CanForAlumni(auth.ApproveUsersPermission, a) {
+		return internal.ProfileClaim{}, internal.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - alumniId=%v is outside the scope of userId=%v", a.ID, p.User.ID)
+	}
+
+	return c, a, nil
+}
+
+func reviewedClaim(c internal.ProfileClaim, status, reason string, p auth.Principal, at time.Epoch) internal.ProfileClaim {
+	c.Status = status
+	c.ReviewedBy = p.User.ID
+	c.ReviewReason = strings.TrimSpace(reason)
+	c.LastUpdatedTimestamp = at
+	return c
+}
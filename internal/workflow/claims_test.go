@@ -0,0 +1,73 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+func TestSearchClaimableAlumni(t *testing.T) {
+	ctx := context.Background()
+	const (
+		unclaimedId = "00000000-0000-4000-8000-00000000b001"
+		claimedId   = "00000000-0000-4000-8000-00000000b002"
+	)
+
+	store := db.NewMemoryStore()
+	aa := []internal.Alumni{
+		{ID: unclaimedId, Firstname: "Jane", Lastname: "Roe", HighSchool: internal.School{YearEnded: "1999"}},
+		{ID: claimedId, Firstname: "Jane", Lastname: "Doe", MarriedName: "Roe", HighSchool: internal.School{YearEnded: "1999"}},
+		{ID: "00000000-0000-4000-8000-00000000b003", Firstname: "Janet", Lastname: "Roe", HighSchool: internal.School{YearEnded: "1999"}},
+		{ID: "00000000-0000-4000-8000-00000000b004", Firstname: "Jane", Lastname: "Roe", HighSchool: internal.School{YearEnded: "2000"}},
+	}
+	for _, a := range aa {
+		if err := store.Alumni.Insert(ctx, a); err != nil {
+			t.Fatalf("unable to insert alumni: %v", err)
+		}
+	}
+	if err := store.Users.Insert(ctx, internal.User{ID: "00000000-0000-4000-8000-000000000100", AlumniID: claimedId}); err != nil {
+		t.Fatalf("unable to insert user: %v", err)
+	}
+
+	search := SearchClaimableAlumni(store.Alumni.RetrieveUnclaimed)
+	claimant := auth.Principal{User: internal.User{ID: "00000000-0000-4000-8000-000000000200", EmailVerified: true}}
+	params := pkg.QueryParams{Firstname: "jane", Lastname: "ROE", YearGraduated: "1999"}
+
+	t.Run("matches", func(t *testing.T) {
+		got, err := search(ctx, params, claimant)
+		if err != nil {
+			t.Fatalf("unable to search: %v", err)
+		}
+		// Only the exact, unlinked match is listed, and without revealing the name it is listed under
+		want := pkg.AlumniMatch{ID: unclaimedId, Name: "J*** R**"}
+		if len(got) != 1 || got[0] != want {
+			t.Errorf("got matches=%+v, want only %+v", got, want)
+		}
+	})
+
+	t.Run("unverified", func(t *testing.T) {
+		p := claimant
+		p.User.EmailVerified = false
+
+		_, err := search(ctx, params, p)
+		wantCode(t, err, apperror.ForbiddenCode)
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		_, err := search(ctx, pkg.QueryParams{Lastname: "roe"}, claimant)
+		wantCode(t, err, apperror.ValidationCode)
+	})
+
+	t.Run("already_linked", func(t *testing.T) {
+		p := claimant
+		p.User.AlumniID = claimedId
+
+		_, err := search(ctx, params, p)
+		wantCode(t, err, apperror.ConflictCode)
+	})
+}
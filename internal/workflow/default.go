@@ -1,23 +1,26 @@
 package workflow
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"strings"
+	gotime "time"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
 	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/jsonpatch"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/storage"
 	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
 	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
-	"github.com/aymerick/raymond"
 	"github.com/gocarina/gocsv"
-	"github.com/mazen160/go-random"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -25,156 +28,272 @@ import (
 // AddUser adds a new user
 func AddUser(insertUser db.InsertUserFunc,
 	retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	insertEmailVerification db.InsertEmailVerificationFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
 	provideTime time.EpochProviderFunc,
 	genUUID uuid.GenV4Func) AddUserFunc {
-	return func(req pkg.UserRequest) (pkg.User, string, error) {
+	return func(ctx context.Context, req pkg.UserRequest) (pkg.UserResponse, error) {
 		log.Printf("Adding new user with email=%v", req.Email)
 
-		u, err := retrieveUserByEmail(req.Email)
+		if req.Email == "" || req.Password == "" {
+			return pkg.UserResponse{}, apperror.New(apperror.ValidationCode, "workflow - email and password are required")
+		}
+
+		u, err := retrieveUserByEmail(ctx, req.Email)
 		if err == nil {
-			return pkg.User{}, "", errors.Errorf("workflow - user already exists with email=%v", u.Email)
+			return pkg.UserResponse{}, apperror.New(apperror.ConflictCode, "workflow - user already exists with email=%v", u.Email)
+		}
+		if apperror.CodeOf(err) != apperror.NotFoundCode {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to check for existing user with email=%v", req.Email)
 		}
 
 		pw, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to hash password, email=%v", req.Email)
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to hash password, email=%v", req.Email)
 		}
 
 		user := mapping.ToDbUser(req, pw, genUUID, provideTime)
-		if err := insertUser(user); err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to insert user into db, email=%v", req.Email)
+		if err := insertUser(ctx, user); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to insert user into db, email=%v", req.Email)
 		}
 
-		uToken, err := token.CreateUserToken(user, provideTime)
-		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
+		// The account already exists at this point, so a failed email is left for the user to resend
+		if err := sendVerificationEmail(ctx, user, insertEmailVerification, getEmailTemplate, sendEmail, provideTime); err != nil {
+			log.Printf("Unable to send verification email to userId=%v, %v", user.ID, err)
 		}
 
-		return mapping.ToDTOUser(user), uToken, nil
+		return startSession(ctx, user, insertRefreshToken, provideTime, genUUID)
 	}
 }
 
+// dummyPasswordHash is compared against when no user matches an email, so that both cases take as long
+var dummyPasswordHash = []byte("$2a$10$3SKsjkhl1p67OktyZDpoxuBbovXQ4ugyUQCy2Lk6YjOe0Gh/Wt9c6")
+
 // LoginUser logs in a new user
-func LoginUser(retrieveUserByEmail db.RetrieveUserByEmailFunc, provideTime time.EpochProviderFunc) LoginUserFunc {
-	return func(req pkg.UserRequest) (pkg.User, string, error) {
-		log.Printf("Logging in user with email=%v", req.Email)
+func LoginUser(retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	retrieveLoginAttempt db.RetrieveLoginAttemptFunc,
+	recordLoginFailure db.RecordLoginFailureFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) LoginUserFunc {
+	return func(ctx context.Context, req pkg.UserRequest, ip string) (pkg.UserResponse, error) {
+		log.Printf("Logging in user with email=%v from ip=%v", req.Email, ip)
 
-		user, err := retrieveUserByEmail(req.Email)
-		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to find user with email=%v", req.Email)
+		if req.Email == "" || req.Password == "" {
+			return pkg.UserResponse{}, apperror.New(apperror.ValidationCode, "workflow - email and password are required")
+		}
+
+		now := provideTime().ToISO8601().Val()
+		keys := loginKeys(req.Email, ip)
+		if err := checkLoginThrottle(ctx, keys, retrieveLoginAttempt, deleteLoginAttempts, now); err != nil {
+			return pkg.UserResponse{}, err
 		}
 
-		if err := bcrypt.CompareHashAndPassword(user.Password, []byte(req.Password)); err != nil {
-			return pkg.User{}, "", errors.Wrap(err, "workflow - password is invalid")
+		user, err := retrieveUserByEmail(ctx, req.Email)
+		if err != nil && apperror.CodeOf(err) != apperror.NotFoundCode {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to find user with email=%v", req.Email)
 		}
 
-		uToken, err := token.CreateUserToken(user, provideTime)
+		hash := user.Password
 		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
+			hash = dummyPasswordHash
+		}
+
+		if pwErr := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || pwErr != nil {
+			if err := countLoginFailure(ctx, keys, recordLoginFailure, now); err != nil {
+				return pkg.UserResponse{}, err
+			}
+			return pkg.UserResponse{}, apperror.New(apperror.UnauthorizedCode, "workflow - invalid credentials")
 		}
 
-		return mapping.ToDTOUser(user), uToken, nil
+		// Failed logins are only cleared once the second factor is entered too
+		if user.TOTPEnabled {
+			mfaToken, err := token.CreateMFAToken(user, provideTime)
+			if err != nil {
+				return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to generate MFA token for userId=%v", user.ID)
+			}
+			return pkg.UserResponse{User: mapping.ToDTOUser(user), MFARequired: true, MFAToken: mfaToken}, nil
+		}
+
+		if err := deleteLoginAttempts(ctx, emailLoginKey(req.Email)); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to delete login attempts for userId=%v", user.ID)
+		}
+
+		return startSession(ctx, user, insertRefreshToken, provideTime, genUUID)
 	}
 }
 
-// AutoLoginUser auto logs in a user
-func AutoLoginUser(retrieveUserById db.RetrieveUserByIDFunc, provideTime time.EpochProviderFunc) AutoLoginUserFunc {
-	return func(tokenString string) (pkg.User, string, error) {
-		log.Printf("Auto logging in user with token=%v", tokenString)
+// Authenticate resolves the user a token was issued to and checks they meet the requirement
+func Authenticate(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveRefreshToken db.RetrieveRefreshTokenByIDFunc,
+	provideTime time.EpochProviderFunc,
+	requireAdmin2FA bool) AuthenticateFunc {
+	return func(ctx context.Context, tokenString string, req auth.Requirement) (auth.Principal, error) {
+		claims, err := token.CheckUserToken(tokenString, provideTime)
+		if err != nil {
+			return auth.Principal{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - unable to decode token")
+		}
+		id := claims.UserID
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
+		session, err := retrieveRefreshToken(ctx, claims.SessionID.Val())
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return auth.Principal{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - sessionId=%v has been revoked", claims.SessionID)
+		}
 		if err != nil {
-			return pkg.User{}, "", errors.Wrap(err, "workflow - unable to decode token")
+			return auth.Principal{}, errors.Wrapf(err, "workflow - unable to retrieve sessionId=%v", claims.SessionID)
+		}
+		if session.UserID != id || !session.ExpiresAt.After(provideTime().ToISO8601().Val()) {
+			return auth.Principal{}, apperror.New(apperror.UnauthorizedCode, "workflow - sessionId=%v is invalid or expired", claims.SessionID)
 		}
 
-		user, err := retrieveUserById(id.Val())
+		user, err := retrieveUserById(ctx, id.Val())
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return auth.Principal{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - token was issued to a user that no longer exists")
+		}
 		if err != nil {
-			return pkg.User{}, "", errors.Wrap(err, "workflow - unable to find user with given token")
+			return auth.Principal{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", id)
 		}
 
-		uToken, err := token.CreateUserToken(user, provideTime)
+		p := auth.Principal{User: user, SessionID: session.ID}
+
+		// Admin privileges are withheld until the admin enables two factor authentication
+		if requireAdmin2FA && p.IsAdmin() && !user.TOTPEnabled {
+			if req == auth.Admin {
+				return auth.Principal{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v must enable two factor authentication to use admin privileges", user.ID)
+			}
+			p.RolesWithheld = true
+		}
+
+		switch {
+		case req == auth.Admin && !p.IsAdmin():
+			return auth.Principal{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v is not an admin", user.ID)
+		case req == auth.Approved && !p.IsApproved():
+			return auth.Principal{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v has not been approved", user.ID)
+		}
+
+		return p, nil
+	}
+}
+
+// AutoLoginUser auto logs in a user
+func AutoLoginUser(provideTime time.EpochProviderFunc) AutoLoginUserFunc {
+	return func(ctx context.Context, p auth.Principal) (pkg.UserResponse, error) {
+		log.Printf("Auto logging in userId=%v", p.User.ID)
+
+		user := p.User
+
+		uToken, err := token.CreateUserToken(user, p.SessionID, provideTime)
 		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
 		}
 
-		return mapping.ToDTOUser(user), uToken, nil
+		return pkg.UserResponse{User: mapping.ToDTOUser(user), Token: uToken}, nil
 	}
 }
 
 func ApproveUser(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
 	provideTime time.EpochProviderFunc,
-	replaceUser db.ReplaceUserFunc) ApproveUserFunc {
-	return func(userId string, tokenString string) (pkg.User, error) {
+	replaceUser db.ReplaceUserFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	genUUID uuid.GenV4Func,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) ApproveUserFunc {
+	return func(ctx context.Context, userId string, p auth.Principal) (_ pkg.User, err error) {
 		log.Printf("Approving user with userId=%v", userId)
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return pkg.User{}, errors.Wrap(err, "workflow - unable to decode token")
-		}
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.ApproveUsersAction, internal.UserTarget, userId, &err)
 
-		user, err := retrieveUserById(id.Val())
+		userToApprove, err := retrieveUserById(ctx, userId)
 		if err != nil {
-			return pkg.User{}, errors.Wrap(err, "workflow - unable to find user with given token")
+			return pkg.User{}, errors.Wrap(err, "workflow - unable to find user to approve")
 		}
 
-		if !user.Admin {
-			return pkg.User{}, errors.Errorf("workflow - userId=%v is not an admin", user.ID)
+		if err := authorizeForUser(ctx, p, auth.ApproveUsersPermission, userToApprove, retrieveAlumniById); err != nil {
+			return pkg.User{}, err
 		}
 
-		userToApprove, err := retrieveUserById(userId)
-		if err != nil {
-			return pkg.User{}, errors.Wrap(err, "workflow - unable to find user to approve")
+		if !userToApprove.EmailVerified {
+			return pkg.User{}, apperror.New(apperror.ValidationCode, "workflow - userId=%v has not verified their email address", userToApprove.ID)
 		}
 
+		alreadyApproved := userToApprove.Status == internal.ApprovedUserStatus
 		userToApprove.Status = internal.ApprovedUserStatus
+		userToApprove.DenialReason = ""
 		userToApprove.LastUpdatedTimestamp = provideTime()
 
-		if err := replaceUser(userToApprove); err != nil {
+		if err := replaceUser(ctx, userToApprove); err != nil {
 			return pkg.User{}, errors.Wrap(err, "workflow - unable to update user")
 		}
 
+		// The approval has already been saved, so failing to tell the user about it is only logged
+		if !alreadyApproved {
+			if err := sendUserStatusEmail(ctx, userToApprove, getEmailTemplate, sendEmail); err != nil {
+				log.Printf("Unable to send approval email to userId=%v: %v", userToApprove.ID, err)
+			}
+		}
+
 		return mapping.ToDTOUser(userToApprove), nil
 	}
 }
 
 func DenyUser(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
 	provideTime time.EpochProviderFunc,
-	replaceUser db.ReplaceUserFunc) DenyUserFunc {
-	return func(userId string, tokenString string) (pkg.User, error) {
+	replaceUser db.ReplaceUserFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	genUUID uuid.GenV4Func,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) DenyUserFunc {
+	return func(ctx context.Context, userId string, req pkg.DenyUserRequest, p auth.Principal) (_ pkg.User, err error) {
 		log.Printf("Denying user with userId=%v", userId)
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return pkg.User{}, errors.Wrap(err, "workflow - unable to decode token")
-		}
-
-		user, err := retrieveUserById(id.Val())
-		if err != nil {
-			return pkg.User{}, errors.Wrap(err, "workflow - unable to find user with given token")
-		}
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.DenyUsersAction, internal.UserTarget, userId, &err)
 
-		if !user.Admin {
-			return pkg.User{}, errors.Errorf("workflow - userId=%v is not an admin", user.ID)
+		reason := strings.TrimSpace(req.Reason)
+		if len(reason) > internal.MaxDenialReasonLength {
+			return pkg.User{}, apperror.New(apperror.ValidationCode, "workflow - denial reason must be at most %v characters", internal.MaxDenialReasonLength)
 		}
 
-		userToDeny, err := retrieveUserById(userId)
+		userToDeny, err := retrieveUserById(ctx, userId)
 		if err != nil {
 			return pkg.User{}, errors.Wrap(err, "workflow - unable to find user to deny")
 		}
 
+		if err := authorizeForUser(ctx, p, auth.ApproveUsersPermission, userToDeny, retrieveAlumniById); err != nil {
+			return pkg.User{}, err
+		}
+
+		alreadyDenied := userToDeny.Status == internal.DeniedUserStatus
 		userToDeny.Status = internal.DeniedUserStatus
+		userToDeny.DenialReason = reason
 		userToDeny.LastUpdatedTimestamp = provideTime()
 
-		if err := replaceUser(userToDeny); err != nil {
+		if err := replaceUser(ctx, userToDeny); err != nil {
 			return pkg.User{}, errors.Wrap(err, "workflow - unable to update user")
 		}
 
+		if err := deleteUserRefreshTokens(ctx, userToDeny.ID.Val()); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to revoke sessions for userId=%v", userToDeny.ID)
+		}
+
+		if !alreadyDenied {
+			if err := sendUserStatusEmail(ctx, userToDeny, getEmailTemplate, sendEmail); err != nil {
+				log.Printf("Unable to send denial email to userId=%v: %v", userToDeny.ID, err)
+			}
+		}
+
 		return mapping.ToDTOUser(userToDeny), nil
 	}
 }
 
-func AddAlumni(retrieveUserById db.RetrieveUserByIDFunc,
-	insertAlumni db.InsertAlumniFunc,
+func AddAlumni(insertAlumni db.InsertAlumniFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	replaceUser db.ReplaceUserFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	provideTime time.EpochProviderFunc,
@@ -182,92 +301,67 @@ func AddAlumni(retrieveUserById db.RetrieveUserByIDFunc,
 	uploadToS3 storage.UploadImageFunc,
 	presignURL storage.GetImageURLFunc,
 	sendEmail email.SendEmailFunc) AddAlumniFunc {
-	return func(req pkg.AlumniRequest, fileData pkg.FileData, tokenString string, skipFileUpload bool) (pkg.Alumni, error) {
+	return func(ctx context.Context, req pkg.AlumniRequest, fileData pkg.FileData, p auth.Principal, skipFileUpload bool) (_ pkg.Alumni, err error) {
 		log.Printf("Adding alumni with details=%+v", req)
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrap(err, "workflow - unable to decode token")
-		}
-
-		user, err := retrieveUserById(id.Val())
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", user.ID)
-		}
+		user := p.User
+		onBehalf := p.Can(auth.EditAlumniPermission)
 
 		// If user already has an AlumniID return an error
-		if user.AlumniID != "" && !user.Admin {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - userId=%v already has alumniId=%v", user.ID, user.AlumniID)
+		if user.AlumniID != "" && !onBehalf {
+			return pkg.Alumni{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has alumniId=%v", user.ID, user.AlumniID)
 		}
 
-		// Upload profile picture to S3
 		s3Filename := ""
 		if !skipFileUpload {
 			s3Filename = genUUID().Val()
-			if err := uploadToS3(fileData.Content, fileData.ContentType, s3Filename, fileData.Header.Filename); err != nil {
-				return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to upload image to S3, userId=%v", user.ID)
-			}
 		}
 
 		a := mapping.ToDBAlumni(req, s3Filename, provideTime, genUUID)
-		if err := insertAlumni(a); err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to insert alumni, userId=%v", user.ID)
+		if onBehalf {
+			defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.InsertAlumniAction, internal.AlumniTarget, a.ID.Val(), &err)
 		}
-
-		if user.Admin {
-			return mapping.ToDTOAlumni(a, presignURL, internal.User{}), nil
+		if onBehalf && !p.CanForAlumni(auth.EditAlumniPermission, a) {
+			return pkg.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v cannot create alumni outside of their scope", user.ID)
 		}
 
-		// Add the AlumniID to the User
-		user.AlumniID = a.ID
-		if err := replaceUser(user); err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
-		}
-
-		// Send email
-		et, err := getEmailTemplate(internal.NewAlumniTemplateName)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve email template")
-		}
-
-		bodyTpl, err := raymond.Parse(et.HTML)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to parse email body template")
+		// Upload profile picture to S3
+		if !skipFileUpload {
+			if err := uploadToS3(ctx, fileData.Content, fileData.ContentType, s3Filename, fileData.Header.Filename); err != nil {
+				return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to upload image to S3, userId=%v", user.ID)
+			}
 		}
 
-		subjectTpl, err := raymond.Parse(et.Subject)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to parse email subject template")
+		if err := insertAlumni(ctx, a); err != nil {
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to insert alumni, userId=%v", user.ID)
 		}
 
-		emailBody, err := bodyTpl.Exec(a)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to exec email body template")
+		if err := recordAlumniHistory(ctx, insertHistory, provideTime, genUUID, internal.Alumni{}, a, p, internal.InsertAlumniAction); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		emailSubject, err := subjectTpl.Exec(a)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to exec email subject template")
+		if onBehalf {
+			return mapping.ToDTOAlumni(ctx, a, presignURL, internal.User{}), nil
 		}
 
-		er := email.SendRequest{
-			Subject:     emailSubject,
-			HTMLContent: emailBody,
-			Recipient:   internal.EmailRecipient,
-			Sender:      internal.NoReplyEmailAddress,
+		// Add the AlumniID to the User
+		user.AlumniID = a.ID
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
 		}
 
-		if err := sendEmail(er); err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to send email")
+		if err := sendTemplateEmail(ctx, internal.NewAlumniTemplateName, internal.EmailRecipient, a, getEmailTemplate, sendEmail); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		return mapping.ToDTOAlumni(a, presignURL, internal.User{}), nil
+		return mapping.ToDTOAlumni(ctx, a, presignURL, internal.User{}), nil
 	}
 }
 
-func UpdateAlumni(retrieveUserById db.RetrieveUserByIDFunc,
-	updateAlumni db.UpdateAlumniFunc,
+func UpdateAlumni(updateAlumni db.UpdateAlumniFunc,
 	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	provideTime time.EpochProviderFunc,
 	genUUID uuid.GenV4Func,
@@ -275,248 +369,257 @@ func UpdateAlumni(retrieveUserById db.RetrieveUserByIDFunc,
 	presignURL storage.GetImageURLFunc,
 	sendEmail email.SendEmailFunc,
 ) UpdateAlumniFunc {
-	return func(req pkg.UpdateAlumniRequest, alumniId string, fileData pkg.FileData, tokenString string, skipFileUpload bool) (pkg.Alumni, error) {
+	return func(ctx context.Context, req pkg.UpdateAlumniRequest, alumniId string, version int64, fileData pkg.FileData, p auth.Principal, skipFileUpload bool) (_ pkg.Alumni, err error) {
 		log.Printf("Updating alumniId=%v", alumniId)
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrap(err, "workflow - unable to decode token")
+		// Only changes made to someone else's alumni are administrative
+		if !p.OwnsAlumni(alumniId) {
+			defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.UpdateAlumniAction, internal.AlumniTarget, alumniId, &err)
 		}
 
-		user, err := retrieveUserById(id.Val())
+		user := p.User
+
+		a, err := retrieveAlumniById(ctx, alumniId)
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", user.ID)
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - alumniId=%v does not exist", alumniId)
 		}
 
-		if user.AlumniID != uuid.V4(alumniId) && !user.Admin {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - userId=%v already has alumniId=%v", user.ID, user.AlumniID)
+		if !p.OwnsAlumni(alumniId) && !p.CanForAlumni(auth.EditAlumniPermission, a) {
+			return pkg.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to alumniId=%v", user.ID, alumniId)
 		}
 
-		a, err := retrieveAlumniById(alumniId)
+		version, err = versionToUpdate(a, version)
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - alumniId=%v does not exist", alumniId)
+			return pkg.Alumni{}, err
 		}
 
 		s3Filename := a.ProfilePictureKey
 		if !skipFileUpload {
 			s3Filename = genUUID().Val()
-			if err := uploadToS3(fileData.Content, fileData.ContentType, s3Filename, fileData.Header.Filename); err != nil {
+			if err := uploadToS3(ctx, fileData.Content, fileData.ContentType, s3Filename, fileData.Header.Filename); err != nil {
 				return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to upload image to S3, userId=%v", user.ID)
 			}
 		}
 
 		updates := mapping.ToAlumniUpdate(req, s3Filename, provideTime)
-		if err := updateAlumni(alumniId, updates); err != nil {
+		alum, err := updateAlumni(ctx, alumniId, version, updates)
+		if err != nil {
 			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to update alumniId=%v", alumniId)
 		}
 
-		alum, err := retrieveAlumniById(alumniId)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
+		if err := recordAlumniHistory(ctx, insertHistory, provideTime, genUUID, a, alum, p, internal.UpdateAlumniAction); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		// Send email
-		et, err := getEmailTemplate(internal.UpdatedAlumniTemplateName)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve email template")
+		if err := sendAlumniUpdatedEmail(ctx, a, updates, getEmailTemplate, sendEmail); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		bodyTpl, err := raymond.Parse(et.HTML)
+		return mapping.ToDTOAlumni(ctx, alum, presignURL, internal.User{}), nil
+	}
+}
+
+// PatchAlumni applies a JSON Patch to the array fields of an alumni, writing every change or none of them
+func PatchAlumni(updateAlumni db.UpdateAlumniFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	presignURL storage.GetImageURLFunc,
+	sendEmail email.SendEmailFunc,
+) PatchAlumniFunc {
+	return func(ctx context.Context, patch jsonpatch.Patch, alumniId string, version int64, p auth.Principal) (_ pkg.Alumni, err error) {
+		log.Printf("Patching alumniId=%v", alumniId)
+
+		if !p.OwnsAlumni(alumniId) {
+			defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.UpdateAlumniAction, internal.AlumniTarget, alumniId, &err)
+		}
+
+		a, err := retrieveAlumniById(ctx, alumniId)
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to parse email body template")
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - alumniId=%v does not exist", alumniId)
+		}
+
+		if !p.OwnsAlumni(alumniId) && !p.CanForAlumni(auth.EditAlumniPermission, a) {
+			return pkg.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to alumniId=%v", p.User.ID, alumniId)
 		}
 
-		subjectTpl, err := raymond.Parse(et.Subject)
+		version, err = versionToUpdate(a, version)
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to parse email subject template")
+			return pkg.Alumni{}, err
 		}
 
-		emailBody, err := bodyTpl.Exec(a)
+		doc, err := json.Marshal(mapping.ToDTOAlumniArrays(a))
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to exec email body template")
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to marshal alumniId=%v", alumniId)
 		}
 
-		bb, err := json.MarshalIndent(updates, "", "\t")
+		patched, err := patch.Apply(doc)
+		if errors.Cause(err) == jsonpatch.ErrTestFailed {
+			// The alumni is not what the client expected it to be, as when it sends an old version
+			return pkg.Alumni{}, apperror.Wrap(err, apperror.ConflictCode, "workflow - alumniId=%v does not pass the patch's test", alumniId)
+		}
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to marshal updates")
+			return pkg.Alumni{}, apperror.Wrap(err, apperror.ValidationCode, "workflow - unable to patch alumniId=%v", alumniId)
+		}
+
+		// Only the array fields are in the document, so a patch that adds any other field fails here
+		var arrays pkg.AlumniArrays
+		if err := jsonpatch.DecodeStrict(patched, &arrays); err != nil {
+			return pkg.Alumni{}, apperror.Wrap(err, apperror.ValidationCode, "workflow - unable to patch alumniId=%v", alumniId)
 		}
-		emailBody = emailBody + "\n\n" + string(bb)
 
-		emailSubject, err := subjectTpl.Exec(a)
+		updates := mapping.ToAlumniUpdate(mapping.ToAlumniArraysUpdate(arrays), a.ProfilePictureKey, provideTime)
+		alum, err := updateAlumni(ctx, alumniId, version, updates)
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to exec email subject template")
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to update alumniId=%v", alumniId)
 		}
 
-		er := email.SendRequest{
-			Subject:     emailSubject,
-			HTMLContent: emailBody,
-			Recipient:   internal.EmailRecipient,
-			Sender:      internal.NoReplyEmailAddress,
+		if err := recordAlumniHistory(ctx, insertHistory, provideTime, genUUID, a, alum, p, internal.UpdateAlumniAction); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		if err := sendEmail(er); err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to send email")
+		if err := sendAlumniUpdatedEmail(ctx, a, updates, getEmailTemplate, sendEmail); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		return mapping.ToDTOAlumni(alum, presignURL, internal.User{}), nil
+		return mapping.ToDTOAlumni(ctx, alum, presignURL, internal.User{}), nil
 	}
 }
 
+// versionToUpdate returns the version of the alumni an update applies to, failing if the caller made its changes to an
+// older version. A version of 0 means the caller did not say, and updates whichever version is current
+func versionToUpdate(a internal.Alumni, version int64) (int64, error) {
+	if version == 0 {
+		return a.Version, nil
+	}
+	if version != a.Version {
+		return 0, apperror.New(apperror.ConflictCode, "workflow - alumniId=%v is at version=%v, not version=%v", a.ID, a.Version, version)
+	}
+	return version, nil
+}
+
 func RetrieveAlumniByID(retrieveByID db.RetrieveAlumniByIDFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
 	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
-	provideTime time.EpochProviderFunc,
 	presignURL storage.GetImageURLFunc) RetrieveAlumniByIDFunc {
-	return func(alumniId string, tokenString string) (pkg.AlumniInterface, error) {
+	return func(ctx context.Context, alumniId string, p auth.Principal) (pkg.AlumniInterface, error) {
 		log.Printf("Retrieving alumni with id=%v", alumniId)
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrap(err, "workflow - unable to decode token")
-		}
-
-		user, err := retrieveUserById(id.Val())
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", user.ID)
-		}
-
-		a, err := retrieveByID(alumniId)
+		a, err := retrieveByID(ctx, alumniId)
 		if err != nil {
 			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
 		}
 
-		if user.AlumniID.Val() != alumniId && !user.Admin && !a.IsPublic || user.AlumniID.Val() != alumniId && !user.IsApproved() && !user.Admin {
-			return pkg.Alumni{}, errors.Errorf("workflow - userId=%v does not have access to alumniId=%v", user.ID, alumniId)
-		}
-
-		// If a user tried to access another user who is public
-		if user.AlumniID.Val() != alumniId && !user.Admin && a.IsPublic {
-			ca := mapping.ToCleanAlumni(a, presignURL, internal.User{})
-			return ca, nil
+		if !p.OwnsAlumni(alumniId) && !p.CanForAlumni(auth.ViewAlumniPermission, a) {
+			// If a user tried to access another user who is public
+			if a.IsPublic && p.IsApproved() {
+				ca := mapping.ToCleanAlumni(ctx, a, presignURL, internal.User{})
+				return ca, nil
+			}
+			return pkg.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to alumniId=%v", p.User.ID, alumniId)
 		}
 
-		aUser, err := retrieveUserByAlumniId(a.ID.Val())
-		if err != nil {
+		// Alumni created by an admin have no account until someone claims them
+		aUser, err := retrieveUserByAlumniId(ctx, a.ID.Val())
+		if err != nil && apperror.CodeOf(err) != apperror.NotFoundCode {
 			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve user with alumniId=%v", a.ID)
 		}
 
-		return mapping.ToDTOAlumni(a, presignURL, aUser), nil
+		return mapping.ToDTOAlumni(ctx, a, presignURL, aUser), nil
 	}
 }
 
 func ChangeAlumniPrivacy(retrieveByID db.RetrieveAlumniByIDFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
 	changePrivacyStatus db.ChangeAlumniPrivacyFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
 	presignURL storage.GetImageURLFunc,
 	isPublic bool) ChangeAlumniPrivacyFunc {
-	return func(alumniId, tokenString string) (pkg.Alumni, error) {
+	return func(ctx context.Context, alumniId string, p auth.Principal) (_ pkg.Alumni, err error) {
 		log.Printf("Updating privacy status of alumni with id=%v", alumniId)
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrap(err, "workflow - unable to decode token")
+		if !p.OwnsAlumni(alumniId) {
+			defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.ChangePrivacyAction, internal.AlumniTarget, alumniId, &err)
 		}
 
-		user, err := retrieveUserById(id.Val())
+		a, err := retrieveByID(ctx, alumniId)
 		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", user.ID)
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
 		}
 
-		if user.AlumniID.Val() != alumniId && !user.Admin {
-			return pkg.Alumni{}, errors.Errorf("workflow - userId=%v does not have access to alumniId=%v", user.ID, alumniId)
+		if !p.OwnsAlumni(alumniId) && !p.CanForAlumni(auth.EditAlumniPermission, a) {
+			return pkg.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to alumniId=%v", p.User.ID, alumniId)
 		}
 
-		if err := changePrivacyStatus(alumniId, isPublic); err != nil {
+		updated, err := changePrivacyStatus(ctx, alumniId, a.Version, isPublic)
+		if err != nil {
 			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to update alumniId=%v", alumniId)
 		}
 
-		a, err := retrieveByID(alumniId)
-		if err != nil {
-			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
+		if err := recordAlumniHistory(ctx, insertHistory, provideTime, genUUID, a, updated, p, internal.ChangePrivacyAction); err != nil {
+			return pkg.Alumni{}, err
 		}
 
-		return mapping.ToDTOAlumni(a, presignURL, internal.User{}), nil
+		return mapping.ToDTOAlumni(ctx, updated, presignURL, internal.User{}), nil
 	}
 }
 
-func RetrieveAlumni(retrieveAlumnis db.RetrieveAllAlumniFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
-	retrieveUsersAlumniIDs db.RetrieveUsersAlumniIDsFunc,
-	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
-	provideTime time.EpochProviderFunc,
+func RetrieveAlumni(retrieveAlumniAccounts db.RetrieveAlumniAccountsFunc,
 	presignURL storage.GetImageURLFunc) RetrieveAlumniFunc {
-	return func(params pkg.QueryParams, tokenString string) ([]pkg.CleanAlumni, pkg.PageInfo, error) {
+	return func(ctx context.Context, params pkg.QueryParams, p auth.Principal) ([]pkg.CleanAlumni, pkg.PageInfo, error) {
 		log.Printf("Retrieving all alumni")
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return []pkg.CleanAlumni{}, pkg.PageInfo{}, errors.Wrap(err, "workflow - unable to decode token")
-		}
+		user := p.User
 
-		user, err := retrieveUserById(id.Val())
-		if err != nil {
-			return []pkg.CleanAlumni{}, pkg.PageInfo{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", user.ID)
+		if !p.IsApproved() {
+			return []pkg.CleanAlumni{}, pkg.PageInfo{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to retrieve alumni until they are approved", user.ID)
 		}
 
-		if !user.Admin && !user.IsApproved() {
-			return []pkg.CleanAlumni{}, pkg.PageInfo{}, errors.Errorf("workflow - userId=%v does not have access to retrieve alumni until they are approved", user.ID)
-		}
+		scope := p.AlumniScope(auth.ViewAlumniPermission)
+		scope.Public = true
 
-		alumniIDs, err := retrieveUsersAlumniIDs(params.Status)
-		if err != nil {
-			return []pkg.CleanAlumni{}, pkg.PageInfo{}, errors.Wrapf(err, "workflow - unable to retrieve alumni ids")
-		}
-
-		aa, pi, err := retrieveAlumnis(params, user.AlumniID.Val(), user.Admin, alumniIDs...)
+		aa, pi, err := retrieveAlumniAccounts(ctx, params, user.AlumniID.Val(), scope)
 		if err != nil {
 			return []pkg.CleanAlumni{}, pkg.PageInfo{}, errors.Wrap(err, "workflow - unable to retrieve all alumnis")
 		}
 
 		cleanAlumni := []pkg.CleanAlumni{}
 		for _, a := range aa {
-			aUser, err := retrieveUserByAlumniId(a.ID.Val())
-			if err != nil {
-				return []pkg.CleanAlumni{}, pkg.PageInfo{}, errors.Wrapf(err, "workflow - unable to retrieve user with alumniId=%v", a.ID)
-			}
-
-			cleanAlumni = append(cleanAlumni, mapping.ToCleanAlumni(a, presignURL, aUser))
+			cleanAlumni = append(cleanAlumni, mapping.ToCleanAlumni(ctx, a.Alumni, presignURL, a.User))
 		}
 
 		return cleanAlumni, pi, nil
 	}
 }
 
-func ExportCSV(retrieveAlumnis db.RetrieveAllAlumniFunc,
-	retrieveUserById db.RetrieveUserByIDFunc,
-	retrieveUsersAlumniIDs db.RetrieveUsersAlumniIDsFunc,
+func ExportCSV(retrieveAlumniAccounts db.RetrieveAlumniAccountsFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
 	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
 	presignURL storage.GetImageURLFunc) ExportCSVFunc {
-	return func(params pkg.QueryParams, tokenString string) ([]byte, error) {
+	return func(ctx context.Context, params pkg.QueryParams, p auth.Principal) (_ []byte, err error) {
 		log.Printf("Exporting CSV of alumni")
 
-		id, _, err := token.CheckUserToken(tokenString, provideTime)
-		if err != nil {
-			return []byte{}, errors.Wrap(err, "workflow - unable to decode token")
-		}
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.ExportAlumniAction, internal.AlumniTarget, "", &err)
 
-		user, err := retrieveUserById(id.Val())
-		if err != nil {
-			return []byte{}, errors.Wrapf(err, "workflow - unable to find user with given token, userId=%v", user.ID)
-		}
+		user := p.User
 
-		if !user.Admin {
-			return []byte{}, errors.Errorf("workflow - user does not have access to export a CSV")
+		if !p.Can(auth.ExportCSVPermission) {
+			return []byte{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to export a CSV", user.ID)
 		}
 
-		alumniIDs, err := retrieveUsersAlumniIDs(params.Status)
+		accounts, _, err := retrieveAlumniAccounts(ctx, params, user.AlumniID.Val(), p.AlumniScope(auth.ExportCSVPermission))
 		if err != nil {
-			return []byte{}, errors.Wrapf(err, "workflow - unable to retrieve alumni ids")
+			return []byte{}, errors.Wrap(err, "workflow - unable to retrieve all alumnis")
 		}
 
-		aa, _, err := retrieveAlumnis(params, user.AlumniID.Val(), user.Admin, alumniIDs...)
-		if err != nil {
-			return []byte{}, errors.Wrap(err, "workflow - unable to retrieve all alumnis")
+		aa := []internal.Alumni{}
+		for _, a := range accounts {
+			aa = append(aa, a.Alumni)
 		}
 
 		bb, err := gocsv.MarshalBytes(aa)
@@ -532,116 +635,104 @@ func ForgotPassword(retrieveUserByEmail db.RetrieveUserByEmailFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	sendEmail email.SendEmailFunc,
 	insertResetPassword db.CreateResetPasswordFunc,
-	provideTime time.EpochProviderFunc) ForgotPasswordFunc {
-	return func(emailAddress string) error {
+	countResetPasswords db.CountResetPasswordsFunc,
+	provideTime time.EpochProviderFunc,
+	resetPasswordTTL gotime.Duration) ForgotPasswordFunc {
+	return func(ctx context.Context, emailAddress string) error {
 		log.Printf("Sending reset password email to %v", emailAddress)
 
-		user, err := retrieveUserByEmail(emailAddress)
+		user, err := retrieveUserByEmail(ctx, emailAddress)
 		if err != nil {
 			return errors.Wrapf(err, "workflow - unable to retrieve user with email=%v", emailAddress)
 		}
 
-		token, err := random.String(16)
-		if err != nil {
-			return errors.Wrap(err, "workflow - unable to generate token")
-		}
-
-		rp := internal.ResetPassword{
-			Email:            user.Email,
-			Token:            token,
-			CreatedTimestamp: provideTime().ToISO8601().Val(),
-		}
-
-		if err := insertResetPassword(rp); err != nil {
-			return errors.Wrapf(err, "workflow - unable to insert reset password")
-		}
-
-		// Send email
-		et, err := getEmailTemplate(internal.ForgotPasswordTemplateName)
+		now := provideTime().ToISO8601().Val()
+		sent, err := countResetPasswords(ctx, user.Email, now.Add(-resetPasswordEmailWindow))
 		if err != nil {
-			return errors.Wrapf(err, "workflow - unable to retrieve email template")
+			return errors.Wrapf(err, "workflow - unable to count reset passwords for email=%v", user.Email)
 		}
-
-		bodyTpl, err := raymond.Parse(et.HTML)
-		if err != nil {
-			return errors.Wrapf(err, "workflow - unable to parse email body template")
-		}
-
-		subjectTpl, err := raymond.Parse(et.Subject)
-		if err != nil {
-			return errors.Wrapf(err, "workflow - unable to parse email subject template")
-		}
-
-		emailBody, err := bodyTpl.Exec(rp)
-		if err != nil {
-			return errors.Wrapf(err, "workflow - unable to exec email body template")
+		if sent >= maxResetPasswordEmails {
+			return apperror.New(apperror.RateLimitedCode, "workflow - too many reset password emails sent to email=%v", user.Email)
 		}
 
-		emailSubject, err := subjectTpl.Exec(rp)
+		resetToken, err := token.NewOpaqueToken()
 		if err != nil {
-			return errors.Wrapf(err, "workflow - unable to exec email subject template")
+			return errors.Wrap(err, "workflow - unable to generate token")
 		}
 
-		er := email.SendRequest{
-			Subject:     emailSubject,
-			HTMLContent: emailBody,
-			Recipient:   user.Email,
-			Sender:      internal.NoReplyEmailAddress,
+		rp := internal.ResetPassword{
+			Email:            user.Email,
+			TokenHash:        token.HashOpaqueToken(resetToken),
+			CreatedTimestamp: now,
+			ExpiresAt:        now.Add(resetPasswordTTL),
 		}
 
-		if err := sendEmail(er); err != nil {
-			return errors.Wrapf(err, "workflow - unable to send email")
+		if err := insertResetPassword(ctx, rp); err != nil {
+			return errors.Wrapf(err, "workflow - unable to insert reset password")
 		}
 
-		return nil
+		// Only the hash is stored, so the template is given the token itself
+		data := pkg.ResetPassword{Email: user.Email, Token: resetToken}
+		return sendTemplateEmail(ctx, internal.ForgotPasswordTemplateName, user.Email, data, getEmailTemplate, sendEmail)
 	}
 }
 
-func SetNewPassword(retrieveResetPassword db.FindResetPasswordFunc,
+func SetNewPassword(consumeResetPassword db.ConsumeResetPasswordFunc,
 	deleteResetPasswords db.DeleteResetPasswordsFunc,
 	retrieveUserByEmail db.RetrieveUserByEmailFunc,
 	replaceUser db.ReplaceUserFunc,
-	provideTime time.EpochProviderFunc) SetNewPasswordFunc {
-	return func(rp pkg.ResetPassword) (pkg.User, string, error) {
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) SetNewPasswordFunc {
+	return func(ctx context.Context, rp pkg.ResetPassword) (pkg.UserResponse, error) {
 		log.Printf("Setting new password for user with email=%v", rp.Email)
 
-		internalRP, err := retrieveResetPassword(rp.Email, rp.Token)
+		if err := validatePassword(rp.Password, rp.Email); err != nil {
+			return pkg.UserResponse{}, err
+		}
+
+		internalRP, err := consumeResetPassword(ctx, rp.Email, token.HashOpaqueToken(rp.Token), provideTime().ToISO8601().Val())
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return pkg.UserResponse{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - reset password token is invalid, expired or has already been used")
+		}
 		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to retrieve reset password")
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to retrieve reset password")
 		}
 
-		user, err := retrieveUserByEmail(internalRP.Email)
+		user, err := retrieveUserByEmail(ctx, internalRP.Email)
 		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to retrieve user with email=%v", internalRP.Email)
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to retrieve user with email=%v", internalRP.Email)
 		}
 
 		// Use bcrypt to encrypt password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(rp.Password), bcrypt.DefaultCost)
 		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to generate hashed password")
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to generate hashed password")
 		}
 
 		user.Password = hashedPassword
+		user.LastUpdatedTimestamp = provideTime()
 
-		if err := replaceUser(user); err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to replace user")
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to replace user")
 		}
 
-		if err := deleteResetPasswords(internalRP.Email); err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to delete reset password")
+		if err := deleteResetPasswords(ctx, internalRP.Email); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to delete reset password")
 		}
 
-		uToken, err := token.CreateUserToken(user, provideTime)
-		if err != nil {
-			return pkg.User{}, "", errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
+		// Revoke every existing session, since any of them may belong to whoever caused the reset
+		if err := deleteUserRefreshTokens(ctx, user.ID.Val()); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to revoke sessions for userId=%v", user.ID)
 		}
 
-		return mapping.ToDTOUser(user), uToken, nil
+		return startSession(ctx, user, insertRefreshToken, provideTime, genUUID)
 	}
 }
 
 func HappyBirthday(retrieveAlumnis db.RetrieveAllAlumniFunc, provideTime time.EpochProviderFunc) HappyBirthdayFunc {
-	return func() (pkg.HappyBirthdayResponse, error) {
+	return func(ctx context.Context) (pkg.HappyBirthdayResponse, error) {
 		iso, err := time.New(provideTime().ToISO8601().Val().Local())
 		if err != nil {
 			return pkg.HappyBirthdayResponse{}, errors.Wrap(err, "workflow - unable to create iso time")
@@ -656,7 +747,7 @@ func HappyBirthday(retrieveAlumnis db.RetrieveAllAlumniFunc, provideTime time.Ep
 
 		qp := pkg.QueryParams{Limit: -1, Birthday: bday}
 
-		aa, _, err := retrieveAlumnis(qp, "", true)
+		aa, _, err := retrieveAlumnis(ctx, qp, "", internal.AlumniScope{All: true})
 		if err != nil {
 			return pkg.HappyBirthdayResponse{}, errors.Wrapf(err, "workflow - unable to retrieve alumnis")
 		}
@@ -681,12 +772,11 @@ func HappyBirthday(retrieveAlumnis db.RetrieveAllAlumniFunc, provideTime time.Ep
 			bday := fmt.Sprintf("%v-%v", m, d)
 			qp := pkg.QueryParams{Limit: -1, Birthday: bday}
 
-			aa, _, err := retrieveAlumnis(qp, "", true)
+			aa, _, err := retrieveAlumnis(ctx, qp, "", internal.AlumniScope{All: true})
 			if err != nil {
 				return pkg.HappyBirthdayResponse{}, errors.Wrapf(err, "workflow - unable to retrieve alumnis")
 			}
 			for _, a := range aa {
-				fmt.Println(a.ID)
 				upcoming = append(upcoming, mapping.ToHappyBirthdayAlumni(a))
 			}
 		}
@@ -705,7 +795,7 @@ func HappyBirthdayEmail(retrieveAlumnis db.RetrieveAllAlumniFunc,
 	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
 	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
 	sendEmail email.SendEmailFunc) HappyBirthdayEmailFunc {
-	return func() error {
+	return func(ctx context.Context) error {
 		ds := provideTime().ToISO8601().DateString()
 		m := strings.Split(ds, "-")[1]
 		d := strings.Split(ds, "-")[2]
@@ -715,51 +805,19 @@ func HappyBirthdayEmail(retrieveAlumnis db.RetrieveAllAlumniFunc,
 
 		log.Printf("Sending emails to Alumnis with Birthday=%v", bday)
 
-		aa, _, err := retrieveAlumnis(qp, "", true)
+		aa, _, err := retrieveAlumnis(ctx, qp, "", internal.AlumniScope{All: true})
 		if err != nil {
 			return errors.Wrapf(err, "workflow - unable to retrieve alumnis")
 		}
 
-		// Send email
-		et, err := getEmailTemplate(internal.HappyBirthdayTemplateName)
-		if err != nil {
-			return errors.Wrapf(err, "workflow - unable to retrieve email template")
-		}
 		for _, a := range aa {
-			user, err := retrieveUserByAlumniId(a.ID.Val())
+			user, err := retrieveUserByAlumniId(ctx, a.ID.Val())
 			if err != nil {
 				return errors.Wrapf(err, "workflow - unable to retrieve user with alumniId=%v", a.ID.Val())
 			}
 
-			bodyTpl, err := raymond.Parse(et.HTML)
-			if err != nil {
-				return errors.Wrapf(err, "workflow - unable to parse email body template")
-			}
-
-			subjectTpl, err := raymond.Parse(et.Subject)
-			if err != nil {
-				return errors.Wrapf(err, "workflow - unable to parse email subject template")
-			}
-
-			emailBody, err := bodyTpl.Exec(a)
-			if err != nil {
-				return errors.Wrapf(err, "workflow - unable to exec email body template")
-			}
-
-			emailSubject, err := subjectTpl.Exec(a)
-			if err != nil {
-				return errors.Wrapf(err, "workflow - unable to exec email subject template")
-			}
-
-			er := email.SendRequest{
-				Subject:     emailSubject,
-				HTMLContent: emailBody,
-				Recipient:   user.Email,
-				Sender:      internal.NoReplyEmailAddress,
-			}
-
-			if err := sendEmail(er); err != nil {
-				return errors.Wrapf(err, "workflow - unable to send email")
+			if err := sendTemplateEmail(ctx, internal.HappyBirthdayTemplateName, user.Email, a, getEmailTemplate, sendEmail); err != nil {
+				return err
 			}
 		}
 		return nil
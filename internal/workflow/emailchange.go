@@ -0,0 +1,196 @@
+package workflow
+
+import (
+	"context"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RequestEmailChange emails a link to confirm the change to the new address, and a link to cancel it to the old one
+func RequestEmailChange(retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	insertEmailChange db.InsertEmailChangeFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	provideTime time.EpochProviderFunc) RequestEmailChangeFunc {
+	return func(ctx context.Context, req pkg.ChangeEmailRequest, p auth.Principal) error {
+		user := p.User
+		log.Printf("Requesting email change for userId=%v", user.ID)
+
+		if err := bcrypt.CompareHashAndPassword(user.Password, []byte(req.Password)); err != nil {
+			return apperror.New(apperror.UnauthorizedCode, "workflow - password does not match for userId=%v", user.ID)
+		}
+
+		newEmail := strings.ToLower(strings.TrimSpace(req.Email))
+		if addr, err := mail.ParseAddress(newEmail); err != nil || addr.Address != newEmail {
+			return apperror.New(apperror.ValidationCode, "workflow - email=%v is not a valid email address", req.Email)
+		}
+		if newEmail == user.Email {
+			return apperror.New(apperror.ValidationCode, "workflow - email=%v is already the email of userId=%v", newEmail, user.ID)
+		}
+
+		_, err := retrieveUserByEmail(ctx, newEmail)
+		if err == nil {
+			return apperror.New(apperror.ConflictCode, "workflow - user already exists with email=%v", newEmail)
+		}
+		if apperror.CodeOf(err) != apperror.NotFoundCode {
+			return errors.Wrapf(err, "workflow - unable to check for existing user with email=%v", newEmail)
+		}
+
+		confirmToken, err := token.NewOpaqueToken()
+		if err != nil {
+			return errors.Wrapf(err, "workflow - unable to generate email change token for userId=%v", user.ID)
+		}
+		cancelToken, err := token.NewOpaqueToken()
+		if err != nil {
+			return errors.Wrapf(err, "workflow - unable to generate email change token for userId=%v", user.ID)
+		}
+
+		// Only the latest request can be confirmed
+		if err := deleteEmailChanges(ctx, user.ID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete email changes for userId=%v", user.ID)
+		}
+
+		currentTime := provideTime()
+		ec := internal.EmailChange{
+			UserID:           user.ID,
+			OldEmail:         user.Email,
+			NewEmail:         newEmail,
+			TokenHash:        token.HashOpaqueToken(confirmToken),
+			CancelTokenHash:  token.HashOpaqueToken(cancelToken),
+			SyncAlumni:       req.SyncAlumni,
+			ExpiresAt:        currentTime.ToISO8601().Val().Add(token.EmailChangeTTL),
+			CreatedTimestamp: currentTime,
+		}
+		if err := insertEmailChange(ctx, ec); err != nil {
+			return errors.Wrapf(err, "workflow - unable to insert email change for userId=%v", user.ID)
+		}
+
+		data := pkg.ChangeEmail{OldEmail: user.Email, NewEmail: newEmail, Token: confirmToken}
+		if err := sendTemplateEmail(ctx, internal.ChangeEmailTemplateName, newEmail, data, getEmailTemplate, sendEmail); err != nil {
+			return err
+		}
+
+		// The change can still be confirmed without the notice, so failing to send it is only logged
+		data.Token = cancelToken
+		if err := sendTemplateEmail(ctx, internal.EmailChangedTemplateName, user.Email, data, getEmailTemplate, sendEmail); err != nil {
+			log.Printf("Unable to send email change notice to userId=%v, %v", user.ID, err)
+		}
+
+		return nil
+	}
+}
+
+// ConfirmEmailChange switches the login email of a user to the address the confirmation token was sent to
+func ConfirmEmailChange(retrieveEmailChange db.RetrieveEmailChangeFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	updateUserEmail db.UpdateUserEmailFunc,
+	updateAlumniEmail db.UpdateAlumniEmailFunc,
+	deleteResetPasswords db.DeleteResetPasswordsFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	provideTime time.EpochProviderFunc) ConfirmEmailChangeFunc {
+	return func(ctx context.Context, req pkg.EmailChangeToken) (pkg.User, error) {
+		ec, err := retrieveEmailChangeByToken(ctx, req.Token, retrieveEmailChange, provideTime)
+		if err != nil {
+			return pkg.User{}, err
+		}
+		// The cancel token was only sent to the old address, so it must not be able to complete the change
+		if ec.TokenHash != token.HashOpaqueToken(req.Token) {
+			return pkg.User{}, apperror.New(apperror.UnauthorizedCode, "workflow - email change token is invalid or has already been used")
+		}
+
+		log.Printf("Confirming email change for userId=%v", ec.UserID)
+
+		currentTime := provideTime()
+		if err := updateUserEmail(ctx, ec.UserID.Val(), ec.OldEmail, ec.NewEmail, currentTime); err != nil {
+			if apperror.CodeOf(err) == apperror.NotFoundCode {
+				return pkg.User{}, apperror.Wrap(err, apperror.ConflictCode, "workflow - email of userId=%v has changed since the change was requested", ec.UserID)
+			}
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to change email of userId=%v", ec.UserID)
+		}
+
+		if err := deleteEmailChanges(ctx, ec.UserID.Val()); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to delete email changes for userId=%v", ec.UserID)
+		}
+
+		// Links sent to the old address must not work anymore
+		if err := deleteResetPasswords(ctx, ec.OldEmail); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to delete reset passwords for userId=%v", ec.UserID)
+		}
+		if err := deleteEmailVerifications(ctx, ec.UserID.Val()); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to delete email verifications for userId=%v", ec.UserID)
+		}
+
+		user, err := retrieveUserById(ctx, ec.UserID.Val())
+		if err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to find userId=%v", ec.UserID)
+		}
+
+		if ec.SyncAlumni && user.AlumniID != "" {
+			if err := updateAlumniEmail(ctx, user.AlumniID.Val(), ec.NewEmail); err != nil {
+				return pkg.User{}, errors.Wrapf(err, "workflow - unable to sync email of alumniId=%v", user.AlumniID)
+			}
+		}
+
+		return mapping.ToDTOUser(user), nil
+	}
+}
+
+// CancelEmailChange discards a pending email change, using the token sent to the old address
+func CancelEmailChange(retrieveEmailChange db.RetrieveEmailChangeFunc,
+	deleteEmailChanges db.DeleteEmailChangesFunc,
+	provideTime time.EpochProviderFunc) CancelEmailChangeFunc {
+	return func(ctx context.Context, req pkg.EmailChangeToken) error {
+		ec, err := retrieveEmailChangeByToken(ctx, req.Token, retrieveEmailChange, provideTime)
+		if err != nil {
+			return err
+		}
+		if ec.CancelTokenHash != token.HashOpaqueToken(req.Token) {
+			return apperror.New(apperror.UnauthorizedCode, "workflow - email change token is invalid or has already been used")
+		}
+
+		log.Printf("Cancelling email change for userId=%v", ec.UserID)
+
+		if err := deleteEmailChanges(ctx, ec.UserID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete email changes for userId=%v", ec.UserID)
+		}
+
+		return nil
+	}
+}
+
+func retrieveEmailChangeByToken(ctx context.Context, changeToken string,
+	retrieveEmailChange db.RetrieveEmailChangeFunc,
+	provideTime time.EpochProviderFunc) (internal.EmailChange, error) {
+	if changeToken == "" {
+		return internal.EmailChange{}, apperror.New(apperror.ValidationCode, "workflow - token is required")
+	}
+
+	ec, err := retrieveEmailChange(ctx, token.HashOpaqueToken(changeToken))
+	if apperror.CodeOf(err) == apperror.NotFoundCode {
+		return internal.EmailChange{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - email change token is invalid or has already been used")
+	}
+	if err != nil {
+		return internal.EmailChange{}, errors.Wrap(err, "workflow - unable to retrieve email change")
+	}
+
+	if ec.ExpiresAt.Before(provideTime().ToISO8601().Val()) {
+		return internal.EmailChange{}, apperror.New(apperror.UnauthorizedCode, "workflow - email change token for userId=%v has expired", ec.UserID)
+	}
+
+	return ec, nil
+}
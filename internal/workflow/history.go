@@ -0,0 +1,117 @@
+package workflow
+
+import (
+	"context"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/storage"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+func RetrieveAlumniHistory(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveHistory db.RetrieveAlumniHistoryFunc) RetrieveAlumniHistoryFunc {
+	return func(ctx context.Context, alumniId string, p auth.Principal) ([]pkg.AlumniHistory, error) {
+		log.Printf("Retrieving history of alumniId=%v", alumniId)
+
+		a, err := retrieveAlumniById(ctx, alumniId)
+		if err != nil {
+			return []pkg.AlumniHistory{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
+		}
+
+		if !p.CanForAlumni(auth.ViewAlumniPermission, a) {
+			return []pkg.AlumniHistory{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to the history of alumniId=%v", p.User.ID, alumniId)
+		}
+
+		hh, err := retrieveHistory(ctx, alumniId)
+		if err != nil {
+			return []pkg.AlumniHistory{}, errors.Wrapf(err, "workflow - unable to retrieve history of alumniId=%v", alumniId)
+		}
+
+		return mapping.ToDTOAlumniHistory(hh), nil
+	}
+}
+
+func RestoreAlumni(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveHistoryVersion db.RetrieveAlumniHistoryVersionFunc,
+	updateAlumni db.UpdateAlumniFunc,
+	insertHistory db.InsertAlumniHistoryFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	presignURL storage.GetImageURLFunc) RestoreAlumniFunc {
+	return func(ctx context.Context, alumniId string, toVersion int64, version int64, p auth.Principal) (_ pkg.Alumni, err error) {
+		log.Printf("Restoring alumniId=%v to version=%v", alumniId, toVersion)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.RestoreAlumniAction, internal.AlumniTarget, alumniId, &err)
+
+		a, err := retrieveAlumniById(ctx, alumniId)
+		if err != nil {
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
+		}
+
+		if !p.CanForAlumni(auth.EditAlumniPermission, a) {
+			return pkg.Alumni{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to restore alumniId=%v", p.User.ID, alumniId)
+		}
+
+		version, err = versionToUpdate(a, version)
+		if err != nil {
+			return pkg.Alumni{}, err
+		}
+
+		h, err := retrieveHistoryVersion(ctx, alumniId, toVersion)
+		if err != nil {
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to retrieve version=%v of alumniId=%v", toVersion, alumniId)
+		}
+
+		alum, err := updateAlumni(ctx, alumniId, version, mapping.ToAlumniRestore(h.Alumni, provideTime))
+		if err != nil {
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to restore alumniId=%v", alumniId)
+		}
+
+		restore := newAlumniHistory(provideTime, genUUID, a, alum, p, internal.RestoreAlumniAction)
+		restore.RestoredVersion = toVersion
+		if err := insertHistory(ctx, restore); err != nil {
+			return pkg.Alumni{}, errors.Wrapf(err, "workflow - unable to record history of alumniId=%v", alumniId)
+		}
+
+		return mapping.ToDTOAlumni(ctx, alum, presignURL, internal.User{}), nil
+	}
+}
+
+// recordAlumniHistory records the changes made by an action that moved an alumni from one version to the next
+func recordAlumniHistory(ctx context.Context, insertHistory db.InsertAlumniHistoryFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	before, after internal.Alumni,
+	p auth.Principal,
+	action string) error {
+	if err := insertHistory(ctx, newAlumniHistory(provideTime, genUUID, before, after, p, action)); err != nil {
+		return errors.Wrapf(err, "workflow - unable to record history of alumniId=%v", after.ID)
+	}
+	return nil
+}
+
+func newAlumniHistory(provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	before, after internal.Alumni,
+	p auth.Principal,
+	action string) internal.AlumniHistory {
+	return internal.AlumniHistory{
+		ID:               genUUID(),
+		AlumniID:         after.ID,
+		Version:          after.Version,
+		ActorID:          p.User.ID,
+		Action:           action,
+		Changes:          mapping.ToAlumniChanges(before, after),
+		Alumni:           after,
+		CreatedTimestamp: provideTime(),
+	}
+}
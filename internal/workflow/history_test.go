@@ -0,0 +1,123 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+)
+
+func TestRestoreAlumni(t *testing.T) {
+	ctx := context.Background()
+	const alumniId = "00000000-0000-4000-8000-00000000b000"
+
+	// newAlumni stores a public alumni at version 2, having been renamed from Jane to Janet
+	newAlumni := func(t *testing.T) (db.Store, RestoreAlumniFunc) {
+		store := db.NewMemoryStore()
+		a := internal.Alumni{ID: alumniId, Firstname: "Jane", Lastname: "Roe", IsPublic: true, Version: 1}
+		if err := store.Alumni.Insert(ctx, a); err != nil {
+			t.Fatalf("unable to insert alumni: %v", err)
+		}
+		if err := store.AlumniHistory.Insert(ctx, internal.AlumniHistory{AlumniID: a.ID, Version: 1, Action: internal.InsertAlumniAction, Alumni: a}); err != nil {
+			t.Fatalf("unable to insert history: %v", err)
+		}
+		firstname := "Janet"
+		renamed, err := store.Alumni.Update(ctx, alumniId, 1, internal.UpdateAlumniRequest{Firstname: &firstname})
+		if err != nil {
+			t.Fatalf("unable to update alumni: %v", err)
+		}
+		if err := store.AlumniHistory.Insert(ctx, internal.AlumniHistory{AlumniID: a.ID, Version: 2, Action: internal.UpdateAlumniAction, Alumni: renamed}); err != nil {
+			t.Fatalf("unable to insert history: %v", err)
+		}
+
+		restore := RestoreAlumni(store.Alumni.RetrieveByID, store.AlumniHistory.RetrieveVersion, store.Alumni.Update, store.AlumniHistory.Insert, store.AuditEvents.Insert, clockAt(testNow), sequentialUUIDs(), noPresign)
+		return store, restore
+	}
+
+	t.Run("restores", func(t *testing.T) {
+		store, restore := newAlumni(t)
+
+		got, err := restore(ctx, alumniId, 1, 2, testAdmin)
+		if err != nil {
+			t.Fatalf("unable to restore: %v", err)
+		}
+		if got.Firstname != "Jane" || got.Version != 3 {
+			t.Errorf("got firstname=%v at version=%v, want Jane at version=3", got.Firstname, got.Version)
+		}
+
+		// Restoring adds a version rather than rewriting history
+		hh, err := store.AlumniHistory.Retrieve(ctx, alumniId)
+		if err != nil {
+			t.Fatalf("unable to retrieve history: %v", err)
+		}
+		if len(hh) != 3 {
+			t.Fatalf("got %v history entries, want 3", len(hh))
+		}
+		var h internal.AlumniHistory
+		for _, candidate := range hh {
+			if candidate.Version == 3 {
+				h = candidate
+			}
+		}
+		if h.Action != internal.RestoreAlumniAction || h.RestoredVersion != 1 || h.ActorID != testAdmin.User.ID {
+			t.Errorf("got history action=%v, restoredVersion=%v, actorId=%v, want %v of version 1 by %v", h.Action, h.RestoredVersion, h.ActorID, internal.RestoreAlumniAction, testAdmin.User.ID)
+		}
+		if len(h.Changes) != 1 || h.Changes[0].Field != "firstname" {
+			t.Errorf("got changes=%+v, want only firstname", h.Changes)
+		}
+
+		ee := auditEvents(t, store)
+		if len(ee) != 1 || ee[0].Action != internal.RestoreAlumniAction || ee[0].Outcome != internal.SuccessOutcome {
+			t.Errorf("got audit events=%+v, want one successful %v", ee, internal.RestoreAlumniAction)
+		}
+	})
+
+	t.Run("keeps_privacy", func(t *testing.T) {
+		store, restore := newAlumni(t)
+		if _, err := store.Alumni.ChangePrivacy(ctx, alumniId, 2, false); err != nil {
+			t.Fatalf("unable to change privacy: %v", err)
+		}
+
+		// Privacy is only changed through its own endpoint, so restoring a public version leaves the alumni private
+		got, err := restore(ctx, alumniId, 1, 3, testAdmin)
+		if err != nil {
+			t.Fatalf("unable to restore: %v", err)
+		}
+		if got.Firstname != "Jane" || got.IsPublic {
+			t.Errorf("got firstname=%v, isPublic=%v, want Jane left private", got.Firstname, got.IsPublic)
+		}
+	})
+
+	t.Run("stale_version", func(t *testing.T) {
+		store, restore := newAlumni(t)
+
+		_, err := restore(ctx, alumniId, 1, 1, testAdmin)
+		wantCode(t, err, apperror.ConflictCode)
+		if a, _ := store.Alumni.RetrieveByID(ctx, alumniId); a.Firstname != "Janet" || a.Version != 2 {
+			t.Errorf("got firstname=%v at version=%v, want it left as Janet at version=2", a.Firstname, a.Version)
+		}
+	})
+
+	t.Run("unknown_version", func(t *testing.T) {
+		_, restore := newAlumni(t)
+
+		_, err := restore(ctx, alumniId, 7, 0, testAdmin)
+		wantCode(t, err, apperror.NotFoundCode)
+	})
+
+	t.Run("not_an_editor", func(t *testing.T) {
+		store, restore := newAlumni(t)
+		p := auth.Principal{User: internal.User{ID: "00000000-0000-4000-8000-000000000100"}}
+
+		_, err := restore(ctx, alumniId, 1, 0, p)
+		wantCode(t, err, apperror.ForbiddenCode)
+
+		ee := auditEvents(t, store)
+		if len(ee) != 1 || ee[0].Outcome != internal.DeniedOutcome || ee[0].ActorID != p.User.ID {
+			t.Errorf("got audit events=%+v, want one %v by %v", ee, internal.DeniedOutcome, p.User.ID)
+		}
+	})
+}
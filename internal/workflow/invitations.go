@@ -0,0 +1,163 @@
+package workflow
+
+import (
+	"context"
+	"log"
+	"net/mail"
+	"strings"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// InviteAlumni emails an alumnus a link to create an approved account for an alumni record an admin created for them
+func InviteAlumni(retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	insertInvitation db.InsertInvitationFunc,
+	deleteInvitations db.DeleteInvitationsFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) InviteAlumniFunc {
+	return func(ctx context.Context, alumniId string, req pkg.InvitationRequest, p auth.Principal) (err error) {
+		log.Printf("Inviting alumniId=%v to claim their alumni record", alumniId)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.InviteAlumniAction, internal.AlumniTarget, alumniId, &err)
+
+		if !p.Can(auth.ApproveUsersPermission) {
+			return apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have permission=%v", p.User.ID, auth.ApproveUsersPermission)
+		}
+
+		a, err := retrieveAlumniById(ctx, alumniId)
+		if err != nil {
+			return errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", alumniId)
+		}
+
+		if !p.CanForAlumni(auth.ApproveUsersPermission, a) {
+			return apperror.New(apperror.ForbiddenCode, "workflow - alumniId=%v is outside the scope of userId=%v", alumniId, p.User.ID)
+		}
+
+		_, err = retrieveUserByAlumniId(ctx, alumniId)
+		if err == nil {
+			return apperror.New(apperror.ConflictCode, "workflow - alumniId=%v has already been claimed", alumniId)
+		}
+		if apperror.CodeOf(err) != apperror.NotFoundCode {
+			return errors.Wrapf(err, "workflow - unable to check for a user with alumniId=%v", alumniId)
+		}
+
+		// The record may already hold the address to invite
+		inviteEmail := req.Email
+		if inviteEmail == "" {
+			inviteEmail = a.EmailAddress
+		}
+		inviteEmail = strings.ToLower(strings.TrimSpace(inviteEmail))
+		if addr, err := mail.ParseAddress(inviteEmail); err != nil || addr.Address != inviteEmail {
+			return apperror.New(apperror.ValidationCode, "workflow - email=%v is not a valid email address", inviteEmail)
+		}
+
+		_, err = retrieveUserByEmail(ctx, inviteEmail)
+		if err == nil {
+			return apperror.New(apperror.ConflictCode, "workflow - user already exists with email=%v", inviteEmail)
+		}
+		if apperror.CodeOf(err) != apperror.NotFoundCode {
+			return errors.Wrapf(err, "workflow - unable to check for existing user with email=%v", inviteEmail)
+		}
+
+		inviteToken, err := token.NewOpaqueToken()
+		if err != nil {
+			return errors.Wrapf(err, "workflow - unable to generate invitation token for alumniId=%v", alumniId)
+		}
+
+		// Only the latest invitation for a record can be accepted
+		if err := deleteInvitations(ctx, alumniId); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete invitations for alumniId=%v", alumniId)
+		}
+
+		currentTime := provideTime()
+		i := internal.Invitation{
+			AlumniID:         a.ID,
+			Email:            inviteEmail,
+			TokenHash:        token.HashOpaqueToken(inviteToken),
+			InvitedBy:        p.User.ID,
+			ExpiresAt:        currentTime.ToISO8601().Val().Add(token.InvitationTTL),
+			CreatedTimestamp: currentTime,
+		}
+		if err := insertInvitation(ctx, i); err != nil {
+			return errors.Wrapf(err, "workflow - unable to insert invitation for alumniId=%v", alumniId)
+		}
+
+		data := pkg.Invitation{Email: inviteEmail, Firstname: a.Firstname, Lastname: a.Lastname, Token: inviteToken}
+		return sendTemplateEmail(ctx, internal.InvitationTemplateName, inviteEmail, data, getEmailTemplate, sendEmail)
+	}
+}
+
+// AcceptInvitation creates an approved account linked to the alumni record the invitation was sent for
+func AcceptInvitation(consumeInvitation db.ConsumeInvitationFunc,
+	retrieveUserByEmail db.RetrieveUserByEmailFunc,
+	retrieveUserByAlumniId db.RetrieveUserByAlumniIDFunc,
+	insertUser db.InsertUserFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) AcceptInvitationFunc {
+	return func(ctx context.Context, req pkg.AcceptInvitationRequest) (pkg.UserResponse, error) {
+		log.Printf("Accepting invitation for email=%v", req.Email)
+
+		if err := validatePassword(req.Password, req.Email); err != nil {
+			return pkg.UserResponse{}, err
+		}
+
+		i, err := consumeInvitation(ctx, req.Email, token.HashOpaqueToken(req.Token), provideTime().ToISO8601().Val())
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return pkg.UserResponse{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - invitation token is invalid, expired or has already been used")
+		}
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrap(err, "workflow - unable to retrieve invitation")
+		}
+
+		// Either may have been taken by someone signing up since the invitation was sent
+		_, err = retrieveUserByAlumniId(ctx, i.AlumniID.Val())
+		if err == nil {
+			return pkg.UserResponse{}, apperror.New(apperror.ConflictCode, "workflow - alumniId=%v has already been claimed", i.AlumniID)
+		}
+		if apperror.CodeOf(err) != apperror.NotFoundCode {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to check for a user with alumniId=%v", i.AlumniID)
+		}
+
+		_, err = retrieveUserByEmail(ctx, i.Email)
+		if err == nil {
+			return pkg.UserResponse{}, apperror.New(apperror.ConflictCode, "workflow - user already exists with email=%v", i.Email)
+		}
+		if apperror.CodeOf(err) != apperror.NotFoundCode {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to check for existing user with email=%v", i.Email)
+		}
+
+		pw, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to hash password, email=%v", i.Email)
+		}
+
+		// The invitation proves both who they are and that they own the email address, so they skip the approval queue
+		user := mapping.ToDbUser(pkg.UserRequest{Email: i.Email}, pw, genUUID, provideTime)
+		user.AlumniID = i.AlumniID
+		user.Status = internal.ApprovedUserStatus
+		user.EmailVerified = true
+		user.InvitedBy = i.InvitedBy
+		if err := insertUser(ctx, user); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to insert user into db, email=%v", i.Email)
+		}
+
+		return startSession(ctx, user, insertRefreshToken, provideTime, genUUID)
+	}
+}
@@ -0,0 +1,151 @@
+package workflow
+
+import (
+	"context"
+	"log"
+	"strings"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+const (
+	emailLoginKeyPrefix = "email:"
+	ipLoginKeyPrefix    = "ip:"
+
+	// loginAttemptWindow is how long failed logins are remembered after the last failure
+	loginAttemptWindow = gotime.Hour
+	// loginBaseDelay is the wait imposed once throttling starts, doubling with each further failure
+	loginBaseDelay = gotime.Second
+	// loginLockoutDuration is how long logins are refused once the lockout threshold is reached
+	loginLockoutDuration = gotime.Minute * 15
+)
+
+// loginPolicy decides how long to wait before accepting another login for a key after repeated failures
+type loginPolicy struct {
+	delayAfter int
+	lockAfter  int
+}
+
+var (
+	// IP addresses can be shared by many alumni behind the same network, so they get more leeway than an email
+	emailLoginPolicy = loginPolicy{delayAfter: 3, lockAfter: 10}
+	ipLoginPolicy    = loginPolicy{delayAfter: 20, lockAfter: 100}
+)
+
+// retryAt returns the earliest time another login will be accepted
+func (lp loginPolicy) retryAt(la internal.LoginAttempt) gotime.Time {
+	switch {
+	case la.Failures >= lp.lockAfter:
+		return la.LastFailureAt.Add(loginLockoutDuration)
+	case la.Failures >= lp.delayAfter:
+		return la.LastFailureAt.Add(loginDelay(la.Failures - lp.delayAfter))
+	}
+	return la.LastFailureAt
+}
+
+// loginDelay returns loginBaseDelay doubled n times, never more than loginLockoutDuration so a long run of failures
+// cannot overflow the shift into a negative or zero wait
+func loginDelay(n int) gotime.Duration {
+	d := loginBaseDelay
+	for i := 0; i < n && d < loginLockoutDuration; i++ {
+		d *= 2
+	}
+	if d > loginLockoutDuration {
+		return loginLockoutDuration
+	}
+	return d
+}
+
+func emailLoginKey(email string) string {
+	return emailLoginKeyPrefix + strings.ToLower(email)
+}
+
+// loginKeys returns the keys failed logins are tracked under with the policy that applies to each
+func loginKeys(email, ip string) map[string]loginPolicy {
+	keys := map[string]loginPolicy{emailLoginKey(email): emailLoginPolicy}
+	if ip != "" {
+		keys[ipLoginKeyPrefix+ip] = ipLoginPolicy
+	}
+	return keys
+}
+
+// checkLoginThrottle returns an error if any of the keys have failed too recently to accept another login
+func checkLoginThrottle(ctx context.Context, keys map[string]loginPolicy,
+	retrieveLoginAttempt db.RetrieveLoginAttemptFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	now gotime.Time) error {
+	for key, lp := range keys {
+		la, err := retrieveLoginAttempt(ctx, key)
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "workflow - unable to retrieve login attempts for key=%v", key)
+		}
+
+		// Failures outside of the window are forgotten, so counting starts over
+		if !la.ExpiresAt.After(now) {
+			if err := deleteLoginAttempts(ctx, key); err != nil {
+				return errors.Wrapf(err, "workflow - unable to delete expired login attempts for key=%v", key)
+			}
+			continue
+		}
+
+		if retryAt := lp.retryAt(la); retryAt.After(now) {
+			return apperror.New(apperror.RateLimitedCode, "workflow - too many failed login attempts, try again after %v", retryAt.Format(gotime.RFC3339))
+		}
+	}
+	return nil
+}
+
+// countLoginFailure counts a failed login against each of the keys
+func countLoginFailure(ctx context.Context, keys map[string]loginPolicy, recordLoginFailure db.RecordLoginFailureFunc, now gotime.Time) error {
+	for key := range keys {
+		la, err := recordLoginFailure(ctx, key, now, now.Add(loginAttemptWindow))
+		if err != nil {
+			return errors.Wrapf(err, "workflow - unable to record login failure for key=%v", key)
+		}
+		if la.Failures == keys[key].lockAfter {
+			log.Printf("Locking out logins for key=%v after %v failures", key, la.Failures)
+		}
+	}
+	return nil
+}
+
+// UnlockUser clears the failed logins recorded against a user's email address
+func UnlockUser(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) UnlockUserFunc {
+	return func(ctx context.Context, userId string, p auth.Principal) (_ pkg.User, err error) {
+		log.Printf("Unlocking user with userId=%v", userId)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.UnlockUserAction, internal.UserTarget, userId, &err)
+
+		user, err := retrieveUserById(ctx, userId)
+		if err != nil {
+			return pkg.User{}, errors.Wrap(err, "workflow - unable to find user to unlock")
+		}
+
+		if err := authorizeForUser(ctx, p, auth.ApproveUsersPermission, user, retrieveAlumniById); err != nil {
+			return pkg.User{}, err
+		}
+
+		if err := deleteLoginAttempts(ctx, emailLoginKey(user.Email)); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to delete login attempts for userId=%v", userId)
+		}
+
+		return mapping.ToDTOUser(user), nil
+	}
+}
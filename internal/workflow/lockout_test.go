@@ -0,0 +1,55 @@
+package workflow
+
+import (
+	"testing"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+)
+
+func TestLoginPolicyRetryAt(t *testing.T) {
+	last := gotime.Date(2021, 1, 1, 9, 0, 0, 0, gotime.UTC)
+
+	for name, lp := range map[string]loginPolicy{"email": emailLoginPolicy, "ip": ipLoginPolicy} {
+		t.Run(name, func(t *testing.T) {
+			if got := lp.retryAt(internal.LoginAttempt{Failures: lp.delayAfter - 1, LastFailureAt: last}); !got.Equal(last) {
+				t.Errorf("got retry after %v below delayAfter, want no delay", got.Sub(last))
+			}
+
+			prev := gotime.Duration(0)
+			for failures := lp.delayAfter; failures < lp.lockAfter; failures++ {
+				delay := lp.retryAt(internal.LoginAttempt{Failures: failures, LastFailureAt: last}).Sub(last)
+				if delay < loginBaseDelay || delay > loginLockoutDuration {
+					t.Fatalf("got delay %v after %v failures, want between %v and %v", delay, failures, loginBaseDelay, loginLockoutDuration)
+				}
+				if delay < prev {
+					t.Fatalf("got delay %v after %v failures, shorter than %v after one fewer", delay, failures, prev)
+				}
+				prev = delay
+			}
+
+			if got := lp.retryAt(internal.LoginAttempt{Failures: lp.lockAfter, LastFailureAt: last}).Sub(last); got != loginLockoutDuration {
+				t.Errorf("got delay %v at lockAfter, want %v", got, loginLockoutDuration)
+			}
+		})
+	}
+}
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		n    int
+		want gotime.Duration
+	}{
+		{n: 0, want: gotime.Second},
+		{n: 1, want: 2 * gotime.Second},
+		{n: 9, want: 512 * gotime.Second},
+		{n: 10, want: loginLockoutDuration},
+		{n: 54, want: loginLockoutDuration},
+		{n: 79, want: loginLockoutDuration},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.n); got != tt.want {
+			t.Errorf("loginDelay(%v) = %v, want %v", tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/aymerick/raymond"
+	"github.com/pkg/errors"
+)
+
+// sendTemplateEmail renders the named email template with data and sends it to the recipient
+func sendTemplateEmail(ctx context.Context, templateName, recipient string,
+	data interface{},
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) error {
+	er, err := renderTemplateEmail(ctx, templateName, recipient, data, getEmailTemplate)
+	if err != nil {
+		return err
+	}
+
+	if err := sendEmail(ctx, er); err != nil {
+		return errors.Wrapf(err, "workflow - unable to send email")
+	}
+
+	return nil
+}
+
+// renderTemplateEmail renders the named email template with data into an email to the recipient
+func renderTemplateEmail(ctx context.Context, templateName, recipient string,
+	data interface{},
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc) (email.SendRequest, error) {
+	et, err := getEmailTemplate(ctx, templateName)
+	if err != nil {
+		return email.SendRequest{}, errors.Wrapf(err, "workflow - unable to retrieve email template=%v", templateName)
+	}
+
+	bodyTpl, err := raymond.Parse(et.HTML)
+	if err != nil {
+		return email.SendRequest{}, errors.Wrapf(err, "workflow - unable to parse email body template")
+	}
+
+	subjectTpl, err := raymond.Parse(et.Subject)
+	if err != nil {
+		return email.SendRequest{}, errors.Wrapf(err, "workflow - unable to parse email subject template")
+	}
+
+	emailBody, err := bodyTpl.Exec(data)
+	if err != nil {
+		return email.SendRequest{}, errors.Wrapf(err, "workflow - unable to exec email body template")
+	}
+
+	emailSubject, err := subjectTpl.Exec(data)
+	if err != nil {
+		return email.SendRequest{}, errors.Wrapf(err, "workflow - unable to exec email subject template")
+	}
+
+	return email.SendRequest{
+		Subject:     emailSubject,
+		HTMLContent: emailBody,
+		Recipient:   recipient,
+		Sender:      internal.NoReplyEmailAddress,
+	}, nil
+}
+
+// sendUserStatusEmail lets a user know their account has been approved or denied
+func sendUserStatusEmail(ctx context.Context, user internal.User,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) error {
+	templateName := internal.ApprovedUserTemplateName
+	if user.Status == internal.DeniedUserStatus {
+		templateName = internal.DeniedUserTemplateName
+	}
+
+	data := pkg.UserStatusEmail{Email: user.Email, Reason: user.DenialReason}
+	return sendTemplateEmail(ctx, templateName, user.Email, data, getEmailTemplate, sendEmail)
+}
+
+// sendAlumniUpdatedEmail lets the alumni office know an alumni was updated, listing the changes below the template
+func sendAlumniUpdatedEmail(ctx context.Context, a internal.Alumni,
+	updates internal.UpdateAlumniRequest,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) error {
+	er, err := renderTemplateEmail(ctx, internal.UpdatedAlumniTemplateName, internal.EmailRecipient, a, getEmailTemplate)
+	if err != nil {
+		return err
+	}
+
+	bb, err := json.MarshalIndent(updates, "", "\t")
+	if err != nil {
+		return errors.Wrapf(err, "workflow - unable to marshal updates")
+	}
+	er.HTMLContent = er.HTMLContent + "\n\n" + string(bb)
+
+	if err := sendEmail(ctx, er); err != nil {
+		return errors.Wrapf(err, "workflow - unable to send email")
+	}
+
+	return nil
+}
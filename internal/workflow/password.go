@@ -0,0 +1,61 @@
+package workflow
+
+import (
+	"strings"
+	gotime "time"
+	"unicode"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+)
+
+const (
+	minPasswordLength = 10
+	// bcrypt ignores everything past 72 bytes, so longer passwords are weaker than they look
+	maxPasswordBytes = 72
+	// minPasswordClasses is how many of lowercase, uppercase, digits and symbols a password must mix
+	minPasswordClasses = 3
+
+	// resetPasswordEmailWindow and maxResetPasswordEmails limit how many reset emails an address receives
+	resetPasswordEmailWindow = gotime.Hour
+	maxResetPasswordEmails   = 3
+)
+
+// validatePassword checks a new password is strong enough to be set for the user with the email
+func validatePassword(password, email string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return apperror.New(apperror.ValidationCode, "workflow - password must be at least %v characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return apperror.New(apperror.ValidationCode, "workflow - password must be at most %v bytes", maxPasswordBytes)
+	}
+
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+	if classes < minPasswordClasses {
+		return apperror.New(apperror.ValidationCode, "workflow - password must mix at least %v of lowercase letters, uppercase letters, digits and symbols", minPasswordClasses)
+	}
+
+	local := strings.ToLower(strings.Split(email, "@")[0])
+	if len(local) >= 4 && strings.Contains(strings.ToLower(password), local) {
+		return apperror.New(apperror.ValidationCode, "workflow - password must not contain the email address")
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package workflow
+
+import (
+	"context"
+	"log"
+	"regexp"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+var graduationYearRegex = regexp.MustCompile(`^\d{4}$`)
+
+func GrantRole(retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertRoleChange db.InsertRoleChangeFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) GrantRoleFunc {
+	return func(ctx context.Context, userId string, req pkg.Role, p auth.Principal) (_ pkg.User, err error) {
+		log.Printf("Granting role=%+v to userId=%v", req, userId)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.GrantRoleAction, internal.UserTarget, userId, &err)
+
+		if !p.Can(auth.ManageRolesPermission) {
+			return pkg.User{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to manage roles", p.User.ID)
+		}
+
+		role := mapping.ToDBRole(req)
+		if err := validateRole(role); err != nil {
+			return pkg.User{}, err
+		}
+
+		user, err := retrieveUserById(ctx, userId)
+		if err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to find userId=%v", userId)
+		}
+
+		if user.HasRole(role) {
+			return pkg.User{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has role=%+v", userId, role)
+		}
+
+		user.Roles = append(user.Roles, role)
+		if role.Name == internal.SuperAdminRole {
+			user.Admin = true
+		}
+		user.LastUpdatedTimestamp = provideTime()
+
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", userId)
+		}
+
+		if err := recordRoleChange(ctx, insertRoleChange, provideTime, genUUID, user, p, internal.GrantRoleAction, role); err != nil {
+			return pkg.User{}, err
+		}
+
+		return mapping.ToDTOUser(user), nil
+	}
+}
+
+func RevokeRole(retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	insertRoleChange db.InsertRoleChangeFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) RevokeRoleFunc {
+	return func(ctx context.Context, userId string, req pkg.Role, p auth.Principal) (_ pkg.User, err error) {
+		log.Printf("Revoking role=%+v from userId=%v", req, userId)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.RevokeRoleAction, internal.UserTarget, userId, &err)
+
+		if !p.Can(auth.ManageRolesPermission) {
+			return pkg.User{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to manage roles", p.User.ID)
+		}
+
+		role := mapping.ToDBRole(req)
+		if role.Name == internal.SuperAdminRole && p.User.ID.Val() == userId {
+			return pkg.User{}, apperror.New(apperror.ValidationCode, "workflow - userId=%v cannot revoke their own super admin role", userId)
+		}
+
+		user, err := retrieveUserById(ctx, userId)
+		if err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to find userId=%v", userId)
+		}
+
+		legacyAdmin := role.Name == internal.SuperAdminRole && user.Admin
+		if !user.HasRole(role) && !legacyAdmin {
+			return pkg.User{}, apperror.New(apperror.NotFoundCode, "workflow - userId=%v does not have role=%+v", userId, role)
+		}
+
+		roles := []internal.Role{}
+		for _, r := range user.Roles {
+			if r != role {
+				roles = append(roles, r)
+			}
+		}
+		user.Roles = roles
+		if role.Name == internal.SuperAdminRole {
+			user.Admin = false
+		}
+		user.LastUpdatedTimestamp = provideTime()
+
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", userId)
+		}
+
+		if err := recordRoleChange(ctx, insertRoleChange, provideTime, genUUID, user, p, internal.RevokeRoleAction, role); err != nil {
+			return pkg.User{}, err
+		}
+
+		// Tokens issued before the revoke must not outlive it
+		if err := deleteUserRefreshTokens(ctx, userId); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to revoke sessions for userId=%v", userId)
+		}
+
+		return mapping.ToDTOUser(user), nil
+	}
+}
+
+func RetrieveUserRoles(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveRoleChanges db.RetrieveRoleChangesFunc) RetrieveUserRolesFunc {
+	return func(ctx context.Context, userId string, p auth.Principal) (pkg.UserRolesResponse, error) {
+		log.Printf("Retrieving roles of userId=%v", userId)
+
+		if !p.Can(auth.ManageRolesPermission) {
+			return pkg.UserRolesResponse{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to manage roles", p.User.ID)
+		}
+
+		user, err := retrieveUserById(ctx, userId)
+		if err != nil {
+			return pkg.UserRolesResponse{}, errors.Wrapf(err, "workflow - unable to find userId=%v", userId)
+		}
+
+		rcs, err := retrieveRoleChanges(ctx, userId)
+		if err != nil {
+			return pkg.UserRolesResponse{}, errors.Wrapf(err, "workflow - unable to retrieve role changes for userId=%v", userId)
+		}
+
+		return pkg.UserRolesResponse{
+			Roles:   mapping.ToDTORoles(user.EffectiveRoles()),
+			History: mapping.ToDTORoleChanges(rcs),
+		}, nil
+	}
+}
+
+// authorizeForUser checks the principal holds the permission over the target user, which for scoped roles is decided by the target's alumni profile
+func authorizeForUser(ctx context.Context, p auth.Principal, perm auth.Permission, target internal.User, retrieveAlumniById db.RetrieveAlumniByIDFunc) error {
+	if !p.Can(perm) {
+		return apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have permission=%v", p.User.ID, perm)
+	}
+
+	if p.AlumniScope(perm).All {
+		return nil
+	}
+
+	if target.AlumniID == "" {
+		return apperror.New(apperror.ForbiddenCode, "workflow - userId=%v has no alumni profile within the scope of userId=%v", target.ID, p.User.ID)
+	}
+
+	a, err := retrieveAlumniById(ctx, target.AlumniID.Val())
+	if err != nil {
+		return errors.Wrapf(err, "workflow - unable to retrieve alumniId=%v", target.AlumniID)
+	}
+
+	if !p.CanForAlumni(perm, a) {
+		return apperror.New(apperror.ForbiddenCode, "workflow - userId=%v is outside the scope of userId=%v", target.ID, p.User.ID)
+	}
+
+	return nil
+}
+
+func validateRole(r internal.Role) error {
+	known := false
+	for _, name := range internal.Roles {
+		if r.Name == name {
+			known = true
+		}
+	}
+	if !known {
+		return apperror.New(apperror.ValidationCode, "workflow - unknown role=%v", r.Name)
+	}
+
+	switch r.Name {
+	case internal.DivisionAdminRole:
+		for _, d := range internal.Divisions {
+			if r.Division == d && r.GraduationYear == "" {
+				return nil
+			}
+		}
+		return apperror.New(apperror.ValidationCode, "workflow - role=%v requires one of divisions=%v", r.Name, internal.Divisions)
+	case internal.ClassCoordinatorRole:
+		if r.Division != "" || !graduationYearRegex.MatchString(r.GraduationYear) {
+			return apperror.New(apperror.ValidationCode, "workflow - role=%v requires a four digit graduationYear", r.Name)
+		}
+		return nil
+	}
+
+	if r.Division != "" || r.GraduationYear != "" {
+		return apperror.New(apperror.ValidationCode, "workflow - role=%v cannot be scoped", r.Name)
+	}
+	return nil
+}
+
+func recordRoleChange(ctx context.Context, insertRoleChange db.InsertRoleChangeFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func,
+	user internal.User,
+	p auth.Principal,
+	action string,
+	role internal.Role) error {
+	rc := internal.RoleChange{
+		ID:               genUUID(),
+		UserID:           user.ID,
+		ActorID:          p.User.ID,
+		Action:           action,
+		Role:             role,
+		CreatedTimestamp: provideTime(),
+	}
+	if err := insertRoleChange(ctx, rc); err != nil {
+		return errors.Wrapf(err, "workflow - unable to record role change for userId=%v", user.ID)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package workflow
+
+import (
+	"context"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+// RefreshToken exchanges a refresh token for a new access token, rotating the refresh token in the process
+func RefreshToken(rotateRefreshToken db.RotateRefreshTokenFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	provideTime time.EpochProviderFunc) RefreshTokenFunc {
+	return func(ctx context.Context, refreshToken string) (pkg.UserResponse, error) {
+		if refreshToken == "" {
+			return pkg.UserResponse{}, apperror.New(apperror.ValidationCode, "workflow - refreshToken is required")
+		}
+
+		newRefreshToken, err := token.NewOpaqueToken()
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrap(err, "workflow - unable to generate refresh token")
+		}
+
+		currentTime := provideTime()
+		rt := internal.RefreshToken{
+			TokenHash:            token.HashOpaqueToken(newRefreshToken),
+			ExpiresAt:            currentTime.ToISO8601().Val().Add(token.RefreshTokenTTL),
+			LastUpdatedTimestamp: currentTime,
+		}
+
+		session, err := rotateRefreshToken(ctx, token.HashOpaqueToken(refreshToken), rt)
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return pkg.UserResponse{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - refresh token is invalid, expired or has already been used")
+		}
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrap(err, "workflow - unable to rotate refresh token")
+		}
+
+		log.Printf("Refreshing sessionId=%v for userId=%v", session.ID, session.UserID)
+
+		user, err := retrieveUserById(ctx, session.UserID.Val())
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return pkg.UserResponse{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - refresh token was issued to a user that no longer exists")
+		}
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to find userId=%v", session.UserID)
+		}
+
+		uToken, err := token.CreateUserToken(user, session.ID, provideTime)
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
+		}
+
+		return pkg.UserResponse{
+			User:         mapping.ToDTOUser(user),
+			Token:        uToken,
+			RefreshToken: newRefreshToken,
+		}, nil
+	}
+}
+
+// Logout revokes the session of the caller, invalidating its access and refresh tokens
+func Logout(deleteRefreshToken db.DeleteRefreshTokenFunc) LogoutFunc {
+	return func(ctx context.Context, p auth.Principal) error {
+		log.Printf("Logging out userId=%v from sessionId=%v", p.User.ID, p.SessionID)
+
+		if err := deleteRefreshToken(ctx, p.SessionID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to revoke sessionId=%v", p.SessionID)
+		}
+
+		return nil
+	}
+}
+
+// startSession persists a new session for the user and returns the user with an access token and a refresh token for it
+func startSession(ctx context.Context, user internal.User,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) (pkg.UserResponse, error) {
+	refreshToken, err := token.NewOpaqueToken()
+	if err != nil {
+		return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to generate refresh token for userId=%v", user.ID)
+	}
+
+	currentTime := provideTime()
+	rt := internal.RefreshToken{
+		ID:                   genUUID(),
+		UserID:               user.ID,
+		TokenHash:            token.HashOpaqueToken(refreshToken),
+		ExpiresAt:            currentTime.ToISO8601().Val().Add(token.RefreshTokenTTL),
+		CreatedTimestamp:     currentTime,
+		LastUpdatedTimestamp: currentTime,
+	}
+	if err := insertRefreshToken(ctx, rt); err != nil {
+		return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to insert refresh token for userId=%v", user.ID)
+	}
+
+	uToken, err := token.CreateUserToken(user, rt.ID, provideTime)
+	if err != nil {
+		return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to generate JWT token for userId=%v", user.ID)
+	}
+
+	return pkg.UserResponse{
+		User:         mapping.ToDTOUser(user),
+		Token:        uToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
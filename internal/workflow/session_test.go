@@ -0,0 +1,103 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+func TestRefreshToken(t *testing.T) {
+	ctx := context.Background()
+
+	// newSession starts a session for a new user in a new store
+	newSession := func(t *testing.T) (db.Store, internal.User, pkg.UserResponse) {
+		store := db.NewMemoryStore()
+		user := internal.User{ID: "00000000-0000-4000-8000-000000000100", Email: "user@example.com"}
+		if err := store.Users.Insert(ctx, user); err != nil {
+			t.Fatalf("unable to insert user: %v", err)
+		}
+		resp, err := startSession(ctx, user, store.RefreshTokens.Insert, clockAt(testNow), sequentialUUIDs())
+		if err != nil {
+			t.Fatalf("unable to start session: %v", err)
+		}
+		return store, user, resp
+	}
+
+	t.Run("rotates", func(t *testing.T) {
+		store, user, session := newSession(t)
+		refresh := RefreshToken(store.RefreshTokens.Rotate, store.Users.RetrieveByID, clockAt(testNow))
+
+		resp, err := refresh(ctx, session.RefreshToken)
+		if err != nil {
+			t.Fatalf("unable to refresh: %v", err)
+		}
+		if resp.User.ID != user.ID || resp.Token == "" {
+			t.Errorf("got userId=%v, token=%q, want userId=%v and a token", resp.User.ID, resp.Token, user.ID)
+		}
+		if resp.RefreshToken == "" || resp.RefreshToken == session.RefreshToken {
+			t.Errorf("got refresh token=%q, want a new one", resp.RefreshToken)
+		}
+
+		// The session keeps its ID, so the access tokens issued for it stay revocable
+		claims, err := token.CheckUserToken(resp.Token, clockAt(testNow))
+		if err != nil {
+			t.Fatalf("unable to check access token: %v", err)
+		}
+		rt, err := store.RefreshTokens.RetrieveByID(ctx, claims.SessionID.Val())
+		if err != nil {
+			t.Fatalf("unable to retrieve session: %v", err)
+		}
+		if rt.TokenHash != token.HashOpaqueToken(resp.RefreshToken) {
+			t.Errorf("got session storing a different refresh token than the one returned")
+		}
+
+		if _, err := refresh(ctx, resp.RefreshToken); err != nil {
+			t.Errorf("unable to refresh with the rotated token: %v", err)
+		}
+	})
+
+	t.Run("reused", func(t *testing.T) {
+		store, _, session := newSession(t)
+		refresh := RefreshToken(store.RefreshTokens.Rotate, store.Users.RetrieveByID, clockAt(testNow))
+
+		if _, err := refresh(ctx, session.RefreshToken); err != nil {
+			t.Fatalf("unable to refresh: %v", err)
+		}
+		_, err := refresh(ctx, session.RefreshToken)
+		wantCode(t, err, apperror.UnauthorizedCode)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		store, _, session := newSession(t)
+		expired := testNow + time.Epoch(token.RefreshTokenTTL)
+		refresh := RefreshToken(store.RefreshTokens.Rotate, store.Users.RetrieveByID, clockAt(expired))
+
+		_, err := refresh(ctx, session.RefreshToken)
+		wantCode(t, err, apperror.UnauthorizedCode)
+	})
+
+	t.Run("user_deleted", func(t *testing.T) {
+		store, user, session := newSession(t)
+		if err := store.Users.Delete(ctx, user.ID.Val()); err != nil {
+			t.Fatalf("unable to delete user: %v", err)
+		}
+		refresh := RefreshToken(store.RefreshTokens.Rotate, store.Users.RetrieveByID, clockAt(testNow))
+
+		_, err := refresh(ctx, session.RefreshToken)
+		wantCode(t, err, apperror.UnauthorizedCode)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		store, _, _ := newSession(t)
+		refresh := RefreshToken(store.RefreshTokens.Rotate, store.Users.RetrieveByID, clockAt(testNow))
+
+		_, err := refresh(ctx, "")
+		wantCode(t, err, apperror.ValidationCode)
+	})
+}
@@ -0,0 +1,77 @@
+package workflow
+
+import (
+	"context"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/aymerick/raymond"
+	"github.com/pkg/errors"
+)
+
+func RetrieveEmailTemplates(retrieveAllEmailTemplates db.RetrieveAllEmailTemplatesFunc) RetrieveEmailTemplatesFunc {
+	return func(ctx context.Context, p auth.Principal) ([]pkg.EmailTemplate, error) {
+		log.Printf("Retrieving all email templates")
+
+		if !p.Can(auth.ManageTemplatesPermission) {
+			return []pkg.EmailTemplate{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to manage email templates", p.User.ID)
+		}
+
+		ets, err := retrieveAllEmailTemplates(ctx)
+		if err != nil {
+			return []pkg.EmailTemplate{}, errors.Wrap(err, "workflow - unable to retrieve email templates")
+		}
+
+		templates := []pkg.EmailTemplate{}
+		for _, et := range ets {
+			templates = append(templates, mapping.ToDTOEmailTemplate(et))
+		}
+
+		return templates, nil
+	}
+}
+
+func UpsertEmailTemplate(upsertEmailTemplate db.UpsertEmailTemplateFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) UpsertEmailTemplateFunc {
+	return func(ctx context.Context, name string, req pkg.EmailTemplate, p auth.Principal) (_ pkg.EmailTemplate, err error) {
+		log.Printf("Saving email template with name=%v", name)
+
+		defer recordAuditEvent(ctx, insertAuditEvent, provideTime, genUUID, p, internal.UpsertTemplateAction, internal.TemplateTarget, name, &err)
+
+		if !p.Can(auth.ManageTemplatesPermission) {
+			return pkg.EmailTemplate{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to manage email templates", p.User.ID)
+		}
+
+		if name == "" || req.Subject == "" || req.HTML == "" {
+			return pkg.EmailTemplate{}, apperror.New(apperror.ValidationCode, "workflow - name, subject and html are required")
+		}
+
+		if _, err := raymond.Parse(req.Subject); err != nil {
+			return pkg.EmailTemplate{}, apperror.Wrap(err, apperror.ValidationCode, "workflow - unable to parse email subject template")
+		}
+
+		if _, err := raymond.Parse(req.HTML); err != nil {
+			return pkg.EmailTemplate{}, apperror.Wrap(err, apperror.ValidationCode, "workflow - unable to parse email body template")
+		}
+
+		et := internal.EmailTemplate{
+			Name:    name,
+			Subject: req.Subject,
+			HTML:    req.HTML,
+		}
+		if err := upsertEmailTemplate(ctx, et); err != nil {
+			return pkg.EmailTemplate{}, errors.Wrapf(err, "workflow - unable to save email template with name=%v", name)
+		}
+
+		return mapping.ToDTOEmailTemplate(et), nil
+	}
+}
@@ -0,0 +1,202 @@
+package workflow
+
+import (
+	"context"
+	"log"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/totp"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+const recoveryCodeCount = 10
+
+// EnrollTOTP generates a new TOTP secret for the caller, which is not required at login until it is confirmed
+func EnrollTOTP(replaceUser db.ReplaceUserFunc,
+	provideTime time.EpochProviderFunc) EnrollTOTPFunc {
+	return func(ctx context.Context, p auth.Principal) (pkg.TOTPEnrollment, error) {
+		user := p.User
+		log.Printf("Enrolling userId=%v in two factor authentication", user.ID)
+
+		if user.TOTPEnabled {
+			return pkg.TOTPEnrollment{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has two factor authentication enabled", user.ID)
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			return pkg.TOTPEnrollment{}, errors.Wrapf(err, "workflow - unable to generate TOTP secret for userId=%v", user.ID)
+		}
+
+		user.TOTPSecret = secret
+		user.LastUpdatedTimestamp = provideTime()
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.TOTPEnrollment{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
+		}
+
+		return pkg.TOTPEnrollment{
+			Secret: secret,
+			URI:    totp.ProvisioningURI(internal.TOTPIssuer, user.Email, secret),
+		}, nil
+	}
+}
+
+// ConfirmTOTP enables two factor authentication for the caller once they prove their authenticator app has the secret
+func ConfirmTOTP(replaceUser db.ReplaceUserFunc,
+	provideTime time.EpochProviderFunc) ConfirmTOTPFunc {
+	return func(ctx context.Context, req pkg.TOTPCodeRequest, p auth.Principal) (pkg.RecoveryCodesResponse, error) {
+		user := p.User
+		log.Printf("Confirming two factor authentication for userId=%v", user.ID)
+
+		if user.TOTPEnabled {
+			return pkg.RecoveryCodesResponse{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v already has two factor authentication enabled", user.ID)
+		}
+		if user.TOTPSecret == "" {
+			return pkg.RecoveryCodesResponse{}, apperror.New(apperror.ValidationCode, "workflow - userId=%v has not started enrolling in two factor authentication", user.ID)
+		}
+
+		currentTime := provideTime()
+		step, ok := totp.Validate(user.TOTPSecret, req.Code, currentTime.ToISO8601().Val())
+		if !ok {
+			return pkg.RecoveryCodesResponse{}, apperror.New(apperror.ValidationCode, "workflow - TOTP code is invalid")
+		}
+
+		codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			return pkg.RecoveryCodesResponse{}, errors.Wrapf(err, "workflow - unable to generate recovery codes for userId=%v", user.ID)
+		}
+
+		hashes := []string{}
+		for _, c := range codes {
+			hashes = append(hashes, token.HashOpaqueToken(totp.NormalizeRecoveryCode(c)))
+		}
+
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodeHashes = hashes
+		user.LastUpdatedTimestamp = currentTime
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.RecoveryCodesResponse{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
+		}
+
+		return pkg.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+	}
+}
+
+// DisableTOTP turns off two factor authentication for the caller
+func DisableTOTP(replaceUser db.ReplaceUserFunc,
+	provideTime time.EpochProviderFunc,
+	requireAdmin2FA bool) DisableTOTPFunc {
+	return func(ctx context.Context, req pkg.TOTPCodeRequest, p auth.Principal) (pkg.User, error) {
+		user := p.User
+		log.Printf("Disabling two factor authentication for userId=%v", user.ID)
+
+		if !user.TOTPEnabled {
+			return pkg.User{}, apperror.New(apperror.ConflictCode, "workflow - userId=%v does not have two factor authentication enabled", user.ID)
+		}
+		if requireAdmin2FA && len(user.EffectiveRoles()) > 0 {
+			return pkg.User{}, apperror.New(apperror.ForbiddenCode, "workflow - two factor authentication is required for admins")
+		}
+
+		currentTime := provideTime()
+		if !verifySecondFactor(&user, req.Code, req.RecoveryCode, currentTime.ToISO8601().Val()) {
+			return pkg.User{}, apperror.New(apperror.ValidationCode, "workflow - TOTP code is invalid")
+		}
+
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		user.RecoveryCodeHashes = nil
+		user.LastUpdatedTimestamp = currentTime
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
+		}
+
+		return mapping.ToDTOUser(user), nil
+	}
+}
+
+// CompleteMFALogin exchanges the token returned by a login requiring a second factor, and a TOTP or recovery code, for a session
+func CompleteMFALogin(retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	retrieveLoginAttempt db.RetrieveLoginAttemptFunc,
+	recordLoginFailure db.RecordLoginFailureFunc,
+	deleteLoginAttempts db.DeleteLoginAttemptsFunc,
+	insertRefreshToken db.InsertRefreshTokenFunc,
+	provideTime time.EpochProviderFunc,
+	genUUID uuid.GenV4Func) CompleteMFALoginFunc {
+	return func(ctx context.Context, req pkg.MFALoginRequest, ip string) (pkg.UserResponse, error) {
+		userId, err := token.CheckMFAToken(req.MFAToken, provideTime)
+		if err != nil {
+			return pkg.UserResponse{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - unable to decode MFA token")
+		}
+
+		log.Printf("Completing two factor login for userId=%v from ip=%v", userId, ip)
+
+		user, err := retrieveUserById(ctx, userId.Val())
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return pkg.UserResponse{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - MFA token was issued to a user that no longer exists")
+		}
+		if err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to find userId=%v", userId)
+		}
+
+		// A second factor is a 6 digit code, so guessing it is throttled the same as guessing a password
+		currentTime := provideTime()
+		now := currentTime.ToISO8601().Val()
+		keys := loginKeys(user.Email, ip)
+		if err := checkLoginThrottle(ctx, keys, retrieveLoginAttempt, deleteLoginAttempts, now); err != nil {
+			return pkg.UserResponse{}, err
+		}
+
+		if !user.TOTPEnabled || !verifySecondFactor(&user, req.Code, req.RecoveryCode, now) {
+			if err := countLoginFailure(ctx, keys, recordLoginFailure, now); err != nil {
+				return pkg.UserResponse{}, err
+			}
+			return pkg.UserResponse{}, apperror.New(apperror.UnauthorizedCode, "workflow - invalid credentials")
+		}
+
+		user.LastUpdatedTimestamp = currentTime
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
+		}
+
+		if err := deleteLoginAttempts(ctx, emailLoginKey(user.Email)); err != nil {
+			return pkg.UserResponse{}, errors.Wrapf(err, "workflow - unable to delete login attempts for userId=%v", user.ID)
+		}
+
+		return startSession(ctx, user, insertRefreshToken, provideTime, genUUID)
+	}
+}
+
+// verifySecondFactor checks a TOTP code or recovery code against the user, consuming it so it cannot be replayed
+func verifySecondFactor(user *internal.User, code, recoveryCode string, now gotime.Time) bool {
+	if code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, code, now)
+		if !ok || step <= user.TOTPLastStep {
+			return false
+		}
+		user.TOTPLastStep = step
+		return true
+	}
+
+	if recoveryCode == "" {
+		return false
+	}
+	hash := token.HashOpaqueToken(totp.NormalizeRecoveryCode(recoveryCode))
+	for i, h := range user.RecoveryCodeHashes {
+		if h == hash {
+			user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i:i], user.RecoveryCodeHashes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package workflow
+
+import (
+	"context"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+// RetrieveUsers lists the users matching the filters, limited to those within the scope of the caller
+func RetrieveUsers(retrieveUsers db.RetrieveUsersFunc) RetrieveUsersFunc {
+	return func(ctx context.Context, params pkg.UserQueryParams, p auth.Principal) (pkg.RetrieveUsersResponse, error) {
+		log.Printf("Retrieving users with params=%+v", params)
+
+		if !p.Can(auth.ApproveUsersPermission) {
+			return pkg.RetrieveUsersResponse{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have access to list users", p.User.ID)
+		}
+
+		// Scoped admins only see users whose alumni profile falls within their scope
+		uu, pi, err := retrieveUsers(ctx, params, p.AlumniScope(auth.ApproveUsersPermission))
+		if err != nil {
+			return pkg.RetrieveUsersResponse{}, errors.Wrap(err, "workflow - unable to retrieve users")
+		}
+
+		users := []pkg.User{}
+		for _, u := range uu {
+			users = append(users, mapping.ToDTOUser(u))
+		}
+
+		return pkg.RetrieveUsersResponse{Users: users, PageInfo: pi}, nil
+	}
+}
+
+// BulkUpdateUsers approves or denies each of the users, reporting the outcome for each rather than stopping at the first failure
+func BulkUpdateUsers(retrieveUserById db.RetrieveUserByIDFunc,
+	retrieveAlumniById db.RetrieveAlumniByIDFunc,
+	provideTime time.EpochProviderFunc,
+	replaceUser db.ReplaceUserFunc,
+	deleteUserRefreshTokens db.DeleteUserRefreshTokensFunc,
+	insertAuditEvent db.InsertAuditEventFunc,
+	genUUID uuid.GenV4Func,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc) BulkUpdateUsersFunc {
+	return func(ctx context.Context, req pkg.BulkUserRequest, p auth.Principal) (pkg.BulkUserResponse, error) {
+		log.Printf("Bulk updating %v users with action=%v", len(req.UserIDs), req.Action)
+
+		if !p.Can(auth.ApproveUsersPermission) {
+			return pkg.BulkUserResponse{}, apperror.New(apperror.ForbiddenCode, "workflow - userId=%v does not have permission=%v", p.User.ID, auth.ApproveUsersPermission)
+		}
+
+		var update func(ctx context.Context, userId string, p auth.Principal) (pkg.User, error)
+		switch req.Action {
+		case internal.ApproveUsersAction:
+			update = ApproveUser(retrieveUserById, retrieveAlumniById, provideTime, replaceUser, insertAuditEvent, genUUID, getEmailTemplate, sendEmail)
+		case internal.DenyUsersAction:
+			denyUser := DenyUser(retrieveUserById, retrieveAlumniById, provideTime, replaceUser, deleteUserRefreshTokens, insertAuditEvent, genUUID, getEmailTemplate, sendEmail)
+			update = func(ctx context.Context, userId string, p auth.Principal) (pkg.User, error) {
+				return denyUser(ctx, userId, pkg.DenyUserRequest{Reason: req.Reason}, p)
+			}
+		default:
+			return pkg.BulkUserResponse{}, apperror.New(apperror.ValidationCode, "workflow - unknown action=%v, expected %v or %v", req.Action, internal.ApproveUsersAction, internal.DenyUsersAction)
+		}
+
+		if len(req.UserIDs) == 0 {
+			return pkg.BulkUserResponse{}, apperror.New(apperror.ValidationCode, "workflow - no userIds to %v", req.Action)
+		}
+		if len(req.UserIDs) > internal.MaxBulkUsers {
+			return pkg.BulkUserResponse{}, apperror.New(apperror.ValidationCode, "workflow - at most %v users can be updated at once", internal.MaxBulkUsers)
+		}
+
+		res := pkg.BulkUserResponse{Results: []pkg.BulkUserResult{}}
+		seen := map[string]bool{}
+		for _, userId := range req.UserIDs {
+			if seen[userId] {
+				continue
+			}
+			seen[userId] = true
+
+			user, err := update(ctx, userId, p)
+			if err != nil {
+				if apperror.CodeOf(err) == apperror.InternalCode {
+					log.Print(err)
+				}
+				res.Failed++
+				res.Results = append(res.Results, pkg.BulkUserResult{UserID: userId, Code: string(apperror.CodeOf(err)), Error: err.Error()})
+				continue
+			}
+
+			res.Succeeded++
+			res.Results = append(res.Results, pkg.BulkUserResult{UserID: userId, User: &user})
+		}
+
+		return res, nil
+	}
+}
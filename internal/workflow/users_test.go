@@ -0,0 +1,173 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+const (
+	verifiedUserID   = "00000000-0000-4000-8000-000000000101"
+	unverifiedUserID = "00000000-0000-4000-8000-000000000102"
+	legacyUserID     = "00000000-0000-4000-8000-000000000103"
+	approvedUserID   = "00000000-0000-4000-8000-000000000104"
+)
+
+// seedUsers stores a user for each of the ways a user can be waiting for approval, or not
+func seedUsers(t *testing.T) db.Store {
+	store := db.NewMemoryStore()
+	uu := []internal.User{
+		{ID: verifiedUserID, Email: "verified@example.com", Status: internal.PendingUserStatus, EmailVerified: true, AlumniID: "00000000-0000-4000-8000-00000000b101"},
+		{ID: unverifiedUserID, Email: "unverified@example.com", Status: internal.PendingUserStatus},
+		// Users created before statuses existed have none
+		{ID: legacyUserID, Email: "legacy@example.com", EmailVerified: true},
+		{ID: approvedUserID, Email: "approved@example.com", Status: internal.ApprovedUserStatus, EmailVerified: true, AlumniID: "00000000-0000-4000-8000-00000000b104"},
+	}
+	for _, u := range uu {
+		if err := store.Users.Insert(context.Background(), u); err != nil {
+			t.Fatalf("unable to insert user: %v", err)
+		}
+	}
+	// Of the users linked to an alumni record, only the verified one's is in the HILI division
+	aa := []internal.Alumni{
+		{ID: "00000000-0000-4000-8000-00000000b101", HILI: true},
+		{ID: "00000000-0000-4000-8000-00000000b104", HAFTR: true},
+	}
+	for _, a := range aa {
+		if err := store.Alumni.Insert(context.Background(), a); err != nil {
+			t.Fatalf("unable to insert alumni: %v", err)
+		}
+	}
+	seedTemplates(t, store, internal.ApprovedUserTemplateName, internal.DeniedUserTemplateName)
+	return store
+}
+
+func TestRetrieveUsers(t *testing.T) {
+	divisionAdmin := auth.Principal{User: internal.User{
+		ID:    "00000000-0000-4000-8000-00000000a001",
+		Roles: []internal.Role{{Name: internal.DivisionAdminRole, Division: internal.HILIDivision}},
+	}}
+
+	tests := []struct {
+		status string
+		as     auth.Principal
+		want   []uuid.V4
+	}{
+		{status: internal.PendingUserStatus, want: []uuid.V4{verifiedUserID, legacyUserID}},
+		{status: internal.ApprovedUserStatus, want: []uuid.V4{approvedUserID}},
+		{status: "", want: []uuid.V4{verifiedUserID, unverifiedUserID, legacyUserID, approvedUserID}},
+		// A division admin only sees the users linked to an alumni record in their division
+		{status: "", as: divisionAdmin, want: []uuid.V4{verifiedUserID}},
+		{status: internal.PendingUserStatus, as: divisionAdmin, want: []uuid.V4{verifiedUserID}},
+		{status: internal.ApprovedUserStatus, as: divisionAdmin, want: []uuid.V4{}},
+	}
+	for _, tt := range tests {
+		store := seedUsers(t)
+		retrieveUsers := RetrieveUsers(store.Users.Retrieve)
+		p := testAdmin
+		if tt.as.User.ID != "" {
+			p = tt.as
+		}
+
+		resp, err := retrieveUsers(context.Background(), pkg.UserQueryParams{Limit: -1, Page: 1, Status: tt.status}, p)
+		if err != nil {
+			t.Fatalf("unable to retrieve users with status=%q: %v", tt.status, err)
+		}
+
+		got := map[uuid.V4]bool{}
+		for _, u := range resp.Users {
+			got[u.ID] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("got %v users with status=%q, want %v", len(got), tt.status, tt.want)
+		}
+		for _, id := range tt.want {
+			if !got[id] {
+				t.Errorf("got users=%v with status=%q, missing userId=%v", resp.Users, tt.status, id)
+			}
+		}
+	}
+}
+
+func TestApproveUser(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		userId      string
+		wantErr     apperror.Code
+		wantOutcome string
+		wantEmails  int
+	}{
+		{name: "verified", userId: verifiedUserID, wantOutcome: internal.SuccessOutcome, wantEmails: 1},
+		{name: "legacy", userId: legacyUserID, wantOutcome: internal.SuccessOutcome, wantEmails: 1},
+		{name: "already_approved", userId: approvedUserID, wantOutcome: internal.SuccessOutcome},
+		{name: "unverified", userId: unverifiedUserID, wantErr: apperror.ValidationCode, wantOutcome: internal.FailureOutcome},
+		{name: "missing", userId: "00000000-0000-4000-8000-000000000999", wantErr: apperror.NotFoundCode, wantOutcome: internal.FailureOutcome},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := seedUsers(t)
+			ses := &fakeSES{}
+			approve := ApproveUser(store.Users.RetrieveByID, store.Alumni.RetrieveByID, clockAt(testNow), store.Users.Replace, store.AuditEvents.Insert, sequentialUUIDs(), store.Templates.RetrieveByName, ses.send)
+
+			got, err := approve(ctx, tt.userId, testAdmin)
+			if tt.wantErr != "" {
+				wantCode(t, err, tt.wantErr)
+			} else if err != nil {
+				t.Fatalf("unable to approve: %v", err)
+			} else if got.Status != internal.ApprovedUserStatus {
+				t.Errorf("got status=%v, want %v", got.Status, internal.ApprovedUserStatus)
+			}
+
+			if tt.wantErr == apperror.ValidationCode {
+				if u, _ := store.Users.RetrieveByID(ctx, tt.userId); u.Status != internal.PendingUserStatus {
+					t.Errorf("got stored status=%v, want it left %v", u.Status, internal.PendingUserStatus)
+				}
+			}
+			if len(ses.sent) != tt.wantEmails {
+				t.Errorf("got %v emails sent, want %v", len(ses.sent), tt.wantEmails)
+			}
+
+			ee := auditEvents(t, store)
+			if len(ee) != 1 || ee[0].Action != internal.ApproveUsersAction || ee[0].TargetID != tt.userId || ee[0].Outcome != tt.wantOutcome {
+				t.Errorf("got audit events=%+v, want one %v of userId=%v with outcome=%v", ee, internal.ApproveUsersAction, tt.userId, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestBulkUpdateUsers(t *testing.T) {
+	store := seedUsers(t)
+	ses := &fakeSES{}
+	bulkUpdate := BulkUpdateUsers(store.Users.RetrieveByID, store.Alumni.RetrieveByID, clockAt(testNow), store.Users.Replace, store.RefreshTokens.DeleteForUser, store.AuditEvents.Insert, sequentialUUIDs(), store.Templates.RetrieveByName, ses.send)
+
+	req := pkg.BulkUserRequest{Action: internal.ApproveUsersAction, UserIDs: []string{verifiedUserID, unverifiedUserID}}
+	resp, err := bulkUpdate(context.Background(), req, testAdmin)
+	if err != nil {
+		t.Fatalf("unable to bulk update users: %v", err)
+	}
+
+	// One user that cannot be approved does not stop the others
+	if resp.Succeeded != 1 || resp.Failed != 1 || len(resp.Results) != 2 {
+		t.Fatalf("got succeeded=%v, failed=%v, results=%+v, want one of each", resp.Succeeded, resp.Failed, resp.Results)
+	}
+	for _, r := range resp.Results {
+		switch r.UserID {
+		case verifiedUserID:
+			if r.User == nil || r.User.Status != internal.ApprovedUserStatus {
+				t.Errorf("got result=%+v for the verified user, want them approved", r)
+			}
+		case unverifiedUserID:
+			if r.Code != string(apperror.ValidationCode) {
+				t.Errorf("got result=%+v for the unverified user, want code=%v", r, apperror.ValidationCode)
+			}
+		}
+	}
+}
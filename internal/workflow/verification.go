@@ -0,0 +1,116 @@
+package workflow
+
+import (
+	"context"
+	"log"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/mapping"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+	"github.com/pkg/errors"
+)
+
+// VerifyEmail marks the email address of the user a verification token was sent to as verified
+func VerifyEmail(retrieveEmailVerification db.RetrieveEmailVerificationFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	retrieveUserById db.RetrieveUserByIDFunc,
+	replaceUser db.ReplaceUserFunc,
+	provideTime time.EpochProviderFunc) VerifyEmailFunc {
+	return func(ctx context.Context, req pkg.VerifyEmail) (pkg.User, error) {
+		if req.Token == "" {
+			return pkg.User{}, apperror.New(apperror.ValidationCode, "workflow - token is required")
+		}
+
+		ev, err := retrieveEmailVerification(ctx, token.HashOpaqueToken(req.Token))
+		if apperror.CodeOf(err) == apperror.NotFoundCode {
+			return pkg.User{}, apperror.Wrap(err, apperror.UnauthorizedCode, "workflow - verification token is invalid or has already been used")
+		}
+		if err != nil {
+			return pkg.User{}, errors.Wrap(err, "workflow - unable to retrieve email verification")
+		}
+
+		log.Printf("Verifying email=%v for userId=%v", ev.Email, ev.UserID)
+
+		currentTime := provideTime()
+		if ev.ExpiresAt.Before(currentTime.ToISO8601().Val()) {
+			return pkg.User{}, apperror.New(apperror.UnauthorizedCode, "workflow - verification token for userId=%v has expired", ev.UserID)
+		}
+
+		user, err := retrieveUserById(ctx, ev.UserID.Val())
+		if err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to find userId=%v", ev.UserID)
+		}
+
+		// The user may have changed their email address since the token was sent
+		if user.Email != ev.Email {
+			return pkg.User{}, apperror.New(apperror.UnauthorizedCode, "workflow - verification token was sent to a previous email of userId=%v", user.ID)
+		}
+
+		user.EmailVerified = true
+		user.LastUpdatedTimestamp = currentTime
+		if err := replaceUser(ctx, user); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to replace userId=%v", user.ID)
+		}
+
+		if err := deleteEmailVerifications(ctx, user.ID.Val()); err != nil {
+			return pkg.User{}, errors.Wrapf(err, "workflow - unable to delete email verifications for userId=%v", user.ID)
+		}
+
+		return mapping.ToDTOUser(user), nil
+	}
+}
+
+// ResendVerificationEmail sends the caller a new verification token, invalidating any previous ones
+func ResendVerificationEmail(insertEmailVerification db.InsertEmailVerificationFunc,
+	deleteEmailVerifications db.DeleteEmailVerificationsFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	provideTime time.EpochProviderFunc) ResendVerificationEmailFunc {
+	return func(ctx context.Context, p auth.Principal) error {
+		user := p.User
+		log.Printf("Resending verification email to userId=%v", user.ID)
+
+		if user.EmailVerified {
+			return apperror.New(apperror.ConflictCode, "workflow - email of userId=%v is already verified", user.ID)
+		}
+
+		if err := deleteEmailVerifications(ctx, user.ID.Val()); err != nil {
+			return errors.Wrapf(err, "workflow - unable to delete email verifications for userId=%v", user.ID)
+		}
+
+		return sendVerificationEmail(ctx, user, insertEmailVerification, getEmailTemplate, sendEmail, provideTime)
+	}
+}
+
+// sendVerificationEmail persists a new verification token for the user and emails it to them
+func sendVerificationEmail(ctx context.Context, user internal.User,
+	insertEmailVerification db.InsertEmailVerificationFunc,
+	getEmailTemplate db.RetrieveEmailTemplateByNameFunc,
+	sendEmail email.SendEmailFunc,
+	provideTime time.EpochProviderFunc) error {
+	verificationToken, err := token.NewOpaqueToken()
+	if err != nil {
+		return errors.Wrapf(err, "workflow - unable to generate verification token for userId=%v", user.ID)
+	}
+
+	currentTime := provideTime()
+	ev := internal.EmailVerification{
+		UserID:           user.ID,
+		Email:            user.Email,
+		TokenHash:        token.HashOpaqueToken(verificationToken),
+		ExpiresAt:        currentTime.ToISO8601().Val().Add(token.EmailVerificationTTL),
+		CreatedTimestamp: currentTime,
+	}
+	if err := insertEmailVerification(ctx, ev); err != nil {
+		return errors.Wrapf(err, "workflow - unable to insert email verification for userId=%v", user.ID)
+	}
+
+	ve := pkg.VerifyEmail{Email: user.Email, Token: verificationToken}
+	return sendTemplateEmail(ctx, internal.VerifyEmailTemplateName, user.Email, ve, getEmailTemplate, sendEmail)
+}
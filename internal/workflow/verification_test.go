@@ -0,0 +1,120 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/token"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+
+	// newVerification stores an unverified user and a verification token sent to them
+	newVerification := func(t *testing.T) (db.Store, internal.User, string) {
+		store := db.NewMemoryStore()
+		user := internal.User{ID: "00000000-0000-4000-8000-000000000100", Email: "user@example.com"}
+		if err := store.Users.Insert(ctx, user); err != nil {
+			t.Fatalf("unable to insert user: %v", err)
+		}
+		ses := &fakeSES{}
+		seedTemplates(t, store, internal.VerifyEmailTemplateName)
+		if err := sendVerificationEmail(ctx, user, store.EmailVerifications.Insert, store.Templates.RetrieveByName, ses.send, clockAt(testNow)); err != nil {
+			t.Fatalf("unable to send verification email: %v", err)
+		}
+		return store, user, ses.sent[0].HTMLContent
+	}
+
+	verifyAt := func(store db.Store, now time.Epoch) VerifyEmailFunc {
+		return VerifyEmail(store.EmailVerifications.Retrieve, store.EmailVerifications.DeleteForUser, store.Users.RetrieveByID, store.Users.Replace, clockAt(now))
+	}
+
+	t.Run("verifies", func(t *testing.T) {
+		store, user, verificationToken := newVerification(t)
+		verify := verifyAt(store, testNow)
+
+		got, err := verify(ctx, pkg.VerifyEmail{Token: verificationToken})
+		if err != nil {
+			t.Fatalf("unable to verify email: %v", err)
+		}
+		if !got.EmailVerified {
+			t.Errorf("got emailVerified=false, want true")
+		}
+		if stored, _ := store.Users.RetrieveByID(ctx, user.ID.Val()); !stored.EmailVerified {
+			t.Errorf("got stored emailVerified=false, want true")
+		}
+
+		_, err = verify(ctx, pkg.VerifyEmail{Token: verificationToken})
+		wantCode(t, err, apperror.UnauthorizedCode)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		store, _, verificationToken := newVerification(t)
+		verify := verifyAt(store, testNow+time.Epoch(token.EmailVerificationTTL)+1)
+
+		_, err := verify(ctx, pkg.VerifyEmail{Token: verificationToken})
+		wantCode(t, err, apperror.UnauthorizedCode)
+	})
+
+	t.Run("email_changed", func(t *testing.T) {
+		store, user, verificationToken := newVerification(t)
+		user.Email = "changed@example.com"
+		if err := store.Users.Replace(ctx, user); err != nil {
+			t.Fatalf("unable to replace user: %v", err)
+		}
+		verify := verifyAt(store, testNow)
+
+		_, err := verify(ctx, pkg.VerifyEmail{Token: verificationToken})
+		wantCode(t, err, apperror.UnauthorizedCode)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		store, _, _ := newVerification(t)
+		verify := verifyAt(store, testNow)
+
+		_, err := verify(ctx, pkg.VerifyEmail{Token: "not-a-token"})
+		wantCode(t, err, apperror.UnauthorizedCode)
+		_, err = verify(ctx, pkg.VerifyEmail{})
+		wantCode(t, err, apperror.ValidationCode)
+	})
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+	ctx := context.Background()
+	store := db.NewMemoryStore()
+	user := internal.User{ID: "00000000-0000-4000-8000-000000000100", Email: "user@example.com"}
+	if err := store.Users.Insert(ctx, user); err != nil {
+		t.Fatalf("unable to insert user: %v", err)
+	}
+	seedTemplates(t, store, internal.VerifyEmailTemplateName)
+
+	ses := &fakeSES{}
+	resend := ResendVerificationEmail(store.EmailVerifications.Insert, store.EmailVerifications.DeleteForUser, store.Templates.RetrieveByName, ses.send, clockAt(testNow))
+	verify := VerifyEmail(store.EmailVerifications.Retrieve, store.EmailVerifications.DeleteForUser, store.Users.RetrieveByID, store.Users.Replace, clockAt(testNow))
+
+	for i := 0; i < 2; i++ {
+		if err := resend(ctx, auth.Principal{User: user}); err != nil {
+			t.Fatalf("unable to resend verification email: %v", err)
+		}
+	}
+	if len(ses.sent) != 2 || ses.sent[0].Recipient != user.Email {
+		t.Fatalf("got %v emails sent, want 2 sent to %v", len(ses.sent), user.Email)
+	}
+
+	// Only the latest token can be used
+	_, err := verify(ctx, pkg.VerifyEmail{Token: ses.sent[0].HTMLContent})
+	wantCode(t, err, apperror.UnauthorizedCode)
+	if _, err := verify(ctx, pkg.VerifyEmail{Token: ses.sent[1].HTMLContent}); err != nil {
+		t.Errorf("unable to verify with the latest token: %v", err)
+	}
+
+	user.EmailVerified = true
+	err = resend(ctx, auth.Principal{User: user})
+	wantCode(t, err, apperror.ConflictCode)
+}
@@ -1,50 +1,155 @@
 package workflow
 
 import (
+	"context"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/jsonpatch"
 	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
 )
 
-// AddUserFunc signs up a new user and returns a representation of the user and a token for the user
-type AddUserFunc func(req pkg.UserRequest) (pkg.User, string, error)
+// AddUserFunc signs up a new user and returns a representation of the user and tokens for the user
+type AddUserFunc func(ctx context.Context, req pkg.UserRequest) (pkg.UserResponse, error)
+
+// LoginUserFunc logs in a user and returns a representation of the user and tokens for the user
+type LoginUserFunc func(ctx context.Context, req pkg.UserRequest, ip string) (pkg.UserResponse, error)
+
+// AutoLoginUserFunc logs in an authenticated user and returns a representation of the user and a refreshed token for the user
+type AutoLoginUserFunc func(ctx context.Context, p auth.Principal) (pkg.UserResponse, error)
+
+// RefreshTokenFunc exchanges a refresh token for a new access token and a new refresh token
+type RefreshTokenFunc func(ctx context.Context, refreshToken string) (pkg.UserResponse, error)
+
+// LogoutFunc ends the session of the caller
+type LogoutFunc func(ctx context.Context, p auth.Principal) error
+
+// VerifyEmailFunc verifies the email address of the user a verification token was sent to
+type VerifyEmailFunc func(ctx context.Context, req pkg.VerifyEmail) (pkg.User, error)
+
+// ResendVerificationEmailFunc sends the caller a new email verification token
+type ResendVerificationEmailFunc func(ctx context.Context, p auth.Principal) error
+
+// UnlockUserFunc returns functionality for an admin to clear the failed logins locking out a user
+type UnlockUserFunc func(ctx context.Context, userId string, p auth.Principal) (pkg.User, error)
+
+// CompleteMFALoginFunc logs in a user who has entered their password with their second factor
+type CompleteMFALoginFunc func(ctx context.Context, req pkg.MFALoginRequest, ip string) (pkg.UserResponse, error)
 
-// LoginUserFunc logs in a user and returns a representation of the user and a token for the user
-type LoginUserFunc func(req pkg.UserRequest) (pkg.User, string, error)
+// EnrollTOTPFunc returns functionality for a user to start enrolling in two factor authentication
+type EnrollTOTPFunc func(ctx context.Context, p auth.Principal) (pkg.TOTPEnrollment, error)
 
-// AutoLoginUserFunc logs in a user using their JWT tokenand returns a representation of the user and a refreshed token for the user
-type AutoLoginUserFunc func(tokenString string) (pkg.User, string, error)
+// ConfirmTOTPFunc returns functionality for a user to finish enrolling in two factor authentication
+type ConfirmTOTPFunc func(ctx context.Context, req pkg.TOTPCodeRequest, p auth.Principal) (pkg.RecoveryCodesResponse, error)
+
+// DisableTOTPFunc returns functionality for a user to turn off two factor authentication
+type DisableTOTPFunc func(ctx context.Context, req pkg.TOTPCodeRequest, p auth.Principal) (pkg.User, error)
 
 // ApproveUserFunc returns functionaliy for an admin to approve a user
-type ApproveUserFunc func(userId string, tokenString string) (pkg.User, error)
+type ApproveUserFunc func(ctx context.Context, userId string, p auth.Principal) (pkg.User, error)
 
 // DenyUserFunc returns functionaliy for an admin to deny a user
-type DenyUserFunc func(userId string, tokenString string) (pkg.User, error)
+type DenyUserFunc func(ctx context.Context, userId string, req pkg.DenyUserRequest, p auth.Principal) (pkg.User, error)
+
+// RequestEmailChangeFunc returns functionality for a user to start changing the email address they log in with
+type RequestEmailChangeFunc func(ctx context.Context, req pkg.ChangeEmailRequest, p auth.Principal) error
+
+// ConfirmEmailChangeFunc returns functionality to complete a change of email with the token sent to the new address
+type ConfirmEmailChangeFunc func(ctx context.Context, req pkg.EmailChangeToken) (pkg.User, error)
+
+// CancelEmailChangeFunc returns functionality to discard a change of email with the token sent to the old address
+type CancelEmailChangeFunc func(ctx context.Context, req pkg.EmailChangeToken) error
+
+// InviteAlumniFunc returns functionality for an admin to invite an alumnus to claim an alumni record
+type InviteAlumniFunc func(ctx context.Context, alumniId string, req pkg.InvitationRequest, p auth.Principal) error
+
+// AcceptInvitationFunc returns functionality to create an approved account from an invitation
+type AcceptInvitationFunc func(ctx context.Context, req pkg.AcceptInvitationRequest) (pkg.UserResponse, error)
+
+// SearchClaimableAlumniFunc returns functionality for a user to find the existing alumni record that is theirs
+type SearchClaimableAlumniFunc func(ctx context.Context, params pkg.QueryParams, p auth.Principal) ([]pkg.AlumniMatch, error)
+
+// ClaimAlumniFunc returns functionality for a user to ask to be linked to an existing alumni record
+type ClaimAlumniFunc func(ctx context.Context, alumniId string, req pkg.ClaimRequest, p auth.Principal) (pkg.ProfileClaim, error)
+
+// RetrieveProfileClaimsFunc returns functionality for an admin to list claims on alumni records
+type RetrieveProfileClaimsFunc func(ctx context.Context, status string, p auth.Principal) ([]pkg.ProfileClaim, error)
+
+// ReviewProfileClaimFunc returns functionality for an admin to approve or reject a claim on an alumni record
+type ReviewProfileClaimFunc func(ctx context.Context, claimId string, req pkg.ReviewClaimRequest, p auth.Principal) (pkg.ProfileClaim, error)
+
+// DeleteAccountFunc returns functionality for a user to delete their account and everything stored about them
+type DeleteAccountFunc func(ctx context.Context, req pkg.DeleteAccountRequest, p auth.Principal) error
+
+// ExportAccountFunc returns functionality for a user to download a ZIP of everything stored about them
+type ExportAccountFunc func(ctx context.Context, p auth.Principal) ([]byte, error)
+
+// RetrieveUsersFunc returns functionality for an admin to list and search users
+type RetrieveUsersFunc func(ctx context.Context, params pkg.UserQueryParams, p auth.Principal) (pkg.RetrieveUsersResponse, error)
+
+// BulkUpdateUsersFunc returns functionality for an admin to approve or deny many users at once
+type BulkUpdateUsersFunc func(ctx context.Context, req pkg.BulkUserRequest, p auth.Principal) (pkg.BulkUserResponse, error)
 
 // AddAlumniFunc returns functionality to add an alumni
-type AddAlumniFunc func(req pkg.AlumniRequest, fileData pkg.FileData, tokenString string, skipFileUpload bool) (pkg.Alumni, error)
+type AddAlumniFunc func(ctx context.Context, req pkg.AlumniRequest, fileData pkg.FileData, p auth.Principal, skipFileUpload bool) (pkg.Alumni, error)
+
+// UpdateAlumniFunc returns functionality to update an alumni. The update only applies while the alumni is still at the given
+// version, and a version of 0 updates whichever version is current
+type UpdateAlumniFunc func(ctx context.Context, req pkg.UpdateAlumniRequest, alumniId string, version int64, fileData pkg.FileData, p auth.Principal, skipFileUpload bool) (pkg.Alumni, error)
+
+// PatchAlumniFunc returns functionality to apply a JSON Patch to the array fields of an alumni, at the given version as with UpdateAlumniFunc
+type PatchAlumniFunc func(ctx context.Context, patch jsonpatch.Patch, alumniId string, version int64, p auth.Principal) (pkg.Alumni, error)
+
+// RetrieveAlumniHistoryFunc returns functionality for an admin to retrieve every change made to an alumni, newest first
+type RetrieveAlumniHistoryFunc func(ctx context.Context, alumniId string, p auth.Principal) ([]pkg.AlumniHistory, error)
 
-// UpdateAlumniFunc returns functionality to update an alumni
-type UpdateAlumniFunc func(req pkg.UpdateAlumniRequest, alumniId string, fileData pkg.FileData, tokenString string, skipFileUpload bool) (pkg.Alumni, error)
+// RestoreAlumniFunc returns functionality for an admin to set an alumni back to how it was at an earlier version, which is
+// made while the alumni is still at the given version as with UpdateAlumniFunc
+type RestoreAlumniFunc func(ctx context.Context, alumniId string, toVersion int64, version int64, p auth.Principal) (pkg.Alumni, error)
 
 // RetrieveAlumniByIDFunc returns functionality to retrieve an alumni by ID
-type RetrieveAlumniByIDFunc func(alumniId string, tokenString string) (pkg.AlumniInterface, error)
+type RetrieveAlumniByIDFunc func(ctx context.Context, alumniId string, p auth.Principal) (pkg.AlumniInterface, error)
 
 // ChangeAlumniPrivacyFunc returns functionality to change the privacy status of an alumni by their ID
-type ChangeAlumniPrivacyFunc func(alumniId string, tokenString string) (pkg.Alumni, error)
+type ChangeAlumniPrivacyFunc func(ctx context.Context, alumniId string, p auth.Principal) (pkg.Alumni, error)
 
 // RetrieveAlumniFunc returns functionality to retrieve all alumni
-type RetrieveAlumniFunc func(params pkg.QueryParams, tokenString string) ([]pkg.CleanAlumni, pkg.PageInfo, error)
+type RetrieveAlumniFunc func(ctx context.Context, params pkg.QueryParams, p auth.Principal) ([]pkg.CleanAlumni, pkg.PageInfo, error)
 
 // HappyBirthdayFunc returns functionality to retrieve alumni's with todays birthday
-type HappyBirthdayFunc func() (pkg.HappyBirthdayResponse, error)
+type HappyBirthdayFunc func(ctx context.Context) (pkg.HappyBirthdayResponse, error)
 
 // HappyBirthdayEmailFunc returns functionality to send an email to all alumni's with todays birthday
-type HappyBirthdayEmailFunc func() error
+type HappyBirthdayEmailFunc func(ctx context.Context) error
 
 // ExportCSVFunc returns functionality to export a CSV with provided query params
-type ExportCSVFunc func(params pkg.QueryParams, tokenString string) ([]byte, error)
+type ExportCSVFunc func(ctx context.Context, params pkg.QueryParams, p auth.Principal) ([]byte, error)
+
+// RetrieveAuditEventsFunc returns functionality for an admin to list the administrative actions taken by users, newest first
+type RetrieveAuditEventsFunc func(ctx context.Context, params pkg.AuditQueryParams, p auth.Principal) (pkg.RetrieveAuditEventsResponse, error)
+
+// ExportAuditCSVFunc returns functionality for an admin to export a CSV of the audit events matching the provided query params
+type ExportAuditCSVFunc func(ctx context.Context, params pkg.AuditQueryParams, p auth.Principal) ([]byte, error)
 
 // ForgotPasswordFunc returns functionality to send a reset password email
-type ForgotPasswordFunc func(email string) error
+type ForgotPasswordFunc func(ctx context.Context, email string) error
 
 // SetNewPasswordFunc returns functionality to set a new password
-type SetNewPasswordFunc func(rp pkg.ResetPassword) (pkg.User, string, error)
+type SetNewPasswordFunc func(ctx context.Context, rp pkg.ResetPassword) (pkg.UserResponse, error)
+
+// AuthenticateFunc returns functionality to resolve the caller of a request from their JWT token and check they meet a requirement
+type AuthenticateFunc func(ctx context.Context, tokenString string, req auth.Requirement) (auth.Principal, error)
+
+// GrantRoleFunc returns functionality for an admin to grant a role to a user
+type GrantRoleFunc func(ctx context.Context, userId string, role pkg.Role, p auth.Principal) (pkg.User, error)
+
+// RevokeRoleFunc returns functionality for an admin to revoke a role from a user
+type RevokeRoleFunc func(ctx context.Context, userId string, role pkg.Role, p auth.Principal) (pkg.User, error)
+
+// RetrieveUserRolesFunc returns functionality for an admin to retrieve the roles of a user and their history
+type RetrieveUserRolesFunc func(ctx context.Context, userId string, p auth.Principal) (pkg.UserRolesResponse, error)
+
+// RetrieveEmailTemplatesFunc returns functionality for an admin to retrieve all email templates
+type RetrieveEmailTemplatesFunc func(ctx context.Context, p auth.Principal) ([]pkg.EmailTemplate, error)
+
+// UpsertEmailTemplateFunc returns functionality for an admin to create or replace an email template
+type UpsertEmailTemplateFunc func(ctx context.Context, name string, req pkg.EmailTemplate, p auth.Principal) (pkg.EmailTemplate, error)
@@ -0,0 +1,75 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	gotime "time"
+
+	"github.com/BenBraunstein/haftr-alumni-golang/common/time"
+	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/apperror"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/auth"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/db"
+	"github.com/BenBraunstein/haftr-alumni-golang/internal/email"
+	"github.com/BenBraunstein/haftr-alumni-golang/pkg"
+)
+
+// testNow is the time every workflow under test runs at, unless a test moves its clock
+var testNow = time.Epoch(gotime.Date(2021, gotime.January, 1, 9, 0, 0, 0, gotime.UTC).UnixNano())
+
+var testAdmin = auth.Principal{User: internal.User{ID: "00000000-0000-4000-8000-00000000a000", Admin: true}}
+
+func clockAt(e time.Epoch) time.EpochProviderFunc {
+	return func() time.Epoch { return e }
+}
+
+// sequentialUUIDs generates v4 shaped UUIDs counting up from 1
+func sequentialUUIDs() uuid.GenV4Func {
+	n := 0
+	return func() uuid.V4 {
+		n++
+		return uuid.V4(fmt.Sprintf("00000000-0000-4000-8000-%012d", n))
+	}
+}
+
+// fakeSES records the emails sent rather than sending them
+type fakeSES struct {
+	sent []email.SendRequest
+}
+
+func (s *fakeSES) send(ctx context.Context, req email.SendRequest) error {
+	s.sent = append(s.sent, req)
+	return nil
+}
+
+func noPresign(ctx context.Context, key string) (string, error) {
+	return "", nil
+}
+
+// seedTemplates stores a template for each name that renders the token and reason it is given
+func seedTemplates(t *testing.T, store db.Store, names ...string) {
+	for _, name := range names {
+		et := internal.EmailTemplate{Name: name, Subject: name, HTML: "{{Token}}{{Reason}}"}
+		if err := store.Templates.Upsert(context.Background(), et); err != nil {
+			t.Fatalf("unable to seed template=%v: %v", name, err)
+		}
+	}
+}
+
+// auditEvents returns every audit event recorded in the store
+func auditEvents(t *testing.T, store db.Store) []internal.AuditEvent {
+	ee, _, err := store.AuditEvents.Retrieve(context.Background(), pkg.AuditQueryParams{Limit: -1, Page: 1})
+	if err != nil {
+		t.Fatalf("unable to retrieve audit events: %v", err)
+	}
+	return ee
+}
+
+func wantCode(t *testing.T, err error, code apperror.Code) {
+	t.Helper()
+	if got := apperror.CodeOf(err); got != code {
+		t.Errorf("got code=%v, err=%v, want code=%v", got, err, code)
+	}
+}
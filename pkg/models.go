@@ -1,8 +1,10 @@
 package pkg
 
 import (
+	"encoding/json"
 	"io"
 	"mime/multipart"
+	"sort"
 
 	"github.com/BenBraunstein/haftr-alumni-golang/common/uuid"
 )
@@ -17,15 +19,120 @@ type UserRequest struct {
 
 // User is the representation of a DTO User
 type User struct {
-	ID       uuid.V4 `json:"id"`
-	Email    string  `json:"email"`
-	AlumniID uuid.V4 `json:"alumniId"`
-	Admin    bool    `json:"admin"`
-	Status   string  `json:"status"`
+	ID               uuid.V4 `json:"id"`
+	Email            string  `json:"email"`
+	AlumniID         uuid.V4 `json:"alumniId"`
+	Admin            bool    `json:"admin"`
+	Roles            []Role  `json:"roles"`
+	Status           string  `json:"status"`
+	DenialReason     string  `json:"denialReason,omitempty"`
+	EmailVerified    bool    `json:"emailVerified"`
+	TOTPEnabled      bool    `json:"totpEnabled"`
+	CreatedTimestamp string  `json:"createdTimestamp"`
+}
+
+// Role is a representation of an administrative role, optionally scoped to a division or graduating class
+type Role struct {
+	Name           string `json:"name"`
+	Division       string `json:"division,omitempty"`
+	GraduationYear string `json:"graduationYear,omitempty"`
+}
+
+// RoleChange is a representation of a role being granted to or revoked from a user
+type RoleChange struct {
+	ID               uuid.V4 `json:"id"`
+	ActorID          uuid.V4 `json:"actorId"`
+	Action           string  `json:"action"`
+	Role             Role    `json:"role"`
+	CreatedTimestamp string  `json:"createdTimestamp"`
+}
+
+// AlumniHistory is a representation of a change made to an alumni
+type AlumniHistory struct {
+	ID               uuid.V4       `json:"id"`
+	Version          int64         `json:"version"`
+	ActorID          uuid.V4       `json:"actorId"`
+	Action           string        `json:"action"`
+	Changes          []FieldChange `json:"changes"`
+	RestoredVersion  int64         `json:"restoredVersion,omitempty"`
+	CreatedTimestamp string        `json:"createdTimestamp"`
+}
+
+// FieldChange is a representation of the value of a single field of an alumni before and after a change
+type FieldChange struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditEvent is a representation of an administrative action taken by a user
+type AuditEvent struct {
+	ID               uuid.V4 `json:"id"`
+	ActorID          uuid.V4 `json:"actorId"`
+	Action           string  `json:"action"`
+	TargetType       string  `json:"targetType"`
+	TargetID         string  `json:"targetId"`
+	IP               string  `json:"ip"`
+	RequestID        string  `json:"requestId"`
+	CorrelationID    string  `json:"correlationId"`
+	Outcome          string  `json:"outcome"`
+	Detail           string  `json:"detail"`
+	CreatedTimestamp string  `json:"createdTimestamp"`
+}
+
+// UserRolesResponse is a representation of the roles of a user and how they came to hold them
+type UserRolesResponse struct {
+	Roles   []Role       `json:"roles"`
+	History []RoleChange `json:"history"`
+}
+
+// EmailTemplate is a representation of a handlebars email template
+type EmailTemplate struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
 }
 
 type UserResponse struct {
-	User  User   `json:"user"`
+	User         User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+	MFARequired  bool   `json:"mfaRequired,omitempty"`
+	MFAToken     string `json:"mfaToken,omitempty"`
+}
+
+// MFALoginRequest is a representation of a request to complete a login with a second factor
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// TOTPEnrollment is a representation of a new TOTP secret for a user to add to their authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TOTPCodeRequest is a representation of a request carrying a TOTP code, or a recovery code in its place
+type TOTPCodeRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// RecoveryCodesResponse is a representation of the recovery codes generated for a user, which are only shown once
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// RefreshTokenRequest is a representation of a request to exchange a refresh token for new tokens
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// VerifyEmail is a representation of a request to verify an email address, and of the email sent to request it
+type VerifyEmail struct {
+	Email string `json:"email"`
 	Token string `json:"token"`
 }
 
@@ -94,62 +201,96 @@ type ResetPassword struct {
 	Password string `json:"password"`
 }
 
+// UpdateAlumniRequest is a partial update of an alumni. Fields left out of the request are left as they are,
+// and fields set to null are cleared
 type UpdateAlumniRequest struct {
-	Title                  string        `json:"title"`
-	Firstname              string        `json:"firstname"`
-	Middlename             string        `json:"middlename"`
-	Lastname               string        `json:"lastname"`
-	MarriedName            string        `json:"marriedName"`
-	MaidenName             string        `json:"maidenName"`
-	MotherName             string        `json:"motherName"`
-	MotherDeceased         bool          `json:"motherDeceased"`
-	FatherName             string        `json:"fatherName"`
-	FatherDeceased         bool          `json:"fatherDeceased"`
-	SpouseName             string        `json:"spouseName"`
-	SpouseMaidenName       string        `json:"spouseMaidenName"`
-	CurrentAddress         Address       `json:"address"`
-	HomePhone              string        `json:"homePhone"`
-	CellPhone              string        `json:"cellPhone"`
-	WorkPhone              string        `json:"workPhone"`
-	EmailAddress           string        `json:"emailAddress"`
-	MiddleSchool           School        `json:"middleschool"`
-	HighSchool             School        `json:"highschool"`
-	IsraelSchool           School        `json:"israelSchool"`
-	CollegeAttended        School        `json:"collegeAttended"`
-	GradSchools            []School      `json:"gradSchools"`
-	Profession             []string      `json:"profession"`
-	Birthday               string        `json:"birthday"`
-	Clubs                  []string      `json:"clubs"`
-	SportsTeams            []string      `json:"sportsTeams"`
-	Awards                 []string      `json:"awards"`
-	Committees             []string      `json:"committees"`
-	OldAddresses           []Address     `json:"oldAddresses"`
-	HillelDayCamp          Camp          `json:"hillelDayCamp"`
-	HillelSleepCamp        Camp          `json:"hillelSleepCamp"`
-	HiliDayCamp            Camp          `json:"hiliDayCamp"`
-	HiliWhiteCamp          Camp          `json:"hiliWhiteCamp"`
-	HiliInternationalCamp  Camp          `json:"hiliInternationalCamp"`
-	HILI                   bool          `json:"hili"`
-	HILLEL                 bool          `json:"hillel"`
-	HAFTR                  bool          `json:"haftr"`
-	ParentOfStudent        bool          `json:"parentOfStudent"`
-	Boards                 []string      `json:"boards"`
-	AlumniPositions        []string      `json:"alumniPositions"`
-	Siblings               []Sibling     `json:"siblings"`
-	Children               []Child       `json:"children"`
-	Grandparents           []Grandparent `json:"grandparents"`
-	ClassPresident         bool          `json:"classPresident"`
-	BoardOfTrustees        bool          `json:"boardOfTrustees"`
-	BoardOfEducation       bool          `json:"boardOfEducation"`
-	BoardsComment          string        `json:"boardsComment"`
-	AlumniNewsletters      bool          `json:"alumniNewsletters"`
-	CommunicationsOutreach bool          `json:"communicationsOutreach"`
-	ClassReunions          bool          `json:"classReunions"`
-	AlumniEvents           bool          `json:"alumniEvents"`
-	FundraisingNetworking  bool          `json:"fundraisingNetworking"`
-	DbResearch             bool          `json:"dbResearch"`
-	AlumniChoir            bool          `json:"alumniChoir"`
-	Comment                string        `json:"comment"`
+	Title                  *string        `json:"title"`
+	Firstname              *string        `json:"firstname"`
+	Middlename             *string        `json:"middlename"`
+	Lastname               *string        `json:"lastname"`
+	MarriedName            *string        `json:"marriedName"`
+	MaidenName             *string        `json:"maidenName"`
+	MotherName             *string        `json:"motherName"`
+	MotherDeceased         *bool          `json:"motherDeceased"`
+	FatherName             *string        `json:"fatherName"`
+	FatherDeceased         *bool          `json:"fatherDeceased"`
+	SpouseName             *string        `json:"spouseName"`
+	SpouseMaidenName       *string        `json:"spouseMaidenName"`
+	CurrentAddress         *Address       `json:"address"`
+	HomePhone              *string        `json:"homePhone"`
+	CellPhone              *string        `json:"cellPhone"`
+	WorkPhone              *string        `json:"workPhone"`
+	EmailAddress           *string        `json:"emailAddress"`
+	MiddleSchool           *School        `json:"middleschool"`
+	HighSchool             *School        `json:"highschool"`
+	IsraelSchool           *School        `json:"israelSchool"`
+	CollegeAttended        *School        `json:"collegeAttended"`
+	GradSchools            *[]School      `json:"gradSchools"`
+	Profession             *[]string      `json:"profession"`
+	Birthday               *string        `json:"birthday"`
+	Clubs                  *[]string      `json:"clubs"`
+	SportsTeams            *[]string      `json:"sportsTeams"`
+	Awards                 *[]string      `json:"awards"`
+	Committees             *[]string      `json:"committees"`
+	OldAddresses           *[]Address     `json:"oldAddresses"`
+	HillelDayCamp          *Camp          `json:"hillelDayCamp"`
+	HillelSleepCamp        *Camp          `json:"hillelSleepCamp"`
+	HiliDayCamp            *Camp          `json:"hiliDayCamp"`
+	HiliWhiteCamp          *Camp          `json:"hiliWhiteCamp"`
+	HiliInternationalCamp  *Camp          `json:"hiliInternationalCamp"`
+	HILI                   *bool          `json:"hili"`
+	HILLEL                 *bool          `json:"hillel"`
+	HAFTR                  *bool          `json:"haftr"`
+	ParentOfStudent        *bool          `json:"parentOfStudent"`
+	Boards                 *[]string      `json:"boards"`
+	AlumniPositions        *[]string      `json:"alumniPositions"`
+	Siblings               *[]Sibling     `json:"siblings"`
+	Children               *[]Child       `json:"children"`
+	Grandparents           *[]Grandparent `json:"grandparents"`
+	ClassPresident         *bool          `json:"classPresident"`
+	BoardOfTrustees        *bool          `json:"boardOfTrustees"`
+	BoardOfEducation       *bool          `json:"boardOfEducation"`
+	BoardsComment          *string        `json:"boardsComment"`
+	AlumniNewsletters      *bool          `json:"alumniNewsletters"`
+	CommunicationsOutreach *bool          `json:"communicationsOutreach"`
+	ClassReunions          *bool          `json:"classReunions"`
+	AlumniEvents           *bool          `json:"alumniEvents"`
+	FundraisingNetworking  *bool          `json:"fundraisingNetworking"`
+	DbResearch             *bool          `json:"dbResearch"`
+	AlumniChoir            *bool          `json:"alumniChoir"`
+	Comment                *string        `json:"comment"`
+	// Cleared are the json names of the fields set to null
+	Cleared []string `json:"-"`
+}
+
+// AlumniArrays are the array fields of an alumni, whose elements a JSON Patch can add, remove and replace one at a time
+type AlumniArrays struct {
+	GradSchools  []School      `json:"gradSchools"`
+	OldAddresses []Address     `json:"oldAddresses"`
+	Siblings     []Sibling     `json:"siblings"`
+	Children     []Child       `json:"children"`
+	Grandparents []Grandparent `json:"grandparents"`
+}
+
+// UnmarshalJSON decodes the fields of an update, noting which of them were set to null
+func (r *UpdateAlumniRequest) UnmarshalJSON(bb []byte) error {
+	type request UpdateAlumniRequest
+	if err := json.Unmarshal(bb, (*request)(r)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(bb, &fields); err != nil {
+		return err
+	}
+	r.Cleared = []string{}
+	for name, value := range fields {
+		if string(value) == "null" {
+			r.Cleared = append(r.Cleared, name)
+		}
+	}
+	sort.Strings(r.Cleared)
+	return nil
 }
 
 type Alumni struct {
@@ -214,6 +355,7 @@ type Alumni struct {
 	Comment                string        `json:"comment"`
 	IsPublic               bool          `json:"isPublic"`
 	ProfilePictureURL      string        `json:"profilePictureURL"`
+	Version                int64         `json:"version"`
 }
 
 type CleanAlumni struct {
@@ -259,6 +401,156 @@ type QueryParams struct {
 	Birthday      string
 }
 
+// UserQueryParams is a representation of the filters an admin can list users by
+type UserQueryParams struct {
+	Limit         int64  `json:"limit"`
+	Page          int64  `json:"page"`
+	Email         string `json:"email"`
+	Status        string `json:"status"`
+	Admin         *bool  `json:"admin"`
+	HasAlumni     *bool  `json:"hasAlumni"`
+	CreatedAfter  string `json:"createdAfter"`
+	CreatedBefore string `json:"createdBefore"`
+}
+
+// AuditQueryParams is a representation of the filters for listing audit events
+type AuditQueryParams struct {
+	Limit         int64  `json:"limit"`
+	Page          int64  `json:"page"`
+	ActorID       string `json:"actorId"`
+	Action        string `json:"action"`
+	TargetType    string `json:"targetType"`
+	TargetID      string `json:"targetId"`
+	Outcome       string `json:"outcome"`
+	CreatedAfter  string `json:"createdAfter"`
+	CreatedBefore string `json:"createdBefore"`
+}
+
+// DenyUserRequest is a representation of a request to deny a user, optionally explaining why
+type DenyUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// UserStatusEmail is a representation of the data an approved or denied user email template is rendered with
+type UserStatusEmail struct {
+	Email  string
+	Reason string
+}
+
+// DeleteAccountRequest is a representation of a request for a user to delete their own account
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// AccountExport is a representation of the account information held about a user, for them to download
+type AccountExport struct {
+	User                 User         `json:"user"`
+	LastUpdatedTimestamp string       `json:"lastUpdatedTimestamp"`
+	RoleHistory          []RoleChange `json:"roleHistory"`
+}
+
+// ChangeEmailRequest is a representation of a request for a user to change the email address they log in with
+type ChangeEmailRequest struct {
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	SyncAlumni bool   `json:"syncAlumni"`
+}
+
+// EmailChangeToken is a representation of a request carrying a token emailed to confirm or cancel a change of email
+type EmailChangeToken struct {
+	Token string `json:"token"`
+}
+
+// ChangeEmail is a representation of the data the change email templates are rendered with
+type ChangeEmail struct {
+	OldEmail string
+	NewEmail string
+	Token    string
+}
+
+// InvitationRequest is a representation of a request to invite an alumnus to claim their alumni record
+type InvitationRequest struct {
+	Email string `json:"email"`
+}
+
+// AcceptInvitationRequest is a representation of a request to accept an invitation and create an account
+type AcceptInvitationRequest struct {
+	Email    string `json:"email"`
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// Invitation is a representation of the data the invitation email template is rendered with
+type Invitation struct {
+	Email     string
+	Firstname string
+	Lastname  string
+	Token     string
+}
+
+// AlumniMatch is a representation of an unclaimed alumni record a user may claim, with its name masked
+type AlumniMatch struct {
+	ID   uuid.V4 `json:"id"`
+	Name string  `json:"name"`
+}
+
+// ClaimRequest is a representation of a request to claim an existing alumni record
+type ClaimRequest struct {
+	Message string `json:"message"`
+}
+
+// ReviewClaimRequest is a representation of an admin approving or rejecting a claim, optionally explaining why
+type ReviewClaimRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ProfileClaim is a representation of a request by a user to be linked to an existing alumni record
+type ProfileClaim struct {
+	ID               uuid.V4 `json:"id"`
+	UserID           uuid.V4 `json:"userId"`
+	AlumniID         uuid.V4 `json:"alumniId"`
+	Message          string  `json:"message"`
+	Disputed         bool    `json:"disputed"`
+	Status           string  `json:"status"`
+	ReviewedBy       uuid.V4 `json:"reviewedBy,omitempty"`
+	ReviewReason     string  `json:"reviewReason,omitempty"`
+	CreatedTimestamp string  `json:"createdTimestamp"`
+}
+
+// RetrieveUsersResponse is a representation of a page of users
+type RetrieveUsersResponse struct {
+	Users    []User   `json:"users"`
+	PageInfo PageInfo `json:"pageInfo"`
+}
+
+// RetrieveAuditEventsResponse is a representation of a page of audit events
+type RetrieveAuditEventsResponse struct {
+	Events   []AuditEvent `json:"events"`
+	PageInfo PageInfo     `json:"pageInfo"`
+}
+
+// BulkUserRequest is a representation of a request to approve or deny many users at once
+type BulkUserRequest struct {
+	Action  string   `json:"action"`
+	UserIDs []string `json:"userIds"`
+	Reason  string   `json:"reason"`
+}
+
+// BulkUserResult is a representation of the outcome of a bulk action for a single user
+type BulkUserResult struct {
+	UserID string `json:"userId"`
+	User   *User  `json:"user,omitempty"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// BulkUserResponse is a representation of the outcome of a bulk action for every user it was requested for
+type BulkUserResponse struct {
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Results   []BulkUserResult `json:"results"`
+}
+
 type School struct {
 	Name        string `json:"name"`
 	YearStarted string `json:"yearStarted"`